@@ -0,0 +1,62 @@
+package main
+
+import (
+	"net"
+	"sync"
+)
+
+// limitListener accepts at most a fixed number of simultaneous connections. Once
+// the limit is reached Accept waits for a connection to close, leaving new clients
+// in the kernel's backlog, so slow or idle clients can't exhaust file descriptors.
+type limitListener struct {
+	net.Listener
+	sem       chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// limitListen listens on addr, accepting at most n simultaneous connections.
+// Zero means unlimited.
+func limitListen(addr string, n int) (net.Listener, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil || n == 0 {
+		return ln, err
+	}
+	return &limitListener{Listener: ln, sem: make(chan struct{}, n), done: make(chan struct{})}, nil
+}
+
+// Accept waits for a free slot, then for the next connection
+func (l *limitListener) Accept() (net.Conn, error) {
+	select {
+	case l.sem <- struct{}{}:
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		<-l.sem
+		return nil, err
+	}
+	return &limitConn{Conn: conn, release: func() { <-l.sem }}, nil
+}
+
+// Close stops the listener, unblocking an Accept waiting for a slot
+func (l *limitListener) Close() error {
+	err := l.Listener.Close()
+	l.closeOnce.Do(func() { close(l.done) })
+	return err
+}
+
+// limitConn frees its listener slot when closed
+type limitConn struct {
+	net.Conn
+	releaseOnce sync.Once
+	release     func()
+}
+
+func (c *limitConn) Close() error {
+	err := c.Conn.Close()
+	c.releaseOnce.Do(c.release)
+	return err
+}
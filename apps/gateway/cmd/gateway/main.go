@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
 	"time"
 
@@ -18,8 +19,13 @@ import (
 	"github.com/lumina/gateway/internal/cache"
 	"github.com/lumina/gateway/internal/config"
 	"github.com/lumina/gateway/internal/database"
+	"github.com/lumina/gateway/internal/jobs"
 	"github.com/lumina/gateway/internal/logging"
+	"github.com/lumina/gateway/internal/metrics"
+	"github.com/lumina/gateway/internal/models"
+	"github.com/lumina/gateway/internal/openapi"
 	"github.com/lumina/gateway/internal/proxy"
+	"github.com/lumina/gateway/internal/webhook"
 )
 
 func main() {
@@ -55,7 +61,9 @@ func main() {
 	}
 
 	// Initialize Redis cache
-	redisCache, err := cache.New(cfg.RedisURL)
+	redisCache, err := cache.New(cfg.RedisURL, cache.Options{
+		KeyConfigTTL: cfg.KeyCacheTTL,
+	})
 	if err != nil {
 		slog.Error("failed to connect to Redis", "error", err)
 		os.Exit(1)
@@ -63,21 +71,149 @@ func main() {
 	defer redisCache.Close()
 
 	// Initialize OpenSearch logging
-	logPipeline, err := logging.New(cfg.OpenSearchURL)
+	logPipeline, err := logging.New(cfg.OpenSearchURLs, logging.Options{
+		BodyMode:        models.LogBodyMode(cfg.LogBodyMode),
+		BodyMaxChars:    cfg.LogBodyMaxChars,
+		ContentMaxChars: cfg.LogContentMaxChars,
+		EnqueueTimeout:  cfg.LogEnqueueTimeout,
+		IndexRetry:      cfg.LogIndexRetry,
+		TailSubscribers: cfg.LogTailMax,
+		TailBuffer:      cfg.LogTailBuffer,
+		MetadataDynamic: cfg.LogMetadataDynamic,
+		MetadataFields:  cfg.LogMetadataFields,
+	})
 	if err != nil {
 		slog.Error("failed to connect to OpenSearch", "error", err)
 		os.Exit(1)
 	}
-	defer logPipeline.Close()
 
 	// Initialize JWT manager
 	jwtManager := auth.NewJWTManager(cfg.JWTSecret)
 
 	// Initialize services
-	keyService := auth.NewKeyService(db, redisCache, cfg.EncryptionKey)
-	proxyHandler := proxy.NewHandler(keyService, logPipeline)
+	encryptionKey, err := auth.DeriveEncryptionKey(cfg.EncryptionKey, cfg.KeyDerivation, cfg.KeySalt)
+	if err != nil {
+		slog.Error("failed to derive encryption key", "error", err)
+		os.Exit(1)
+	}
+
+	// The previous key, if any, keeps provider keys readable until they are rotated
+	keyring := auth.NewKeyring(cfg.KeyVersion, encryptionKey)
+	if cfg.PreviousKey != "" {
+		previousKey, err := auth.DeriveEncryptionKey(cfg.PreviousKey, cfg.KeyDerivation, cfg.KeySalt)
+		if err != nil {
+			slog.Error("failed to derive previous encryption key", "error", err)
+			os.Exit(1)
+		}
+		keyring.Keys[cfg.KeyVersion-1] = previousKey
+	}
+
+	var hashSecret []byte
+	if cfg.KeyHashSecret != "" {
+		hashSecret = []byte(cfg.KeyHashSecret)
+	}
+
+	keyService := auth.NewKeyService(db, redisCache, keyring, hashSecret, auth.Policy{
+		RequireBudget:  cfg.RequireBudget,
+		MaxBudgetLimit: cfg.MaxBudgetLimit,
+		MaxKeysPerUser: cfg.MaxKeysPerUser,
+		KeyPrefix:      cfg.KeyPrefix,
+		DeniedModels:   cfg.DeniedModels,
+	})
+
+	keyService.EnableLocalCache(cfg.KeyLocalCacheSize, cfg.KeyLocalCacheTTL)
+	keyService.StartSpendWorkers(cfg.SpendWorkers, cfg.SpendQueueSize)
+	if cfg.ShareTokenSecret != "" {
+		keyService.EnableShareTokens([]byte(cfg.ShareTokenSecret))
+	}
+
+	// Background tasks that run until shutdown
+	bgCtx, stopBackground := context.WithCancel(context.Background())
+	defer stopBackground()
+
+	go keyService.WatchDeniedModels(bgCtx, cfg.DeniedModelsInterval)
+	go keyService.WatchInvalidations(bgCtx)
+	go keyService.RunEncryptionRotations(bgCtx)
+
+	// Preload recently used keys in the background so startup isn't delayed
+	if cfg.CacheWarmupKeys > 0 {
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), cfg.CacheWarmupTimeout)
+			defer cancel()
+
+			start := time.Now()
+			warmed, err := keyService.WarmCache(ctx, cfg.CacheWarmupKeys)
+			if err != nil {
+				slog.Warn("cache warmup incomplete", "warmed", warmed, "error", err)
+				return
+			}
+			slog.Info("cache warmup complete", "warmed", warmed, "duration", time.Since(start))
+		}()
+	}
+
+	if cfg.StatsRollupAt != "" {
+		at, err := jobs.ParseTimeOfDay(cfg.StatsRollupAt)
+		if err != nil {
+			slog.Error("invalid stats rollup schedule", "error", err)
+			os.Exit(1)
+		}
+		go jobs.NewDailyRollup(db, logPipeline, redisCache, at).Run(bgCtx)
+	}
+
+	var notifier *webhook.Notifier
+	if cfg.WebhookURL != "" {
+		notifier = webhook.New(cfg.WebhookURL, cfg.WebhookSecret)
+	}
+
+	if cfg.KeyIdleRevokeDays > 0 {
+		const day = 24 * time.Hour
+		go jobs.NewIdleKeyRevocation(db, keyService, redisCache, notifier,
+			time.Duration(cfg.KeyIdleRevokeDays)*day, time.Duration(cfg.KeyIdleRevokeGrace)*day).Run(bgCtx)
+	}
+
+	transforms, err := proxy.LoadTransforms(cfg.TransformsFile)
+	if err != nil {
+		slog.Error("failed to load request transforms", "error", err)
+		os.Exit(1)
+	}
+
+	proxyHandler := proxy.NewHandler(keyService, logPipeline, redisCache, proxy.Options{
+		Transforms:                transforms,
+		InjectStreamUsage:         cfg.StreamIncludeUsage,
+		StreamIdleTimeout:         cfg.StreamIdleTimeout,
+		UpstreamTimeout:           cfg.UpstreamTimeout,
+		StreamTimeout:             cfg.StreamTimeout,
+		AnthropicDefaultMaxTokens: cfg.AnthropicMaxTokens,
+		GzipMinBytes:              cfg.GzipMinBytes,
+		GzipProviders:             cfg.GzipProviders,
+		ProviderConcurrency:       cfg.ProviderConcurrency,
+		ProviderConcurrencyWait:   cfg.ProviderConcurrencyWait,
+		MaxStreams:                cfg.MaxStreams,
+		MaxStreamsPerKey:          cfg.MaxStreamsPerKey,
+		RateLimit:                 cfg.RateLimit,
+		LogSampleRate:             cfg.LogSampleRate,
+		DebugCaptureRate:          cfg.DebugCaptureRate,
+		EchoRequestID:             cfg.EchoRequestID,
+		RequestIDHeader:           cfg.RequestIDHeader,
+		LegacyCompletions:         cfg.LegacyCompletions,
+		DefaultProvider:           cfg.DefaultProvider,
+		ProviderMetadata:          cfg.ProviderMetadata,
+		UnsupportedParams:         cfg.UnsupportedParams,
+		UnknownPricing:            cfg.UnknownPricing,
+		RewriteResponseModel:      cfg.RewriteModel,
+		ForwardedHeaders:          cfg.ForwardedHeaders,
+	})
+	if notifier != nil {
+		proxyHandler.SetNotifier(notifier)
+	}
+	sessionService := auth.NewSessionService(db, redisCache, jwtManager)
+
 	apiHandler := api.NewHandler(db, keyService, jwtManager)
+	apiHandler.SetRegistrationEnabled(cfg.RegistrationEnabled)
+	apiHandler.SetCookiePolicy(cfg.CookieSameSite, cfg.CookieSecure)
+	apiHandler.SetMaxLogPageSize(cfg.LogSearchMaxSize)
 	apiHandler.SetLogPipeline(logPipeline)
+	apiHandler.SetSessions(sessionService)
 
 	// Set up router
 	r := chi.NewRouter()
@@ -87,12 +223,11 @@ func main() {
 	r.Use(middleware.RealIP)
 	r.Use(middleware.Logger)
 	r.Use(middleware.Recoverer)
-	r.Use(middleware.Timeout(60 * time.Second))
 	r.Use(cors.Handler(cors.Options{
-		AllowedOrigins:   []string{"http://localhost:3000", "http://127.0.0.1:3000"},
+		AllowedOrigins:   cfg.CORSAllowedOrigins,
 		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
 		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token"},
-		ExposedHeaders:   []string{"Link"},
+		ExposedHeaders:   []string{"Link", "X-Total-Count"},
 		AllowCredentials: true,
 		MaxAge:           300,
 	}))
@@ -103,74 +238,159 @@ func main() {
 		w.Write([]byte(`{"status":"ok"}`))
 	})
 
+	// OpenAPI document for client generation
+	r.Get("/openapi.json", openapi.Handler)
+
+	// Prometheus metrics
+	r.Get("/metrics", metrics.Handler)
+
 	// API routes (dashboard management)
 	r.Route("/api", func(r chi.Router) {
-		// Public routes
-		r.Post("/auth/login", apiHandler.Login)
-		r.Post("/auth/register", apiHandler.Register)
+		// Live log tails stay open, so they are exempt from the request timeout
+		r.With(auth.JWTMiddleware(jwtManager, sessionService)).Get("/logs/stream", apiHandler.StreamLogs)
 
-		// Protected routes
+		// The rest of the dashboard API shares a fixed 60s request timeout
 		r.Group(func(r chi.Router) {
-			r.Use(auth.JWTMiddleware(jwtManager))
-
-			r.Post("/auth/logout", apiHandler.Logout)
-			r.Get("/auth/me", apiHandler.Me)
-
-			// Key management
-			r.Route("/keys", func(r chi.Router) {
-				r.Get("/", apiHandler.ListKeys)
-				r.Post("/", apiHandler.CreateKey)
-				r.Get("/{id}", apiHandler.GetKey)
-				r.Put("/{id}", apiHandler.UpdateKey)
-				r.Delete("/{id}", apiHandler.RevokeKey)
-			})
+			r.Use(middleware.Timeout(60 * time.Second))
 
-			// Provider management (account-level API keys)
-			r.Route("/providers", func(r chi.Router) {
-				r.Get("/", apiHandler.ListProviders)
-				r.Post("/", apiHandler.SetProvider)
-				r.Delete("/{provider}", apiHandler.RemoveProvider)
-			})
+			// Public routes
+			r.Post("/auth/login", apiHandler.Login)
+			r.Post("/auth/register", apiHandler.Register)
+
+			// Protected routes
+			r.Group(func(r chi.Router) {
+				r.Use(auth.JWTMiddleware(jwtManager, sessionService))
+
+				r.Post("/auth/logout", apiHandler.Logout)
+				r.Get("/auth/me", apiHandler.Me)
+				r.Get("/auth/sessions", apiHandler.ListSessions)
+				r.Delete("/auth/sessions/{id}", apiHandler.RevokeSession)
+
+				// Key management
+				r.Route("/keys", func(r chi.Router) {
+					r.Get("/", apiHandler.ListKeys)
+					r.Post("/", apiHandler.CreateKey)
+					r.Post("/import", apiHandler.ImportKey)
+					r.Get("/{id}", apiHandler.GetKey)
+					r.Get("/{id}/config", apiHandler.GetKeyConfig)
+					r.Get("/{id}/activity", apiHandler.GetKeyActivity)
+					r.Get("/{id}/errors", apiHandler.GetKeyErrors)
+					r.Get("/{id}/export", apiHandler.ExportKey)
+					r.Post("/{id}/share-tokens", apiHandler.CreateShareToken)
+					r.Put("/{id}", apiHandler.UpdateKey)
+					r.Delete("/{id}", apiHandler.RevokeKey)
+				})
+
+				// Gateway capabilities
+				r.Get("/meta/providers", apiHandler.GetProviderCatalog)
+
+				// Provider management (account-level API keys)
+				r.Route("/providers", func(r chi.Router) {
+					r.Get("/", apiHandler.ListProviders)
+					r.Post("/", apiHandler.SetProvider)
+					r.Post("/import", apiHandler.ImportProviders)
+					r.Get("/status", apiHandler.GetProviderStatus)
+					r.Delete("/{provider}", apiHandler.RemoveProvider)
+				})
 
-			// Statistics
-			r.Get("/stats/overview", apiHandler.GetOverview)
-			r.Get("/stats/daily", apiHandler.GetDailyStats)
+				// Statistics
+				r.Get("/stats/overview", apiHandler.GetOverview)
+				r.Get("/stats/daily", apiHandler.GetDailyStats)
+				r.Get("/stats/daily-by-model", apiHandler.GetDailyStatsByModel)
 
-			// Logs
-			r.Get("/logs", apiHandler.SearchLogs)
-			r.Get("/logs/{id}", apiHandler.GetLog)
+				// Logs
+				r.Get("/logs", apiHandler.SearchLogs)
+				r.Get("/logs/{id}", apiHandler.GetLog)
+
+				// Administration
+				r.Route("/admin", func(r chi.Router) {
+					r.Use(apiHandler.AdminOnly)
+
+					r.Get("/denied-models", apiHandler.ListDeniedModels)
+					r.Post("/denied-models", apiHandler.DenyModel)
+					r.Delete("/denied-models", apiHandler.AllowModel)
+
+					r.Post("/users", apiHandler.CreateUser)
+					r.Put("/users/{id}/key-limit", apiHandler.SetUserKeyLimit)
+
+					r.Post("/break-glass/provider-key", apiHandler.RevealProviderKey)
+
+					r.Post("/encryption/rotate", apiHandler.RotateEncryption)
+					r.Get("/encryption/status", apiHandler.GetEncryptionStatus)
+				})
+			})
 		})
 	})
 
-	// LLM Proxy routes (OpenAI compatible)
-	r.Route("/v1", func(r chi.Router) {
-		r.Post("/chat/completions", proxyHandler.ChatCompletions)
-		r.Post("/completions", proxyHandler.Completions)
-		r.Post("/embeddings", proxyHandler.Embeddings)
-	})
+	// Proxy routes have no request timeout; they apply their own upstream deadlines,
+	// which are longer for streams
+	proxyRoutes := func(r chi.Router) {
+		// LLM Proxy routes (OpenAI compatible)
+		r.Route("/v1", func(r chi.Router) {
+			r.Post("/chat/completions", proxyHandler.ChatCompletions)
+			r.Post("/completions", proxyHandler.Completions)
+			r.Post("/embeddings", proxyHandler.Embeddings)
+		})
 
-	// Anthropic proxy routes
-	r.Route("/anthropic", func(r chi.Router) {
-		r.Post("/v1/messages", proxyHandler.AnthropicMessages)
-	})
+		// Anthropic proxy routes, passing requests through in Anthropic's own format
+		r.Route("/anthropic", func(r chi.Router) {
+			r.Post("/v1/messages", proxyHandler.AnthropicNativeMessages)
+		})
 
-	// Create server
-	srv := &http.Server{
-		Addr:         ":" + cfg.Port,
-		Handler:      r,
-		ReadTimeout:  30 * time.Second,
-		WriteTimeout: 120 * time.Second,
-		IdleTimeout:  120 * time.Second,
+		// Lumina-native proxy routes with stricter request rules
+		r.Route("/lumina/v2", proxyHandler.WithVersion(proxy.V2).Mount)
 	}
 
-	// Start server in goroutine
-	go func() {
-		slog.Info("server listening", "addr", srv.Addr)
-		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			slog.Error("server error", "error", err)
+	// Create servers. With a client CA configured the proxy routes are only served
+	// on a separate listener requiring verified client certificates.
+	var servers []*http.Server
+	if cfg.ProxyClientCA == "" {
+		r.Group(proxyRoutes)
+		servers = append(servers, newServer(cfg, cfg.Port, r))
+	} else {
+		tlsConfig, err := proxy.ClientCATLSConfig(cfg.ProxyClientCA)
+		if err != nil {
+			slog.Error("failed to load proxy client CA", "error", err)
 			os.Exit(1)
 		}
-	}()
+
+		pr := chi.NewRouter()
+		pr.Use(middleware.RequestID)
+		pr.Use(middleware.RealIP)
+		pr.Use(middleware.Logger)
+		pr.Use(middleware.Recoverer)
+		pr.Get("/health", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte(`{"status":"ok"}`))
+		})
+		proxyRoutes(pr)
+
+		mtlsSrv := newServer(cfg, cfg.ProxyMTLSPort, pr)
+		mtlsSrv.TLSConfig = tlsConfig
+		servers = append(servers, newServer(cfg, cfg.Port, r), mtlsSrv)
+	}
+
+	// Start servers in goroutines
+	for _, s := range servers {
+		go func() {
+			ln, err := limitListen(s.Addr, cfg.MaxConnections)
+			if err != nil {
+				slog.Error("server error", "addr", s.Addr, "error", err)
+				os.Exit(1)
+			}
+
+			slog.Info("server listening", "addr", s.Addr, "tls", cfg.TLSCertFile != "", "client_certs", s.TLSConfig != nil)
+			if cfg.TLSCertFile != "" {
+				err = s.ServeTLS(ln, cfg.TLSCertFile, cfg.TLSKeyFile)
+			} else {
+				err = s.Serve(ln)
+			}
+			if err != nil && err != http.ErrServerClosed {
+				slog.Error("server error", "addr", s.Addr, "error", err)
+				os.Exit(1)
+			}
+		}()
+	}
 
 	// Graceful shutdown
 	quit := make(chan os.Signal, 1)
@@ -182,9 +402,51 @@ func main() {
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
-	if err := srv.Shutdown(ctx); err != nil {
-		slog.Error("server forced to shutdown", "error", err)
+	// End live log tails, which would otherwise keep their connections open
+	logPipeline.EndTails()
+
+	// Stop accepting requests and wait for in-flight ones, including open streams
+	var wg sync.WaitGroup
+	for _, s := range servers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := s.Shutdown(ctx); err != nil {
+				slog.Error("server forced to shutdown", "addr", s.Addr, "error", err)
+			}
+		}()
 	}
+	wg.Wait()
+
+	// Let queued spend and detached usage updates finish before their connections close
+	if err := keyService.Drain(ctx); err != nil {
+		slog.Error("background work did not finish before shutdown", "error", err)
+	}
+
+	// Flush the remaining log entries now that no request can add more
+	logPipeline.Close()
 
 	slog.Info("server stopped")
 }
+
+// newServer creates an HTTP server for handler on port
+func newServer(cfg *config.Config, port string, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              ":" + port,
+		Handler:           handler,
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		ReadTimeout:       30 * time.Second,
+		WriteTimeout:      writeTimeout(cfg),
+		IdleTimeout:       120 * time.Second,
+		MaxHeaderBytes:    cfg.MaxHeaderBytes,
+	}
+}
+
+// writeTimeout leaves room for the longest upstream call plus reading the request
+// and writing the error or final event; without an upstream deadline there is none
+func writeTimeout(cfg *config.Config) time.Duration {
+	if cfg.UpstreamTimeout == 0 || cfg.StreamTimeout == 0 {
+		return 0
+	}
+	return max(cfg.UpstreamTimeout, cfg.StreamTimeout) + 30*time.Second
+}
@@ -0,0 +1,44 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+)
+
+const (
+	tokenCookieName   = "token"
+	tokenCookieMaxAge = 86400 // 24 hours, matching the JWT lifetime
+)
+
+// SetCookiePolicy configures the session cookie. sameSite is "lax", "strict" or
+// "none"; "none" lets a dashboard on another site send the cookie and requires
+// secure, since browsers reject SameSite=None cookies without the Secure flag.
+func (h *Handler) SetCookiePolicy(sameSite string, secure bool) {
+	switch strings.ToLower(sameSite) {
+	case "strict":
+		h.cookieSameSite = http.SameSiteStrictMode
+	case "none":
+		h.cookieSameSite = http.SameSiteNoneMode
+	default:
+		h.cookieSameSite = http.SameSiteLaxMode
+	}
+	h.cookieSecure = secure
+}
+
+// setTokenCookie stores the session token in a cookie; an empty token clears it
+func (h *Handler) setTokenCookie(w http.ResponseWriter, token string) {
+	maxAge := tokenCookieMaxAge
+	if token == "" {
+		maxAge = -1
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     tokenCookieName,
+		Value:    token,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   h.cookieSecure,
+		SameSite: h.cookieSameSite,
+		MaxAge:   maxAge,
+	})
+}
@@ -1,9 +1,18 @@
 package api
 
 import (
+	"database/sql"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net"
 	"net/http"
+	"net/url"
+	"slices"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/go-chi/chi/v5"
@@ -13,25 +22,54 @@ import (
 	"github.com/lumina/gateway/internal/database"
 	"github.com/lumina/gateway/internal/logging"
 	"github.com/lumina/gateway/internal/models"
+	"github.com/lumina/gateway/internal/pricing"
 )
 
 // Handler handles dashboard API requests
 type Handler struct {
-	db          *database.DB
-	keyService  *auth.KeyService
-	jwtManager  *auth.JWTManager
-	logPipeline *logging.Pipeline
+	db                  *database.DB
+	keyService          *auth.KeyService
+	jwtManager          *auth.JWTManager
+	sessions            *auth.SessionService
+	logPipeline         *logging.Pipeline
+	registrationEnabled bool
+	maxLogPageSize      int
+	cookieSameSite      http.SameSite
+	cookieSecure        bool
 }
 
+// maxLogSearchWindow is OpenSearch's default index.max_result_window
+const maxLogSearchWindow = 10000
+
 // NewHandler creates a new API handler
 func NewHandler(db *database.DB, keyService *auth.KeyService, jwtManager *auth.JWTManager) *Handler {
 	return &Handler{
-		db:         db,
-		keyService: keyService,
-		jwtManager: jwtManager,
+		db:                  db,
+		keyService:          keyService,
+		jwtManager:          jwtManager,
+		registrationEnabled: true,
+		maxLogPageSize:      100,
+		cookieSameSite:      http.SameSiteLaxMode,
 	}
 }
 
+// SetMaxLogPageSize sets the largest page of log entries SearchLogs returns
+func (h *Handler) SetMaxLogPageSize(size int) {
+	h.maxLogPageSize = size
+}
+
+// SetRegistrationEnabled controls whether anyone can sign up through Register.
+// When disabled, only the first user of a deployment can register; admins
+// create every other account.
+func (h *Handler) SetRegistrationEnabled(enabled bool) {
+	h.registrationEnabled = enabled
+}
+
+// SetSessions sets the session service that issues and revokes dashboard logins
+func (h *Handler) SetSessions(sessions *auth.SessionService) {
+	h.sessions = sessions
+}
+
 // SetLogPipeline sets the log pipeline (called after initialization)
 func (h *Handler) SetLogPipeline(pipeline *logging.Pipeline) {
 	h.logPipeline = pipeline
@@ -47,55 +85,88 @@ func (h *Handler) Register(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if req.Email == "" || req.Password == "" {
-		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "email and password required"})
+	if !h.registrationEnabled {
+		// Still let the first user in so a fresh deployment can bootstrap its admin
+		count, err := h.db.CountUsers(r.Context())
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "internal error"})
+			return
+		}
+		if count > 0 {
+			writeJSON(w, http.StatusForbidden, map[string]string{"error": "registration is disabled"})
+			return
+		}
+	}
+
+	user, ok := h.createUser(w, r, req.Email, req.Password)
+	if !ok {
+		return
+	}
+
+	token, ok := h.startSession(w, r, user)
+	if !ok {
 		return
 	}
 
+	writeJSON(w, http.StatusCreated, models.AuthResponse{User: user, Token: token})
+}
+
+// startSession records a session for the user's login and sets its token cookie,
+// writing the error response and returning false on failure
+func (h *Handler) startSession(w http.ResponseWriter, r *http.Request, user *models.User) (string, bool) {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+
+	token, err := h.sessions.Start(r.Context(), user, ip, r.UserAgent())
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to generate token"})
+		return "", false
+	}
+
+	h.setTokenCookie(w, token)
+	return token, true
+}
+
+// createUser validates credentials and creates the user, writing the error
+// response and returning false on failure
+func (h *Handler) createUser(w http.ResponseWriter, r *http.Request, email, password string) (*models.User, bool) {
+	var v validator
+	v.check(email != "", "email", "is required")
+	v.check(email == "" || strings.Contains(email, "@"), "email", "must be a valid email address")
+	v.check(password != "", "password", "is required")
+	if !v.valid() {
+		v.writeErrors(w)
+		return nil, false
+	}
+
 	// Check if user exists
-	existing, err := h.db.GetUserByEmail(r.Context(), req.Email)
+	existing, err := h.db.GetUserByEmail(r.Context(), email)
 	if err != nil {
 		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "internal error"})
-		return
+		return nil, false
 	}
 	if existing != nil {
 		writeJSON(w, http.StatusConflict, map[string]string{"error": "email already registered"})
-		return
+		return nil, false
 	}
 
 	// Hash password
-	hash, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 	if err != nil {
 		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "internal error"})
-		return
+		return nil, false
 	}
 
 	// Create user
-	user, err := h.db.CreateUser(r.Context(), req.Email, string(hash))
+	user, err := h.db.CreateUser(r.Context(), email, string(hash))
 	if err != nil {
 		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to create user"})
-		return
-	}
-
-	// Generate token
-	token, err := h.jwtManager.GenerateToken(user.ID, user.Email)
-	if err != nil {
-		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to generate token"})
-		return
+		return nil, false
 	}
 
-	// Set cookie
-	http.SetCookie(w, &http.Cookie{
-		Name:     "token",
-		Value:    token,
-		Path:     "/",
-		HttpOnly: true,
-		Secure:   false, // Set to true in production with HTTPS
-		SameSite: http.SameSiteLaxMode,
-		MaxAge:   86400, // 24 hours
-	})
-
-	writeJSON(w, http.StatusCreated, models.AuthResponse{User: user, Token: token})
+	return user, true
 }
 
 // Login handles user login
@@ -106,6 +177,14 @@ func (h *Handler) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	var v validator
+	v.check(req.Email != "", "email", "is required")
+	v.check(req.Password != "", "password", "is required")
+	if !v.valid() {
+		v.writeErrors(w)
+		return
+	}
+
 	// Get user
 	user, err := h.db.GetUserByEmail(r.Context(), req.Email)
 	if err != nil {
@@ -123,36 +202,25 @@ func (h *Handler) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Generate token
-	token, err := h.jwtManager.GenerateToken(user.ID, user.Email)
-	if err != nil {
-		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to generate token"})
+	token, ok := h.startSession(w, r, user)
+	if !ok {
 		return
 	}
 
-	// Set cookie
-	http.SetCookie(w, &http.Cookie{
-		Name:     "token",
-		Value:    token,
-		Path:     "/",
-		HttpOnly: true,
-		Secure:   false, // Set to true in production with HTTPS
-		SameSite: http.SameSiteLaxMode,
-		MaxAge:   86400, // 24 hours
-	})
-
 	writeJSON(w, http.StatusOK, models.AuthResponse{User: user, Token: token})
 }
 
-// Logout handles user logout
+// Logout handles user logout, revoking the current session
 func (h *Handler) Logout(w http.ResponseWriter, r *http.Request) {
-	http.SetCookie(w, &http.Cookie{
-		Name:     "token",
-		Value:    "",
-		Path:     "/",
-		HttpOnly: true,
-		MaxAge:   -1,
-	})
+	if sessionID := auth.GetSessionID(r.Context()); sessionID != "" {
+		err := h.sessions.Revoke(r.Context(), auth.GetUserID(r.Context()), sessionID)
+		if err != nil && !errors.Is(err, auth.ErrSessionNotFound) {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to revoke session"})
+			return
+		}
+	}
+
+	h.setTokenCookie(w, "")
 
 	writeJSON(w, http.StatusOK, map[string]string{"message": "logged out"})
 }
@@ -169,6 +237,37 @@ func (h *Handler) Me(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, http.StatusOK, user)
 }
 
+// ListSessions lists the user's active sessions, marking the one making the request
+func (h *Handler) ListSessions(w http.ResponseWriter, r *http.Request) {
+	sessions, err := h.sessions.List(r.Context(), auth.GetUserID(r.Context()), auth.GetSessionID(r.Context()))
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to list sessions"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, sessions)
+}
+
+// RevokeSession signs one of the user's sessions out
+func (h *Handler) RevokeSession(w http.ResponseWriter, r *http.Request) {
+	sessionID := chi.URLParam(r, "id")
+
+	if err := h.sessions.Revoke(r.Context(), auth.GetUserID(r.Context()), sessionID); err != nil {
+		if errors.Is(err, auth.ErrSessionNotFound) {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "session not found"})
+			return
+		}
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to revoke session"})
+		return
+	}
+
+	if sessionID == auth.GetSessionID(r.Context()) {
+		h.setTokenCookie(w, "")
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{"message": "session revoked"})
+}
+
 // Key management handlers
 
 // ListKeys lists all virtual keys for the user
@@ -200,13 +299,46 @@ func (h *Handler) CreateKey(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if req.Name == "" {
-		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "name is required"})
+	h.createKey(w, r, userID, &req)
+}
+
+// ImportKey creates a new key, with a fresh secret, from an exported configuration
+func (h *Handler) ImportKey(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserID(r.Context())
+
+	var export models.KeyExport
+	if err := json.NewDecoder(r.Body).Decode(&export); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request body"})
+		return
+	}
+	if export.Version != models.KeyExportVersion {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("unsupported export version %d", export.Version)})
+		return
+	}
+
+	h.createKey(w, r, userID, &export.Config)
+}
+
+// createKey validates and creates a key for CreateKey and ImportKey
+func (h *Handler) createKey(w http.ResponseWriter, r *http.Request, userID string, req *models.CreateKeyRequest) {
+	var v validator
+	v.check(req.Name != "", "name", "is required")
+	validateKeySettings(&v, req.AllowedModels, req.BudgetLimit, &req.LogBodyMode, req.LogSampleRate, &req.StreamBudget, &req.BudgetMode, req.AllowedOrigins, req.ParamLimits, req.ModelRoutes, &req.MaxMessages, &req.MaxPromptTokens, &req.OpenAIOrganization, &req.OpenAIProject, req.RequestQuota)
+	if !v.valid() {
+		v.writeErrors(w)
 		return
 	}
 
-	resp, err := h.keyService.CreateKey(r.Context(), userID, &req)
+	resp, err := h.keyService.CreateKey(r.Context(), userID, req)
 	if err != nil {
+		if errors.Is(err, auth.ErrBudgetRequired) || errors.Is(err, auth.ErrBudgetTooHigh) {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		if errors.Is(err, auth.ErrKeyLimitReached) {
+			writeJSON(w, http.StatusForbidden, map[string]string{"error": err.Error()})
+			return
+		}
 		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to create key"})
 		return
 	}
@@ -214,6 +346,118 @@ func (h *Handler) CreateKey(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, http.StatusCreated, resp)
 }
 
+// validateKeySettings checks the settings shared by key creation and updates
+func validateKeySettings(v *validator, allowedModels []string, budgetLimit *float64, logBodyMode *models.LogBodyMode, logSampleRate *float64, streamBudget *models.StreamBudgetMode, budgetMode *models.BudgetMode, allowedOrigins []string, paramLimits map[string]models.ParamLimit, modelRoutes map[string]models.ModelRoute, maxMessages, maxPromptTokens *int, openAIOrganization, openAIProject *string, requestQuota *int64) {
+	for _, pattern := range allowedModels {
+		if pattern == "" {
+			v.check(false, "allowed_models", "must not contain empty patterns")
+			break
+		}
+	}
+	v.check(budgetLimit == nil || *budgetLimit >= 0, "budget_limit", "must not be negative")
+	v.check(logBodyMode == nil || *logBodyMode == "" || logBodyMode.Valid(), "log_body_mode", "must be 'full', 'truncated' or 'metadata'")
+	v.check(logSampleRate == nil || (*logSampleRate >= 0 && *logSampleRate <= 1), "log_sample_rate", "must be between 0 and 1")
+	v.check(streamBudget == nil || *streamBudget == "" || streamBudget.Valid(), "stream_budget_mode", "must be 'flag' or 'abort'")
+	v.check(budgetMode == nil || *budgetMode == "" || budgetMode.Valid(), "budget_mode", "must be 'hard' or 'soft'")
+	for _, origin := range allowedOrigins {
+		if !validOrigin(origin) {
+			v.check(false, "allowed_origins", "must be web origins such as 'https://app.example.com', without a path")
+			break
+		}
+	}
+	v.check(maxMessages == nil || *maxMessages >= 0, "max_messages", "must not be negative")
+	v.check(maxPromptTokens == nil || *maxPromptTokens >= 0, "max_prompt_tokens", "must not be negative")
+	v.check(requestQuota == nil || *requestQuota >= 0, "request_quota", "must not be negative")
+	v.check(openAIOrganization == nil || validOpenAIScope(*openAIOrganization, "org-"), "openai_organization", "must be an OpenAI organization ID such as 'org-...'")
+	v.check(openAIProject == nil || validOpenAIScope(*openAIProject, "proj_"), "openai_project", "must be an OpenAI project ID such as 'proj_...'")
+	if (openAIOrganization != nil && *openAIOrganization != "") || (openAIProject != nil && *openAIProject != "") {
+		v.check(len(allowedModels) == 0 || slices.ContainsFunc(allowedModels, mayMatchOpenAI), "allowed_models", "must include OpenAI models when an OpenAI organization or project is set")
+	}
+	for name, route := range modelRoutes {
+		if !validModelRoute(name, route) {
+			v.check(false, "model_routes", fmt.Sprintf("%q must be a name without '/', with tiers in ascending order of positive max_prompt_tokens and every model given as 'provider/model'", name))
+			break
+		}
+	}
+	for param, limit := range paramLimits {
+		if !validParamLimit(param, limit) {
+			v.check(false, "param_limits", fmt.Sprintf("%q must be one of temperature, top_p, top_k, max_tokens, frequency_penalty or presence_penalty, with min at most max, a default between them and whole numbers for top_k and max_tokens", param))
+			break
+		}
+	}
+}
+
+// validOpenAIScope reports whether id is empty or an OpenAI organization or project ID
+// with the given prefix, which is safe to send as a header
+func validOpenAIScope(id, prefix string) bool {
+	if id == "" {
+		return true
+	}
+	rest, ok := strings.CutPrefix(id, prefix)
+	if !ok || rest == "" || len(id) > 64 {
+		return false
+	}
+	for _, c := range rest {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+// mayMatchOpenAI reports whether an allowed model pattern can match OpenAI models
+func mayMatchOpenAI(pattern string) bool {
+	return pattern == "*" || strings.HasPrefix(pattern, "openai/") || strings.HasPrefix(pattern, "*/")
+}
+
+// validModelRoute reports whether route is a usable route for the logical model name
+func validModelRoute(name string, route models.ModelRoute) bool {
+	if name == "" || strings.Contains(name, "/") || !strings.Contains(route.Default, "/") {
+		return false
+	}
+	previous := 0
+	for _, tier := range route.Tiers {
+		if tier.MaxPromptTokens <= previous || !strings.Contains(tier.Model, "/") {
+			return false
+		}
+		previous = tier.MaxPromptTokens
+	}
+	return true
+}
+
+// validParamLimit reports whether limit is a consistent limit for a known parameter,
+// in whole numbers for parameters that take them
+func validParamLimit(param string, limit models.ParamLimit) bool {
+	integer, ok := models.LimitedParams[param]
+	if !ok {
+		return false
+	}
+	for _, value := range []*float64{limit.Min, limit.Max, limit.Default} {
+		if value != nil && integer && *value != math.Trunc(*value) {
+			return false
+		}
+	}
+	if limit.Min != nil && limit.Max != nil && *limit.Min > *limit.Max {
+		return false
+	}
+	if limit.Default != nil {
+		if (limit.Min != nil && *limit.Default < *limit.Min) || (limit.Max != nil && *limit.Default > *limit.Max) {
+			return false
+		}
+	}
+	return true
+}
+
+// validOrigin reports whether s is an http(s) origin: a scheme and host, optionally with a port
+func validOrigin(s string) bool {
+	u, err := url.Parse(s)
+	if err != nil {
+		return false
+	}
+	return (u.Scheme == "http" || u.Scheme == "https") && u.Host != "" && u.User == nil &&
+		(u.Path == "" || u.Path == "/") && u.RawQuery == "" && u.Fragment == ""
+}
+
 // GetKey gets a single key by ID
 func (h *Handler) GetKey(w http.ResponseWriter, r *http.Request) {
 	userID := auth.GetUserID(r.Context())
@@ -239,6 +483,51 @@ func (h *Handler) GetKey(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, http.StatusOK, key)
 }
 
+// ExportKey returns a key's configuration, without its secret, for ImportKey
+func (h *Handler) ExportKey(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserID(r.Context())
+	keyID := chi.URLParam(r, "id")
+
+	key, err := h.keyService.GetKey(r.Context(), keyID, userID)
+	if err != nil {
+		if err.Error() == "key not found" {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "key not found"})
+			return
+		}
+		if err.Error() == "unauthorized" {
+			writeJSON(w, http.StatusForbidden, map[string]string{"error": "forbidden"})
+			return
+		}
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to get key"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, models.KeyExport{
+		Version:    models.KeyExportVersion,
+		ExportedAt: time.Now().UTC(),
+		Config: models.CreateKeyRequest{
+			Name:               key.Name,
+			AllowedModels:      key.AllowedModels,
+			BudgetLimit:        key.BudgetLimit,
+			LogBodyMode:        key.LogBodyMode,
+			LogSampleRate:      key.LogSampleRate,
+			StreamBudget:       key.StreamBudget,
+			Debug:              key.Debug,
+			BudgetMode:         key.BudgetMode,
+			DebugCapture:       key.DebugCapture,
+			AllowedOrigins:     key.AllowedOrigins,
+			AutoRevokeExempt:   key.AutoRevokeExempt,
+			ParamLimits:        key.ParamLimits,
+			ModelRoutes:        key.ModelRoutes,
+			MaxMessages:        key.MaxMessages,
+			MaxPromptTokens:    key.MaxPromptTokens,
+			OpenAIOrganization: key.OpenAIOrganization,
+			OpenAIProject:      key.OpenAIProject,
+			RequestQuota:       key.RequestQuota,
+		},
+	})
+}
+
 // RevokeKey revokes a virtual key
 func (h *Handler) RevokeKey(w http.ResponseWriter, r *http.Request) {
 	userID := auth.GetUserID(r.Context())
@@ -260,6 +549,196 @@ func (h *Handler) RevokeKey(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, http.StatusOK, map[string]string{"message": "key revoked"})
 }
 
+// GetKeyConfig returns the effective configuration the proxy applies to a key
+func (h *Handler) GetKeyConfig(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserID(r.Context())
+	keyID := chi.URLParam(r, "id")
+
+	config, err := h.keyService.GetEffectiveConfig(r.Context(), keyID, userID)
+	if err != nil {
+		if err.Error() == "key not found" {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "key not found"})
+			return
+		}
+		if err.Error() == "unauthorized" {
+			writeJSON(w, http.StatusForbidden, map[string]string{"error": "forbidden"})
+			return
+		}
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to get key config"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, config)
+}
+
+// maxActivityHours bounds the range of GetKeyActivity
+const maxActivityHours = 7 * 24
+
+// GetKeyActivity returns hourly request, error and cost counts for one key over
+// the last `hours` hours (24 by default)
+func (h *Handler) GetKeyActivity(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserID(r.Context())
+	keyID := chi.URLParam(r, "id")
+
+	if h.logPipeline == nil {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "logging not available"})
+		return
+	}
+
+	hours := 24
+	if s := r.URL.Query().Get("hours"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > maxActivityHours {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("hours must be between 1 and %d", maxActivityHours)})
+			return
+		}
+		hours = n
+	}
+
+	// Verify ownership before querying the logs
+	if _, err := h.keyService.GetKey(r.Context(), keyID, userID); err != nil {
+		if err.Error() == "key not found" {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "key not found"})
+			return
+		}
+		if err.Error() == "unauthorized" {
+			writeJSON(w, http.StatusForbidden, map[string]string{"error": "forbidden"})
+			return
+		}
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to get key"})
+		return
+	}
+
+	endDate := time.Now()
+	startDate := endDate.Add(-time.Duration(hours) * time.Hour)
+
+	buckets, err := h.logPipeline.GetKeyActivity(r.Context(), userID, keyID, startDate, endDate)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to get key activity"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, models.KeyActivity{KeyID: keyID, Buckets: buckets})
+}
+
+// GetKeyErrors returns the key's most recent failed requests with their logged errors
+func (h *Handler) GetKeyErrors(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserID(r.Context())
+	keyID := chi.URLParam(r, "id")
+
+	if h.logPipeline == nil {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "logging not available"})
+		return
+	}
+
+	hours := 24
+	if s := r.URL.Query().Get("hours"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > maxActivityHours {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("hours must be between 1 and %d", maxActivityHours)})
+			return
+		}
+		hours = n
+	}
+
+	limit := min(20, h.maxLogPageSize)
+	if s := r.URL.Query().Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "limit must be a positive integer"})
+			return
+		}
+		limit = min(n, h.maxLogPageSize)
+	}
+
+	// Verify ownership before querying the logs
+	if _, err := h.keyService.GetKey(r.Context(), keyID, userID); err != nil {
+		if err.Error() == "key not found" {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "key not found"})
+			return
+		}
+		if err.Error() == "unauthorized" {
+			writeJSON(w, http.StatusForbidden, map[string]string{"error": "forbidden"})
+			return
+		}
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to get key"})
+		return
+	}
+
+	startDate := time.Now().Add(-time.Duration(hours) * time.Hour)
+	entries, _, err := h.logPipeline.Search(r.Context(), logging.SearchParams{
+		UserID:    userID,
+		KeyID:     keyID,
+		MinStatus: 400,
+		StartDate: &startDate,
+		Size:      limit,
+	})
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to get key errors"})
+		return
+	}
+
+	errs := make([]models.KeyError, 0, len(entries))
+	for _, entry := range entries {
+		errs = append(errs, models.KeyError{
+			TraceID:    entry.TraceID,
+			Timestamp:  entry.Timestamp,
+			Model:      entry.Request.Model,
+			StatusCode: entry.Response.StatusCode,
+			Error:      entry.Response.Error,
+		})
+	}
+
+	writeJSON(w, http.StatusOK, models.KeyErrors{KeyID: keyID, Errors: errs})
+}
+
+// CreateShareToken mints a signed, expiring token granting constrained use of a key,
+// e.g. for a demo, without handing out the key itself
+func (h *Handler) CreateShareToken(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserID(r.Context())
+	keyID := chi.URLParam(r, "id")
+
+	var req models.CreateShareTokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request body"})
+		return
+	}
+
+	maxTTL := int(auth.MaxShareTokenTTL / time.Second)
+	var v validator
+	v.check(req.ExpiresIn >= 0 && req.ExpiresIn <= maxTTL, "expires_in", fmt.Sprintf("must be between 1 and %d seconds, or omitted for one hour", maxTTL))
+	v.check(req.Model == "" || (strings.Contains(req.Model, "/") && !strings.ContainsAny(req.Model, "*?[")), "model", "must be a single model such as 'openai/gpt-4o-mini'")
+	v.check(req.MaxRequests >= 0, "max_requests", "must not be negative")
+	if !v.valid() {
+		v.writeErrors(w)
+		return
+	}
+
+	token, err := h.keyService.CreateShareToken(r.Context(), keyID, userID, &req)
+	if err != nil {
+		if err.Error() == "key not found" {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "key not found"})
+			return
+		}
+		if err.Error() == "unauthorized" {
+			writeJSON(w, http.StatusForbidden, map[string]string{"error": "forbidden"})
+			return
+		}
+		if errors.Is(err, auth.ErrShareTokensDisabled) {
+			writeJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "share tokens are not enabled on this gateway"})
+			return
+		}
+		if errors.Is(err, auth.ErrKeyRevoked) || errors.Is(err, auth.ErrModelNotAllowed) {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to create share token"})
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, token)
+}
+
 // UpdateKey updates a virtual key
 func (h *Handler) UpdateKey(w http.ResponseWriter, r *http.Request) {
 	userID := auth.GetUserID(r.Context())
@@ -271,6 +750,14 @@ func (h *Handler) UpdateKey(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	var v validator
+	v.check(req.Name == nil || *req.Name != "", "name", "must not be empty")
+	validateKeySettings(&v, req.AllowedModels, req.BudgetLimit, req.LogBodyMode, req.LogSampleRate, req.StreamBudget, req.BudgetMode, req.AllowedOrigins, req.ParamLimits, req.ModelRoutes, req.MaxMessages, req.MaxPromptTokens, req.OpenAIOrganization, req.OpenAIProject, req.RequestQuota)
+	if !v.valid() {
+		v.writeErrors(w)
+		return
+	}
+
 	if err := h.keyService.UpdateKey(r.Context(), keyID, userID, &req); err != nil {
 		if err.Error() == "key not found" {
 			writeJSON(w, http.StatusNotFound, map[string]string{"error": "key not found"})
@@ -280,6 +767,10 @@ func (h *Handler) UpdateKey(w http.ResponseWriter, r *http.Request) {
 			writeJSON(w, http.StatusForbidden, map[string]string{"error": "forbidden"})
 			return
 		}
+		if errors.Is(err, auth.ErrBudgetRequired) || errors.Is(err, auth.ErrBudgetTooHigh) {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
 		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to update key"})
 		return
 	}
@@ -293,12 +784,40 @@ func (h *Handler) UpdateKey(w http.ResponseWriter, r *http.Request) {
 func (h *Handler) ListProviders(w http.ResponseWriter, r *http.Request) {
 	userID := auth.GetUserID(r.Context())
 
-	providers, err := h.keyService.GetUserProviders(r.Context(), userID)
+	filter := models.ProviderFilter{
+		Provider: models.ProviderType(r.URL.Query().Get("provider")),
+		Label:    r.URL.Query().Get("label"),
+	}
+
+	// Pagination is opt-in so existing clients keep receiving every provider
+	if s := r.URL.Query().Get("size"); s != "" {
+		size, err := strconv.Atoi(s)
+		if err != nil || size < 1 || size > 100 {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "size must be between 1 and 100"})
+			return
+		}
+		filter.Limit = size
+	}
+
+	if p := r.URL.Query().Get("page"); p != "" {
+		page, err := strconv.Atoi(p)
+		if err != nil || page < 0 {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "page must be a non-negative integer"})
+			return
+		}
+		if filter.Limit == 0 {
+			filter.Limit = 20
+		}
+		filter.Offset = page * filter.Limit
+	}
+
+	providers, total, err := h.keyService.GetUserProviders(r.Context(), userID, filter)
 	if err != nil {
 		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to list providers"})
 		return
 	}
 
+	w.Header().Set("X-Total-Count", strconv.Itoa(total))
 	writeJSON(w, http.StatusOK, providers)
 }
 
@@ -312,7 +831,7 @@ func (h *Handler) SetProvider(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if req.Provider != models.ProviderOpenAI && req.Provider != models.ProviderAnthropic {
+	if !req.Provider.Valid() {
 		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "provider must be 'openai' or 'anthropic'"})
 		return
 	}
@@ -322,7 +841,12 @@ func (h *Handler) SetProvider(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := h.keyService.SetUserProvider(r.Context(), userID, req.Provider, req.APIKey); err != nil {
+	if msg := validateProviderModels(req.Models); msg != "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": msg})
+		return
+	}
+
+	if err := h.keyService.SetUserProvider(r.Context(), userID, req); err != nil {
 		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to set provider"})
 		return
 	}
@@ -330,18 +854,89 @@ func (h *Handler) SetProvider(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, http.StatusOK, map[string]string{"message": "provider configured"})
 }
 
+// validateProviderModels checks the model patterns of a provider key, which are bare
+// model names (without the provider prefix) that may end in '*'
+func validateProviderModels(patterns []string) string {
+	for _, pattern := range patterns {
+		if pattern == "" || strings.Contains(pattern, "/") {
+			return "models must be non-empty model names without a provider prefix, e.g. 'gpt-4o*'"
+		}
+	}
+	return ""
+}
+
+// maxProviderImport bounds the number of provider keys imported in one request
+const maxProviderImport = 100
+
+// ImportProviders sets several provider API keys at once, e.g. when migrating from
+// another gateway. Invalid items are reported individually; valid ones are saved
+// together in a single transaction.
+func (h *Handler) ImportProviders(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserID(r.Context())
+
+	var reqs []models.SetProviderRequest
+	if err := json.NewDecoder(r.Body).Decode(&reqs); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request body; expected an array of providers"})
+		return
+	}
+
+	if len(reqs) == 0 || len(reqs) > maxProviderImport {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("between 1 and %d providers can be imported at once", maxProviderImport)})
+		return
+	}
+
+	results := make([]models.ImportProviderResult, len(reqs))
+	var valid []models.SetProviderRequest
+	var validIdx []int
+	seen := map[models.ProviderType]bool{}
+
+	for i, req := range reqs {
+		results[i] = models.ImportProviderResult{Index: i, Provider: req.Provider}
+		switch {
+		case !req.Provider.Valid():
+			results[i].Error = "provider must be 'openai' or 'anthropic'"
+		case req.APIKey == "":
+			results[i].Error = "api_key is required"
+		case validateProviderModels(req.Models) != "":
+			results[i].Error = validateProviderModels(req.Models)
+		case seen[req.Provider]:
+			results[i].Error = "provider appears more than once"
+		default:
+			seen[req.Provider] = true
+			valid = append(valid, req)
+			validIdx = append(validIdx, i)
+		}
+	}
+
+	if len(valid) > 0 {
+		err := h.keyService.ImportUserProviders(r.Context(), userID, valid)
+		for _, i := range validIdx {
+			if err != nil {
+				results[i].Error = "failed to save provider"
+			} else {
+				results[i].Success = true
+			}
+		}
+	}
+
+	resp := models.ImportProvidersResponse{Results: results}
+	for _, result := range results {
+		if result.Success {
+			resp.Imported++
+		} else {
+			resp.Failed++
+		}
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
 // RemoveProvider removes an account-level provider API key
 func (h *Handler) RemoveProvider(w http.ResponseWriter, r *http.Request) {
 	userID := auth.GetUserID(r.Context())
-	provider := chi.URLParam(r, "provider")
-
-	var providerType models.ProviderType
-	switch provider {
-	case "openai":
-		providerType = models.ProviderOpenAI
-	case "anthropic":
-		providerType = models.ProviderAnthropic
-	default:
+	providerType := models.ProviderType(chi.URLParam(r, "provider"))
+
+	if !providerType.Valid() {
 		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid provider"})
 		return
 	}
@@ -354,6 +949,235 @@ func (h *Handler) RemoveProvider(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, http.StatusOK, map[string]string{"message": "provider removed"})
 }
 
+// Admin handlers
+
+// AdminOnly rejects requests from users that aren't admins
+func (h *Handler) AdminOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, err := h.db.GetUserByID(r.Context(), auth.GetUserID(r.Context()))
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "internal error"})
+			return
+		}
+		if user == nil || !user.IsAdmin {
+			writeJSON(w, http.StatusForbidden, map[string]string{"error": "forbidden"})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// CreateUser lets an admin create an account, which works even when registration is disabled
+func (h *Handler) CreateUser(w http.ResponseWriter, r *http.Request) {
+	var req models.CreateUserRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request body"})
+		return
+	}
+
+	user, ok := h.createUser(w, r, req.Email, req.Password)
+	if !ok {
+		return
+	}
+
+	if req.IsAdmin && !user.IsAdmin {
+		if err := h.db.SetUserAdmin(r.Context(), user.ID, true); err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to grant admin"})
+			return
+		}
+		user.IsAdmin = true
+	}
+
+	writeJSON(w, http.StatusCreated, user)
+}
+
+// ListDeniedModels lists the model patterns disabled for every key
+func (h *Handler) ListDeniedModels(w http.ResponseWriter, r *http.Request) {
+	denied, err := h.db.ListDeniedModels(r.Context())
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to list denied models"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, denied)
+}
+
+// DenyModel disables a model pattern for every key, regardless of allow-lists
+func (h *Handler) DenyModel(w http.ResponseWriter, r *http.Request) {
+	var req models.DeniedModel
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request body"})
+		return
+	}
+
+	if req.Pattern == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "pattern is required"})
+		return
+	}
+
+	if err := h.keyService.DenyModel(r.Context(), req.Pattern, req.Reason); err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to deny model"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{"message": "model denied"})
+}
+
+// AllowModel removes a pattern from the model denylist. The pattern is passed as
+// a query parameter since patterns contain slashes.
+func (h *Handler) AllowModel(w http.ResponseWriter, r *http.Request) {
+	pattern := r.URL.Query().Get("pattern")
+	if pattern == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "pattern is required"})
+		return
+	}
+
+	if err := h.keyService.AllowModel(r.Context(), pattern); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "pattern not denied"})
+			return
+		}
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to allow model"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{"message": "model allowed"})
+}
+
+// SetUserKeyLimit overrides the maximum number of keys for a user
+func (h *Handler) SetUserKeyLimit(w http.ResponseWriter, r *http.Request) {
+	userID := chi.URLParam(r, "id")
+
+	var req models.SetKeyLimitRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request body"})
+		return
+	}
+
+	if req.MaxKeys != nil && *req.MaxKeys < 0 {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "max_keys must not be negative"})
+		return
+	}
+
+	if err := h.db.SetUserKeyLimit(r.Context(), userID, req.MaxKeys); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "user not found"})
+			return
+		}
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to set key limit"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{"message": "key limit updated"})
+}
+
+// RevealProviderKey is a break-glass lookup of which provider key served a request.
+// Every lookup is written to the audit log before anything is returned, and only
+// the key's fingerprint and label are revealed, never the key itself.
+func (h *Handler) RevealProviderKey(w http.ResponseWriter, r *http.Request) {
+	if h.logPipeline == nil {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "logging not available"})
+		return
+	}
+
+	var req models.BreakGlassRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request body"})
+		return
+	}
+	if req.TraceID == "" || strings.TrimSpace(req.Reason) == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "trace_id and reason are required"})
+		return
+	}
+
+	adminID := auth.GetUserID(r.Context())
+	if err := h.db.InsertAuditEntry(r.Context(), &models.AuditEntry{
+		AdminID:   adminID,
+		Action:    "provider_key.reveal",
+		Target:    req.TraceID,
+		Reason:    req.Reason,
+		CreatedAt: time.Now(),
+	}); err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to record audit entry"})
+		return
+	}
+	slog.Warn("break-glass provider key lookup", "admin_id", adminID, "trace_id", req.TraceID)
+
+	entry, err := h.logPipeline.GetLog(r.Context(), req.TraceID)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to get log"})
+		return
+	}
+	if entry == nil {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "log not found"})
+		return
+	}
+	if entry.ProviderKey == "" {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "no provider key fingerprint recorded for this request"})
+		return
+	}
+
+	reveal := models.ProviderKeyReveal{
+		TraceID:     entry.TraceID,
+		UserID:      entry.UserID,
+		Provider:    entry.Request.Provider,
+		Fingerprint: entry.ProviderKey,
+	}
+
+	provider, err := h.keyService.FindProviderKey(r.Context(), entry.UserID, entry.Request.Provider, entry.ProviderKey)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to look up provider key"})
+		return
+	}
+	if provider != nil {
+		reveal.ProviderID = provider.ID
+		reveal.Label = provider.Label
+		reveal.Current = true
+	}
+
+	writeJSON(w, http.StatusOK, reveal)
+}
+
+// RotateEncryption starts re-encrypting every account's provider keys under the
+// primary encryption key version in the background
+func (h *Handler) RotateEncryption(w http.ResponseWriter, r *http.Request) {
+	adminID := auth.GetUserID(r.Context())
+	version := h.keyService.PrimaryKeyVersion()
+
+	if err := h.db.InsertAuditEntry(r.Context(), &models.AuditEntry{
+		AdminID:   adminID,
+		Action:    "encryption.rotate",
+		Target:    fmt.Sprintf("version %d", version),
+		CreatedAt: time.Now(),
+	}); err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to record audit entry"})
+		return
+	}
+
+	rotation, err := h.keyService.StartEncryptionRotation(r.Context(), adminID)
+	if errors.Is(err, auth.ErrRotationInProgress) {
+		writeJSON(w, http.StatusConflict, map[string]string{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to start encryption rotation"})
+		return
+	}
+
+	slog.Info("encryption rotation started", "admin_id", adminID, "rotation_id", rotation.ID, "target_version", version, "total", rotation.Total)
+	writeJSON(w, http.StatusAccepted, rotation)
+}
+
+// GetEncryptionStatus reports the progress of rotating provider keys to the primary encryption key
+func (h *Handler) GetEncryptionStatus(w http.ResponseWriter, r *http.Request) {
+	status, err := h.keyService.EncryptionStatus(r.Context())
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to get encryption status"})
+		return
+	}
+	writeJSON(w, http.StatusOK, status)
+}
+
 // Stats handlers
 
 // GetOverview returns overview statistics
@@ -377,10 +1201,74 @@ func (h *Handler) GetOverview(w http.ResponseWriter, r *http.Request) {
 			overview.TotalRequests = stats.TotalRequests
 			overview.AvgLatency = stats.AvgLatency
 			overview.SuccessRate = stats.SuccessRate
+			overview.TotalTokens = stats.TotalTokens
+			overview.PromptTokens = stats.PromptTokens
+			overview.CompletionTokens = stats.CompletionTokens
 		}
 	}
 
-	writeJSON(w, http.StatusOK, overview)
+	overview.RequestQuotas, err = h.keyService.RequestQuotas(r.Context(), userID)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to get overview"})
+		return
+	}
+
+	writeNegotiated(w, r, http.StatusOK, overview, func() [][]string { return overviewCSV(overview) })
+}
+
+// Provider health is judged from all gateway traffic in a recent window
+const (
+	providerStatusWindow      = 15 * time.Minute
+	providerStatusMinRequests = 10   // Fewer requests report "unknown"
+	providerDegradedErrorRate = 0.05 // Error rate from which a provider is "degraded"
+	providerDownErrorRate     = 0.5  // Error rate from which a provider is "down"
+)
+
+// GetProviderCatalog lists the providers the gateway supports and a curated model
+// catalog with pricing, so frontends don't have to hardcode them
+func (h *Handler) GetProviderCatalog(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, pricing.Catalog())
+}
+
+// GetProviderStatus reports recent upstream error rates per provider, so users can
+// tell their own misconfiguration apart from a provider outage
+func (h *Handler) GetProviderStatus(w http.ResponseWriter, r *http.Request) {
+	if h.logPipeline == nil {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "logging not available"})
+		return
+	}
+
+	traffic, err := h.logPipeline.GetProviderTraffic(r.Context(), time.Now().Add(-providerStatusWindow))
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to get provider status"})
+		return
+	}
+
+	resp := models.ProviderStatusResponse{
+		WindowMinutes: int(providerStatusWindow.Minutes()),
+		Providers:     []models.ProviderStatus{},
+	}
+	for _, provider := range []models.ProviderType{models.ProviderOpenAI, models.ProviderAnthropic} {
+		status := traffic[string(provider)]
+		status.Provider = provider
+		if status.Requests > 0 {
+			status.ErrorRate = float64(status.Errors) / float64(status.Requests)
+		}
+
+		switch {
+		case status.Requests < providerStatusMinRequests:
+			status.Status = "unknown"
+		case status.ErrorRate >= providerDownErrorRate:
+			status.Status = "down"
+		case status.ErrorRate >= providerDegradedErrorRate:
+			status.Status = "degraded"
+		default:
+			status.Status = "up"
+		}
+		resp.Providers = append(resp.Providers, status)
+	}
+
+	writeJSON(w, http.StatusOK, resp)
 }
 
 // GetDailyStats returns daily statistics
@@ -409,7 +1297,41 @@ func (h *Handler) GetDailyStats(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	writeJSON(w, http.StatusOK, stats)
+	writeNegotiated(w, r, http.StatusOK, stats, func() [][]string { return dailyStatsCSV(stats) })
+}
+
+// GetDailyStatsByModel returns daily spend broken down by model for stacked charts
+func (h *Handler) GetDailyStatsByModel(w http.ResponseWriter, r *http.Request) {
+	if h.logPipeline == nil {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "logging not available"})
+		return
+	}
+
+	userID := auth.GetUserID(r.Context())
+
+	// Parse date range
+	endDate := time.Now()
+	startDate := endDate.AddDate(0, 0, -7) // Default to last 7 days
+
+	if start := r.URL.Query().Get("start"); start != "" {
+		if t, err := time.Parse("2006-01-02", start); err == nil {
+			startDate = t
+		}
+	}
+
+	if end := r.URL.Query().Get("end"); end != "" {
+		if t, err := time.Parse("2006-01-02", end); err == nil {
+			endDate = t
+		}
+	}
+
+	stats, err := h.logPipeline.GetDailyCostByModel(r.Context(), userID, startDate, endDate)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to get daily stats"})
+		return
+	}
+
+	writeNegotiated(w, r, http.StatusOK, stats, func() [][]string { return dailyModelStatsCSV(stats) })
 }
 
 // Log handlers
@@ -445,19 +1367,48 @@ func (h *Handler) SearchLogs(w http.ResponseWriter, r *http.Request) {
 
 	page := 0
 	if p := r.URL.Query().Get("page"); p != "" {
-		if pageNum, err := strconv.Atoi(p); err == nil {
-			page = pageNum
+		pageNum, err := strconv.Atoi(p)
+		if err != nil || pageNum < 0 {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "page must be a non-negative integer"})
+			return
 		}
+		page = pageNum
 	}
 
-	size := 20
+	size := min(20, h.maxLogPageSize)
 	if s := r.URL.Query().Get("size"); s != "" {
-		if sizeNum, err := strconv.Atoi(s); err == nil && sizeNum <= 100 {
-			size = sizeNum
+		sizeNum, err := strconv.Atoi(s)
+		if err != nil || sizeNum < 1 {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "size must be a positive integer"})
+			return
+		}
+		size = min(sizeNum, h.maxLogPageSize)
+	}
+
+	// OpenSearch refuses to page past its result window
+	if (page+1)*size > maxLogSearchWindow {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("page out of range; only the first %d results can be paged through", maxLogSearchWindow)})
+		return
+	}
+
+	// Metadata tags are filtered with metadata.<key>=<value> query parameters
+	metadata := map[string]string{}
+	for key, values := range r.URL.Query() {
+		if tag := strings.TrimPrefix(key, "metadata."); tag != key && tag != "" && len(values) > 0 {
+			metadata[tag] = values[0]
 		}
 	}
 
-	entries, total, err := h.logPipeline.Search(r.Context(), query, model, statusCode, startDate, endDate, page*size, size)
+	entries, total, err := h.logPipeline.Search(r.Context(), logging.SearchParams{
+		Query:      query,
+		Model:      model,
+		StatusCode: statusCode,
+		StartDate:  startDate,
+		EndDate:    endDate,
+		Metadata:   metadata,
+		From:       page * size,
+		Size:       size,
+	})
 	if err != nil {
 		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "search failed"})
 		return
@@ -493,6 +1444,68 @@ func (h *Handler) GetLog(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, http.StatusOK, entry)
 }
 
+// logStreamHeartbeat is how often an idle log stream sends a comment, so proxies
+// don't close the connection
+const logStreamHeartbeat = 15 * time.Second
+
+// StreamLogs streams the user's request logs as server-sent events as they are
+// logged. Entries are dropped for clients that can't keep up.
+func (h *Handler) StreamLogs(w http.ResponseWriter, r *http.Request) {
+	if h.logPipeline == nil {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "logging not available"})
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "streaming not supported"})
+		return
+	}
+
+	sub, err := h.logPipeline.Tail(auth.GetUserID(r.Context()))
+	if errors.Is(err, logging.ErrTooManyTails) {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "too many live log streams, try again later"})
+		return
+	}
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to stream logs"})
+		return
+	}
+	defer h.logPipeline.EndTail(sub)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(logStreamHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+		case entry, ok := <-sub.Entries():
+			if !ok {
+				return
+			}
+			data, err := json.Marshal(entry)
+			if err != nil {
+				slog.Warn("failed to encode streamed log entry", "error", err)
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "event: log\ndata: %s\n\n", data); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
+
 func writeJSON(w http.ResponseWriter, status int, data interface{}) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
@@ -0,0 +1,100 @@
+package api
+
+import (
+	"encoding/csv"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/lumina/gateway/internal/models"
+)
+
+const csvContentType = "text/csv"
+
+// wantsCSV reports whether the Accept header prefers CSV over JSON
+func wantsCSV(r *http.Request) bool {
+	accept := r.Header.Get("Accept")
+	if accept == "" {
+		return false
+	}
+
+	csvQ, jsonQ := -1.0, -1.0
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if parsed, err := strconv.ParseFloat(v, 64); err == nil {
+				q = parsed
+			}
+		}
+
+		switch mediaType {
+		case csvContentType:
+			csvQ = max(csvQ, q)
+		case "application/json", "*/*":
+			jsonQ = max(jsonQ, q)
+		}
+	}
+
+	return csvQ > 0 && csvQ > jsonQ
+}
+
+// writeNegotiated writes data as CSV when the client asks for it via the Accept
+// header, and as JSON otherwise. toCSV returns the rows including the header.
+func writeNegotiated(w http.ResponseWriter, r *http.Request, status int, data interface{}, toCSV func() [][]string) {
+	w.Header().Add("Vary", "Accept")
+
+	if !wantsCSV(r) {
+		writeJSON(w, status, data)
+		return
+	}
+
+	w.Header().Set("Content-Type", csvContentType+"; charset=utf-8")
+	w.WriteHeader(status)
+	cw := csv.NewWriter(w)
+	cw.WriteAll(toCSV())
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+func overviewCSV(o *models.Overview) [][]string {
+	return [][]string{
+		{"total_spend", "total_requests", "avg_latency", "success_rate", "total_tokens", "prompt_tokens", "completion_tokens"},
+		{formatFloat(o.TotalSpend), strconv.FormatInt(o.TotalRequests, 10), formatFloat(o.AvgLatency), formatFloat(o.SuccessRate),
+			strconv.FormatInt(o.TotalTokens, 10), strconv.FormatInt(o.PromptTokens, 10), strconv.FormatInt(o.CompletionTokens, 10)},
+	}
+}
+
+func dailyStatsCSV(stats []*models.DailyStat) [][]string {
+	rows := [][]string{{"date", "key_id", "total_tokens", "total_cost"}}
+	for _, s := range stats {
+		rows = append(rows, []string{s.Date.Format("2006-01-02"), s.KeyID, strconv.Itoa(s.TotalTokens), formatFloat(s.TotalCost)})
+	}
+	return rows
+}
+
+// dailyModelStatsCSV renders one row per day with a cost column per model,
+// which spreadsheets can chart directly as stacked series
+func dailyModelStatsCSV(stats *models.DailyModelStats) [][]string {
+	modelNames := append([]string(nil), stats.Models...)
+	sort.Strings(modelNames)
+
+	header := append([]string{"date", "total_cost"}, modelNames...)
+	rows := [][]string{header}
+	for _, day := range stats.Days {
+		row := []string{day.Date, formatFloat(day.TotalCost)}
+		for _, model := range modelNames {
+			row = append(row, formatFloat(day.Models[model]))
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
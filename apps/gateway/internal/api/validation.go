@@ -0,0 +1,35 @@
+package api
+
+import "net/http"
+
+// fieldError describes a problem with a single request field
+type fieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// validator collects every field error of a request so they can be reported together
+type validator struct {
+	errors []fieldError
+}
+
+// check records message for field when ok is false
+func (v *validator) check(ok bool, field, message string) {
+	if !ok {
+		v.errors = append(v.errors, fieldError{Field: field, Message: message})
+	}
+}
+
+// valid reports whether no errors were recorded
+func (v *validator) valid() bool {
+	return len(v.errors) == 0
+}
+
+// writeErrors responds with 400 listing all field errors. The first message is
+// also given as "error" for clients that only read a single message.
+func (v *validator) writeErrors(w http.ResponseWriter) {
+	writeJSON(w, http.StatusBadRequest, map[string]interface{}{
+		"error":  v.errors[0].Field + ": " + v.errors[0].Message,
+		"errors": v.errors,
+	})
+}
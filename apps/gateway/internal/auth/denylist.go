@@ -0,0 +1,100 @@
+package auth
+
+import (
+	"context"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"github.com/lumina/gateway/internal/cache"
+	"github.com/lumina/gateway/internal/database"
+)
+
+// modelDenylist holds the operator-disabled model patterns: a static set from
+// configuration plus those stored in the database, which are reloaded periodically
+type modelDenylist struct {
+	static   []string
+	patterns atomic.Pointer[[]string]
+}
+
+func newModelDenylist(static []string) *modelDenylist {
+	d := &modelDenylist{static: static}
+	d.patterns.Store(&static)
+	return d
+}
+
+// refresh reloads the stored patterns
+func (d *modelDenylist) refresh(ctx context.Context, db *database.DB) error {
+	denied, err := db.ListDeniedModels(ctx)
+	if err != nil {
+		return err
+	}
+
+	patterns := append([]string(nil), d.static...)
+	for _, m := range denied {
+		patterns = append(patterns, m.Pattern)
+	}
+	d.patterns.Store(&patterns)
+	return nil
+}
+
+func (d *modelDenylist) denies(model string) bool {
+	for _, pattern := range *d.patterns.Load() {
+		if matchModelPattern(pattern, model) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsModelDenied reports whether the operator has disabled a model for every key
+func (s *KeyService) IsModelDenied(model string) bool {
+	return s.denylist.denies(model)
+}
+
+// WatchDeniedModels reloads the denylist from the database every interval until
+// ctx is done, so changes made through other replicas take effect
+func (s *KeyService) WatchDeniedModels(ctx context.Context, interval time.Duration) {
+	if err := s.denylist.refresh(ctx, s.db); err != nil {
+		slog.Warn("failed to load denied models", "error", err)
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.denylist.refresh(ctx, s.db); err != nil {
+				slog.Warn("failed to refresh denied models", "error", err)
+			}
+		}
+	}
+}
+
+// DenyModel disables a model pattern for every key
+func (s *KeyService) DenyModel(ctx context.Context, pattern, reason string) error {
+	if err := s.db.AddDeniedModel(ctx, pattern, reason); err != nil {
+		return err
+	}
+	return s.announceDeniedModels(ctx)
+}
+
+// AllowModel removes a model pattern from the denylist
+func (s *KeyService) AllowModel(ctx context.Context, pattern string) error {
+	if err := s.db.RemoveDeniedModel(ctx, pattern); err != nil {
+		return err
+	}
+	return s.announceDeniedModels(ctx)
+}
+
+// announceDeniedModels reloads the denylist here and tells the other replicas to
+// do the same instead of waiting for their next refresh
+func (s *KeyService) announceDeniedModels(ctx context.Context) error {
+	if err := s.cache.PublishInvalidation(ctx, cache.InvalidateDeniedModels, ""); err != nil {
+		slog.Warn("failed to publish denylist change", "error", err)
+	}
+	return s.denylist.refresh(ctx, s.db)
+}
@@ -0,0 +1,63 @@
+package auth
+
+import (
+	"fmt"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// Encryption key derivation modes
+const (
+	KeyDerivationRaw    = "raw"    // ENCRYPTION_KEY is key material; its first 32 bytes are used as-is
+	KeyDerivationScrypt = "scrypt" // ENCRYPTION_KEY is a passphrase stretched with scrypt
+)
+
+// scrypt cost parameters. Changing them changes the derived key, making
+// provider keys stored under the old parameters undecryptable.
+const (
+	scryptN = 1 << 15
+	scryptR = 8
+	scryptP = 1
+)
+
+// encryptionKeyLen is the AES-256 key size
+const encryptionKeyLen = 32
+
+// minSaltLen is the shortest salt accepted for scrypt derivation
+const minSaltLen = 16
+
+// Keyring holds the encryption keys provider API keys may be encrypted with, by
+// version. New ciphertext always uses the primary version; older versions are kept
+// so keys can be decrypted until they have been re-encrypted.
+type Keyring struct {
+	Primary int
+	Keys    map[int][]byte
+}
+
+// NewKeyring creates a keyring with a single key
+func NewKeyring(version int, key []byte) Keyring {
+	return Keyring{Primary: version, Keys: map[int][]byte{version: key}}
+}
+
+// DeriveEncryptionKey turns the configured secret into a 32-byte AES key
+// according to the derivation mode. The salt is only used by scrypt, and must
+// be at least minSaltLen bytes.
+func DeriveEncryptionKey(secret, mode, salt string) ([]byte, error) {
+	switch mode {
+	case KeyDerivationRaw, "":
+		if len(secret) < encryptionKeyLen {
+			return nil, fmt.Errorf("raw encryption key must be at least %d bytes", encryptionKeyLen)
+		}
+		return []byte(secret[:encryptionKeyLen]), nil
+	case KeyDerivationScrypt:
+		if len(salt) < minSaltLen {
+			return nil, fmt.Errorf("encryption key salt must be at least %d bytes", minSaltLen)
+		}
+		key, err := scrypt.Key([]byte(secret), []byte(salt), scryptN, scryptR, scryptP, encryptionKeyLen)
+		if err != nil {
+			return nil, fmt.Errorf("failed to derive encryption key: %w", err)
+		}
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown key derivation mode %q", mode)
+}
@@ -0,0 +1,131 @@
+package auth
+
+import (
+	"container/list"
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/lumina/gateway/internal/cache"
+	"github.com/lumina/gateway/internal/models"
+)
+
+// localKeyCache is a small in-process LRU of key configurations in front of Redis.
+// Entries live only briefly; changes made on any replica evict them right away
+// through the cache's invalidation channel; the TTL bounds staleness if a message is missed.
+type localKeyCache struct {
+	size int
+	ttl  time.Duration
+
+	mu      sync.Mutex
+	order   *list.List // Most recently used at the front
+	entries map[string]*list.Element
+}
+
+type localKeyEntry struct {
+	keyHash   string
+	config    *models.KeyConfig
+	expiresAt time.Time
+}
+
+func newLocalKeyCache(size int, ttl time.Duration) *localKeyCache {
+	return &localKeyCache{
+		size:    size,
+		ttl:     ttl,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+func (c *localKeyCache) get(keyHash string) *models.KeyConfig {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[keyHash]
+	if !ok {
+		return nil
+	}
+	entry := elem.Value.(*localKeyEntry)
+	if time.Now().After(entry.expiresAt) {
+		c.order.Remove(elem)
+		delete(c.entries, keyHash)
+		return nil
+	}
+	c.order.MoveToFront(elem)
+	return entry.config
+}
+
+func (c *localKeyCache) set(keyHash string, config *models.KeyConfig) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry := &localKeyEntry{keyHash: keyHash, config: config, expiresAt: time.Now().Add(c.ttl)}
+	if elem, ok := c.entries[keyHash]; ok {
+		elem.Value = entry
+		c.order.MoveToFront(elem)
+		return
+	}
+
+	c.entries[keyHash] = c.order.PushFront(entry)
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*localKeyEntry).keyHash)
+	}
+}
+
+func (c *localKeyCache) remove(keyHash string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[keyHash]; ok {
+		c.order.Remove(elem)
+		delete(c.entries, keyHash)
+	}
+}
+
+// EnableLocalCache keeps up to size key configurations in process memory for ttl,
+// saving a Redis round-trip per request. It must be called before the service is
+// used, together with WatchInvalidations so changes on other replicas evict entries.
+func (s *KeyService) EnableLocalCache(size int, ttl time.Duration) {
+	if size <= 0 || ttl <= 0 {
+		return
+	}
+	s.local = newLocalKeyCache(size, ttl)
+}
+
+// WatchInvalidations applies changes announced by any replica to this one's
+// in-process state (cached key configs and the model denylist) until ctx is done
+func (s *KeyService) WatchInvalidations(ctx context.Context) {
+	err := s.cache.SubscribeInvalidations(ctx, func(kind, id string) {
+		switch kind {
+		case cache.InvalidateKeyConfig:
+			if s.local != nil {
+				s.local.remove(id)
+			}
+		case cache.InvalidateDeniedModels:
+			if err := s.denylist.refresh(ctx, s.db); err != nil {
+				slog.Warn("failed to refresh denied models", "error", err)
+			}
+		}
+	})
+	if err != nil && ctx.Err() == nil {
+		slog.Error("invalidation subscription ended", "error", err)
+	}
+}
+
+// invalidateKey removes a key's cached configuration from Redis and from the
+// in-process cache of every replica. Failures are logged; the key's TTLs still apply.
+func (s *KeyService) invalidateKey(ctx context.Context, keyHash string) {
+	// Evict here right away rather than waiting for our own message
+	if s.local != nil {
+		s.local.remove(keyHash)
+	}
+	if err := s.cache.DeleteKeyConfig(ctx, keyHash); err != nil {
+		slog.Warn("failed to delete key from cache", "error", err)
+	}
+	if err := s.cache.PublishInvalidation(ctx, cache.InvalidateKeyConfig, keyHash); err != nil {
+		slog.Warn("failed to publish key invalidation", "error", err)
+	}
+}
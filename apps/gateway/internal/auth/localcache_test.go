@@ -0,0 +1,115 @@
+package auth
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+
+	"github.com/lumina/gateway/internal/cache"
+	"github.com/lumina/gateway/internal/database"
+	"github.com/lumina/gateway/internal/models"
+)
+
+// watchingService returns a key service with a local cache that applies
+// invalidations from the shared Redis until the test ends
+func watchingService(t *testing.T, db *database.DB, c *cache.Cache) *KeyService {
+	t.Helper()
+	s := NewKeyService(db, c, Keyring{}, nil, Policy{})
+	s.EnableLocalCache(16, time.Minute)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.WatchInvalidations(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+		s.Drain(context.Background())
+	})
+	return s
+}
+
+// waitForSubscribers blocks until n replicas listen on the invalidation channel
+func waitForSubscribers(t *testing.T, mr *miniredis.Miniredis, n int) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for mr.PubSubNumSub("invalidations")["invalidations"] < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("fewer than %d replicas subscribed to invalidations", n)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// waitForEviction blocks until s no longer holds keyHash in its local cache
+func waitForEviction(t *testing.T, s *KeyService, keyHash string) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for s.local.get(keyHash) != nil {
+		if time.Now().After(deadline) {
+			t.Fatal("key config still cached locally on the other replica")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestInvalidateKeyEvictsOtherReplicas(t *testing.T) {
+	mr, c := testRedis(t)
+	a := watchingService(t, nil, c)
+	b := watchingService(t, nil, c)
+	waitForSubscribers(t, mr, 2)
+
+	config := &models.KeyConfig{KeyID: "key-1"}
+	a.local.set("hash-1", config)
+	b.local.set("hash-1", config)
+	b.local.set("hash-2", config)
+
+	a.invalidateKey(context.Background(), "hash-1")
+
+	if a.local.get("hash-1") != nil {
+		t.Error("key config still cached locally on the replica that changed it")
+	}
+	waitForEviction(t, b, "hash-1")
+	if b.local.get("hash-2") == nil {
+		t.Error("unrelated key config was evicted")
+	}
+}
+
+func TestRevokeKeyEvictsOtherReplicas(t *testing.T) {
+	db := testDB(t)
+	mr, c := testRedis(t)
+	a := watchingService(t, db, c)
+	b := watchingService(t, db, c)
+	waitForSubscribers(t, mr, 2)
+	ctx := context.Background()
+
+	key, _ := createTestKey(t, a)
+	b.local.set(key.KeyHash, &models.KeyConfig{KeyID: key.ID, UserID: key.UserID})
+
+	if err := a.RevokeKey(ctx, key.ID, key.UserID); err != nil {
+		t.Fatalf("RevokeKey: %v", err)
+	}
+	waitForEviction(t, b, key.KeyHash)
+}
+
+func TestUpdateKeyEvictsOtherReplicas(t *testing.T) {
+	db := testDB(t)
+	mr, c := testRedis(t)
+	a := watchingService(t, db, c)
+	b := watchingService(t, db, c)
+	waitForSubscribers(t, mr, 2)
+	ctx := context.Background()
+
+	key, _ := createTestKey(t, a)
+	b.local.set(key.KeyHash, &models.KeyConfig{KeyID: key.ID, UserID: key.UserID})
+
+	name := "renamed"
+	if err := a.UpdateKey(ctx, key.ID, key.UserID, &models.UpdateKeyRequest{Name: &name}); err != nil {
+		t.Fatalf("UpdateKey: %v", err)
+	}
+	waitForEviction(t, b, key.KeyHash)
+}
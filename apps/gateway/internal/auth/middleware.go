@@ -9,12 +9,14 @@ import (
 type contextKey string
 
 const (
-	UserIDKey contextKey = "userID"
-	EmailKey  contextKey = "email"
+	UserIDKey    contextKey = "userID"
+	EmailKey     contextKey = "email"
+	SessionIDKey contextKey = "sessionID"
 )
 
-// JWTMiddleware validates JWT tokens from cookies or Authorization header
-func JWTMiddleware(jwtManager *JWTManager) func(http.Handler) http.Handler {
+// JWTMiddleware validates JWT tokens from cookies or Authorization header, rejecting
+// those whose session was revoked
+func JWTMiddleware(jwtManager *JWTManager, sessions *SessionService) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			var tokenString string
@@ -44,9 +46,16 @@ func JWTMiddleware(jwtManager *JWTManager) func(http.Handler) http.Handler {
 				return
 			}
 
+			active, err := sessions.Active(r.Context(), claims)
+			if err != nil || !active {
+				http.Error(w, `{"error":"unauthorized"}`, http.StatusUnauthorized)
+				return
+			}
+
 			// Add claims to context
 			ctx := context.WithValue(r.Context(), UserIDKey, claims.UserID)
 			ctx = context.WithValue(ctx, EmailKey, claims.Email)
+			ctx = context.WithValue(ctx, SessionIDKey, claims.ID)
 
 			next.ServeHTTP(w, r.WithContext(ctx))
 		})
@@ -61,6 +70,15 @@ func GetUserID(ctx context.Context) string {
 	return ""
 }
 
+// GetSessionID extracts the session ID from the context, empty for tokens issued
+// before sessions were tracked
+func GetSessionID(ctx context.Context) string {
+	if sessionID, ok := ctx.Value(SessionIDKey).(string); ok {
+		return sessionID
+	}
+	return ""
+}
+
 // GetEmail extracts the email from the context
 func GetEmail(ctx context.Context) string {
 	if email, ok := ctx.Value(EmailKey).(string); ok {
@@ -0,0 +1,190 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lumina/gateway/internal/models"
+)
+
+// ErrRotationInProgress is returned when starting a rotation while another one is running
+var ErrRotationInProgress = errors.New("an encryption key rotation is already running")
+
+const (
+	// rotationBatchSize is the number of provider keys re-encrypted per batch
+	rotationBatchSize = 100
+
+	// rotationLockTTL bounds how long a replica holds the rotation lock for one batch,
+	// including after a crash
+	rotationLockTTL = time.Minute
+
+	// rotationPollInterval is how often replicas check for a rotation to resume, e.g.
+	// after the replica that was running it stopped
+	rotationPollInterval = time.Minute
+)
+
+// PrimaryKeyVersion returns the version of the encryption key new ciphertext uses
+func (s *KeyService) PrimaryKeyVersion() int {
+	return s.keyring.Primary
+}
+
+// EncryptionStatus reports the primary key version, how many provider keys still use
+// other versions and the most recent rotation
+func (s *KeyService) EncryptionStatus(ctx context.Context) (*models.EncryptionStatus, error) {
+	remaining, err := s.db.CountProvidersNotAtVersion(ctx, s.keyring.Primary)
+	if err != nil {
+		return nil, err
+	}
+	rotation, err := s.db.GetLatestEncryptionRotation(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &models.EncryptionStatus{PrimaryVersion: s.keyring.Primary, Remaining: remaining, Rotation: rotation}, nil
+}
+
+// StartEncryptionRotation starts re-encrypting every provider key under the primary
+// encryption key version in the background. Progress is stored in the database, so
+// the rotation resumes on any replica if this one stops.
+func (s *KeyService) StartEncryptionRotation(ctx context.Context, startedBy string) (*models.EncryptionRotation, error) {
+	running, err := s.db.GetRunningEncryptionRotation(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if running != nil {
+		return nil, ErrRotationInProgress
+	}
+
+	total, err := s.db.CountProvidersNotAtVersion(ctx, s.keyring.Primary)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	rotation := &models.EncryptionRotation{
+		ID:            uuid.New().String(),
+		TargetVersion: s.keyring.Primary,
+		Status:        models.RotationRunning,
+		Total:         total,
+		StartedBy:     startedBy,
+		StartedAt:     now,
+		UpdatedAt:     now,
+	}
+	if err := s.db.CreateEncryptionRotation(ctx, rotation); err != nil {
+		return nil, err
+	}
+
+	select {
+	case s.rotationWake <- struct{}{}:
+	default:
+	}
+	return rotation, nil
+}
+
+// RunEncryptionRotations works on the running encryption rotation, if any, whenever
+// one is started on this replica and periodically otherwise, until ctx is done
+func (s *KeyService) RunEncryptionRotations(ctx context.Context) {
+	ticker := time.NewTicker(rotationPollInterval)
+	defer ticker.Stop()
+
+	for {
+		for ctx.Err() == nil {
+			more, err := s.rotateBatch(ctx)
+			if err != nil {
+				slog.Error("encryption rotation batch failed", "error", err)
+				break
+			}
+			if !more {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-s.rotationWake:
+		}
+	}
+}
+
+// rotateBatch re-encrypts the next batch of the running rotation and reports whether
+// there is more work to do. A Redis lock held for each batch keeps replicas from
+// working on the same rotation at once.
+func (s *KeyService) rotateBatch(ctx context.Context) (bool, error) {
+	lock, err := s.cache.AcquireLock(ctx, "encryption_rotation", rotationLockTTL)
+	if err != nil {
+		return false, err
+	}
+	if lock == nil {
+		// Another replica is working on it
+		return false, nil
+	}
+	defer func() {
+		if err := lock.Release(context.Background()); err != nil {
+			slog.Warn("failed to release encryption rotation lock", "error", err)
+		}
+	}()
+
+	rotation, err := s.db.GetRunningEncryptionRotation(ctx)
+	if err != nil || rotation == nil {
+		return false, err
+	}
+	if rotation.TargetVersion != s.keyring.Primary {
+		// E.g. during a rolling deploy; replicas with the target key will pick it up
+		slog.Warn("skipping encryption rotation to a different key version",
+			"rotation_id", rotation.ID, "target_version", rotation.TargetVersion, "primary_version", s.keyring.Primary)
+		return false, nil
+	}
+
+	providers, err := s.db.ListProvidersNotAtVersion(ctx, rotation.TargetVersion, rotation.Cursor, rotationBatchSize)
+	if err != nil {
+		return false, err
+	}
+
+	for _, p := range providers {
+		rotated, err := s.reencrypt(ctx, p)
+		if err != nil {
+			if ctx.Err() != nil {
+				return false, ctx.Err()
+			}
+			slog.Warn("failed to re-encrypt provider key", "rotation_id", rotation.ID, "provider_id", p.ID, "error", err)
+			rotation.Failed++
+		} else if rotated {
+			rotation.Rotated++
+		}
+		rotation.Cursor = p.ID
+	}
+
+	more := len(providers) == rotationBatchSize
+	if !more {
+		now := time.Now()
+		rotation.Status = models.RotationCompleted
+		rotation.CompletedAt = &now
+	}
+	if err := s.db.UpdateEncryptionRotation(ctx, rotation); err != nil {
+		return false, err
+	}
+	if !more {
+		slog.Info("encryption rotation complete", "rotation_id", rotation.ID,
+			"target_version", rotation.TargetVersion, "rotated", rotation.Rotated, "failed", rotation.Failed)
+	}
+	return more, nil
+}
+
+// reencrypt re-encrypts a provider key under the primary key version. It reports false
+// without error when the key was changed concurrently, which already re-encrypted it.
+func (s *KeyService) reencrypt(ctx context.Context, p models.UserProvider) (bool, error) {
+	apiKey, err := s.Decrypt(p.APIKeyEncrypted, p.KeyVersion)
+	if err != nil {
+		return false, fmt.Errorf("decryption error: %w", err)
+	}
+	encryptedKey, err := s.Encrypt(apiKey)
+	if err != nil {
+		return false, fmt.Errorf("failed to encrypt API key: %w", err)
+	}
+	return s.db.ReencryptUserProvider(ctx, p.ID, p.APIKeyEncrypted, encryptedKey, s.keyring.Primary, FingerprintProviderKey(apiKey))
+}
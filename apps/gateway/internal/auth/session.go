@@ -0,0 +1,109 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/lumina/gateway/internal/cache"
+	"github.com/lumina/gateway/internal/database"
+	"github.com/lumina/gateway/internal/models"
+)
+
+var ErrSessionNotFound = errors.New("session not found")
+
+// SessionService tracks dashboard logins as sessions, each identified by the ID in
+// its token, so users can list where they are signed in and revoke sessions
+type SessionService struct {
+	db    *database.DB
+	cache *cache.Cache
+	jwt   *JWTManager
+}
+
+// NewSessionService creates a session service issuing tokens with jwtManager
+func NewSessionService(db *database.DB, cache *cache.Cache, jwtManager *JWTManager) *SessionService {
+	return &SessionService{db: db, cache: cache, jwt: jwtManager}
+}
+
+// Start records a new session for the user, logged in from ip with userAgent,
+// and returns its token
+func (s *SessionService) Start(ctx context.Context, user *models.User, ip, userAgent string) (string, error) {
+	now := time.Now()
+	session := &models.Session{
+		ID:        uuid.New().String(),
+		UserID:    user.ID,
+		IP:        ip,
+		UserAgent: userAgent,
+		CreatedAt: now,
+		ExpiresAt: now.Add(tokenExpiry),
+	}
+	if err := s.db.CreateSession(ctx, session); err != nil {
+		return "", err
+	}
+	return s.jwt.GenerateToken(user.ID, user.Email, session.ID, session.ExpiresAt)
+}
+
+// Active reports whether a token's session may still be used, and records the use.
+// Tokens issued before sessions were tracked carry no session and stay valid until
+// they expire.
+func (s *SessionService) Active(ctx context.Context, claims *Claims) (bool, error) {
+	if claims.ID == "" {
+		return true, nil
+	}
+
+	denied, err := s.cache.IsSessionDenied(ctx, claims.ID)
+	if err != nil {
+		// The database is authoritative when the denylist can't be read
+		slog.Warn("failed to check session denylist", "error", err)
+		active, err := s.db.IsSessionActive(ctx, claims.ID)
+		if err != nil || !active {
+			return false, err
+		}
+	} else if denied {
+		return false, nil
+	}
+
+	if due, err := s.cache.ShouldRecordUsage(ctx, "session:"+claims.ID); err == nil && due {
+		if err := s.db.TouchSession(ctx, claims.ID); err != nil {
+			slog.Warn("failed to record session use", "session_id", claims.ID, "error", err)
+		}
+	}
+	return true, nil
+}
+
+// List returns the user's active sessions, marking currentID as the current one
+func (s *SessionService) List(ctx context.Context, userID, currentID string) ([]*models.Session, error) {
+	sessions, err := s.db.ListActiveSessions(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, session := range sessions {
+		session.Current = session.ID == currentID
+	}
+	return sessions, nil
+}
+
+// Revoke ends one of the user's sessions. Its token is denylisted until it expires,
+// so it stops working immediately on every replica.
+func (s *SessionService) Revoke(ctx context.Context, userID, sessionID string) error {
+	if _, err := uuid.Parse(sessionID); err != nil {
+		return ErrSessionNotFound
+	}
+
+	expiresAt, err := s.db.RevokeSession(ctx, sessionID, userID)
+	if err != nil {
+		return err
+	}
+	if expiresAt == nil {
+		return ErrSessionNotFound
+	}
+
+	if err := s.cache.DenySession(ctx, sessionID, *expiresAt); err != nil {
+		return fmt.Errorf("failed to denylist session: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,180 @@
+package auth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/lumina/gateway/internal/models"
+)
+
+const (
+	// shareTokenPrefix starts every share token, so the proxy can tell them from virtual keys
+	shareTokenPrefix = "lst_"
+
+	// DefaultShareTokenTTL is the lifetime of share tokens minted without one
+	DefaultShareTokenTTL = time.Hour
+
+	// MaxShareTokenTTL bounds the lifetime of share tokens
+	MaxShareTokenTTL = 7 * 24 * time.Hour
+)
+
+var (
+	ErrShareTokensDisabled = errors.New("share tokens are not enabled")
+	ErrInvalidShareToken   = errors.New("invalid share token")
+	ErrShareTokenExpired   = errors.New("share token has expired")
+	ErrShareTokenExhausted = errors.New("share token request limit reached")
+)
+
+// shareClaims is the signed payload of a share token
+type shareClaims struct {
+	ID          string `json:"jti"`
+	KeyID       string `json:"kid"`
+	ExpiresAt   int64  `json:"exp"`
+	Model       string `json:"model,omitempty"`
+	MaxRequests int    `json:"max,omitempty"`
+}
+
+// EnableShareTokens lets keys mint share tokens signed with secret, see CreateShareToken.
+// It must be called before the service is used, with the same secret on every replica.
+func (s *KeyService) EnableShareTokens(secret []byte) {
+	s.shareSecret = secret
+}
+
+// CreateShareToken mints a share token for one of the user's keys: a signed token that
+// can be used in place of the key until it expires, optionally limited to one model and
+// a number of requests. Requests made with it are logged and billed under the key.
+func (s *KeyService) CreateShareToken(ctx context.Context, keyID, userID string, req *models.CreateShareTokenRequest) (*models.ShareToken, error) {
+	if s.shareSecret == nil {
+		return nil, ErrShareTokensDisabled
+	}
+
+	key, err := s.GetKey(ctx, keyID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if key.RevokedAt != nil {
+		return nil, ErrKeyRevoked
+	}
+	if req.Model != "" && !s.IsModelAllowed(&models.KeyConfig{AllowedModels: key.AllowedModels}, req.Model) {
+		return nil, ErrModelNotAllowed
+	}
+
+	ttl := DefaultShareTokenTTL
+	if req.ExpiresIn > 0 {
+		ttl = time.Duration(req.ExpiresIn) * time.Second
+	}
+	claims := shareClaims{
+		ID:          uuid.New().String(),
+		KeyID:       key.ID,
+		ExpiresAt:   time.Now().Add(ttl).Unix(),
+		Model:       req.Model,
+		MaxRequests: req.MaxRequests,
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode share token: %w", err)
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+
+	return &models.ShareToken{
+		ID:          claims.ID,
+		KeyID:       key.ID,
+		Token:       shareTokenPrefix + encoded + "." + base64.RawURLEncoding.EncodeToString(s.signShareToken(encoded)),
+		Model:       claims.Model,
+		MaxRequests: claims.MaxRequests,
+		ExpiresAt:   time.Unix(claims.ExpiresAt, 0).UTC(),
+	}, nil
+}
+
+// signShareToken returns the HMAC of a share token's encoded payload
+func (s *KeyService) signShareToken(payload string) []byte {
+	mac := hmac.New(sha256.New, s.shareSecret)
+	mac.Write([]byte(shareTokenPrefix + payload))
+	return mac.Sum(nil)
+}
+
+// parseShareToken verifies a share token's signature and returns its claims
+func (s *KeyService) parseShareToken(token string) (*shareClaims, error) {
+	payload, signature, ok := strings.Cut(strings.TrimPrefix(token, shareTokenPrefix), ".")
+	if !ok {
+		return nil, ErrInvalidShareToken
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil || !hmac.Equal(mac, s.signShareToken(payload)) {
+		return nil, ErrInvalidShareToken
+	}
+
+	raw, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, ErrInvalidShareToken
+	}
+	var claims shareClaims
+	if err := json.Unmarshal(raw, &claims); err != nil || claims.ID == "" || claims.KeyID == "" {
+		return nil, ErrInvalidShareToken
+	}
+	return &claims, nil
+}
+
+// resolveShareToken returns the configuration of the key a share token was minted for,
+// narrowed to the token's model. Each call counts as one of the token's requests.
+func (s *KeyService) resolveShareToken(ctx context.Context, token string) (*models.KeyConfig, error) {
+	if s.shareSecret == nil {
+		return nil, ErrInvalidShareToken
+	}
+	claims, err := s.parseShareToken(token)
+	if err != nil {
+		return nil, err
+	}
+	expiresAt := time.Unix(claims.ExpiresAt, 0)
+	if !time.Now().Before(expiresAt) {
+		return nil, ErrShareTokenExpired
+	}
+
+	// The key is read by ID so revoking it immediately disables its share tokens
+	key, err := s.db.GetVirtualKeyByID(ctx, claims.KeyID)
+	if err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+	if key == nil {
+		return nil, ErrInvalidShareToken
+	}
+	if key.RevokedAt != nil {
+		return nil, ErrKeyRevoked
+	}
+
+	config, err := s.lookupKeyHash(ctx, key.KeyHash, "")
+	if err != nil {
+		return nil, err
+	}
+	// The key's allowed models may have changed since the token was minted
+	if claims.Model != "" && !s.IsModelAllowed(config, claims.Model) {
+		return nil, ErrModelNotAllowed
+	}
+
+	if claims.MaxRequests > 0 {
+		uses, err := s.cache.IncrementShareTokenUses(ctx, claims.ID, expiresAt)
+		if err != nil {
+			return nil, fmt.Errorf("cache error: %w", err)
+		}
+		if uses > int64(claims.MaxRequests) {
+			return nil, ErrShareTokenExhausted
+		}
+	}
+
+	// Cached configs are shared, so the narrowed one is a copy
+	shared := *config
+	if claims.Model != "" {
+		shared.AllowedModels = []string{claims.Model}
+	}
+	shared.ShareTokenID = claims.ID
+	return &shared, nil
+}
@@ -0,0 +1,87 @@
+package auth
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/lumina/gateway/internal/metrics"
+)
+
+// spendUpdateTimeout bounds each spend update, so a slow database can't hold up
+// the workers or shutdown indefinitely
+const spendUpdateTimeout = 10 * time.Second
+
+var spendQueueFull = metrics.NewCounter("lumina_spend_queue_full_total",
+	"Spend updates made inline by the request because the spend queue was full.")
+
+// spendUpdate is a request's cost and tokens waiting to be recorded against a key
+type spendUpdate struct {
+	keyID  string
+	cost   float64
+	tokens int
+}
+
+// StartSpendWorkers records spend through a queue of up to size updates served by
+// workers goroutines, instead of a goroutine per request. Drain closes the queue and
+// waits for the updates still in it. It must be called before the service is used.
+func (s *KeyService) StartSpendWorkers(workers, size int) {
+	if workers <= 0 || size <= 0 {
+		return
+	}
+	s.spendQueue = make(chan spendUpdate, size)
+	for range workers {
+		s.Go(func() {
+			for u := range s.spendQueue {
+				s.recordSpend(u)
+			}
+		})
+	}
+}
+
+// RecordSpend records a request's cost and tokens against its key in the background.
+// When the queue is full, or already closed for shutdown, the update is made before
+// returning instead, so spend is never dropped.
+func (s *KeyService) RecordSpend(keyID string, cost float64, tokens int) {
+	u := spendUpdate{keyID: keyID, cost: cost, tokens: tokens}
+	if s.spendQueue == nil {
+		s.Go(func() { s.recordSpend(u) })
+		return
+	}
+
+	s.spendMu.RLock()
+	if !s.spendClosed {
+		select {
+		case s.spendQueue <- u:
+			s.spendMu.RUnlock()
+			return
+		default:
+			spendQueueFull.Inc()
+		}
+	}
+	s.spendMu.RUnlock()
+
+	s.recordSpend(u)
+}
+
+// recordSpend applies one spend update
+func (s *KeyService) recordSpend(u spendUpdate) {
+	ctx, cancel := context.WithTimeout(context.Background(), spendUpdateTimeout)
+	defer cancel()
+
+	if err := s.UpdateSpend(ctx, u.keyID, u.cost, u.tokens); err != nil {
+		slog.Error("failed to update spend", "key_id", u.keyID, "cost", u.cost, "error", err)
+	}
+}
+
+// closeSpendQueue stops queueing spend updates, letting the workers exit once the
+// queue is empty
+func (s *KeyService) closeSpendQueue() {
+	s.spendMu.Lock()
+	defer s.spendMu.Unlock()
+
+	if s.spendQueue != nil && !s.spendClosed {
+		s.spendClosed = true
+		close(s.spendQueue)
+	}
+}
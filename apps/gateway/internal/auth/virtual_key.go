@@ -4,6 +4,7 @@ import (
 	"context"
 	"crypto/aes"
 	"crypto/cipher"
+	"crypto/hmac"
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/hex"
@@ -12,6 +13,7 @@ import (
 	"io"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
@@ -22,49 +24,157 @@ import (
 )
 
 const (
+	// virtualKeyPrefix starts every virtual key, and is the whole prefix of keys
+	// created before per-environment prefixes
 	virtualKeyPrefix = "lum_"
+
+	// virtualKeySecretLen is the length of the hex-encoded random part of a key
+	virtualKeySecretLen = 64
 )
 
 var (
-	ErrInvalidKey       = errors.New("invalid virtual key")
-	ErrKeyRevoked       = errors.New("virtual key has been revoked")
-	ErrBudgetExceeded   = errors.New("budget limit exceeded")
-	ErrModelNotAllowed  = errors.New("model not allowed for this key")
-	ErrProviderNotFound = errors.New("provider not configured for this key")
+	ErrInvalidKey            = errors.New("invalid virtual key")
+	ErrKeyRevoked            = errors.New("virtual key has been revoked")
+	ErrBudgetExceeded        = errors.New("budget limit exceeded")
+	ErrModelNotAllowed       = errors.New("model not allowed for this key")
+	ErrProviderUnsupported   = errors.New("provider not supported by the gateway")
+	ErrProviderNotConfigured = errors.New("provider credentials not configured for this account")
+	ErrNoProviders           = errors.New("no provider API keys configured for this account")
+	ErrProviderModelNoAccess = errors.New("no provider credentials on this account have access to the model")
+	ErrBudgetRequired        = errors.New("a budget limit is required for every key")
+	ErrBudgetTooHigh         = errors.New("budget limit exceeds the maximum allowed")
+	ErrKeyLimitReached       = errors.New("maximum number of keys reached")
 )
 
+// Policy holds operator-level rules applied when keys are created or updated
+type Policy struct {
+	RequireBudget  bool     // Reject keys without a budget limit
+	MaxBudgetLimit float64  // Upper bound for budget limits, zero means unbounded
+	MaxKeysPerUser int      // Active keys a user may hold unless overridden per user, zero means unlimited
+	KeyPrefix      string   // Prefix of new keys, e.g. "lum_prod_"; empty means "lum_"
+	DeniedModels   []string // Model patterns rejected for every key, in addition to those stored in the database
+}
+
 // KeyService manages virtual keys
 type KeyService struct {
-	db            *database.DB
-	cache         *cache.Cache
-	encryptionKey []byte
+	db           *database.DB
+	cache        *cache.Cache
+	keyring      Keyring
+	hashSecret   []byte // HMAC key for virtual key hashes, nil for plain SHA256
+	policy       Policy
+	denylist     *modelDenylist
+	local        *localKeyCache // nil unless EnableLocalCache was called
+	shareSecret  []byte         // HMAC key for share tokens, nil unless EnableShareTokens was called
+	background   sync.WaitGroup
+	rotationWake chan struct{}    // Signals the rotation worker that a rotation was started
+	spendQueue   chan spendUpdate // nil unless StartSpendWorkers was called
+	spendMu      sync.RWMutex     // Guards closing spendQueue against concurrent sends
+	spendClosed  bool
 }
 
-// NewKeyService creates a new key service
-func NewKeyService(db *database.DB, cache *cache.Cache, encryptionKey string) *KeyService {
+// NewKeyService creates a new key service. The keyring's keys must be 32-byte
+// AES keys, see DeriveEncryptionKey. When hashSecret is set, virtual keys are
+// stored as HMAC-SHA256 hashes instead of plain SHA256.
+func NewKeyService(db *database.DB, cache *cache.Cache, keyring Keyring, hashSecret []byte, policy Policy) *KeyService {
 	return &KeyService{
-		db:            db,
-		cache:         cache,
-		encryptionKey: []byte(encryptionKey[:32]), // Use first 32 bytes
+		db:           db,
+		cache:        cache,
+		keyring:      keyring,
+		rotationWake: make(chan struct{}, 1),
+		hashSecret:   hashSecret,
+		policy:       policy,
+		denylist:     newModelDenylist(policy.DeniedModels),
 	}
 }
 
+// keyPrefix returns the prefix of keys created by this deployment
+func (s *KeyService) keyPrefix() string {
+	if s.policy.KeyPrefix == "" {
+		return virtualKeyPrefix
+	}
+	return s.policy.KeyPrefix
+}
+
 // GenerateVirtualKey generates a new virtual key
 func (s *KeyService) GenerateVirtualKey() string {
-	b := make([]byte, 32)
+	b := make([]byte, virtualKeySecretLen/2)
 	rand.Read(b)
-	return virtualKeyPrefix + hex.EncodeToString(b)
+	return s.keyPrefix() + hex.EncodeToString(b)
+}
+
+// hasValidPrefix reports whether a virtual key belongs to this deployment: it carries
+// the configured prefix, or is a legacy key with just "lum_" before its secret. Keys
+// with another environment's prefix, e.g. "lum_test_" in production, are rejected.
+func (s *KeyService) hasValidPrefix(virtualKey string) bool {
+	if strings.HasPrefix(virtualKey, s.keyPrefix()) {
+		return true
+	}
+	secret, ok := strings.CutPrefix(virtualKey, virtualKeyPrefix)
+	if !ok || len(secret) != virtualKeySecretLen {
+		return false
+	}
+	_, err := hex.DecodeString(secret)
+	return err == nil
+}
+
+// PreviewKey returns a non-sensitive representation of a virtual key (the prefix
+// and last 4 characters) that can be shown to identify it later
+func (s *KeyService) PreviewKey(virtualKey string) string {
+	prefix := virtualKeyPrefix
+	if strings.HasPrefix(virtualKey, s.keyPrefix()) {
+		prefix = s.keyPrefix()
+	}
+	return prefix + "..." + virtualKey[len(virtualKey)-4:]
 }
 
-// HashKey creates a SHA256 hash of a virtual key
+// FingerprintProviderKey returns a short, non-reversible identifier of a provider
+// API key, recorded with each request so the key that served it can be identified later,
+// and shown in the provider list so users can tell which key is configured
+func FingerprintProviderKey(apiKey string) string {
+	sum := sha256.Sum256([]byte(apiKey))
+	return "sha256:" + hex.EncodeToString(sum[:8])
+}
+
+// HashKey creates the stored hash of a virtual key: HMAC-SHA256 keyed with the
+// hash secret when one is configured, plain SHA256 otherwise
 func (s *KeyService) HashKey(virtualKey string) string {
+	if s.hashSecret == nil {
+		return legacyHashKey(virtualKey)
+	}
+	mac := hmac.New(sha256.New, s.hashSecret)
+	mac.Write([]byte(virtualKey))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// legacyHashKey is the unkeyed SHA256 hash used before hash secrets were supported
+func legacyHashKey(virtualKey string) string {
 	hash := sha256.Sum256([]byte(virtualKey))
 	return hex.EncodeToString(hash[:])
 }
 
-// Encrypt encrypts the real API key
+// upgradeLegacyKey looks up a key stored with a plain SHA256 hash and, if found,
+// replaces its stored hash with the HMAC one so it is only matched the slow way once
+func (s *KeyService) upgradeLegacyKey(ctx context.Context, virtualKey, keyHash string) (*models.VirtualKey, error) {
+	legacyHash := legacyHashKey(virtualKey)
+	key, err := s.db.GetVirtualKeyByHash(ctx, legacyHash)
+	if err != nil || key == nil {
+		return key, err
+	}
+
+	if err := s.db.UpdateVirtualKeyHash(ctx, key.ID, keyHash); err != nil {
+		return nil, err
+	}
+	key.KeyHash = keyHash
+
+	// Drop any config cached under the old hash so revocations keep taking effect
+	s.invalidateKey(ctx, legacyHash)
+
+	return key, nil
+}
+
+// Encrypt encrypts the real API key with the primary encryption key
 func (s *KeyService) Encrypt(plaintext string) ([]byte, error) {
-	block, err := aes.NewCipher(s.encryptionKey)
+	block, err := aes.NewCipher(s.keyring.Keys[s.keyring.Primary])
 	if err != nil {
 		return nil, err
 	}
@@ -83,9 +193,14 @@ func (s *KeyService) Encrypt(plaintext string) ([]byte, error) {
 	return ciphertext, nil
 }
 
-// Decrypt decrypts the real API key
-func (s *KeyService) Decrypt(ciphertext []byte) (string, error) {
-	block, err := aes.NewCipher(s.encryptionKey)
+// Decrypt decrypts the real API key with the given version of the encryption key
+func (s *KeyService) Decrypt(ciphertext []byte, version int) (string, error) {
+	key, ok := s.keyring.Keys[version]
+	if !ok {
+		return "", fmt.Errorf("no encryption key configured for version %d", version)
+	}
+
+	block, err := aes.NewCipher(key)
 	if err != nil {
 		return "", err
 	}
@@ -111,20 +226,49 @@ func (s *KeyService) Decrypt(ciphertext []byte) (string, error) {
 
 // CreateKey creates a new virtual key (access control only, providers are at account level)
 func (s *KeyService) CreateKey(ctx context.Context, userID string, req *models.CreateKeyRequest) (*models.CreateKeyResponse, error) {
+	if req.BudgetLimit == nil && s.policy.RequireBudget {
+		return nil, ErrBudgetRequired
+	}
+	if err := s.checkBudgetLimit(req.BudgetLimit); err != nil {
+		return nil, err
+	}
+	if err := s.checkKeyLimit(ctx, userID); err != nil {
+		return nil, err
+	}
+
 	// Generate virtual key
 	virtualKey := s.GenerateVirtualKey()
 	keyHash := s.HashKey(virtualKey)
 
 	// Create key in database
 	key := &models.VirtualKey{
-		ID:            uuid.New().String(),
-		UserID:        userID,
-		Name:          req.Name,
-		KeyHash:       keyHash,
-		AllowedModels: req.AllowedModels,
-		BudgetLimit:   req.BudgetLimit,
-		CurrentSpend:  0,
-		CreatedAt:     time.Now(),
+		ID:                 uuid.New().String(),
+		UserID:             userID,
+		Name:               req.Name,
+		KeyHash:            keyHash,
+		KeyPreview:         s.PreviewKey(virtualKey),
+		AllowedModels:      req.AllowedModels,
+		BudgetLimit:        req.BudgetLimit,
+		CurrentSpend:       0,
+		LogBodyMode:        req.LogBodyMode,
+		LogSampleRate:      req.LogSampleRate,
+		StreamBudget:       req.StreamBudget,
+		Debug:              req.Debug,
+		BudgetMode:         req.BudgetMode,
+		DebugCapture:       req.DebugCapture,
+		AllowedOrigins:     req.AllowedOrigins,
+		AutoRevokeExempt:   req.AutoRevokeExempt,
+		ParamLimits:        req.ParamLimits,
+		ModelRoutes:        req.ModelRoutes,
+		MaxMessages:        req.MaxMessages,
+		MaxPromptTokens:    req.MaxPromptTokens,
+		OpenAIOrganization: req.OpenAIOrganization,
+		OpenAIProject:      req.OpenAIProject,
+		RequestQuota:       req.RequestQuota,
+		CreatedAt:          time.Now(),
+	}
+	if key.RequestQuota != nil && *key.RequestQuota == 0 {
+		key.RequestQuota = nil // Zero means no quota, as in updates
 	}
 
 	if err := s.db.CreateVirtualKey(ctx, key); err != nil {
@@ -136,30 +280,69 @@ func (s *KeyService) CreateKey(ctx context.Context, userID string, req *models.C
 		Name:          key.Name,
 		AllowedModels: key.AllowedModels,
 		VirtualKey:    virtualKey, // Only returned once
+		KeyPreview:    key.KeyPreview,
 		CreatedAt:     key.CreatedAt,
 	}, nil
 }
 
-// ValidateKey validates a virtual key and returns the key configuration
+// ValidateKey validates a virtual key and returns the key configuration. It returns
+// ErrNoProviders for valid keys whose account has no provider API keys yet, since
+// they can't serve any request.
 func (s *KeyService) ValidateKey(ctx context.Context, virtualKey string) (*models.KeyConfig, error) {
-	if !strings.HasPrefix(virtualKey, virtualKeyPrefix) {
+	var config *models.KeyConfig
+	var err error
+	if strings.HasPrefix(virtualKey, shareTokenPrefix) {
+		config, err = s.resolveShareToken(ctx, virtualKey)
+	} else {
+		config, err = s.lookupKey(ctx, virtualKey)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(config.Providers) == 0 {
+		return nil, ErrNoProviders
+	}
+	return config, nil
+}
+
+// lookupKey returns the configuration of an active virtual key from the caches or the database
+func (s *KeyService) lookupKey(ctx context.Context, virtualKey string) (*models.KeyConfig, error) {
+	if !s.hasValidPrefix(virtualKey) {
 		return nil, ErrInvalidKey
 	}
+	return s.lookupKeyHash(ctx, s.HashKey(virtualKey), virtualKey)
+}
 
-	keyHash := s.HashKey(virtualKey)
+// lookupKeyHash returns the configuration of the active virtual key stored as keyHash.
+// virtualKey, when known, lets keys stored as plain SHA256 be upgraded to HMAC hashes.
+func (s *KeyService) lookupKeyHash(ctx context.Context, keyHash, virtualKey string) (*models.KeyConfig, error) {
+	// Check the in-process cache, then Redis
+	if s.local != nil {
+		if config := s.local.get(keyHash); config != nil {
+			s.recordKeyUsage(config.KeyID)
+			return config, nil
+		}
+	}
 
-	// Check cache first
 	config, err := s.cache.GetKeyConfig(ctx, keyHash)
 	if err != nil {
 		return nil, fmt.Errorf("cache error: %w", err)
 	}
 
 	if config != nil {
+		if s.local != nil {
+			s.local.set(keyHash, config)
+		}
+		s.recordKeyUsage(config.KeyID)
 		return config, nil
 	}
 
 	// Fallback to database
 	key, err := s.db.GetVirtualKeyByHash(ctx, keyHash)
+	if err == nil && key == nil && s.hashSecret != nil && virtualKey != "" {
+		// Keys created before the hash secret was configured are still stored as plain SHA256
+		key, err = s.upgradeLegacyKey(ctx, virtualKey, keyHash)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("database error: %w", err)
 	}
@@ -172,50 +355,109 @@ func (s *KeyService) ValidateKey(ctx context.Context, virtualKey string) (*model
 		return nil, ErrKeyRevoked
 	}
 
-	// Fetch provider API keys from user's account (not the key)
-	userProviders, err := s.db.GetUserProviders(ctx, key.UserID)
+	providers, err := s.userProviderKeys(ctx, key.UserID)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get user providers: %w", err)
-	}
-
-	// Decrypt all provider API keys
-	providers := make(map[string]string)
-	for _, p := range userProviders {
-		realAPIKey, err := s.Decrypt(p.APIKeyEncrypted)
-		if err != nil {
-			return nil, fmt.Errorf("decryption error: %w", err)
-		}
-		providers[string(p.Provider)] = realAPIKey
-	}
-
-	config = &models.KeyConfig{
-		KeyID:         key.ID,
-		UserID:        key.UserID,
-		Name:          key.Name,
-		AllowedModels: key.AllowedModels,
-		Providers:     providers,
-		BudgetLimit:   key.BudgetLimit,
-		CurrentSpend:  key.CurrentSpend,
+		return nil, err
 	}
+	config = newKeyConfig(key, providers)
 
 	// Cache the configuration
 	if err := s.cache.SetKeyConfig(ctx, keyHash, config); err != nil {
 		// Log but don't fail
 		fmt.Printf("failed to cache key config: %v\n", err)
 	}
+	if s.local != nil {
+		s.local.set(keyHash, config)
+	}
+
+	s.recordKeyUsage(config.KeyID)
 
 	return config, nil
 }
 
-// GetProviderKey returns the API key for a specific provider
-func (s *KeyService) GetProviderKey(config *models.KeyConfig, provider string) (string, error) {
+// GetProviderKey returns the API key for a specific provider that has access to the
+// model (given without its "provider/" prefix) and records its usage. Keys without
+// model capabilities are assumed to serve every model.
+// It returns ErrProviderUnsupported for providers the gateway can't route to,
+// ErrProviderNotConfigured when the account has no credentials for a supported provider
+// and ErrProviderModelNoAccess when its credentials don't cover the model.
+func (s *KeyService) GetProviderKey(ctx context.Context, config *models.KeyConfig, provider, model string) (string, error) {
+	if !models.ProviderType(provider).Valid() {
+		return "", ErrProviderUnsupported
+	}
+
 	apiKey, ok := config.Providers[provider]
 	if !ok {
-		return "", ErrProviderNotFound
+		return "", ErrProviderNotConfigured
 	}
+	if !providerKeyServes(config.ProviderModels[provider], model) {
+		return "", ErrProviderModelNoAccess
+	}
+
+	s.recordProviderUsage(config.UserID, provider)
+
 	return apiKey, nil
 }
 
+// Go runs fn in the background, detached from any request. Drain waits for it on shutdown.
+func (s *KeyService) Go(fn func()) {
+	s.background.Add(1)
+	go func() {
+		defer s.background.Done()
+		fn()
+	}()
+}
+
+// Drain waits for background work started with Go, including queued spend updates,
+// to finish, or for ctx to be done. Spend recorded afterwards is applied inline.
+func (s *KeyService) Drain(ctx context.Context) error {
+	s.closeSpendQueue()
+
+	done := make(chan struct{})
+	go func() {
+		s.background.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		if pending := len(s.spendQueue); pending > 0 {
+			return fmt.Errorf("%w with %d spend updates not recorded", ctx.Err(), pending)
+		}
+		return ctx.Err()
+	}
+}
+
+// recordKeyUsage updates the key's last_used_at in the background, throttled through Redis
+func (s *KeyService) recordKeyUsage(keyID string) {
+	s.Go(func() {
+		ctx := context.Background()
+		due, err := s.cache.ShouldRecordUsage(ctx, "key:"+keyID)
+		if err != nil || !due {
+			return
+		}
+		if err := s.db.TouchVirtualKey(ctx, keyID); err != nil {
+			fmt.Printf("failed to record key usage: %v\n", err)
+		}
+	})
+}
+
+// recordProviderUsage updates the provider's last_used_at in the background, throttled through Redis
+func (s *KeyService) recordProviderUsage(userID, provider string) {
+	s.Go(func() {
+		ctx := context.Background()
+		due, err := s.cache.ShouldRecordUsage(ctx, "provider:"+userID+":"+provider)
+		if err != nil || !due {
+			return
+		}
+		if err := s.db.TouchUserProvider(ctx, userID, models.ProviderType(provider)); err != nil {
+			fmt.Printf("failed to record provider usage: %v\n", err)
+		}
+	})
+}
+
 // IsModelAllowed checks if a model is allowed for the key
 // Model format: "provider/model" e.g., "openai/gpt-4o", "anthropic/claude-3-sonnet"
 func (s *KeyService) IsModelAllowed(config *models.KeyConfig, model string) bool {
@@ -260,6 +502,173 @@ func matchModelPattern(pattern, model string) bool {
 	return false
 }
 
+// providerKeyServes reports whether a provider key with the given model patterns has
+// access to a model. Keys without patterns serve every model.
+func providerKeyServes(patterns []string, model string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, pattern := range patterns {
+		if matchModelPattern(pattern, model) {
+			return true
+		}
+	}
+	return false
+}
+
+// accountProviders holds the decrypted provider API keys of an account and the
+// model patterns each has access to
+type accountProviders struct {
+	keys   map[string]string
+	models map[string][]string
+}
+
+// userProviderKeys fetches and decrypts the provider API keys of a user's account
+func (s *KeyService) userProviderKeys(ctx context.Context, userID string) (accountProviders, error) {
+	providers := accountProviders{keys: make(map[string]string), models: make(map[string][]string)}
+
+	userProviders, err := s.db.GetUserProviders(ctx, userID)
+	if err != nil {
+		return providers, fmt.Errorf("failed to get user providers: %w", err)
+	}
+
+	for _, p := range userProviders {
+		realAPIKey, err := s.Decrypt(p.APIKeyEncrypted, p.KeyVersion)
+		if err != nil {
+			return providers, fmt.Errorf("decryption error: %w", err)
+		}
+		providers.keys[string(p.Provider)] = realAPIKey
+		if len(p.Models) > 0 {
+			providers.models[string(p.Provider)] = p.Models
+		}
+	}
+	return providers, nil
+}
+
+// FindProviderKey returns the account's current credentials for provider if their
+// fingerprint matches, or nil when the key has since been rotated or removed
+func (s *KeyService) FindProviderKey(ctx context.Context, userID, provider, fingerprint string) (*models.UserProvider, error) {
+	userProviders, err := s.db.GetUserProviders(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user providers: %w", err)
+	}
+
+	for _, p := range userProviders {
+		if string(p.Provider) != provider {
+			continue
+		}
+		apiKey, err := s.Decrypt(p.APIKeyEncrypted, p.KeyVersion)
+		if err != nil {
+			return nil, fmt.Errorf("decryption error: %w", err)
+		}
+		if FingerprintProviderKey(apiKey) == fingerprint {
+			return &p, nil
+		}
+	}
+	return nil, nil
+}
+
+// newKeyConfig builds the cached configuration of a key
+func newKeyConfig(key *models.VirtualKey, providers accountProviders) *models.KeyConfig {
+	return &models.KeyConfig{
+		KeyID:              key.ID,
+		UserID:             key.UserID,
+		Name:               key.Name,
+		AllowedModels:      key.AllowedModels,
+		Providers:          providers.keys,
+		ProviderModels:     providers.models,
+		BudgetLimit:        key.BudgetLimit,
+		CurrentSpend:       key.CurrentSpend,
+		LogBodyMode:        key.LogBodyMode,
+		LogSampleRate:      key.LogSampleRate,
+		StreamBudget:       key.StreamBudget,
+		Debug:              key.Debug,
+		BudgetMode:         key.BudgetMode,
+		DebugCapture:       key.DebugCapture,
+		AllowedOrigins:     key.AllowedOrigins,
+		ParamLimits:        key.ParamLimits,
+		ModelRoutes:        key.ModelRoutes,
+		MaxMessages:        key.MaxMessages,
+		MaxPromptTokens:    key.MaxPromptTokens,
+		OpenAIOrganization: key.OpenAIOrganization,
+		OpenAIProject:      key.OpenAIProject,
+		RequestQuota:       key.RequestQuota,
+	}
+}
+
+// WarmCache preloads the configuration of the most recently used keys into the
+// cache so the first requests after a deploy don't all fall through to the database.
+// It returns the number of keys cached.
+func (s *KeyService) WarmCache(ctx context.Context, limit int) (int, error) {
+	keys, err := s.db.ListRecentlyUsedVirtualKeys(ctx, limit)
+	if err != nil {
+		return 0, err
+	}
+
+	// Keys of the same account share provider keys, so decrypt them once per user
+	providersByUser := make(map[string]accountProviders)
+	warmed := 0
+	for _, key := range keys {
+		if err := ctx.Err(); err != nil {
+			return warmed, err
+		}
+
+		providers, ok := providersByUser[key.UserID]
+		if !ok {
+			providers, err = s.userProviderKeys(ctx, key.UserID)
+			if err != nil {
+				return warmed, err
+			}
+			providersByUser[key.UserID] = providers
+		}
+
+		if err := s.cache.SetKeyConfig(ctx, key.KeyHash, newKeyConfig(key, providers)); err != nil {
+			return warmed, fmt.Errorf("failed to cache key config: %w", err)
+		}
+		warmed++
+	}
+
+	return warmed, nil
+}
+
+// checkKeyLimit verifies the user may create another key, honoring a per-user override
+func (s *KeyService) checkKeyLimit(ctx context.Context, userID string) error {
+	limit := s.policy.MaxKeysPerUser
+
+	user, err := s.db.GetUserByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if user != nil && user.MaxKeys != nil {
+		limit = *user.MaxKeys
+	}
+
+	if limit <= 0 {
+		return nil
+	}
+
+	count, err := s.db.CountActiveVirtualKeys(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if count >= limit {
+		return ErrKeyLimitReached
+	}
+
+	return nil
+}
+
+// checkBudgetLimit validates a requested budget limit against the policy
+func (s *KeyService) checkBudgetLimit(limit *float64) error {
+	if limit == nil {
+		return nil
+	}
+	if s.policy.MaxBudgetLimit > 0 && *limit > s.policy.MaxBudgetLimit {
+		return ErrBudgetTooHigh
+	}
+	return nil
+}
+
 // CheckBudget checks if the request would exceed the budget limit
 func (s *KeyService) CheckBudget(config *models.KeyConfig, estimatedCost float64) error {
 	if config.BudgetLimit == nil {
@@ -273,16 +682,21 @@ func (s *KeyService) CheckBudget(config *models.KeyConfig, estimatedCost float64
 	return nil
 }
 
-// UpdateSpend updates the spend for a key
+// UpdateSpend records a request's cost and tokens against a key and refreshes the
+// spend in its cached configuration, so budget checks see it before the cache expires.
+// The in-process cache catches up within its short TTL.
 func (s *KeyService) UpdateSpend(ctx context.Context, keyID string, cost float64, tokens int) error {
-	// Update database
-	if err := s.db.UpdateKeySpend(ctx, keyID, cost); err != nil {
+	spend, keyHash, err := s.db.UpdateKeySpend(ctx, keyID, cost, tokens)
+	if err != nil {
 		return err
 	}
 
-	// Update daily stats
-	if err := s.db.UpsertDailyStat(ctx, keyID, tokens, cost); err != nil {
-		return err
+	if err := s.cache.RaiseKeySpend(ctx, keyHash, spend); err != nil {
+		// Drop the configuration rather than leave a stale spend cached
+		fmt.Printf("failed to refresh cached key spend: %v\n", err)
+		if err := s.cache.DeleteKeyConfig(ctx, keyHash); err != nil {
+			fmt.Printf("failed to invalidate key cache: %v\n", err)
+		}
 	}
 
 	return nil
@@ -309,15 +723,33 @@ func (s *KeyService) RevokeKey(ctx context.Context, keyID, userID string) error
 		return err
 	}
 
-	// Remove from cache
-	if err := s.cache.DeleteKeyConfig(ctx, key.KeyHash); err != nil {
-		// Log but don't fail
-		fmt.Printf("failed to delete key from cache: %v\n", err)
+	// Deny before removing from cache so a failed delete can't leave the key usable
+	if err := s.cache.DenyKey(ctx, key.KeyHash); err != nil {
+		fmt.Printf("failed to deny revoked key: %v\n", err)
 	}
 
+	s.invalidateKey(ctx, key.KeyHash)
+
 	return nil
 }
 
+// RevokeIdleKey revokes a key listed by the database as idle with the same cutoffs. It
+// reports false if the key was used, exempted or revoked in the meantime.
+func (s *KeyService) RevokeIdleKey(ctx context.Context, key *models.VirtualKey, usedBefore, createdBefore time.Time) (bool, error) {
+	revoked, err := s.db.RevokeIdleVirtualKey(ctx, key.ID, usedBefore, createdBefore)
+	if err != nil || !revoked {
+		return false, err
+	}
+
+	if err := s.cache.DenyKey(ctx, key.KeyHash); err != nil {
+		fmt.Printf("failed to deny revoked key: %v\n", err)
+	}
+
+	s.invalidateKey(ctx, key.KeyHash)
+
+	return true, nil
+}
+
 // UpdateKey updates a virtual key
 func (s *KeyService) UpdateKey(ctx context.Context, keyID, userID string, req *models.UpdateKeyRequest) error {
 	// Get key to verify ownership
@@ -334,16 +766,20 @@ func (s *KeyService) UpdateKey(ctx context.Context, keyID, userID string, req *m
 		return errors.New("unauthorized")
 	}
 
-	// Update basic info (name, allowed_models, budget_limit)
-	if err := s.db.UpdateVirtualKey(ctx, keyID, req.Name, req.AllowedModels, req.BudgetLimit); err != nil {
+	if req.ClearBudget && s.policy.RequireBudget {
+		return ErrBudgetRequired
+	}
+	if err := s.checkBudgetLimit(req.BudgetLimit); err != nil {
 		return err
 	}
 
-	// Invalidate cache
-	if err := s.cache.DeleteKeyConfig(ctx, key.KeyHash); err != nil {
-		fmt.Printf("failed to delete key from cache: %v\n", err)
+	// Update key settings
+	if err := s.db.UpdateVirtualKey(ctx, keyID, req); err != nil {
+		return err
 	}
 
+	s.invalidateKey(ctx, key.KeyHash)
+
 	return nil
 }
 
@@ -356,23 +792,47 @@ func (s *KeyService) invalidateUserKeyCache(ctx context.Context, userID string)
 
 	fmt.Printf("invalidating cache for %d keys for user %s\n", len(keys), userID)
 	for _, key := range keys {
-		fmt.Printf("deleting cache for key %s (hash: %s)\n", key.ID, key.KeyHash)
-		if err := s.cache.DeleteKeyConfig(ctx, key.KeyHash); err != nil {
-			fmt.Printf("failed to delete key %s from cache: %v\n", key.ID, err)
-		}
+		s.invalidateKey(ctx, key.KeyHash)
 	}
 
 	return nil
 }
 
 // SetUserProvider sets or updates an account-level provider API key
-func (s *KeyService) SetUserProvider(ctx context.Context, userID string, provider models.ProviderType, apiKey string) error {
-	encryptedKey, err := s.Encrypt(apiKey)
+func (s *KeyService) SetUserProvider(ctx context.Context, userID string, req models.SetProviderRequest) error {
+	encryptedKey, err := s.Encrypt(req.APIKey)
 	if err != nil {
 		return fmt.Errorf("failed to encrypt API key: %w", err)
 	}
 
-	if err := s.db.SetUserProvider(ctx, userID, provider, encryptedKey); err != nil {
+	key := database.ProviderKey{Provider: req.Provider, EncryptedKey: encryptedKey, KeyVersion: s.keyring.Primary, Label: req.Label, Models: req.Models,
+		Fingerprint: FingerprintProviderKey(req.APIKey)}
+	if err := s.db.SetUserProvider(ctx, userID, key); err != nil {
+		return err
+	}
+
+	// Invalidate all cached keys for this user since they contain provider keys
+	if err := s.invalidateUserKeyCache(ctx, userID); err != nil {
+		fmt.Printf("failed to invalidate user key cache: %v\n", err)
+	}
+
+	return nil
+}
+
+// ImportUserProviders encrypts and stores several provider API keys at once.
+// Either all of them are saved or none is.
+func (s *KeyService) ImportUserProviders(ctx context.Context, userID string, reqs []models.SetProviderRequest) error {
+	keys := make([]database.ProviderKey, len(reqs))
+	for i, req := range reqs {
+		encryptedKey, err := s.Encrypt(req.APIKey)
+		if err != nil {
+			return fmt.Errorf("failed to encrypt API key: %w", err)
+		}
+		keys[i] = database.ProviderKey{Provider: req.Provider, EncryptedKey: encryptedKey, KeyVersion: s.keyring.Primary, Label: req.Label, Models: req.Models,
+			Fingerprint: FingerprintProviderKey(req.APIKey)}
+	}
+
+	if err := s.db.SetUserProviders(ctx, userID, keys); err != nil {
 		return err
 	}
 
@@ -384,23 +844,28 @@ func (s *KeyService) SetUserProvider(ctx context.Context, userID string, provide
 	return nil
 }
 
-// GetUserProviders returns all configured providers for a user (without actual API keys)
-func (s *KeyService) GetUserProviders(ctx context.Context, userID string) ([]models.ProviderInfo, error) {
-	providers, err := s.db.GetUserProviders(ctx, userID)
+// GetUserProviders returns the configured providers for a user matching the filter (without actual API keys),
+// along with the total number of matches
+func (s *KeyService) GetUserProviders(ctx context.Context, userID string, filter models.ProviderFilter) ([]models.ProviderInfo, int, error) {
+	providers, total, err := s.db.ListUserProviders(ctx, userID, filter)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 
 	result := make([]models.ProviderInfo, len(providers))
 	for i, p := range providers {
 		result[i] = models.ProviderInfo{
-			Provider:  p.Provider,
-			CreatedAt: p.CreatedAt,
-			UpdatedAt: p.UpdatedAt,
+			Provider:    p.Provider,
+			Label:       p.Label,
+			Models:      p.Models,
+			Fingerprint: p.Fingerprint,
+			CreatedAt:   p.CreatedAt,
+			UpdatedAt:   p.UpdatedAt,
+			LastUsedAt:  p.LastUsedAt,
 		}
 	}
 
-	return result, nil
+	return result, total, nil
 }
 
 // RemoveUserProvider removes an account-level provider API key
@@ -422,6 +887,43 @@ func (s *KeyService) ListKeys(ctx context.Context, userID string) ([]*models.Vir
 	return s.db.ListVirtualKeysByUser(ctx, userID)
 }
 
+// RequestQuotas reports how much of their monthly request quota the user's active
+// keys with one have used
+func (s *KeyService) RequestQuotas(ctx context.Context, userID string) ([]models.KeyRequestQuota, error) {
+	keys, err := s.db.ListVirtualKeysByUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	var limited []*models.VirtualKey
+	var keyIDs []string
+	for _, key := range keys {
+		if key.RevokedAt == nil && key.RequestQuota != nil && *key.RequestQuota > 0 {
+			limited = append(limited, key)
+			keyIDs = append(keyIDs, key.ID)
+		}
+	}
+
+	counts, resetsAt, err := s.cache.GetRequestQuotaCounts(ctx, keyIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	quotas := make([]models.KeyRequestQuota, 0, len(limited))
+	for _, key := range limited {
+		used := counts[key.ID]
+		quotas = append(quotas, models.KeyRequestQuota{
+			KeyID:     key.ID,
+			KeyName:   key.Name,
+			Quota:     *key.RequestQuota,
+			Used:      used,
+			Remaining: max(*key.RequestQuota-used, 0),
+			ResetsAt:  resetsAt,
+		})
+	}
+	return quotas, nil
+}
+
 // GetKey gets a key by ID
 func (s *KeyService) GetKey(ctx context.Context, keyID, userID string) (*models.VirtualKey, error) {
 	key, err := s.db.GetVirtualKeyByID(ctx, keyID)
@@ -439,3 +941,57 @@ func (s *KeyService) GetKey(ctx context.Context, keyID, userID string) (*models.
 
 	return key, nil
 }
+
+// GetEffectiveConfig resolves what the proxy enforces for a key: model patterns,
+// budget and the providers available to it. Provider keys are never decrypted.
+func (s *KeyService) GetEffectiveConfig(ctx context.Context, keyID, userID string) (*models.EffectiveKeyConfig, error) {
+	key, err := s.GetKey(ctx, keyID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	userProviders, err := s.db.GetUserProviders(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user providers: %w", err)
+	}
+
+	providers := make([]models.ProviderType, len(userProviders))
+	for i, p := range userProviders {
+		providers[i] = p.Provider
+	}
+
+	allowedModels := key.AllowedModels
+	if allowedModels == nil {
+		allowedModels = []string{}
+	}
+
+	config := &models.EffectiveKeyConfig{
+		KeyID:              key.ID,
+		Name:               key.Name,
+		Active:             key.RevokedAt == nil,
+		AllowedModels:      allowedModels,
+		BudgetLimit:        key.BudgetLimit,
+		CurrentSpend:       key.CurrentSpend,
+		Providers:          providers,
+		LogBodyMode:        key.LogBodyMode,
+		LogSampleRate:      key.LogSampleRate,
+		StreamBudget:       key.StreamBudget,
+		Debug:              key.Debug,
+		BudgetMode:         key.BudgetMode,
+		DebugCapture:       key.DebugCapture,
+		AllowedOrigins:     key.AllowedOrigins,
+		ParamLimits:        key.ParamLimits,
+		ModelRoutes:        key.ModelRoutes,
+		MaxMessages:        key.MaxMessages,
+		MaxPromptTokens:    key.MaxPromptTokens,
+		OpenAIOrganization: key.OpenAIOrganization,
+		OpenAIProject:      key.OpenAIProject,
+		RequestQuota:       key.RequestQuota,
+	}
+	if key.BudgetLimit != nil {
+		remaining := max(*key.BudgetLimit-key.CurrentSpend, 0)
+		config.BudgetRemaining = &remaining
+	}
+
+	return config, nil
+}
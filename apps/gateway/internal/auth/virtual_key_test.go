@@ -0,0 +1,111 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/alicebob/miniredis/v2/server"
+	"github.com/google/uuid"
+
+	"github.com/lumina/gateway/internal/cache"
+	"github.com/lumina/gateway/internal/database"
+	"github.com/lumina/gateway/internal/models"
+)
+
+// testRedis starts an in-memory Redis and returns a cache connected to it
+func testRedis(t *testing.T) (*miniredis.Miniredis, *cache.Cache) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	c, err := cache.New("redis://"+mr.Addr(), cache.Options{})
+	if err != nil {
+		t.Fatalf("cache.New: %v", err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return mr, c
+}
+
+// testDB connects to the Postgres database named by TEST_DATABASE_URL and
+// migrates it, skipping the test when the variable isn't set
+func testDB(t *testing.T) *database.DB {
+	t.Helper()
+	url := os.Getenv("TEST_DATABASE_URL")
+	if url == "" {
+		t.Skip("TEST_DATABASE_URL not set")
+	}
+	db, err := database.New(url)
+	if err != nil {
+		t.Fatalf("database.New: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := db.Migrate(); err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+	return db
+}
+
+// createTestKey stores a new user and virtual key and returns the key and its plaintext
+func createTestKey(t *testing.T, s *KeyService) (*models.VirtualKey, string) {
+	t.Helper()
+	ctx := context.Background()
+	user, err := s.db.CreateUser(ctx, uuid.New().String()+"@example.com", "x")
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	virtualKey := s.GenerateVirtualKey()
+	key := &models.VirtualKey{
+		ID:        uuid.New().String(),
+		UserID:    user.ID,
+		Name:      "test",
+		KeyHash:   s.HashKey(virtualKey),
+		CreatedAt: time.Now(),
+	}
+	if err := s.db.CreateVirtualKey(ctx, key); err != nil {
+		t.Fatalf("CreateVirtualKey: %v", err)
+	}
+	return key, virtualKey
+}
+
+func TestValidateKeyRevokedWhenCacheDeleteFails(t *testing.T) {
+	db := testDB(t)
+	mr, c := testRedis(t)
+	s := NewKeyService(db, c, Keyring{}, nil, Policy{})
+	t.Cleanup(func() { s.Drain(context.Background()) })
+	ctx := context.Background()
+
+	key, virtualKey := createTestKey(t, s)
+
+	// Warm the cache with a usable configuration
+	config := &models.KeyConfig{KeyID: key.ID, UserID: key.UserID, Providers: map[string]string{"openai": "sk-test"}}
+	if err := c.SetKeyConfig(ctx, key.KeyHash, config); err != nil {
+		t.Fatalf("SetKeyConfig: %v", err)
+	}
+	if _, err := s.ValidateKey(ctx, virtualKey); err != nil {
+		t.Fatalf("ValidateKey before revocation: %v", err)
+	}
+
+	// Make every DEL fail, so DeleteKeyConfig leaves the cached config in place
+	mr.Server().SetPreHook(func(p *server.Peer, cmd string, args ...string) bool {
+		if strings.EqualFold(cmd, "DEL") {
+			p.WriteError("ERR injected failure")
+			return true
+		}
+		return false
+	})
+	defer mr.Server().SetPreHook(nil)
+
+	if err := s.RevokeKey(ctx, key.ID, key.UserID); err != nil {
+		t.Fatalf("RevokeKey: %v", err)
+	}
+	if !mr.Exists("key_config:" + key.KeyHash) {
+		t.Fatal("cached config was deleted; the injected failure didn't apply")
+	}
+
+	if _, err := s.ValidateKey(ctx, virtualKey); !errors.Is(err, ErrKeyRevoked) {
+		t.Fatalf("ValidateKey after revocation = %v, want ErrKeyRevoked", err)
+	}
+}
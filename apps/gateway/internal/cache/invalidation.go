@@ -0,0 +1,57 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+)
+
+// invalidationChannel is the pub/sub channel replicas use to tell each other to
+// drop in-process copies of data that changed
+const invalidationChannel = "invalidations"
+
+// Kinds of data carried by invalidation messages
+const (
+	InvalidateKeyConfig    = "key_config"    // ID is the key hash
+	InvalidateDeniedModels = "denied_models" // ID is unused
+)
+
+// PublishInvalidation tells every replica, including this one, that the item of
+// the given kind changed
+func (c *Cache) PublishInvalidation(ctx context.Context, kind, id string) error {
+	if err := c.client.Publish(ctx, invalidationChannel, kind+":"+id).Err(); err != nil {
+		return fmt.Errorf("failed to publish invalidation: %w", err)
+	}
+	return nil
+}
+
+// SubscribeInvalidations calls handle for every invalidation published by any
+// replica until ctx is done. The client resubscribes after Redis errors on its own;
+// messages published meanwhile are lost, so local caches must still expire.
+func (c *Cache) SubscribeInvalidations(ctx context.Context, handle func(kind, id string)) error {
+	sub := c.client.Subscribe(ctx, invalidationChannel)
+	defer sub.Close()
+
+	if _, err := sub.Receive(ctx); err != nil {
+		return fmt.Errorf("failed to subscribe to invalidations: %w", err)
+	}
+
+	messages := sub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case msg, ok := <-messages:
+			if !ok {
+				return nil
+			}
+			kind, id, found := strings.Cut(msg.Payload, ":")
+			if !found {
+				slog.Warn("ignoring malformed invalidation", "payload", msg.Payload)
+				continue
+			}
+			handle(kind, id)
+		}
+	}
+}
@@ -4,33 +4,75 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"strconv"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/redis/go-redis/v9"
 
 	"github.com/lumina/gateway/internal/models"
 )
 
 const (
-	keyConfigPrefix  = "key_config:"
-	rateLimitPrefix  = "rate_limit:"
-	keyConfigTTL     = 1 * time.Hour
-	rateLimitWindow  = 1 * time.Minute
+	keyConfigPrefix = "key_config:"
+	rateLimitPrefix = "rate_limit:"
+	lastUsedPrefix  = "last_used:"
+	slotsPrefix     = "slots:"
+	revokedPrefix   = "revoked:"
+	sessionPrefix   = "revoked_session:"
+	lockPrefix      = "lock:"
+	oncePrefix      = "once:"
+	sharePrefix     = "share_uses:"
+	quotaPrefix     = "request_quota:"
+	rateLimitWindow = 1 * time.Minute
+	lastUsedWindow  = 1 * time.Minute
+
+	// slotTTL reclaims concurrency slots whose holder never released them (e.g. a crashed
+	// replica). It must exceed the longest upstream request.
+	slotTTL = 5 * time.Minute
 )
 
+// acquireSlotScript atomically drops expired holders, then adds the new holder if a slot is free
+var acquireSlotScript = redis.NewScript(`
+local now = tonumber(ARGV[1])
+local ttl = tonumber(ARGV[2])
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', now - ttl)
+if redis.call('ZCARD', KEYS[1]) >= tonumber(ARGV[3]) then
+	return 0
+end
+redis.call('ZADD', KEYS[1], now, ARGV[4])
+redis.call('PEXPIRE', KEYS[1], ttl)
+return 1
+`)
+
+// releaseLockScript deletes a lock only if it is still held by the given token, so an
+// expired lock taken over by another replica is never released by the old holder
+var releaseLockScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+// Options configures the cache
+type Options struct {
+	KeyConfigTTL time.Duration // How long key configurations stay cached
+}
+
 // Cache wraps the Redis client
 type Cache struct {
 	client *redis.Client
+	opts   Options
 }
 
 // New creates a new Redis cache connection
-func New(redisURL string) (*Cache, error) {
-	opts, err := redis.ParseURL(redisURL)
+func New(redisURL string, opts Options) (*Cache, error) {
+	redisOpts, err := redis.ParseURL(redisURL)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse Redis URL: %w", err)
 	}
 
-	client := redis.NewClient(opts)
+	client := redis.NewClient(redisOpts)
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
@@ -39,7 +81,11 @@ func New(redisURL string) (*Cache, error) {
 		return nil, fmt.Errorf("failed to ping Redis: %w", err)
 	}
 
-	return &Cache{client: client}, nil
+	if opts.KeyConfigTTL <= 0 {
+		opts.KeyConfigTTL = time.Hour
+	}
+
+	return &Cache{client: client, opts: opts}, nil
 }
 
 // Close closes the Redis connection
@@ -47,19 +93,26 @@ func (c *Cache) Close() error {
 	return c.client.Close()
 }
 
-// GetKeyConfig retrieves a key configuration from cache
+// GetKeyConfig retrieves a key configuration from cache. Keys on the revocation
+// denylist are treated as missing, even if deleting their cached config failed.
 func (c *Cache) GetKeyConfig(ctx context.Context, keyHash string) (*models.KeyConfig, error) {
 	key := keyConfigPrefix + keyHash
-	data, err := c.client.Get(ctx, key).Bytes()
-	if err == redis.Nil {
-		return nil, nil
-	}
+	values, err := c.client.MGet(ctx, key, revokedPrefix+keyHash).Result()
 	if err != nil {
 		return nil, fmt.Errorf("failed to get key config: %w", err)
 	}
 
+	data, ok := values[0].(string)
+	if !ok {
+		return nil, nil
+	}
+	if values[1] != nil {
+		c.client.Del(ctx, key)
+		return nil, nil
+	}
+
 	var config models.KeyConfig
-	if err := json.Unmarshal(data, &config); err != nil {
+	if err := json.Unmarshal([]byte(data), &config); err != nil {
 		return nil, fmt.Errorf("failed to unmarshal key config: %w", err)
 	}
 
@@ -74,13 +127,90 @@ func (c *Cache) SetKeyConfig(ctx context.Context, keyHash string, config *models
 		return fmt.Errorf("failed to marshal key config: %w", err)
 	}
 
-	if err := c.client.Set(ctx, key, data, keyConfigTTL).Err(); err != nil {
+	if err := c.client.Set(ctx, key, data, c.opts.KeyConfigTTL).Err(); err != nil {
 		return fmt.Errorf("failed to set key config: %w", err)
 	}
 
 	return nil
 }
 
+// RaiseKeySpend sets the current spend of a cached key configuration to spend unless it
+// is already higher, as when a later update landed first. Keys that aren't cached are
+// left alone. The update is retried if the configuration changes concurrently.
+func (c *Cache) RaiseKeySpend(ctx context.Context, keyHash string, spend float64) error {
+	key := keyConfigPrefix + keyHash
+	raise := func(tx *redis.Tx) error {
+		data, err := tx.Get(ctx, key).Bytes()
+		if err == redis.Nil {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		var config models.KeyConfig
+		if err := json.Unmarshal(data, &config); err != nil {
+			return err
+		}
+		if config.CurrentSpend >= spend {
+			return nil
+		}
+		config.CurrentSpend = spend
+		updated, err := json.Marshal(&config)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.SetArgs(ctx, key, updated, redis.SetArgs{KeepTTL: true})
+			return nil
+		})
+		return err
+	}
+
+	for attempt := 0; attempt < 3; attempt++ {
+		err := c.client.Watch(ctx, raise, key)
+		if err != redis.TxFailedErr {
+			if err != nil {
+				return fmt.Errorf("failed to update cached key spend: %w", err)
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("failed to update cached key spend: too many concurrent updates")
+}
+
+// DenyKey puts a revoked key on the denylist checked by GetKeyConfig. Entries
+// only need to outlive any cached config, so they expire with the cache TTL.
+func (c *Cache) DenyKey(ctx context.Context, keyHash string) error {
+	if err := c.client.Set(ctx, revokedPrefix+keyHash, 1, c.opts.KeyConfigTTL).Err(); err != nil {
+		return fmt.Errorf("failed to deny key: %w", err)
+	}
+	return nil
+}
+
+// DenySession puts a revoked dashboard session on the denylist checked by
+// IsSessionDenied until its token expires
+func (c *Cache) DenySession(ctx context.Context, sessionID string, expiresAt time.Time) error {
+	ttl := time.Until(expiresAt)
+	if ttl <= 0 {
+		return nil
+	}
+	if err := c.client.Set(ctx, sessionPrefix+sessionID, 1, ttl).Err(); err != nil {
+		return fmt.Errorf("failed to deny session: %w", err)
+	}
+	return nil
+}
+
+// IsSessionDenied reports whether a dashboard session was revoked
+func (c *Cache) IsSessionDenied(ctx context.Context, sessionID string) (bool, error) {
+	n, err := c.client.Exists(ctx, sessionPrefix+sessionID).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to check session denylist: %w", err)
+	}
+	return n > 0, nil
+}
+
 // DeleteKeyConfig removes a key configuration from cache
 func (c *Cache) DeleteKeyConfig(ctx context.Context, keyHash string) error {
 	key := keyConfigPrefix + keyHash
@@ -90,24 +220,99 @@ func (c *Cache) DeleteKeyConfig(ctx context.Context, keyHash string) error {
 	return nil
 }
 
-// IncrementRateLimit increments the rate limit counter and returns the current count
-func (c *Cache) IncrementRateLimit(ctx context.Context, keyHash string) (int64, error) {
-	key := rateLimitPrefix + keyHash
+// rateLimitKey returns the counter of a key's fixed rate limit window starting at window
+func rateLimitKey(keyID string, window time.Time) string {
+	return fmt.Sprintf("%s%s:%d", rateLimitPrefix, keyID, window.Unix())
+}
+
+// IncrementRateLimit counts a request against the key's current fixed one-minute
+// window and returns the count so far and when the window resets
+func (c *Cache) IncrementRateLimit(ctx context.Context, keyID string) (int64, time.Time, error) {
+	window := time.Now().Truncate(rateLimitWindow)
+	key := rateLimitKey(keyID, window)
 
 	pipe := c.client.Pipeline()
 	incr := pipe.Incr(ctx, key)
 	pipe.Expire(ctx, key, rateLimitWindow)
 	_, err := pipe.Exec(ctx)
 	if err != nil {
-		return 0, fmt.Errorf("failed to increment rate limit: %w", err)
+		return 0, time.Time{}, fmt.Errorf("failed to increment rate limit: %w", err)
+	}
+
+	return incr.Val(), window.Add(rateLimitWindow), nil
+}
+
+// quotaPeriod returns the calendar month (UTC) containing t and when it ends
+func quotaPeriod(t time.Time) (string, time.Time) {
+	t = t.UTC()
+	start := time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+	return start.Format("2006-01"), start.AddDate(0, 1, 0)
+}
+
+// IncrementRequestQuota counts a request against the key's monthly request quota
+// and returns the count so far this calendar month (UTC) and when it resets. The
+// counter outlives its month by a day so late requests near midnight still land.
+func (c *Cache) IncrementRequestQuota(ctx context.Context, keyID string) (int64, time.Time, error) {
+	period, resetsAt := quotaPeriod(time.Now())
+	key := quotaPrefix + keyID + ":" + period
+
+	pipe := c.client.Pipeline()
+	incr := pipe.Incr(ctx, key)
+	pipe.ExpireAt(ctx, key, resetsAt.Add(24*time.Hour))
+	_, err := pipe.Exec(ctx)
+	if err != nil {
+		return 0, time.Time{}, fmt.Errorf("failed to increment request quota: %w", err)
+	}
+
+	return incr.Val(), resetsAt, nil
+}
+
+// GetRequestQuotaCounts returns the requests counted this calendar month (UTC) for
+// each of the keys, and when the counts reset
+func (c *Cache) GetRequestQuotaCounts(ctx context.Context, keyIDs []string) (map[string]int64, time.Time, error) {
+	period, resetsAt := quotaPeriod(time.Now())
+	counts := make(map[string]int64, len(keyIDs))
+	if len(keyIDs) == 0 {
+		return counts, resetsAt, nil
+	}
+
+	keys := make([]string, len(keyIDs))
+	for i, id := range keyIDs {
+		keys[i] = quotaPrefix + id + ":" + period
+	}
+	values, err := c.client.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("failed to get request quota counts: %w", err)
+	}
+
+	for i, value := range values {
+		if str, ok := value.(string); ok {
+			count, _ := strconv.ParseInt(str, 10, 64)
+			counts[keyIDs[i]] = count
+		}
+	}
+	return counts, resetsAt, nil
+}
+
+// IncrementShareTokenUses counts a request made with a share token and returns the
+// count so far. The counter expires together with the token.
+func (c *Cache) IncrementShareTokenUses(ctx context.Context, tokenID string, expiresAt time.Time) (int64, error) {
+	key := sharePrefix + tokenID
+
+	pipe := c.client.Pipeline()
+	incr := pipe.Incr(ctx, key)
+	pipe.ExpireAt(ctx, key, expiresAt)
+	_, err := pipe.Exec(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count share token use: %w", err)
 	}
 
 	return incr.Val(), nil
 }
 
-// GetRateLimitCount returns the current rate limit count
-func (c *Cache) GetRateLimitCount(ctx context.Context, keyHash string) (int64, error) {
-	key := rateLimitPrefix + keyHash
+// GetRateLimitCount returns the number of requests counted in the key's current window
+func (c *Cache) GetRateLimitCount(ctx context.Context, keyID string) (int64, error) {
+	key := rateLimitKey(keyID, time.Now().Truncate(rateLimitWindow))
 	count, err := c.client.Get(ctx, key).Int64()
 	if err == redis.Nil {
 		return 0, nil
@@ -117,3 +322,72 @@ func (c *Cache) GetRateLimitCount(ctx context.Context, keyHash string) (int64, e
 	}
 	return count, nil
 }
+
+// ShouldRecordUsage reports whether a usage timestamp for the given resource is due to be persisted.
+// It returns true at most once per lastUsedWindow so database writes are coalesced across requests and replicas.
+func (c *Cache) ShouldRecordUsage(ctx context.Context, resource string) (bool, error) {
+	key := lastUsedPrefix + resource
+	ok, err := c.client.SetNX(ctx, key, 1, lastUsedWindow).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to check usage throttle: %w", err)
+	}
+	return ok, nil
+}
+
+// FirstInWindow reports whether this is the first call for name within window
+// across all replicas, e.g. to send a notification at most once per window
+func (c *Cache) FirstInWindow(ctx context.Context, name string, window time.Duration) (bool, error) {
+	ok, err := c.client.SetNX(ctx, oncePrefix+name, 1, window).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to check notification window: %w", err)
+	}
+	return ok, nil
+}
+
+// AcquireSlot takes one of limit concurrent slots shared by all replicas for the
+// named resource. holder identifies the slot so it can be released.
+func (c *Cache) AcquireSlot(ctx context.Context, name, holder string, limit int) (bool, error) {
+	acquired, err := acquireSlotScript.Run(ctx, c.client, []string{slotsPrefix + name},
+		time.Now().UnixMilli(), slotTTL.Milliseconds(), limit, holder).Int()
+	if err != nil {
+		return false, fmt.Errorf("failed to acquire slot: %w", err)
+	}
+	return acquired == 1, nil
+}
+
+// ReleaseSlot frees a slot taken with AcquireSlot
+func (c *Cache) ReleaseSlot(ctx context.Context, name, holder string) error {
+	if err := c.client.ZRem(ctx, slotsPrefix+name, holder).Err(); err != nil {
+		return fmt.Errorf("failed to release slot: %w", err)
+	}
+	return nil
+}
+
+// Lock is a distributed lock held by this replica
+type Lock struct {
+	cache *Cache
+	key   string
+	token string
+}
+
+// AcquireLock takes the named lock for at most ttl, so a crashed holder can't block
+// others forever. It returns nil without error when another replica holds the lock.
+func (c *Cache) AcquireLock(ctx context.Context, name string, ttl time.Duration) (*Lock, error) {
+	lock := &Lock{cache: c, key: lockPrefix + name, token: uuid.New().String()}
+	ok, err := c.client.SetNX(ctx, lock.key, lock.token, ttl).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to acquire lock: %w", err)
+	}
+	if !ok {
+		return nil, nil
+	}
+	return lock, nil
+}
+
+// Release frees the lock if it is still held by this replica
+func (l *Lock) Release(ctx context.Context) error {
+	if err := releaseLockScript.Run(ctx, l.cache.client, []string{l.key}, l.token).Err(); err != nil {
+		return fmt.Errorf("failed to release lock: %w", err)
+	}
+	return nil
+}
@@ -3,31 +3,223 @@ package config
 import (
 	"fmt"
 	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
 )
 
 // Config holds all configuration for the gateway
 type Config struct {
-	Port          string
-	DatabaseURL   string
-	RedisURL      string
-	OpenSearchURL string
-	JWTSecret     string
-	EncryptionKey string
-	LogLevel      string
+	Port             string
+	TLSCertFile      string // Serve HTTPS with this certificate when set
+	TLSKeyFile       string
+	ProxyMTLSPort    string // Port serving the proxy routes with mutual TLS when ProxyClientCA is set
+	ProxyClientCA    string // PEM file of CAs proxy clients' certificates must be signed by
+	DatabaseURL      string
+	RedisURL         string
+	OpenSearchURLs   []string // One or more nodes, given as a comma-separated OPENSEARCH_URL
+	JWTSecret        string
+	EncryptionKey    string
+	KeyDerivation    string // How ENCRYPTION_KEY becomes the AES key: raw or scrypt
+	KeySalt          string // Salt for passphrase derivation
+	KeyVersion       int    // Version of ENCRYPTION_KEY, recorded with each provider key it encrypts
+	PreviousKey      string // Encryption key of the previous version, kept to decrypt provider keys not yet rotated
+	KeyHashSecret    string // HMAC secret for virtual key hashes, empty keeps plain SHA256
+	ShareTokenSecret string // HMAC secret for share tokens, empty disables them
+	LogLevel         string
+
+	// Connection limits, guarding against clients that hold connections open
+	MaxConnections    int           // Simultaneous connections accepted per listener, zero means unlimited
+	MaxHeaderBytes    int           // Largest request header block accepted
+	ReadHeaderTimeout time.Duration // Time a client gets to send its request headers
+
+	// Request/response body logging
+	LogBodyMode        string        // full, truncated or metadata
+	LogBodyMaxChars    int           // Character limit applied in truncated mode
+	LogContentMaxChars int           // Hard limit on logged response content in every mode, zero means unlimited
+	LogSampleRate      float64       // Fraction of successful requests logged; errors are always logged
+	DebugCaptureRate   float64       // Fraction of requests whose raw bodies go to the debug index
+	LogSearchMaxSize   int           // Largest page size accepted by the log search API
+	LogEnqueueTimeout  time.Duration // How long a request waits for room in a full logging pipeline, zero drops immediately
+	LogIndexRetry      time.Duration // How often index creation is retried when OpenSearch isn't ready at startup, zero disables
+	LogTailMax         int           // Maximum concurrent live log tail connections per replica, zero disables them
+	LogTailBuffer      int           // Entries buffered per live tail connection before it misses some
+	LogMetadataDynamic string        // Mapping of undeclared metadata tags in the log index: true, false or strict
+	LogMetadataFields  []string      // Metadata tags always mapped as keywords
+
+	// Key policy
+	RequireBudget  bool    // Reject keys created without a budget limit
+	MaxBudgetLimit float64 // Maximum budget limit per key, zero means unbounded
+	MaxKeysPerUser int     // Maximum active keys per user (admins can override per user), zero means unlimited
+	KeyPrefix      string  // Prefix of new virtual keys, e.g. lum_prod_, to tell environments apart
+
+	// Model denylist
+	DeniedModels         []string      // Model patterns rejected for every key
+	DeniedModelsInterval time.Duration // How often denied models are reloaded from the database
+
+	// Cache
+	KeyCacheTTL        time.Duration // How long key configurations stay cached in Redis
+	KeyLocalCacheTTL   time.Duration // How long key configurations stay cached in process memory, zero disables
+	KeyLocalCacheSize  int           // Maximum key configurations cached in process memory
+	SpendWorkers       int           // Goroutines recording spend from the spend queue, zero uses one goroutine per request
+	SpendQueueSize     int           // Spend updates queued before requests record their own inline
+	CacheWarmupKeys    int           // Most recently used keys preloaded into the cache on startup, zero disables
+	CacheWarmupTimeout time.Duration // Upper bound on the time spent warming the cache
+
+	// Scheduled jobs
+	StatsRollupAt      string // Time of day (HH:MM UTC) to recompute the previous day's stats from logs, empty when disabled
+	KeyIdleRevokeDays  int    // Revoke keys unused for this many days, zero disables
+	KeyIdleRevokeGrace int    // Days a never-used key is kept before it counts as idle
+
+	// Accounts
+	RegistrationEnabled bool // Allow self-service sign up; admins can always create users
+
+	// Dashboard
+	CORSAllowedOrigins []string // Origins allowed to call the API from a browser
+	CookieSameSite     string   // SameSite attribute of the session cookie: lax, strict or none
+	CookieSecure       bool     // Only send the session cookie over HTTPS
+
+	// Proxy behavior
+	StreamIncludeUsage bool          // Inject stream_options.include_usage into OpenAI streaming requests
+	StreamIdleTimeout  time.Duration // End a stream when the upstream sends nothing for this long, zero disables
+	UpstreamTimeout    time.Duration // Deadline for non-streaming upstream calls, zero disables
+	StreamTimeout      time.Duration // Deadline for streaming upstream calls, zero leaves them to StreamIdleTimeout
+	AnthropicMaxTokens int           // max_tokens injected into Anthropic requests that omit it, zero rejects them
+	GzipMinBytes       int           // Gzip upstream request bodies of at least this size, zero disables
+	GzipProviders      []string      // Providers that accept gzip-encoded request bodies
+	EchoRequestID      bool          // Echo client request ID headers on proxy responses
+	RequestIDHeader    string        // Header carrying client request IDs recorded in logs, e.g. X-Correlation-Id or traceparent
+	LegacyCompletions  bool          // Serve the deprecated /v1/completions endpoint
+	DefaultProvider    string        // Provider for model names without a "provider/" prefix, empty rejects them
+	ProviderMetadata   string        // Forward body metadata to providers: off, client or merge
+	RewriteModel       bool          // Report the requested model name in responses instead of the served one
+	TransformsFile     string        // JSON file extending the built-in per-provider request transforms
+	UnsupportedParams  string        // Handling of parameters the provider doesn't support: strip or reject
+	UnknownPricing     string        // Handling of models missing from the pricing table: fallback or reject
+	ForwardedHeaders   []string      // Upstream response headers relayed to clients, "*" suffix matches a prefix
+
+	// Global per-provider concurrency
+	ProviderConcurrency     map[string]int // Maximum in-flight requests per provider across replicas
+	ProviderConcurrencyWait time.Duration  // How long a request queues for a slot before a 429
+
+	// Concurrent streaming connections per replica
+	MaxStreams       int // Overall cap, zero means unlimited
+	MaxStreamsPerKey int // Cap for any single key, zero means unlimited
+
+	// Rate limiting
+	RateLimit int // Requests per minute allowed for each key across replicas, zero means unlimited
+
+	// Webhooks
+	WebhookURL    string // Receives event notifications such as budget.exceeded, empty disables them
+	WebhookSecret string // Signs webhook payloads with HMAC-SHA256 when set
 }
 
 // Load reads configuration from environment variables
 func Load() (*Config, error) {
+	env := &envParser{}
 	cfg := &Config{
-		Port:          getEnv("PORT", "8080"),
-		DatabaseURL:   os.Getenv("DATABASE_URL"),
-		RedisURL:      getEnv("REDIS_URL", "redis://localhost:6379"),
-		OpenSearchURL: getEnv("OPENSEARCH_URL", "http://localhost:9200"),
-		JWTSecret:     os.Getenv("JWT_SECRET"),
-		EncryptionKey: os.Getenv("ENCRYPTION_KEY"),
-		LogLevel:      getEnv("LOG_LEVEL", "info"),
+		Port:             getEnv("PORT", "8080"),
+		TLSCertFile:      os.Getenv("TLS_CERT_FILE"),
+		TLSKeyFile:       os.Getenv("TLS_KEY_FILE"),
+		ProxyMTLSPort:    getEnv("PROXY_MTLS_PORT", "8443"),
+		ProxyClientCA:    os.Getenv("PROXY_CLIENT_CA_FILE"),
+		DatabaseURL:      os.Getenv("DATABASE_URL"),
+		RedisURL:         getEnv("REDIS_URL", "redis://localhost:6379"),
+		OpenSearchURLs:   getEnvList("OPENSEARCH_URL", []string{"http://localhost:9200"}),
+		JWTSecret:        os.Getenv("JWT_SECRET"),
+		EncryptionKey:    os.Getenv("ENCRYPTION_KEY"),
+		KeyDerivation:    getEnv("ENCRYPTION_KEY_DERIVATION", "raw"),
+		KeySalt:          os.Getenv("ENCRYPTION_KEY_SALT"),
+		KeyVersion:       env.getInt("ENCRYPTION_KEY_VERSION", 1),
+		PreviousKey:      os.Getenv("ENCRYPTION_KEY_PREVIOUS"),
+		KeyHashSecret:    os.Getenv("KEY_HASH_SECRET"),
+		ShareTokenSecret: os.Getenv("SHARE_TOKEN_SECRET"),
+		LogLevel:         getEnv("LOG_LEVEL", "info"),
+
+		MaxConnections:    env.getInt("MAX_CONNECTIONS", 4096),
+		MaxHeaderBytes:    env.getInt("MAX_HEADER_BYTES", 64<<10),
+		ReadHeaderTimeout: env.getDuration("READ_HEADER_TIMEOUT", 10*time.Second),
+
+		LogBodyMode:        getEnv("LOG_BODY_MODE", "full"),
+		LogBodyMaxChars:    env.getInt("LOG_BODY_MAX_CHARS", 2000),
+		LogContentMaxChars: env.getInt("LOG_CONTENT_MAX_CHARS", 100000),
+		LogSampleRate:      env.getFloat("LOG_SAMPLE_RATE", 1),
+		DebugCaptureRate:   env.getFloat("DEBUG_CAPTURE_RATE", 0),
+		LogSearchMaxSize:   env.getInt("LOG_SEARCH_MAX_SIZE", 100),
+		LogEnqueueTimeout:  env.getDuration("LOG_ENQUEUE_TIMEOUT", 0),
+		LogIndexRetry:      env.getDuration("OPENSEARCH_INDEX_RETRY", 15*time.Second),
+		LogTailMax:         env.getInt("LOG_TAIL_MAX_SUBSCRIBERS", 100),
+		LogTailBuffer:      env.getInt("LOG_TAIL_BUFFER", 100),
+		LogMetadataDynamic: getEnv("LOG_METADATA_DYNAMIC", "false"),
+		LogMetadataFields:  getEnvList("LOG_METADATA_FIELDS", nil),
+
+		RequireBudget:  env.getBool("REQUIRE_BUDGET", false),
+		MaxBudgetLimit: env.getFloat("MAX_BUDGET_LIMIT", 0),
+		MaxKeysPerUser: env.getInt("MAX_KEYS_PER_USER", 100),
+		KeyPrefix:      getEnv("KEY_PREFIX", "lum_"),
+
+		DeniedModels:         getEnvList("MODEL_DENYLIST", nil),
+		DeniedModelsInterval: env.getDuration("MODEL_DENYLIST_REFRESH", 30*time.Second),
+
+		KeyCacheTTL:        env.getDuration("KEY_CACHE_TTL", time.Hour),
+		KeyLocalCacheTTL:   env.getDuration("KEY_LOCAL_CACHE_TTL", 5*time.Second),
+		KeyLocalCacheSize:  env.getInt("KEY_LOCAL_CACHE_SIZE", 10000),
+		SpendWorkers:       env.getInt("SPEND_WORKERS", 4),
+		SpendQueueSize:     env.getInt("SPEND_QUEUE_SIZE", 1000),
+		CacheWarmupKeys:    env.getInt("CACHE_WARMUP_KEYS", 0),
+		CacheWarmupTimeout: env.getDuration("CACHE_WARMUP_TIMEOUT", 30*time.Second),
+
+		StatsRollupAt:      getEnv("STATS_ROLLUP_AT", "off"),
+		KeyIdleRevokeDays:  env.getInt("KEY_IDLE_REVOKE_DAYS", 0),
+		KeyIdleRevokeGrace: env.getInt("KEY_IDLE_REVOKE_GRACE_DAYS", 30),
+
+		RegistrationEnabled: env.getBool("REGISTRATION_ENABLED", true),
+
+		CORSAllowedOrigins: getEnvList("CORS_ALLOWED_ORIGINS", []string{"http://localhost:3000", "http://127.0.0.1:3000"}),
+		CookieSameSite:     strings.ToLower(getEnv("COOKIE_SAMESITE", "lax")),
+		CookieSecure:       env.getBool("COOKIE_SECURE", false),
+
+		StreamIncludeUsage: env.getBool("STREAM_INCLUDE_USAGE", true),
+		StreamIdleTimeout:  env.getDuration("STREAM_IDLE_TIMEOUT", 60*time.Second),
+		UpstreamTimeout:    env.getDuration("UPSTREAM_TIMEOUT", 60*time.Second),
+		StreamTimeout:      env.getDuration("UPSTREAM_STREAM_TIMEOUT", 30*time.Minute),
+		AnthropicMaxTokens: env.getInt("ANTHROPIC_DEFAULT_MAX_TOKENS", 4096),
+		GzipMinBytes:       env.getInt("UPSTREAM_GZIP_MIN_BYTES", 0),
+		GzipProviders:      getEnvList("UPSTREAM_GZIP_PROVIDERS", []string{"openai"}),
+		EchoRequestID:      env.getBool("REQUEST_ID_ECHO", true),
+		RequestIDHeader:    getEnv("REQUEST_ID_HEADER", "X-Request-Id"),
+		LegacyCompletions:  env.getBool("LEGACY_COMPLETIONS_ENABLED", true),
+		DefaultProvider:    os.Getenv("DEFAULT_PROVIDER"),
+		ProviderMetadata:   getEnv("PROVIDER_METADATA", "off"),
+		RewriteModel:       env.getBool("RESPONSE_MODEL_REWRITE", false),
+		TransformsFile:     os.Getenv("REQUEST_TRANSFORMS_FILE"),
+		UnsupportedParams:  strings.ToLower(getEnv("UNSUPPORTED_PARAMS", "strip")),
+		UnknownPricing:     strings.ToLower(getEnv("UNKNOWN_MODEL_PRICING", "fallback")),
+		ForwardedHeaders: getEnvList("UPSTREAM_RESPONSE_HEADERS", []string{
+			"Content-Type", "Retry-After", "X-Ratelimit-*", "Anthropic-Ratelimit-*", "Openai-Processing-Ms",
+		}),
+
+		ProviderConcurrencyWait: env.getDuration("PROVIDER_CONCURRENCY_WAIT", 0),
+
+		MaxStreams:       env.getInt("MAX_CONCURRENT_STREAMS", 0),
+		MaxStreamsPerKey: env.getInt("MAX_CONCURRENT_STREAMS_PER_KEY", 0),
+		RateLimit:        env.getInt("RATE_LIMIT_PER_MINUTE", 0),
+
+		WebhookURL:    os.Getenv("WEBHOOK_URL"),
+		WebhookSecret: os.Getenv("WEBHOOK_SECRET"),
+	}
+
+	if env.err != nil {
+		return nil, env.err
 	}
 
+	concurrency, err := getEnvIntMap("PROVIDER_CONCURRENCY_LIMITS")
+	if err != nil {
+		return nil, err
+	}
+	cfg.ProviderConcurrency = concurrency
+
 	if cfg.DatabaseURL == "" {
 		return nil, fmt.Errorf("DATABASE_URL is required")
 	}
@@ -36,20 +228,337 @@ func Load() (*Config, error) {
 		return nil, fmt.Errorf("JWT_SECRET is required")
 	}
 
+	// A short HS256 secret can be brute-forced, letting anyone forge session tokens
+	if len(cfg.JWTSecret) < 32 {
+		return nil, fmt.Errorf("JWT_SECRET must be at least 32 characters")
+	}
+
+	if (cfg.TLSCertFile == "") != (cfg.TLSKeyFile == "") {
+		return nil, fmt.Errorf("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+
+	if cfg.ProxyClientCA != "" {
+		if cfg.TLSCertFile == "" {
+			return nil, fmt.Errorf("TLS_CERT_FILE and TLS_KEY_FILE are required when PROXY_CLIENT_CA_FILE is set")
+		}
+		if cfg.ProxyMTLSPort == cfg.Port {
+			return nil, fmt.Errorf("PROXY_MTLS_PORT must differ from PORT")
+		}
+	}
+
 	if cfg.EncryptionKey == "" {
 		return nil, fmt.Errorf("ENCRYPTION_KEY is required")
 	}
 
-	if len(cfg.EncryptionKey) < 32 {
-		return nil, fmt.Errorf("ENCRYPTION_KEY must be at least 32 characters")
+	switch cfg.KeyDerivation {
+	case "raw":
+		if len(cfg.EncryptionKey) < 32 {
+			return nil, fmt.Errorf("ENCRYPTION_KEY must be at least 32 characters")
+		}
+	case "scrypt":
+		if len(cfg.KeySalt) < 16 {
+			return nil, fmt.Errorf("ENCRYPTION_KEY_SALT must be at least 16 characters when ENCRYPTION_KEY_DERIVATION is scrypt")
+		}
+	default:
+		return nil, fmt.Errorf("ENCRYPTION_KEY_DERIVATION must be raw or scrypt")
+	}
+
+	if cfg.KeyVersion < 1 {
+		return nil, fmt.Errorf("ENCRYPTION_KEY_VERSION must be a positive integer")
+	}
+
+	if cfg.PreviousKey != "" {
+		if cfg.KeyVersion < 2 {
+			return nil, fmt.Errorf("ENCRYPTION_KEY_VERSION must be at least 2 when ENCRYPTION_KEY_PREVIOUS is set")
+		}
+		if cfg.PreviousKey == cfg.EncryptionKey {
+			return nil, fmt.Errorf("ENCRYPTION_KEY_PREVIOUS must differ from ENCRYPTION_KEY")
+		}
+		if cfg.KeyDerivation == "raw" && len(cfg.PreviousKey) < 32 {
+			return nil, fmt.Errorf("ENCRYPTION_KEY_PREVIOUS must be at least 32 characters")
+		}
+	}
+
+	if cfg.KeyHashSecret != "" {
+		if len(cfg.KeyHashSecret) < 32 {
+			return nil, fmt.Errorf("KEY_HASH_SECRET must be at least 32 characters")
+		}
+		if cfg.KeyHashSecret == cfg.EncryptionKey {
+			return nil, fmt.Errorf("KEY_HASH_SECRET must differ from ENCRYPTION_KEY")
+		}
+	}
+
+	if cfg.ShareTokenSecret != "" {
+		if len(cfg.ShareTokenSecret) < 32 {
+			return nil, fmt.Errorf("SHARE_TOKEN_SECRET must be at least 32 characters")
+		}
+		if cfg.ShareTokenSecret == cfg.EncryptionKey || cfg.ShareTokenSecret == cfg.JWTSecret || cfg.ShareTokenSecret == cfg.KeyHashSecret {
+			return nil, fmt.Errorf("SHARE_TOKEN_SECRET must differ from ENCRYPTION_KEY, JWT_SECRET and KEY_HASH_SECRET")
+		}
+	}
+
+	switch cfg.LogBodyMode {
+	case "full", "truncated", "metadata":
+	default:
+		return nil, fmt.Errorf("LOG_BODY_MODE must be one of full, truncated or metadata")
+	}
+
+	switch cfg.CookieSameSite {
+	case "lax", "strict":
+	case "none":
+		// Browsers drop SameSite=None cookies that aren't Secure
+		if !cfg.CookieSecure {
+			return nil, fmt.Errorf("COOKIE_SAMESITE=none requires COOKIE_SECURE=true")
+		}
+	default:
+		return nil, fmt.Errorf("COOKIE_SAMESITE must be one of lax, strict or none")
+	}
+
+	if cfg.LogSampleRate < 0 || cfg.LogSampleRate > 1 {
+		return nil, fmt.Errorf("LOG_SAMPLE_RATE must be between 0 and 1")
+	}
+
+	if cfg.DebugCaptureRate < 0 || cfg.DebugCaptureRate > 1 {
+		return nil, fmt.Errorf("DEBUG_CAPTURE_RATE must be between 0 and 1")
+	}
+
+	if cfg.DeniedModelsInterval <= 0 {
+		return nil, fmt.Errorf("MODEL_DENYLIST_REFRESH must be positive")
+	}
+
+	if cfg.StatsRollupAt == "off" {
+		cfg.StatsRollupAt = ""
+	} else if _, err := time.Parse("15:04", cfg.StatsRollupAt); err != nil {
+		return nil, fmt.Errorf("STATS_ROLLUP_AT must be a time of day as HH:MM, or off")
+	}
+
+	if cfg.KeyIdleRevokeDays < 0 || cfg.KeyIdleRevokeGrace < 0 {
+		return nil, fmt.Errorf("KEY_IDLE_REVOKE_DAYS and KEY_IDLE_REVOKE_GRACE_DAYS must not be negative")
+	}
+
+	switch cfg.ProviderMetadata {
+	case "off", "client", "merge":
+	default:
+		return nil, fmt.Errorf("PROVIDER_METADATA must be one of off, client or merge")
+	}
+
+	switch cfg.UnsupportedParams {
+	case "strip", "reject":
+	default:
+		return nil, fmt.Errorf("UNSUPPORTED_PARAMS must be strip or reject")
+	}
+
+	switch cfg.UnknownPricing {
+	case "fallback", "reject":
+	default:
+		return nil, fmt.Errorf("UNKNOWN_MODEL_PRICING must be fallback or reject")
+	}
+
+	switch cfg.DefaultProvider {
+	case "", "openai", "anthropic":
+	default:
+		return nil, fmt.Errorf("DEFAULT_PROVIDER must be openai or anthropic")
+	}
+
+	if cfg.KeyCacheTTL <= 0 {
+		return nil, fmt.Errorf("KEY_CACHE_TTL must be positive")
+	}
+
+	if cfg.KeyLocalCacheTTL < 0 {
+		return nil, fmt.Errorf("KEY_LOCAL_CACHE_TTL must not be negative")
+	}
+
+	if cfg.KeyLocalCacheTTL > 0 && cfg.KeyLocalCacheSize < 1 {
+		return nil, fmt.Errorf("KEY_LOCAL_CACHE_SIZE must be a positive integer")
+	}
+
+	if cfg.SpendWorkers < 0 {
+		return nil, fmt.Errorf("SPEND_WORKERS must not be negative")
+	}
+
+	if cfg.SpendWorkers > 0 && cfg.SpendQueueSize < 1 {
+		return nil, fmt.Errorf("SPEND_QUEUE_SIZE must be a positive integer")
+	}
+
+	if cfg.CacheWarmupKeys < 0 {
+		return nil, fmt.Errorf("CACHE_WARMUP_KEYS must not be negative")
+	}
+
+	if cfg.AnthropicMaxTokens < 0 {
+		return nil, fmt.Errorf("ANTHROPIC_DEFAULT_MAX_TOKENS must not be negative")
+	}
+
+	if cfg.LogSearchMaxSize < 1 || cfg.LogSearchMaxSize > 10000 {
+		return nil, fmt.Errorf("LOG_SEARCH_MAX_SIZE must be between 1 and 10000")
+	}
+
+	if cfg.LogEnqueueTimeout < 0 || cfg.LogEnqueueTimeout > time.Second {
+		return nil, fmt.Errorf("LOG_ENQUEUE_TIMEOUT must be between 0 and 1s")
+	}
+
+	if !keyPrefixPattern.MatchString(cfg.KeyPrefix) {
+		return nil, fmt.Errorf("KEY_PREFIX must start with lum_ and end with an underscore, using lowercase letters, digits and underscores, e.g. lum_prod_")
+	}
+
+	if cfg.MaxBudgetLimit < 0 {
+		return nil, fmt.Errorf("MAX_BUDGET_LIMIT must not be negative")
+	}
+
+	if cfg.RateLimit < 0 {
+		return nil, fmt.Errorf("RATE_LIMIT_PER_MINUTE must not be negative")
+	}
+
+	if cfg.MaxConnections < 0 {
+		return nil, fmt.Errorf("MAX_CONNECTIONS must not be negative")
+	}
+
+	if cfg.MaxHeaderBytes < 1 {
+		return nil, fmt.Errorf("MAX_HEADER_BYTES must be a positive integer")
+	}
+
+	if cfg.ReadHeaderTimeout <= 0 {
+		return nil, fmt.Errorf("READ_HEADER_TIMEOUT must be positive")
+	}
+
+	if cfg.MaxStreams < 0 || cfg.MaxStreamsPerKey < 0 {
+		return nil, fmt.Errorf("MAX_CONCURRENT_STREAMS and MAX_CONCURRENT_STREAMS_PER_KEY must not be negative")
+	}
+
+	if cfg.UpstreamTimeout < 0 || cfg.StreamTimeout < 0 {
+		return nil, fmt.Errorf("UPSTREAM_TIMEOUT and UPSTREAM_STREAM_TIMEOUT must not be negative")
+	}
+
+	if cfg.LogTailMax < 0 || cfg.LogTailBuffer < 1 {
+		return nil, fmt.Errorf("LOG_TAIL_MAX_SUBSCRIBERS must not be negative and LOG_TAIL_BUFFER must be a positive integer")
+	}
+
+	if cfg.LogIndexRetry < 0 {
+		return nil, fmt.Errorf("OPENSEARCH_INDEX_RETRY must not be negative")
+	}
+
+	if strings.ContainsAny(cfg.RequestIDHeader, " \t:") {
+		return nil, fmt.Errorf("REQUEST_ID_HEADER must be a header name, e.g. X-Correlation-Id")
+	}
+
+	if cfg.LogMetadataDynamic != "true" && cfg.LogMetadataDynamic != "false" && cfg.LogMetadataDynamic != "strict" {
+		return nil, fmt.Errorf("LOG_METADATA_DYNAMIC must be true, false or strict")
+	}
+
+	for _, field := range cfg.LogMetadataFields {
+		if strings.ContainsAny(field, ".*") {
+			return nil, fmt.Errorf("LOG_METADATA_FIELDS contains invalid metadata tag %q", field)
+		}
+	}
+
+	if cfg.LogContentMaxChars < 0 {
+		return nil, fmt.Errorf("LOG_CONTENT_MAX_CHARS must not be negative")
+	}
+
+	if cfg.LogBodyMaxChars < 1 {
+		return nil, fmt.Errorf("LOG_BODY_MAX_CHARS must be a positive integer")
 	}
 
 	return cfg, nil
 }
 
+// keyPrefixPattern matches valid virtual key prefixes such as "lum_" and "lum_prod_"
+var keyPrefixPattern = regexp.MustCompile(`^lum_([a-z0-9]+_)*$`)
+
 func getEnv(key, defaultValue string) string {
 	if value := os.Getenv(key); value != "" {
 		return value
 	}
 	return defaultValue
 }
+
+// envParser reads typed environment variables, keeping the first value that
+// doesn't parse in err so that a typo fails startup instead of quietly running
+// with the default
+type envParser struct {
+	err error
+}
+
+func (p *envParser) invalid(key, want string) {
+	if p.err == nil {
+		p.err = fmt.Errorf("%s must be %s, got %q", key, want, os.Getenv(key))
+	}
+}
+
+func (p *envParser) getBool(key string, defaultValue bool) bool {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		p.invalid(key, "true or false")
+		return defaultValue
+	}
+	return b
+}
+
+func (p *envParser) getInt(key string, defaultValue int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		p.invalid(key, "an integer")
+		return defaultValue
+	}
+	return i
+}
+
+func (p *envParser) getDuration(key string, defaultValue time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		p.invalid(key, "a duration such as 30s or 5m")
+		return defaultValue
+	}
+	return d
+}
+
+func (p *envParser) getFloat(key string, defaultValue float64) float64 {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		p.invalid(key, "a number")
+		return defaultValue
+	}
+	return f
+}
+
+func getEnvList(key string, defaultValue []string) []string {
+	var values []string
+	for _, value := range strings.Split(os.Getenv(key), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	if len(values) == 0 {
+		return defaultValue
+	}
+	return values
+}
+
+// getEnvIntMap parses a comma-separated list of name=value pairs, e.g. "openai=50,anthropic=20"
+func getEnvIntMap(key string) (map[string]int, error) {
+	values := map[string]int{}
+	for _, pair := range getEnvList(key, nil) {
+		name, value, ok := strings.Cut(pair, "=")
+		n, err := strconv.Atoi(strings.TrimSpace(value))
+		if !ok || err != nil || n < 0 {
+			return nil, fmt.Errorf("%s must be a comma-separated list of name=count pairs", key)
+		}
+		values[strings.TrimSpace(name)] = n
+	}
+	return values, nil
+}
@@ -0,0 +1,106 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+// setRequiredEnv sets the variables Load requires, other than JWT_SECRET
+func setRequiredEnv(t *testing.T) {
+	t.Helper()
+	t.Setenv("DATABASE_URL", "postgres://localhost/lumina")
+	t.Setenv("ENCRYPTION_KEY", strings.Repeat("e", 32))
+}
+
+func TestLoadJWTSecretLength(t *testing.T) {
+	tests := []struct {
+		name    string
+		secret  string
+		wantErr string
+	}{
+		{"missing", "", "JWT_SECRET is required"},
+		{"31 characters", strings.Repeat("s", 31), "JWT_SECRET must be at least 32 characters"},
+		{"32 characters", strings.Repeat("s", 32), ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setRequiredEnv(t)
+			t.Setenv("JWT_SECRET", tt.secret)
+
+			cfg, err := Load()
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("Load() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Load() error = %v", err)
+			}
+			if cfg.JWTSecret != tt.secret {
+				t.Errorf("JWTSecret = %q, want %q", cfg.JWTSecret, tt.secret)
+			}
+		})
+	}
+}
+
+func TestLoadInvalidBool(t *testing.T) {
+	tests := []struct {
+		key   string
+		value string
+	}{
+		{"REGISTRATION_ENABLED", "no"},
+		{"REGISTRATION_ENABLED", "off"},
+		{"COOKIE_SECURE", "yes"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.key+"="+tt.value, func(t *testing.T) {
+			setRequiredEnv(t)
+			t.Setenv("JWT_SECRET", strings.Repeat("s", 32))
+			t.Setenv(tt.key, tt.value)
+
+			_, err := Load()
+			if err == nil || !strings.HasPrefix(err.Error(), tt.key+" must be true or false") {
+				t.Fatalf("Load() error = %v, want one naming %s", err, tt.key)
+			}
+		})
+	}
+}
+
+func TestLoadRegistrationDisabled(t *testing.T) {
+	setRequiredEnv(t)
+	t.Setenv("JWT_SECRET", strings.Repeat("s", 32))
+	t.Setenv("REGISTRATION_ENABLED", "false")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.RegistrationEnabled {
+		t.Error("RegistrationEnabled = true, want false")
+	}
+}
+
+func TestLoadInvalidNumber(t *testing.T) {
+	tests := []struct {
+		key   string
+		value string
+		want  string
+	}{
+		{"MAX_KEYS_PER_USER", "10O", "MAX_KEYS_PER_USER must be an integer"},
+		{"UPSTREAM_TIMEOUT", "60", "UPSTREAM_TIMEOUT must be a duration"},
+		{"MAX_BUDGET_LIMIT", "$100", "MAX_BUDGET_LIMIT must be a number"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.key+"="+tt.value, func(t *testing.T) {
+			setRequiredEnv(t)
+			t.Setenv("JWT_SECRET", strings.Repeat("s", 32))
+			t.Setenv(tt.key, tt.value)
+
+			_, err := Load()
+			if err == nil || !strings.HasPrefix(err.Error(), tt.want) {
+				t.Fatalf("Load() error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,9 @@
+-- Migration: Provider labels and usage tracking
+-- Provider credentials can carry a free-form label (e.g. "production", "staging")
+-- and record when they were last used by the proxy
+
+ALTER TABLE user_providers ADD COLUMN IF NOT EXISTS label VARCHAR(255) NOT NULL DEFAULT '';
+ALTER TABLE user_providers ADD COLUMN IF NOT EXISTS last_used_at TIMESTAMP;
+
+-- Add indexes for filtering
+CREATE INDEX IF NOT EXISTS idx_user_providers_label ON user_providers(user_id, label);
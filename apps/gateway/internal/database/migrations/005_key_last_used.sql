@@ -0,0 +1,4 @@
+-- Migration: Virtual key usage tracking
+-- Records when each virtual key was last used so stale keys can be identified
+
+ALTER TABLE virtual_keys ADD COLUMN IF NOT EXISTS last_used_at TIMESTAMP;
@@ -0,0 +1,4 @@
+-- Migration: Per-key request body logging mode
+-- An empty value means the key uses the deployment-wide default (LOG_BODY_MODE)
+
+ALTER TABLE virtual_keys ADD COLUMN IF NOT EXISTS log_body_mode VARCHAR(16) NOT NULL DEFAULT '';
@@ -0,0 +1,4 @@
+-- Migration: Per-key log sampling
+-- Fraction of successful requests that get logged; NULL means the key uses LOG_SAMPLE_RATE
+
+ALTER TABLE virtual_keys ADD COLUMN IF NOT EXISTS log_sample_rate DOUBLE PRECISION;
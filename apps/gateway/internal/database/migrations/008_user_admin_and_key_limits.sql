@@ -0,0 +1,10 @@
+-- Migration: Admin users and per-user key limits
+-- Admins can override the deployment-wide MAX_KEYS_PER_USER for individual users
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS is_admin BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS max_keys INTEGER;
+
+-- The earliest registered user administers existing deployments
+UPDATE users SET is_admin = TRUE
+WHERE id = (SELECT id FROM users ORDER BY created_at LIMIT 1)
+AND NOT EXISTS (SELECT 1 FROM users WHERE is_admin);
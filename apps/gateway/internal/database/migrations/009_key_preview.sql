@@ -0,0 +1,4 @@
+-- Migration: Key preview
+-- Stores a non-sensitive preview (prefix plus last 4 characters) so users can tell keys apart
+
+ALTER TABLE virtual_keys ADD COLUMN IF NOT EXISTS key_preview VARCHAR(32) NOT NULL DEFAULT '';
@@ -0,0 +1,4 @@
+-- Migration: Index keys by last use
+-- Supports preloading the most recently used keys into the cache on startup
+
+CREATE INDEX IF NOT EXISTS idx_virtual_keys_last_used ON virtual_keys(last_used_at DESC) WHERE revoked_at IS NULL;
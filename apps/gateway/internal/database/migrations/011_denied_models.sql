@@ -0,0 +1,8 @@
+-- Migration: Denied models
+-- Operator-level model patterns rejected regardless of key allow-lists
+
+CREATE TABLE IF NOT EXISTS denied_models (
+    pattern VARCHAR(255) PRIMARY KEY,
+    reason TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
@@ -0,0 +1,4 @@
+-- Migration: Per-key handling of streams that exceed the budget
+-- An empty value lets the stream finish and flags the log entry (same as 'flag')
+
+ALTER TABLE virtual_keys ADD COLUMN IF NOT EXISTS stream_budget_mode VARCHAR(16) NOT NULL DEFAULT '';
@@ -0,0 +1,4 @@
+-- Migration: Per-key debug flag
+-- Debug keys may override the request's model with the X-Lumina-Model header
+
+ALTER TABLE virtual_keys ADD COLUMN IF NOT EXISTS debug BOOLEAN NOT NULL DEFAULT FALSE;
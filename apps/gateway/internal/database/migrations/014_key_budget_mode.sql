@@ -0,0 +1,4 @@
+-- Migration: Per-key budget mode
+-- 'soft' lets requests past the budget limit through and flags them; an empty value behaves like 'hard'
+
+ALTER TABLE virtual_keys ADD COLUMN IF NOT EXISTS budget_mode VARCHAR(16) NOT NULL DEFAULT '';
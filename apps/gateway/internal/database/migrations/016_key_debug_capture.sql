@@ -0,0 +1,4 @@
+-- Migration: Per-key debug capture
+-- Keys with debug_capture have the raw bodies of every request stored in the lumina-debug index
+
+ALTER TABLE virtual_keys ADD COLUMN IF NOT EXISTS debug_capture BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1,4 @@
+-- Migration: Provider key model capabilities
+-- Model patterns a provider credential has access to (e.g. {"gpt-4o*"}); empty means any model
+
+ALTER TABLE user_providers ADD COLUMN IF NOT EXISTS models TEXT[] NOT NULL DEFAULT '{}';
@@ -0,0 +1,23 @@
+-- Migration: Encryption key rotation
+-- Provider keys record the version of the encryption key they were encrypted with,
+-- so they can be re-encrypted under a new key in the background
+
+ALTER TABLE user_providers ADD COLUMN IF NOT EXISTS key_version INTEGER NOT NULL DEFAULT 1;
+
+CREATE INDEX IF NOT EXISTS idx_user_providers_key_version ON user_providers(key_version);
+
+CREATE TABLE IF NOT EXISTS encryption_rotations (
+    id UUID PRIMARY KEY,
+    target_version INTEGER NOT NULL,
+    status VARCHAR(16) NOT NULL, -- running, completed
+    total INTEGER NOT NULL DEFAULT 0,
+    rotated INTEGER NOT NULL DEFAULT 0,
+    failed INTEGER NOT NULL DEFAULT 0,
+    cursor_id UUID, -- Last provider key processed, the job resumes after it
+    started_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    started_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    completed_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_encryption_rotations_started_at ON encryption_rotations(started_at);
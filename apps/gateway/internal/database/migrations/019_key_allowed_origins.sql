@@ -0,0 +1,4 @@
+-- Migration: Per-key allowed origins
+-- Web origins a key may be used from, checked against the Origin or Referer header; empty means any
+
+ALTER TABLE virtual_keys ADD COLUMN IF NOT EXISTS allowed_origins TEXT[] NOT NULL DEFAULT '{}';
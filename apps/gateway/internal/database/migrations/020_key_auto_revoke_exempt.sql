@@ -0,0 +1,4 @@
+-- Migration: Per-key opt-out of idle key revocation
+-- Keys with auto_revoke_exempt are never revoked for being unused
+
+ALTER TABLE virtual_keys ADD COLUMN IF NOT EXISTS auto_revoke_exempt BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1,4 @@
+-- Migration: Per-key parameter limits
+-- Bounds and defaults for sampling parameters such as temperature, keyed by parameter name
+
+ALTER TABLE virtual_keys ADD COLUMN IF NOT EXISTS param_limits JSONB NOT NULL DEFAULT '{}';
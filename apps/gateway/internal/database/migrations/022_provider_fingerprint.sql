@@ -0,0 +1,4 @@
+-- Migration: Provider key fingerprints
+-- Non-reversible identifier of each stored provider API key, so users can tell which key is configured
+
+ALTER TABLE user_providers ADD COLUMN IF NOT EXISTS fingerprint VARCHAR(32) NOT NULL DEFAULT '';
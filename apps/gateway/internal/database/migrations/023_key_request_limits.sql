@@ -0,0 +1,5 @@
+-- Migration: Per-key request size limits
+-- Maximum messages and estimated prompt tokens per request; zero means no limit
+
+ALTER TABLE virtual_keys ADD COLUMN IF NOT EXISTS max_messages INT NOT NULL DEFAULT 0;
+ALTER TABLE virtual_keys ADD COLUMN IF NOT EXISTS max_prompt_tokens INT NOT NULL DEFAULT 0;
@@ -0,0 +1,5 @@
+-- Migration: Per-key OpenAI organization and project
+-- Sent as the OpenAI-Organization and OpenAI-Project headers; empty sends none
+
+ALTER TABLE virtual_keys ADD COLUMN IF NOT EXISTS openai_organization TEXT NOT NULL DEFAULT '';
+ALTER TABLE virtual_keys ADD COLUMN IF NOT EXISTS openai_project TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,13 @@
+-- Migration: Store spend as integer micro-dollars
+-- DECIMAL(10,2) rounded every request's cost to whole cents as it was added, so requests
+-- cheaper than half a cent were never counted. Integer micro-dollars add up exactly.
+
+ALTER TABLE virtual_keys RENAME COLUMN current_spend TO current_spend_micros;
+ALTER TABLE virtual_keys ALTER COLUMN current_spend_micros TYPE BIGINT USING COALESCE(round(current_spend_micros * 1000000), 0)::BIGINT;
+ALTER TABLE virtual_keys ALTER COLUMN current_spend_micros SET DEFAULT 0;
+ALTER TABLE virtual_keys ALTER COLUMN current_spend_micros SET NOT NULL;
+
+ALTER TABLE daily_stats RENAME COLUMN total_cost TO total_cost_micros;
+ALTER TABLE daily_stats ALTER COLUMN total_cost_micros TYPE BIGINT USING COALESCE(round(total_cost_micros * 1000000), 0)::BIGINT;
+ALTER TABLE daily_stats ALTER COLUMN total_cost_micros SET DEFAULT 0;
+ALTER TABLE daily_stats ALTER COLUMN total_cost_micros SET NOT NULL;
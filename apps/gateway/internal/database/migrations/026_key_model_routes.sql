@@ -0,0 +1,4 @@
+-- Migration: Per-key model routes
+-- Logical model names resolved to a model picked by the request's estimated prompt size
+
+ALTER TABLE virtual_keys ADD COLUMN IF NOT EXISTS model_routes JSONB NOT NULL DEFAULT '{}';
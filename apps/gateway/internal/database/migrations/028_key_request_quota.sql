@@ -0,0 +1,4 @@
+-- Migration: Per-key monthly request quota
+-- Most requests a key may make per calendar month (UTC); NULL means no quota
+
+ALTER TABLE virtual_keys ADD COLUMN IF NOT EXISTS request_quota BIGINT;
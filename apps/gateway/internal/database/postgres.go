@@ -4,6 +4,7 @@ import (
 	"context"
 	"database/sql"
 	"embed"
+	"encoding/json"
 	"fmt"
 	"strings"
 	"time"
@@ -18,6 +19,42 @@ import (
 //go:embed migrations/*.sql
 var migrationsFS embed.FS
 
+// virtualKeyColumns lists the columns read by every virtual key query, in scan order
+const virtualKeyColumns = `id, user_id, name, key_hash, key_preview, allowed_models, budget_limit, current_spend_micros, log_body_mode, log_sample_rate, stream_budget_mode, debug, budget_mode, debug_capture, allowed_origins, auto_revoke_exempt, param_limits, model_routes, max_messages, max_prompt_tokens, openai_organization, openai_project, request_quota, created_at, revoked_at, last_used_at`
+
+// rowScanner is implemented by both *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanVirtualKey scans a row selected with virtualKeyColumns
+func scanVirtualKey(row rowScanner) (*models.VirtualKey, error) {
+	key := &models.VirtualKey{}
+	var allowedModels, allowedOrigins pq.StringArray
+	var paramLimits, modelRoutes []byte
+	var spend int64
+	err := row.Scan(&key.ID, &key.UserID, &key.Name, &key.KeyHash, &key.KeyPreview, &allowedModels, &key.BudgetLimit, &spend, &key.LogBodyMode, &key.LogSampleRate, &key.StreamBudget, &key.Debug, &key.BudgetMode, &key.DebugCapture, &allowedOrigins, &key.AutoRevokeExempt, &paramLimits, &modelRoutes, &key.MaxMessages, &key.MaxPromptTokens, &key.OpenAIOrganization, &key.OpenAIProject, &key.RequestQuota, &key.CreatedAt, &key.RevokedAt, &key.LastUsedAt)
+	if err != nil {
+		return nil, err
+	}
+	key.CurrentSpend = fromMicros(spend)
+	key.AllowedModels = allowedModels
+	key.AllowedOrigins = allowedOrigins
+	if err := json.Unmarshal(paramLimits, &key.ParamLimits); err != nil {
+		return nil, fmt.Errorf("invalid param limits: %w", err)
+	}
+	if len(key.ParamLimits) == 0 {
+		key.ParamLimits = nil
+	}
+	if err := json.Unmarshal(modelRoutes, &key.ModelRoutes); err != nil {
+		return nil, fmt.Errorf("invalid model routes: %w", err)
+	}
+	if len(key.ModelRoutes) == 0 {
+		key.ModelRoutes = nil
+	}
+	return key, nil
+}
+
 // DB wraps the database connection
 type DB struct {
 	conn *sql.DB
@@ -107,7 +144,7 @@ func (db *DB) Migrate() error {
 
 // User operations
 
-// CreateUser creates a new user
+// CreateUser creates a new user. The first user of a deployment becomes its admin.
 func (db *DB) CreateUser(ctx context.Context, email, passwordHash string) (*models.User, error) {
 	user := &models.User{
 		ID:           uuid.New().String(),
@@ -116,10 +153,12 @@ func (db *DB) CreateUser(ctx context.Context, email, passwordHash string) (*mode
 		CreatedAt:    time.Now(),
 	}
 
-	_, err := db.conn.ExecContext(ctx,
-		`INSERT INTO users (id, email, password_hash, created_at) VALUES ($1, $2, $3, $4)`,
+	err := db.conn.QueryRowContext(ctx,
+		`INSERT INTO users (id, email, password_hash, is_admin, created_at)
+		VALUES ($1, $2, $3, NOT EXISTS (SELECT 1 FROM users), $4)
+		RETURNING is_admin`,
 		user.ID, user.Email, user.PasswordHash, user.CreatedAt,
-	)
+	).Scan(&user.IsAdmin)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create user: %w", err)
 	}
@@ -131,9 +170,9 @@ func (db *DB) CreateUser(ctx context.Context, email, passwordHash string) (*mode
 func (db *DB) GetUserByEmail(ctx context.Context, email string) (*models.User, error) {
 	user := &models.User{}
 	err := db.conn.QueryRowContext(ctx,
-		`SELECT id, email, password_hash, created_at FROM users WHERE email = $1`,
+		`SELECT id, email, password_hash, is_admin, max_keys, created_at FROM users WHERE email = $1`,
 		email,
-	).Scan(&user.ID, &user.Email, &user.PasswordHash, &user.CreatedAt)
+	).Scan(&user.ID, &user.Email, &user.PasswordHash, &user.IsAdmin, &user.MaxKeys, &user.CreatedAt)
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
@@ -147,9 +186,9 @@ func (db *DB) GetUserByEmail(ctx context.Context, email string) (*models.User, e
 func (db *DB) GetUserByID(ctx context.Context, id string) (*models.User, error) {
 	user := &models.User{}
 	err := db.conn.QueryRowContext(ctx,
-		`SELECT id, email, password_hash, created_at FROM users WHERE id = $1`,
+		`SELECT id, email, password_hash, is_admin, max_keys, created_at FROM users WHERE id = $1`,
 		id,
-	).Scan(&user.ID, &user.Email, &user.PasswordHash, &user.CreatedAt)
+	).Scan(&user.ID, &user.Email, &user.PasswordHash, &user.IsAdmin, &user.MaxKeys, &user.CreatedAt)
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
@@ -159,14 +198,238 @@ func (db *DB) GetUserByID(ctx context.Context, id string) (*models.User, error)
 	return user, nil
 }
 
+// CountUsers returns the number of registered users
+func (db *DB) CountUsers(ctx context.Context) (int, error) {
+	var count int
+	if err := db.conn.QueryRowContext(ctx, `SELECT COUNT(*) FROM users`).Scan(&count); err != nil {
+		return 0, fmt.Errorf("failed to count users: %w", err)
+	}
+	return count, nil
+}
+
+// SetUserAdmin grants or revokes admin rights
+func (db *DB) SetUserAdmin(ctx context.Context, userID string, isAdmin bool) error {
+	_, err := db.conn.ExecContext(ctx, `UPDATE users SET is_admin = $1 WHERE id = $2`, isAdmin, userID)
+	if err != nil {
+		return fmt.Errorf("failed to set user admin: %w", err)
+	}
+	return nil
+}
+
+// SetUserKeyLimit overrides a user's maximum number of active keys (nil restores the default)
+func (db *DB) SetUserKeyLimit(ctx context.Context, userID string, maxKeys *int) error {
+	result, err := db.conn.ExecContext(ctx,
+		`UPDATE users SET max_keys = $1 WHERE id = $2`,
+		maxKeys, userID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to set user key limit: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// Denied model operations
+
+// ListDeniedModels returns the operator-disabled model patterns
+func (db *DB) ListDeniedModels(ctx context.Context) ([]models.DeniedModel, error) {
+	rows, err := db.conn.QueryContext(ctx,
+		`SELECT pattern, reason, created_at FROM denied_models ORDER BY pattern`,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list denied models: %w", err)
+	}
+	defer rows.Close()
+
+	denied := []models.DeniedModel{}
+	for rows.Next() {
+		var m models.DeniedModel
+		if err := rows.Scan(&m.Pattern, &m.Reason, &m.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan denied model: %w", err)
+		}
+		denied = append(denied, m)
+	}
+
+	return denied, rows.Err()
+}
+
+// AddDeniedModel disables a model pattern, updating the reason if it already exists
+func (db *DB) AddDeniedModel(ctx context.Context, pattern, reason string) error {
+	_, err := db.conn.ExecContext(ctx,
+		`INSERT INTO denied_models (pattern, reason, created_at) VALUES ($1, $2, NOW())
+		ON CONFLICT (pattern) DO UPDATE SET reason = EXCLUDED.reason`,
+		pattern, reason,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to add denied model: %w", err)
+	}
+	return nil
+}
+
+// RemoveDeniedModel re-enables a model pattern
+func (db *DB) RemoveDeniedModel(ctx context.Context, pattern string) error {
+	result, err := db.conn.ExecContext(ctx, `DELETE FROM denied_models WHERE pattern = $1`, pattern)
+	if err != nil {
+		return fmt.Errorf("failed to remove denied model: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// InsertAuditEntry records an admin action in the audit log. Actions taken by the
+// gateway itself have an empty AdminID.
+func (db *DB) InsertAuditEntry(ctx context.Context, entry *models.AuditEntry) error {
+	_, err := db.conn.ExecContext(ctx,
+		`INSERT INTO admin_audit_log (admin_id, action, target, reason, created_at) VALUES (NULLIF($1, '')::uuid, $2, $3, $4, $5)`,
+		entry.AdminID, entry.Action, entry.Target, entry.Reason, entry.CreatedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to insert audit entry: %w", err)
+	}
+	return nil
+}
+
+// Session operations
+
+// CreateSession records a new login session, removing the user's sessions that
+// expired more than a day ago
+func (db *DB) CreateSession(ctx context.Context, session *models.Session) error {
+	_, err := db.conn.ExecContext(ctx,
+		`INSERT INTO sessions (id, user_id, ip, user_agent, created_at, last_seen_at, expires_at) VALUES ($1, $2, $3, $4, $5, $5, $6)`,
+		session.ID, session.UserID, session.IP, session.UserAgent, session.CreatedAt, session.ExpiresAt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create session: %w", err)
+	}
+
+	_, err = db.conn.ExecContext(ctx,
+		`DELETE FROM sessions WHERE user_id = $1 AND expires_at < NOW() - INTERVAL '1 day'`,
+		session.UserID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to delete expired sessions: %w", err)
+	}
+	return nil
+}
+
+// ListActiveSessions returns the user's sessions that are neither expired nor revoked,
+// most recently seen first
+func (db *DB) ListActiveSessions(ctx context.Context, userID string) ([]*models.Session, error) {
+	rows, err := db.conn.QueryContext(ctx,
+		`SELECT id, user_id, ip, user_agent, created_at, last_seen_at, expires_at, revoked_at
+		FROM sessions
+		WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > NOW()
+		ORDER BY last_seen_at DESC`,
+		userID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list sessions: %w", err)
+	}
+	defer rows.Close()
+
+	sessions := []*models.Session{}
+	for rows.Next() {
+		session := &models.Session{}
+		if err := rows.Scan(&session.ID, &session.UserID, &session.IP, &session.UserAgent, &session.CreatedAt, &session.LastSeenAt, &session.ExpiresAt, &session.RevokedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan session: %w", err)
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, rows.Err()
+}
+
+// IsSessionActive reports whether a session exists and is neither expired nor revoked
+func (db *DB) IsSessionActive(ctx context.Context, id string) (bool, error) {
+	var active bool
+	err := db.conn.QueryRowContext(ctx,
+		`SELECT EXISTS(SELECT 1 FROM sessions WHERE id = $1 AND revoked_at IS NULL AND expires_at > NOW())`,
+		id,
+	).Scan(&active)
+	if err != nil {
+		return false, fmt.Errorf("failed to check session: %w", err)
+	}
+	return active, nil
+}
+
+// RevokeSession marks one of the user's sessions as revoked and returns when its
+// token expires, or nil if the user has no such session. Revoking a session twice
+// keeps the first revocation time.
+func (db *DB) RevokeSession(ctx context.Context, id, userID string) (*time.Time, error) {
+	var expiresAt time.Time
+	err := db.conn.QueryRowContext(ctx,
+		`UPDATE sessions SET revoked_at = COALESCE(revoked_at, NOW()) WHERE id = $1 AND user_id = $2 RETURNING expires_at`,
+		id, userID,
+	).Scan(&expiresAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to revoke session: %w", err)
+	}
+	return &expiresAt, nil
+}
+
+// TouchSession records that a session was just used
+func (db *DB) TouchSession(ctx context.Context, id string) error {
+	_, err := db.conn.ExecContext(ctx, `UPDATE sessions SET last_seen_at = NOW() WHERE id = $1`, id)
+	if err != nil {
+		return fmt.Errorf("failed to touch session: %w", err)
+	}
+	return nil
+}
+
 // Virtual Key operations
 
+// ListRecentlyUsedVirtualKeys returns up to limit non-revoked keys, most recently used first
+func (db *DB) ListRecentlyUsedVirtualKeys(ctx context.Context, limit int) ([]*models.VirtualKey, error) {
+	rows, err := db.conn.QueryContext(ctx,
+		`SELECT `+virtualKeyColumns+`
+		FROM virtual_keys
+		WHERE revoked_at IS NULL AND last_used_at IS NOT NULL
+		ORDER BY last_used_at DESC
+		LIMIT $1`,
+		limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list recently used keys: %w", err)
+	}
+	defer rows.Close()
+
+	var keys []*models.VirtualKey
+	for rows.Next() {
+		key, err := scanVirtualKey(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan virtual key: %w", err)
+		}
+		keys = append(keys, key)
+	}
+
+	return keys, rows.Err()
+}
+
+// CountActiveVirtualKeys counts a user's keys that haven't been revoked
+func (db *DB) CountActiveVirtualKeys(ctx context.Context, userID string) (int, error) {
+	var count int
+	err := db.conn.QueryRowContext(ctx,
+		`SELECT COUNT(*) FROM virtual_keys WHERE user_id = $1 AND revoked_at IS NULL`,
+		userID,
+	).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count virtual keys: %w", err)
+	}
+	return count, nil
+}
+
 // CreateVirtualKey creates a new virtual key (access control only, providers are at account level)
 func (db *DB) CreateVirtualKey(ctx context.Context, key *models.VirtualKey) error {
 	_, err := db.conn.ExecContext(ctx,
-		`INSERT INTO virtual_keys (id, user_id, name, key_hash, allowed_models, budget_limit, current_spend, created_at)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
-		key.ID, key.UserID, key.Name, key.KeyHash, pq.Array(key.AllowedModels), key.BudgetLimit, key.CurrentSpend, key.CreatedAt,
+		`INSERT INTO virtual_keys (id, user_id, name, key_hash, key_preview, allowed_models, budget_limit, current_spend_micros, log_body_mode, log_sample_rate, stream_budget_mode, debug, budget_mode, debug_capture, allowed_origins, auto_revoke_exempt, param_limits, model_routes, max_messages, max_prompt_tokens, openai_organization, openai_project, request_quota, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, COALESCE($15::text[], '{}'), $16, $17, $18, $19, $20, $21, $22, $23, $24)`,
+		key.ID, key.UserID, key.Name, key.KeyHash, key.KeyPreview, pq.Array(key.AllowedModels), key.BudgetLimit, toMicros(key.CurrentSpend), key.LogBodyMode, key.LogSampleRate, key.StreamBudget, key.Debug, key.BudgetMode, key.DebugCapture, pq.Array(key.AllowedOrigins), key.AutoRevokeExempt, paramLimitsJSON(key.ParamLimits), modelRoutesJSON(key.ModelRoutes), key.MaxMessages, key.MaxPromptTokens, key.OpenAIOrganization, key.OpenAIProject, key.RequestQuota, key.CreatedAt,
 	)
 	if err != nil {
 		return fmt.Errorf("failed to create virtual key: %w", err)
@@ -177,12 +440,56 @@ func (db *DB) CreateVirtualKey(ctx context.Context, key *models.VirtualKey) erro
 // User Provider operations (account-level API keys)
 
 // SetUserProvider sets or updates a provider API key for a user's account
-func (db *DB) SetUserProvider(ctx context.Context, userID string, provider models.ProviderType, encryptedKey []byte) error {
-	_, err := db.conn.ExecContext(ctx,
-		`INSERT INTO user_providers (id, user_id, provider, api_key_encrypted, created_at, updated_at)
-		VALUES ($1, $2, $3, $4, NOW(), NOW())
-		ON CONFLICT (user_id, provider) DO UPDATE SET api_key_encrypted = EXCLUDED.api_key_encrypted, updated_at = NOW()`,
-		uuid.New().String(), userID, provider, encryptedKey,
+func (db *DB) SetUserProvider(ctx context.Context, userID string, key ProviderKey) error {
+	return setUserProvider(ctx, db.conn, userID, key)
+}
+
+// ProviderKey is an encrypted provider API key to store on an account
+type ProviderKey struct {
+	Provider     models.ProviderType
+	EncryptedKey []byte
+	Label        string   // Empty keeps the label of a key being replaced
+	Models       []string // Model patterns the key has access to, empty means any
+	KeyVersion   int      // Version of the encryption key EncryptedKey uses
+	Fingerprint  string   // Non-reversible identifier of the plaintext key
+}
+
+// SetUserProviders upserts several provider API keys in a single transaction
+func (db *DB) SetUserProviders(ctx context.Context, userID string, keys []ProviderKey) error {
+	tx, err := db.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	for _, key := range keys {
+		if err := setUserProvider(ctx, tx, userID, key); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// execer is implemented by both *sql.DB and *sql.Tx
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+func setUserProvider(ctx context.Context, exec execer, userID string, key ProviderKey) error {
+	modelPatterns := key.Models
+	if modelPatterns == nil {
+		modelPatterns = []string{}
+	}
+	_, err := exec.ExecContext(ctx,
+		`INSERT INTO user_providers (id, user_id, provider, api_key_encrypted, key_version, label, models, fingerprint, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, NOW(), NOW())
+		ON CONFLICT (user_id, provider) DO UPDATE SET api_key_encrypted = EXCLUDED.api_key_encrypted, key_version = EXCLUDED.key_version,
+			label = COALESCE(NULLIF(EXCLUDED.label, ''), user_providers.label), models = EXCLUDED.models, fingerprint = EXCLUDED.fingerprint, updated_at = NOW()`,
+		uuid.New().String(), userID, key.Provider, key.EncryptedKey, key.KeyVersion, key.Label, pq.Array(modelPatterns), key.Fingerprint,
 	)
 	if err != nil {
 		return fmt.Errorf("failed to set user provider: %w", err)
@@ -192,37 +499,75 @@ func (db *DB) SetUserProvider(ctx context.Context, userID string, provider model
 
 // GetUserProviders retrieves all provider API keys for a user's account
 func (db *DB) GetUserProviders(ctx context.Context, userID string) ([]models.UserProvider, error) {
-	rows, err := db.conn.QueryContext(ctx,
-		`SELECT id, user_id, provider, api_key_encrypted, created_at, updated_at
-		FROM user_providers WHERE user_id = $1`,
-		userID,
-	)
+	providers, _, err := db.ListUserProviders(ctx, userID, models.ProviderFilter{})
+	return providers, err
+}
+
+// ListUserProviders retrieves the provider API keys for a user's account matching the filter,
+// along with the total number of matches before pagination is applied
+func (db *DB) ListUserProviders(ctx context.Context, userID string, filter models.ProviderFilter) ([]models.UserProvider, int, error) {
+	where := []string{"user_id = $1"}
+	args := []interface{}{userID}
+	argCount := 2
+
+	if filter.Provider != "" {
+		where = append(where, fmt.Sprintf("provider = $%d", argCount))
+		args = append(args, filter.Provider)
+		argCount++
+	}
+
+	if filter.Label != "" {
+		where = append(where, fmt.Sprintf("label = $%d", argCount))
+		args = append(args, filter.Label)
+		argCount++
+	}
+
+	whereClause := strings.Join(where, " AND ")
+
+	var total int
+	err := db.conn.QueryRowContext(ctx,
+		`SELECT COUNT(*) FROM user_providers WHERE `+whereClause,
+		args...,
+	).Scan(&total)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get user providers: %w", err)
+		return nil, 0, fmt.Errorf("failed to count user providers: %w", err)
+	}
+
+	query := `SELECT id, user_id, provider, api_key_encrypted, key_version, label, models, fingerprint, created_at, updated_at, last_used_at
+		FROM user_providers WHERE ` + whereClause + ` ORDER BY provider, created_at`
+
+	if filter.Limit > 0 {
+		query += fmt.Sprintf(" LIMIT $%d OFFSET $%d", argCount, argCount+1)
+		args = append(args, filter.Limit, filter.Offset)
+	}
+
+	rows, err := db.conn.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to get user providers: %w", err)
 	}
 	defer rows.Close()
 
 	var providers []models.UserProvider
 	for rows.Next() {
 		var p models.UserProvider
-		err := rows.Scan(&p.ID, &p.UserID, &p.Provider, &p.APIKeyEncrypted, &p.CreatedAt, &p.UpdatedAt)
+		err := rows.Scan(&p.ID, &p.UserID, &p.Provider, &p.APIKeyEncrypted, &p.KeyVersion, &p.Label, pq.Array(&p.Models), &p.Fingerprint, &p.CreatedAt, &p.UpdatedAt, &p.LastUsedAt)
 		if err != nil {
-			return nil, fmt.Errorf("failed to scan user provider: %w", err)
+			return nil, 0, fmt.Errorf("failed to scan user provider: %w", err)
 		}
 		providers = append(providers, p)
 	}
 
-	return providers, nil
+	return providers, total, nil
 }
 
 // GetUserProvider retrieves a specific provider API key for a user
 func (db *DB) GetUserProvider(ctx context.Context, userID string, provider models.ProviderType) (*models.UserProvider, error) {
 	p := &models.UserProvider{}
 	err := db.conn.QueryRowContext(ctx,
-		`SELECT id, user_id, provider, api_key_encrypted, created_at, updated_at
+		`SELECT id, user_id, provider, api_key_encrypted, key_version, label, models, fingerprint, created_at, updated_at, last_used_at
 		FROM user_providers WHERE user_id = $1 AND provider = $2`,
 		userID, provider,
-	).Scan(&p.ID, &p.UserID, &p.Provider, &p.APIKeyEncrypted, &p.CreatedAt, &p.UpdatedAt)
+	).Scan(&p.ID, &p.UserID, &p.Provider, &p.APIKeyEncrypted, &p.KeyVersion, &p.Label, pq.Array(&p.Models), &p.Fingerprint, &p.CreatedAt, &p.UpdatedAt, &p.LastUsedAt)
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
@@ -232,6 +577,121 @@ func (db *DB) GetUserProvider(ctx context.Context, userID string, provider model
 	return p, nil
 }
 
+// Encryption rotation operations
+
+// CountProvidersNotAtVersion counts the provider keys encrypted under any key version other than version
+func (db *DB) CountProvidersNotAtVersion(ctx context.Context, version int) (int, error) {
+	var count int
+	err := db.conn.QueryRowContext(ctx,
+		`SELECT COUNT(*) FROM user_providers WHERE key_version <> $1`, version,
+	).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count provider keys: %w", err)
+	}
+	return count, nil
+}
+
+// ListProvidersNotAtVersion returns up to limit provider keys encrypted under other key
+// versions than version, ordered by ID and starting after afterID (empty starts at the beginning)
+func (db *DB) ListProvidersNotAtVersion(ctx context.Context, version int, afterID string, limit int) ([]models.UserProvider, error) {
+	if afterID == "" {
+		afterID = uuid.Nil.String()
+	}
+	rows, err := db.conn.QueryContext(ctx,
+		`SELECT id, api_key_encrypted, key_version FROM user_providers
+		WHERE key_version <> $1 AND id > $2
+		ORDER BY id LIMIT $3`,
+		version, afterID, limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list provider keys: %w", err)
+	}
+	defer rows.Close()
+
+	var providers []models.UserProvider
+	for rows.Next() {
+		var p models.UserProvider
+		if err := rows.Scan(&p.ID, &p.APIKeyEncrypted, &p.KeyVersion); err != nil {
+			return nil, fmt.Errorf("failed to scan provider key: %w", err)
+		}
+		providers = append(providers, p)
+	}
+	return providers, rows.Err()
+}
+
+// ReencryptUserProvider replaces a provider key's ciphertext if it is still the one that
+// was read, so a key the user changed in the meantime is not overwritten. It also
+// records the fingerprint of keys stored before fingerprints were. It reports whether
+// the row was updated.
+func (db *DB) ReencryptUserProvider(ctx context.Context, id string, old, encryptedKey []byte, version int, fingerprint string) (bool, error) {
+	result, err := db.conn.ExecContext(ctx,
+		`UPDATE user_providers SET api_key_encrypted = $1, key_version = $2, fingerprint = $5 WHERE id = $3 AND api_key_encrypted = $4`,
+		encryptedKey, version, id, old, fingerprint,
+	)
+	if err != nil {
+		return false, fmt.Errorf("failed to re-encrypt provider key: %w", err)
+	}
+	n, _ := result.RowsAffected()
+	return n > 0, nil
+}
+
+const encryptionRotationColumns = `id, target_version, status, total, rotated, failed, COALESCE(cursor_id::text, ''),
+	COALESCE(started_by::text, ''), started_at, updated_at, completed_at`
+
+func scanEncryptionRotation(row *sql.Row) (*models.EncryptionRotation, error) {
+	r := &models.EncryptionRotation{}
+	err := row.Scan(&r.ID, &r.TargetVersion, &r.Status, &r.Total, &r.Rotated, &r.Failed, &r.Cursor,
+		&r.StartedBy, &r.StartedAt, &r.UpdatedAt, &r.CompletedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get encryption rotation: %w", err)
+	}
+	return r, nil
+}
+
+// CreateEncryptionRotation records a new running rotation
+func (db *DB) CreateEncryptionRotation(ctx context.Context, r *models.EncryptionRotation) error {
+	_, err := db.conn.ExecContext(ctx,
+		`INSERT INTO encryption_rotations (id, target_version, status, total, started_by, started_at, updated_at)
+		VALUES ($1, $2, $3, $4, NULLIF($5, '')::uuid, $6, $6)`,
+		r.ID, r.TargetVersion, r.Status, r.Total, r.StartedBy, r.StartedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create encryption rotation: %w", err)
+	}
+	return nil
+}
+
+// GetRunningEncryptionRotation returns the rotation in progress, or nil if there is none
+func (db *DB) GetRunningEncryptionRotation(ctx context.Context) (*models.EncryptionRotation, error) {
+	return scanEncryptionRotation(db.conn.QueryRowContext(ctx,
+		`SELECT `+encryptionRotationColumns+` FROM encryption_rotations
+		WHERE status = $1 ORDER BY started_at DESC LIMIT 1`, models.RotationRunning,
+	))
+}
+
+// GetLatestEncryptionRotation returns the most recently started rotation, or nil if there is none
+func (db *DB) GetLatestEncryptionRotation(ctx context.Context) (*models.EncryptionRotation, error) {
+	return scanEncryptionRotation(db.conn.QueryRowContext(ctx,
+		`SELECT `+encryptionRotationColumns+` FROM encryption_rotations ORDER BY started_at DESC LIMIT 1`,
+	))
+}
+
+// UpdateEncryptionRotation saves the progress of a rotation
+func (db *DB) UpdateEncryptionRotation(ctx context.Context, r *models.EncryptionRotation) error {
+	_, err := db.conn.ExecContext(ctx,
+		`UPDATE encryption_rotations SET status = $1, rotated = $2, failed = $3, cursor_id = NULLIF($4, '')::uuid,
+			updated_at = NOW(), completed_at = $5 WHERE id = $6`,
+		r.Status, r.Rotated, r.Failed, r.Cursor, r.CompletedAt, r.ID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update encryption rotation: %w", err)
+	}
+	return nil
+}
+
 // RemoveUserProvider removes a provider API key from a user's account
 func (db *DB) RemoveUserProvider(ctx context.Context, userID string, provider models.ProviderType) error {
 	_, err := db.conn.ExecContext(ctx,
@@ -244,30 +704,48 @@ func (db *DB) RemoveUserProvider(ctx context.Context, userID string, provider mo
 	return nil
 }
 
+// TouchUserProvider records that a provider API key was just used
+func (db *DB) TouchUserProvider(ctx context.Context, userID string, provider models.ProviderType) error {
+	_, err := db.conn.ExecContext(ctx,
+		`UPDATE user_providers SET last_used_at = NOW() WHERE user_id = $1 AND provider = $2`,
+		userID, provider,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to touch user provider: %w", err)
+	}
+	return nil
+}
+
 // GetVirtualKeyByHash retrieves a virtual key by its hash
 func (db *DB) GetVirtualKeyByHash(ctx context.Context, keyHash string) (*models.VirtualKey, error) {
-	key := &models.VirtualKey{}
-	var allowedModels pq.StringArray
-	err := db.conn.QueryRowContext(ctx,
-		`SELECT id, user_id, name, key_hash, allowed_models, budget_limit, current_spend, created_at, revoked_at
+	key, err := scanVirtualKey(db.conn.QueryRowContext(ctx,
+		`SELECT `+virtualKeyColumns+`
 		FROM virtual_keys WHERE key_hash = $1 AND revoked_at IS NULL`,
 		keyHash,
-	).Scan(&key.ID, &key.UserID, &key.Name, &key.KeyHash, &allowedModels, &key.BudgetLimit, &key.CurrentSpend, &key.CreatedAt, &key.RevokedAt)
+	))
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to get virtual key: %w", err)
 	}
-	key.AllowedModels = allowedModels
 
 	return key, nil
 }
 
+// UpdateVirtualKeyHash replaces the stored hash of a key
+func (db *DB) UpdateVirtualKeyHash(ctx context.Context, id, keyHash string) error {
+	_, err := db.conn.ExecContext(ctx, `UPDATE virtual_keys SET key_hash = $1 WHERE id = $2`, keyHash, id)
+	if err != nil {
+		return fmt.Errorf("failed to update key hash: %w", err)
+	}
+	return nil
+}
+
 // ListVirtualKeysByUser lists all virtual keys for a user
 func (db *DB) ListVirtualKeysByUser(ctx context.Context, userID string) ([]*models.VirtualKey, error) {
 	rows, err := db.conn.QueryContext(ctx,
-		`SELECT id, user_id, name, key_hash, allowed_models, budget_limit, current_spend, created_at, revoked_at
+		`SELECT `+virtualKeyColumns+`
 		FROM virtual_keys WHERE user_id = $1 ORDER BY created_at DESC`,
 		userID,
 	)
@@ -278,13 +756,10 @@ func (db *DB) ListVirtualKeysByUser(ctx context.Context, userID string) ([]*mode
 
 	var keys []*models.VirtualKey
 	for rows.Next() {
-		key := &models.VirtualKey{}
-		var allowedModels pq.StringArray
-		err := rows.Scan(&key.ID, &key.UserID, &key.Name, &key.KeyHash, &allowedModels, &key.BudgetLimit, &key.CurrentSpend, &key.CreatedAt, &key.RevokedAt)
+		key, err := scanVirtualKey(rows)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan virtual key: %w", err)
 		}
-		key.AllowedModels = allowedModels
 		keys = append(keys, key)
 	}
 
@@ -293,20 +768,17 @@ func (db *DB) ListVirtualKeysByUser(ctx context.Context, userID string) ([]*mode
 
 // GetVirtualKeyByID retrieves a virtual key by ID
 func (db *DB) GetVirtualKeyByID(ctx context.Context, id string) (*models.VirtualKey, error) {
-	key := &models.VirtualKey{}
-	var allowedModels pq.StringArray
-	err := db.conn.QueryRowContext(ctx,
-		`SELECT id, user_id, name, key_hash, allowed_models, budget_limit, current_spend, created_at, revoked_at
+	key, err := scanVirtualKey(db.conn.QueryRowContext(ctx,
+		`SELECT `+virtualKeyColumns+`
 		FROM virtual_keys WHERE id = $1`,
 		id,
-	).Scan(&key.ID, &key.UserID, &key.Name, &key.KeyHash, &allowedModels, &key.BudgetLimit, &key.CurrentSpend, &key.CreatedAt, &key.RevokedAt)
+	))
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to get virtual key: %w", err)
 	}
-	key.AllowedModels = allowedModels
 
 	return key, nil
 }
@@ -323,28 +795,188 @@ func (db *DB) RevokeVirtualKey(ctx context.Context, id string) error {
 	return nil
 }
 
-// UpdateVirtualKey updates a virtual key's basic info
-func (db *DB) UpdateVirtualKey(ctx context.Context, id string, name *string, allowedModels []string, budgetLimit *float64) error {
+// ListIdleVirtualKeys returns up to limit active keys, oldest first, that aren't exempt
+// from idle revocation and were last used before usedBefore, or never used and created
+// before createdBefore
+func (db *DB) ListIdleVirtualKeys(ctx context.Context, usedBefore, createdBefore time.Time, limit int) ([]*models.VirtualKey, error) {
+	rows, err := db.conn.QueryContext(ctx,
+		`SELECT `+virtualKeyColumns+`
+		FROM virtual_keys
+		WHERE revoked_at IS NULL AND NOT auto_revoke_exempt
+			AND (last_used_at < $1 OR (last_used_at IS NULL AND created_at < $2))
+		ORDER BY created_at
+		LIMIT $3`,
+		usedBefore, createdBefore, limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list idle keys: %w", err)
+	}
+	defer rows.Close()
+
+	var keys []*models.VirtualKey
+	for rows.Next() {
+		key, err := scanVirtualKey(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan virtual key: %w", err)
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+// RevokeIdleVirtualKey revokes a key listed by ListIdleVirtualKeys, reporting false if it
+// was used, exempted or revoked since
+func (db *DB) RevokeIdleVirtualKey(ctx context.Context, id string, usedBefore, createdBefore time.Time) (bool, error) {
+	result, err := db.conn.ExecContext(ctx,
+		`UPDATE virtual_keys SET revoked_at = NOW()
+		WHERE id = $1 AND revoked_at IS NULL AND NOT auto_revoke_exempt
+			AND (last_used_at < $2 OR (last_used_at IS NULL AND created_at < $3))`,
+		id, usedBefore, createdBefore,
+	)
+	if err != nil {
+		return false, fmt.Errorf("failed to revoke idle key: %w", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to revoke idle key: %w", err)
+	}
+	return n > 0, nil
+}
+
+// paramLimitsJSON encodes parameter limits for the param_limits column
+func paramLimitsJSON(limits map[string]models.ParamLimit) []byte {
+	if len(limits) == 0 {
+		return []byte("{}")
+	}
+	data, _ := json.Marshal(limits)
+	return data
+}
+
+// modelRoutesJSON encodes model routes for the model_routes column
+func modelRoutesJSON(routes map[string]models.ModelRoute) []byte {
+	if len(routes) == 0 {
+		return []byte("{}")
+	}
+	data, _ := json.Marshal(routes)
+	return data
+}
+
+// UpdateVirtualKey updates a virtual key's settings, leaving fields absent from the request untouched
+func (db *DB) UpdateVirtualKey(ctx context.Context, id string, req *models.UpdateKeyRequest) error {
 	query := `UPDATE virtual_keys SET `
 	args := []interface{}{}
 	argCount := 1
 	updates := []string{}
 
-	if name != nil {
+	if req.Name != nil {
 		updates = append(updates, fmt.Sprintf("name = $%d", argCount))
-		args = append(args, *name)
+		args = append(args, *req.Name)
 		argCount++
 	}
 
-	if allowedModels != nil {
+	if req.AllowedModels != nil {
 		updates = append(updates, fmt.Sprintf("allowed_models = $%d", argCount))
-		args = append(args, pq.Array(allowedModels))
+		args = append(args, pq.Array(req.AllowedModels))
 		argCount++
 	}
 
-	if budgetLimit != nil {
+	if req.ClearBudget {
+		updates = append(updates, "budget_limit = NULL")
+	} else if req.BudgetLimit != nil {
 		updates = append(updates, fmt.Sprintf("budget_limit = $%d", argCount))
-		args = append(args, *budgetLimit)
+		args = append(args, *req.BudgetLimit)
+		argCount++
+	}
+
+	if req.LogBodyMode != nil {
+		updates = append(updates, fmt.Sprintf("log_body_mode = $%d", argCount))
+		args = append(args, *req.LogBodyMode)
+		argCount++
+	}
+
+	if req.LogSampleRate != nil {
+		updates = append(updates, fmt.Sprintf("log_sample_rate = $%d", argCount))
+		args = append(args, *req.LogSampleRate)
+		argCount++
+	}
+
+	if req.StreamBudget != nil {
+		updates = append(updates, fmt.Sprintf("stream_budget_mode = $%d", argCount))
+		args = append(args, *req.StreamBudget)
+		argCount++
+	}
+
+	if req.Debug != nil {
+		updates = append(updates, fmt.Sprintf("debug = $%d", argCount))
+		args = append(args, *req.Debug)
+		argCount++
+	}
+
+	if req.BudgetMode != nil {
+		updates = append(updates, fmt.Sprintf("budget_mode = $%d", argCount))
+		args = append(args, *req.BudgetMode)
+		argCount++
+	}
+
+	if req.DebugCapture != nil {
+		updates = append(updates, fmt.Sprintf("debug_capture = $%d", argCount))
+		args = append(args, *req.DebugCapture)
+		argCount++
+	}
+
+	if req.AllowedOrigins != nil {
+		updates = append(updates, fmt.Sprintf("allowed_origins = $%d", argCount))
+		args = append(args, pq.Array(req.AllowedOrigins))
+		argCount++
+	}
+
+	if req.AutoRevokeExempt != nil {
+		updates = append(updates, fmt.Sprintf("auto_revoke_exempt = $%d", argCount))
+		args = append(args, *req.AutoRevokeExempt)
+		argCount++
+	}
+
+	if req.ParamLimits != nil {
+		updates = append(updates, fmt.Sprintf("param_limits = $%d", argCount))
+		args = append(args, paramLimitsJSON(req.ParamLimits))
+		argCount++
+	}
+
+	if req.ModelRoutes != nil {
+		updates = append(updates, fmt.Sprintf("model_routes = $%d", argCount))
+		args = append(args, modelRoutesJSON(req.ModelRoutes))
+		argCount++
+	}
+
+	if req.MaxMessages != nil {
+		updates = append(updates, fmt.Sprintf("max_messages = $%d", argCount))
+		args = append(args, *req.MaxMessages)
+		argCount++
+	}
+
+	if req.MaxPromptTokens != nil {
+		updates = append(updates, fmt.Sprintf("max_prompt_tokens = $%d", argCount))
+		args = append(args, *req.MaxPromptTokens)
+		argCount++
+	}
+
+	if req.OpenAIOrganization != nil {
+		updates = append(updates, fmt.Sprintf("openai_organization = $%d", argCount))
+		args = append(args, *req.OpenAIOrganization)
+		argCount++
+	}
+
+	if req.OpenAIProject != nil {
+		updates = append(updates, fmt.Sprintf("openai_project = $%d", argCount))
+		args = append(args, *req.OpenAIProject)
+		argCount++
+	}
+
+	if req.RequestQuota != nil && *req.RequestQuota == 0 {
+		updates = append(updates, "request_quota = NULL")
+	} else if req.RequestQuota != nil {
+		updates = append(updates, fmt.Sprintf("request_quota = $%d", argCount))
+		args = append(args, *req.RequestQuota)
 		argCount++
 	}
 
@@ -363,29 +995,60 @@ func (db *DB) UpdateVirtualKey(ctx context.Context, id string, name *string, all
 	return nil
 }
 
-// UpdateKeySpend updates the current spend for a key
-func (db *DB) UpdateKeySpend(ctx context.Context, keyID string, amount float64) error {
+// TouchVirtualKey records that a virtual key was just used
+func (db *DB) TouchVirtualKey(ctx context.Context, id string) error {
 	_, err := db.conn.ExecContext(ctx,
-		`UPDATE virtual_keys SET current_spend = current_spend + $1 WHERE id = $2`,
-		amount, keyID,
+		`UPDATE virtual_keys SET last_used_at = NOW() WHERE id = $1`,
+		id,
 	)
 	if err != nil {
-		return fmt.Errorf("failed to update key spend: %w", err)
+		return fmt.Errorf("failed to touch virtual key: %w", err)
 	}
 	return nil
 }
 
+// UpdateKeySpend adds a request's cost to the key's current spend and its tokens and
+// cost to today's stats in one transaction. It returns the key's new current spend and
+// its hash, for refreshing cached configurations.
+func (db *DB) UpdateKeySpend(ctx context.Context, keyID string, cost float64, tokens int) (float64, string, error) {
+	tx, err := db.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, "", fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	micros := toMicros(cost)
+	var spend int64
+	var keyHash string
+	err = tx.QueryRowContext(ctx,
+		`UPDATE virtual_keys SET current_spend_micros = current_spend_micros + $1 WHERE id = $2 RETURNING current_spend_micros, key_hash`,
+		micros, keyID,
+	).Scan(&spend, &keyHash)
+	if err != nil {
+		return 0, "", fmt.Errorf("failed to update key spend: %w", err)
+	}
+
+	if err := upsertDailyStat(ctx, tx, keyID, tokens, micros); err != nil {
+		return 0, "", err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, "", fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return fromMicros(spend), keyHash, nil
+}
+
 // Daily Stats operations
 
-// UpsertDailyStat upserts daily statistics
-func (db *DB) UpsertDailyStat(ctx context.Context, keyID string, tokens int, cost float64) error {
-	_, err := db.conn.ExecContext(ctx,
-		`INSERT INTO daily_stats (id, key_id, date, total_tokens, total_cost)
+// upsertDailyStat adds tokens and a cost in micro-dollars to a key's stats for today
+func upsertDailyStat(ctx context.Context, exec execer, keyID string, tokens int, costMicros int64) error {
+	_, err := exec.ExecContext(ctx,
+		`INSERT INTO daily_stats (id, key_id, date, total_tokens, total_cost_micros)
 		VALUES ($1, $2, CURRENT_DATE, $3, $4)
 		ON CONFLICT (key_id, date) DO UPDATE SET
 			total_tokens = daily_stats.total_tokens + EXCLUDED.total_tokens,
-			total_cost = daily_stats.total_cost + EXCLUDED.total_cost`,
-		uuid.New().String(), keyID, tokens, cost,
+			total_cost_micros = daily_stats.total_cost_micros + EXCLUDED.total_cost_micros`,
+		uuid.New().String(), keyID, tokens, costMicros,
 	)
 	if err != nil {
 		return fmt.Errorf("failed to upsert daily stat: %w", err)
@@ -393,10 +1056,27 @@ func (db *DB) UpsertDailyStat(ctx context.Context, keyID string, tokens int, cos
 	return nil
 }
 
+// SetDailyStat overwrites a key's totals for a day with authoritative values.
+// Keys that no longer exist are skipped.
+func (db *DB) SetDailyStat(ctx context.Context, keyID string, date time.Time, tokens int, cost float64) error {
+	_, err := db.conn.ExecContext(ctx,
+		`INSERT INTO daily_stats (id, key_id, date, total_tokens, total_cost_micros)
+		SELECT $1, id, $3, $4, $5 FROM virtual_keys WHERE id = $2
+		ON CONFLICT (key_id, date) DO UPDATE SET
+			total_tokens = EXCLUDED.total_tokens,
+			total_cost_micros = EXCLUDED.total_cost_micros`,
+		uuid.New().String(), keyID, date.Format("2006-01-02"), tokens, toMicros(cost),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to set daily stat: %w", err)
+	}
+	return nil
+}
+
 // GetDailyStats retrieves daily stats for a user within a date range
 func (db *DB) GetDailyStats(ctx context.Context, userID string, startDate, endDate time.Time) ([]*models.DailyStat, error) {
 	rows, err := db.conn.QueryContext(ctx,
-		`SELECT ds.id, ds.key_id, ds.date, ds.total_tokens, ds.total_cost
+		`SELECT ds.id, ds.key_id, ds.date, ds.total_tokens, ds.total_cost_micros
 		FROM daily_stats ds
 		JOIN virtual_keys vk ON ds.key_id = vk.id
 		WHERE vk.user_id = $1 AND ds.date >= $2 AND ds.date <= $3
@@ -411,10 +1091,12 @@ func (db *DB) GetDailyStats(ctx context.Context, userID string, startDate, endDa
 	var stats []*models.DailyStat
 	for rows.Next() {
 		stat := &models.DailyStat{}
-		err := rows.Scan(&stat.ID, &stat.KeyID, &stat.Date, &stat.TotalTokens, &stat.TotalCost)
+		var cost int64
+		err := rows.Scan(&stat.ID, &stat.KeyID, &stat.Date, &stat.TotalTokens, &cost)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan daily stat: %w", err)
 		}
+		stat.TotalCost = fromMicros(cost)
 		stats = append(stats, stat)
 	}
 
@@ -425,14 +1107,16 @@ func (db *DB) GetDailyStats(ctx context.Context, userID string, startDate, endDa
 func (db *DB) GetUserOverview(ctx context.Context, userID string) (*models.Overview, error) {
 	overview := &models.Overview{}
 
-	// Get total spend from virtual keys
+	// Get total spend from virtual keys, summed in micro-dollars
+	var spend int64
 	err := db.conn.QueryRowContext(ctx,
-		`SELECT COALESCE(SUM(current_spend), 0) FROM virtual_keys WHERE user_id = $1`,
+		`SELECT COALESCE(SUM(current_spend_micros), 0) FROM virtual_keys WHERE user_id = $1`,
 		userID,
-	).Scan(&overview.TotalSpend)
+	).Scan(&spend)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get total spend: %w", err)
 	}
+	overview.TotalSpend = fromMicros(spend)
 
 	return overview, nil
 }
@@ -0,0 +1,164 @@
+package database
+
+import (
+	"context"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/lumina/gateway/internal/models"
+)
+
+// testDB connects to the Postgres database named by TEST_DATABASE_URL and
+// migrates it, skipping the test when the variable isn't set
+func testDB(t *testing.T) *DB {
+	t.Helper()
+	url := os.Getenv("TEST_DATABASE_URL")
+	if url == "" {
+		t.Skip("TEST_DATABASE_URL not set")
+	}
+	db, err := New(url)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := db.Migrate(); err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+	return db
+}
+
+func TestUpdateKeySpendConcurrent(t *testing.T) {
+	db := testDB(t)
+	ctx := context.Background()
+
+	user, err := db.CreateUser(ctx, uuid.New().String()+"@example.com", "x")
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	key := &models.VirtualKey{ID: uuid.New().String(), UserID: user.ID, Name: "test", KeyHash: uuid.New().String(), CreatedAt: time.Now()}
+	if err := db.CreateVirtualKey(ctx, key); err != nil {
+		t.Fatalf("CreateVirtualKey: %v", err)
+	}
+
+	const workers, updates = 20, 50
+	const cost, tokens = 0.000003, 7
+
+	var wg sync.WaitGroup
+	errs := make(chan error, workers*updates)
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range updates {
+				if _, _, err := db.UpdateKeySpend(ctx, key.ID, cost, tokens); err != nil {
+					errs <- err
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatalf("UpdateKeySpend: %v", err)
+	}
+
+	n := int64(workers * updates)
+	got, err := db.GetVirtualKeyByID(ctx, key.ID)
+	if err != nil {
+		t.Fatalf("GetVirtualKeyByID: %v", err)
+	}
+	if want := fromMicros(toMicros(cost) * n); got.CurrentSpend != want {
+		t.Errorf("current spend = %v, want %v", got.CurrentSpend, want)
+	}
+
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	stats, err := db.GetDailyStats(ctx, user.ID, today.AddDate(0, 0, -1), today.AddDate(0, 0, 1))
+	if err != nil {
+		t.Fatalf("GetDailyStats: %v", err)
+	}
+	var totalTokens int
+	var totalCost float64
+	for _, stat := range stats {
+		totalTokens += stat.TotalTokens
+		totalCost += stat.TotalCost
+	}
+	if want := int(n) * tokens; totalTokens != want {
+		t.Errorf("daily tokens = %d, want %d", totalTokens, want)
+	}
+	if want := fromMicros(toMicros(cost) * n); totalCost != want {
+		t.Errorf("daily cost = %v, want %v", totalCost, want)
+	}
+}
+
+func TestSetUserProviderKeepsLabel(t *testing.T) {
+	db := testDB(t)
+	ctx := context.Background()
+
+	user, err := db.CreateUser(ctx, uuid.New().String()+"@example.com", "x")
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+
+	set := func(key []byte, label string) *models.UserProvider {
+		t.Helper()
+		if err := db.SetUserProvider(ctx, user.ID, ProviderKey{Provider: models.ProviderOpenAI, EncryptedKey: key, Label: label, KeyVersion: 1}); err != nil {
+			t.Fatalf("SetUserProvider: %v", err)
+		}
+		provider, err := db.GetUserProvider(ctx, user.ID, models.ProviderOpenAI)
+		if err != nil {
+			t.Fatalf("GetUserProvider: %v", err)
+		}
+		return provider
+	}
+
+	set([]byte("key-1"), "production")
+	// Rotating the key without a label keeps the existing one
+	if got := set([]byte("key-2"), ""); got.Label != "production" {
+		t.Errorf("label after rotation = %q, want production", got.Label)
+	}
+	if got := set([]byte("key-3"), "staging"); got.Label != "staging" {
+		t.Errorf("label after relabelling = %q, want staging", got.Label)
+	}
+}
+
+func TestSetDailyStatReplacesTotals(t *testing.T) {
+	db := testDB(t)
+	ctx := context.Background()
+
+	user, err := db.CreateUser(ctx, uuid.New().String()+"@example.com", "x")
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	key := &models.VirtualKey{ID: uuid.New().String(), UserID: user.ID, Name: "test", KeyHash: uuid.New().String(), CreatedAt: time.Now()}
+	if err := db.CreateVirtualKey(ctx, key); err != nil {
+		t.Fatalf("CreateVirtualKey: %v", err)
+	}
+
+	// Real-time updates over-count the day, and the recomputed totals correct them
+	for range 3 {
+		if _, _, err := db.UpdateKeySpend(ctx, key.ID, 0.5, 100); err != nil {
+			t.Fatalf("UpdateKeySpend: %v", err)
+		}
+	}
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	stats, err := db.GetDailyStats(ctx, user.ID, today.AddDate(0, 0, -1), today.AddDate(0, 0, 1))
+	if err != nil || len(stats) != 1 {
+		t.Fatalf("GetDailyStats = %+v, %v, want one day", stats, err)
+	}
+	day := stats[0].Date
+	if err := db.SetDailyStat(ctx, key.ID, day, 200, 1); err != nil {
+		t.Fatalf("SetDailyStat: %v", err)
+	}
+
+	stats, err = db.GetDailyStats(ctx, user.ID, day, day)
+	if err != nil {
+		t.Fatalf("GetDailyStats: %v", err)
+	}
+	if len(stats) != 1 || stats[0].TotalTokens != 200 || stats[0].TotalCost != 1 {
+		t.Errorf("daily stats = %+v, want 200 tokens costing $1", stats)
+	}
+}
@@ -0,0 +1,19 @@
+package database
+
+import "math"
+
+// microsPerDollar is the number of micro-dollars, the unit spend and daily costs are
+// stored in, per dollar. Integer amounts add up without drift; dollars are only used
+// at the boundary of this package.
+const microsPerDollar = 1_000_000
+
+// toMicros converts a cost in dollars to micro-dollars. Every cost is kept at full
+// precision, however small, so sums of many requests don't lose their fractions.
+func toMicros(dollars float64) int64 {
+	return int64(math.Round(dollars * microsPerDollar))
+}
+
+// fromMicros converts an amount stored in micro-dollars to dollars
+func fromMicros(micros int64) float64 {
+	return float64(micros) / microsPerDollar
+}
@@ -0,0 +1,53 @@
+package database
+
+import "testing"
+
+func TestToMicros(t *testing.T) {
+	tests := []struct {
+		dollars float64
+		want    int64
+	}{
+		{0, 0},
+		{1, 1_000_000},
+		{0.000001, 1},
+		{0.0000004, 0},
+		{0.0000005, 1},
+		{0.000003, 3}, // A sub-cent cost is kept, not rounded away
+		{0.1 + 0.2, 300_000},
+		{12.345678, 12_345_678},
+		{-0.25, -250_000},
+	}
+	for _, tt := range tests {
+		if got := toMicros(tt.dollars); got != tt.want {
+			t.Errorf("toMicros(%v) = %d, want %d", tt.dollars, got, tt.want)
+		}
+	}
+}
+
+func TestFromMicros(t *testing.T) {
+	if got := fromMicros(12_345_678); got != 12.345678 {
+		t.Errorf("fromMicros(12345678) = %v, want 12.345678", got)
+	}
+}
+
+func TestSumMicroCostsWithoutDrift(t *testing.T) {
+	const n = 1_000_000
+	tests := []struct {
+		cost float64
+		want float64 // Total in dollars
+	}{
+		{0.000001, 1},
+		{0.000003, 3},
+		{0.0001, 100},
+		{0.1, 100_000},
+	}
+	for _, tt := range tests {
+		var micros int64
+		for range n {
+			micros += toMicros(tt.cost)
+		}
+		if got := fromMicros(micros); got != tt.want {
+			t.Errorf("summing $%v %d times = $%v, want $%v", tt.cost, n, got, tt.want)
+		}
+	}
+}
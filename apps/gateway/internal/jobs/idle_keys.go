@@ -0,0 +1,152 @@
+package jobs
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/lumina/gateway/internal/auth"
+	"github.com/lumina/gateway/internal/cache"
+	"github.com/lumina/gateway/internal/database"
+	"github.com/lumina/gateway/internal/models"
+	"github.com/lumina/gateway/internal/webhook"
+)
+
+// IdleKeyRevocation periodically revokes keys that haven't been used for a while,
+// so forgotten keys don't stay usable forever. Keys that were never used are given
+// a grace period from creation instead. Each revocation is recorded in the audit log
+// and sent to the webhook. A Redis lock ensures only one replica runs it at a time.
+type IdleKeyRevocation struct {
+	db         *database.DB
+	keyService *auth.KeyService
+	cache      *cache.Cache
+	notifier   *webhook.Notifier // Nil when webhooks are disabled
+	idle       time.Duration     // How long a key may go unused
+	grace      time.Duration     // How long a never-used key may exist
+}
+
+const (
+	// idleRevocationInterval is how often idle keys are looked for
+	idleRevocationInterval = time.Hour
+
+	// idleRevocationLockTTL bounds how long a replica holds the revocation lock,
+	// including after a crash
+	idleRevocationLockTTL = 10 * time.Minute
+
+	// idleRevocationBatchSize is the number of idle keys listed at once
+	idleRevocationBatchSize = 100
+)
+
+// NewIdleKeyRevocation creates a job revoking keys unused for idle, or never used and
+// older than grace. notifier may be nil.
+func NewIdleKeyRevocation(db *database.DB, keyService *auth.KeyService, cache *cache.Cache, notifier *webhook.Notifier, idle, grace time.Duration) *IdleKeyRevocation {
+	return &IdleKeyRevocation{db: db, keyService: keyService, cache: cache, notifier: notifier, idle: idle, grace: grace}
+}
+
+// Run revokes idle keys every idleRevocationInterval until ctx is done
+func (j *IdleKeyRevocation) Run(ctx context.Context) {
+	ticker := time.NewTicker(idleRevocationInterval)
+	defer ticker.Stop()
+
+	for {
+		j.runLocked(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// runLocked revokes idle keys unless another replica is already doing so
+func (j *IdleKeyRevocation) runLocked(ctx context.Context) {
+	lock, err := j.cache.AcquireLock(ctx, "idle_key_revocation", idleRevocationLockTTL)
+	if err != nil {
+		slog.Error("failed to acquire idle key revocation lock", "error", err)
+		return
+	}
+	if lock == nil {
+		return
+	}
+	defer func() {
+		if err := lock.Release(context.Background()); err != nil {
+			slog.Warn("failed to release idle key revocation lock", "error", err)
+		}
+	}()
+
+	revoked, err := j.RevokeIdleKeys(ctx)
+	if err != nil {
+		slog.Error("idle key revocation failed", "revoked", revoked, "error", err)
+		return
+	}
+	if revoked > 0 {
+		slog.Info("revoked idle keys", "revoked", revoked)
+	}
+}
+
+// RevokeIdleKeys revokes every key that is currently idle and returns how many were revoked
+func (j *IdleKeyRevocation) RevokeIdleKeys(ctx context.Context) (int, error) {
+	now := time.Now()
+	usedBefore, createdBefore := now.Add(-j.idle), now.Add(-j.grace)
+
+	revoked := 0
+	for {
+		keys, err := j.db.ListIdleVirtualKeys(ctx, usedBefore, createdBefore, idleRevocationBatchSize)
+		if err != nil {
+			return revoked, err
+		}
+
+		for _, key := range keys {
+			ok, err := j.keyService.RevokeIdleKey(ctx, key, usedBefore, createdBefore)
+			if err != nil {
+				return revoked, err
+			}
+			if ok {
+				revoked++
+				j.record(ctx, key)
+			}
+		}
+
+		// Revoked keys drop out of the list, so a full batch means there may be more.
+		// Keys that were used in the meantime stay out too, since their last use moved
+		// past the cutoff.
+		if len(keys) < idleRevocationBatchSize {
+			return revoked, nil
+		}
+	}
+}
+
+// record writes the audit entry and sends the webhook for a revoked key
+func (j *IdleKeyRevocation) record(ctx context.Context, key *models.VirtualKey) {
+	reason := fmt.Sprintf("unused for %d days", int(j.idle.Hours()/24))
+	if key.LastUsedAt == nil {
+		reason = fmt.Sprintf("never used in the %d days since creation", int(j.grace.Hours()/24))
+	}
+	slog.Info("revoked idle key", "key_id", key.ID, "user_id", key.UserID, "reason", reason)
+
+	if err := j.db.InsertAuditEntry(ctx, &models.AuditEntry{
+		Action:    "key.idle_revoke",
+		Target:    key.ID,
+		Reason:    reason,
+		CreatedAt: time.Now(),
+	}); err != nil {
+		slog.Warn("failed to record idle key revocation", "key_id", key.ID, "error", err)
+	}
+
+	if j.notifier == nil {
+		return
+	}
+	if err := j.notifier.Send(ctx, webhook.EventKeyIdleRevoked, map[string]interface{}{
+		"key_id":       key.ID,
+		"key_name":     key.Name,
+		"key_preview":  key.KeyPreview,
+		"user_id":      key.UserID,
+		"created_at":   key.CreatedAt,
+		"last_used_at": key.LastUsedAt,
+		"reason":       reason,
+	}); err != nil {
+		slog.Warn("failed to send idle key revocation webhook", "key_id", key.ID, "error", err)
+	}
+}
@@ -0,0 +1,110 @@
+// Package jobs runs scheduled background maintenance tasks
+package jobs
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/lumina/gateway/internal/cache"
+	"github.com/lumina/gateway/internal/database"
+	"github.com/lumina/gateway/internal/logging"
+)
+
+// DailyRollup recomputes the previous day's per-key daily_stats from the request
+// logs once a day. The real-time counters can drift (failed or racing updates);
+// the rollup replaces them with totals derived from the logs, so re-running it
+// for the same day never double-counts. It is opt-in, since log entries dropped
+// by the pipeline lower the totals it writes. A Redis lock ensures only one
+// replica runs it each day.
+type DailyRollup struct {
+	db          *database.DB
+	logPipeline *logging.Pipeline
+	cache       *cache.Cache
+	at          time.Duration // Time of day (UTC) at which the rollup runs
+}
+
+// rollupLockTTL bounds how long a replica holds the rollup lock, including after a crash
+const rollupLockTTL = 30 * time.Minute
+
+// ParseTimeOfDay parses an "HH:MM" time of day into an offset from midnight
+func ParseTimeOfDay(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %q, expected HH:MM", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// NewDailyRollup creates a rollup job running daily at the given offset from midnight UTC
+func NewDailyRollup(db *database.DB, logPipeline *logging.Pipeline, cache *cache.Cache, at time.Duration) *DailyRollup {
+	return &DailyRollup{db: db, logPipeline: logPipeline, cache: cache, at: at}
+}
+
+// Run rolls up the previous day at the scheduled time every day until ctx is done
+func (j *DailyRollup) Run(ctx context.Context) {
+	for {
+		now := time.Now().UTC()
+		next := now.Truncate(24 * time.Hour).Add(j.at)
+		if !next.After(now) {
+			next = next.Add(24 * time.Hour)
+		}
+
+		timer := time.NewTimer(next.Sub(now))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		j.runLocked(ctx, next.Truncate(24*time.Hour).AddDate(0, 0, -1))
+	}
+}
+
+// runLocked rolls up a day unless another replica already has
+func (j *DailyRollup) runLocked(ctx context.Context, day time.Time) {
+	date := day.Format("2006-01-02")
+
+	lock, err := j.cache.AcquireLock(ctx, "daily_rollup:"+date, rollupLockTTL)
+	if err != nil {
+		slog.Error("failed to acquire daily stats rollup lock", "date", date, "error", err)
+		return
+	}
+	if lock == nil {
+		slog.Info("daily stats rollup handled by another replica", "date", date)
+		return
+	}
+
+	// On success the lock is left to expire so replicas whose timers fire a little
+	// later skip the day; on failure it is released so another replica can retry
+	start := time.Now()
+	keys, err := j.RollupDay(ctx, day)
+	if err != nil {
+		slog.Error("daily stats rollup failed", "date", date, "error", err)
+		if err := lock.Release(context.Background()); err != nil {
+			slog.Warn("failed to release daily stats rollup lock", "date", date, "error", err)
+		}
+		return
+	}
+	slog.Info("daily stats rollup complete", "date", date, "keys", keys, "duration", time.Since(start))
+}
+
+// RollupDay recomputes daily_stats for one UTC day and returns the number of keys
+// updated. Keys without logged traffic that day are left untouched.
+func (j *DailyRollup) RollupDay(ctx context.Context, day time.Time) (int, error) {
+	start := day.UTC().Truncate(24 * time.Hour)
+	totals, err := j.logPipeline.GetKeyTotals(ctx, start, start.AddDate(0, 0, 1))
+	if err != nil {
+		return 0, err
+	}
+
+	for _, stat := range totals {
+		if err := j.db.SetDailyStat(ctx, stat.KeyID, start, stat.TotalTokens, stat.TotalCost); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(totals), nil
+}
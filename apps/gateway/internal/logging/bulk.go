@@ -0,0 +1,96 @@
+package logging
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+)
+
+// maxBulkErrorBody bounds how much of a failed bulk response is read for logging
+const maxBulkErrorBody = 64 << 10
+
+// bulkItem is the per-document result of a bulk request
+type bulkItem struct {
+	Index struct {
+		ID     string `json:"_id"`
+		Status int    `json:"status"`
+		Error  *struct {
+			Type   string `json:"type"`
+			Reason string `json:"reason"`
+		} `json:"error,omitempty"`
+	} `json:"index"`
+}
+
+// bulkResult summarizes a bulk response
+type bulkResult struct {
+	errors bool // The response's top-level "errors" flag
+	items  int
+	failed int
+}
+
+// decodeBulkResponse reads a bulk response one item at a time, so memory stays
+// bounded however large the batch. Each failed document is logged as it is read.
+func decodeBulkResponse(r io.Reader) (bulkResult, error) {
+	var result bulkResult
+	dec := json.NewDecoder(r)
+
+	if err := expectDelim(dec, '{'); err != nil {
+		return result, err
+	}
+
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return result, err
+		}
+
+		switch tok {
+		case "errors":
+			if err := dec.Decode(&result.errors); err != nil {
+				return result, err
+			}
+		case "items":
+			if err := expectDelim(dec, '['); err != nil {
+				return result, err
+			}
+			for dec.More() {
+				var item bulkItem
+				if err := dec.Decode(&item); err != nil {
+					return result, err
+				}
+				result.items++
+				if item.Index.Error != nil {
+					result.failed++
+					slog.Error("document index failed",
+						"id", item.Index.ID,
+						"status", item.Index.Status,
+						"error_type", item.Index.Error.Type,
+						"reason", item.Index.Error.Reason)
+				}
+			}
+			if err := expectDelim(dec, ']'); err != nil {
+				return result, err
+			}
+		default:
+			// Skip fields we don't need, such as "took"
+			var skip json.RawMessage
+			if err := dec.Decode(&skip); err != nil {
+				return result, err
+			}
+		}
+	}
+
+	return result, nil
+}
+
+func expectDelim(dec *json.Decoder, want json.Delim) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if tok != want {
+		return fmt.Errorf("expected %q, got %v", want, tok)
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// endpointCooldown is how long a node that failed is skipped before it is tried again
+const endpointCooldown = 30 * time.Second
+
+// endpoint is a single OpenSearch node the pipeline can talk to
+type endpoint struct {
+	url      string
+	failedAt atomic.Int64 // Unix nanoseconds of the last failure, zero when healthy
+}
+
+func (e *endpoint) healthy(now time.Time) bool {
+	failedAt := e.failedAt.Load()
+	return failedAt == 0 || now.Sub(time.Unix(0, failedAt)) > endpointCooldown
+}
+
+// endpointPool round-robins requests across OpenSearch nodes, skipping nodes that recently errored
+type endpointPool struct {
+	endpoints []*endpoint
+	next      atomic.Uint64
+}
+
+func newEndpointPool(urls []string) *endpointPool {
+	pool := &endpointPool{endpoints: make([]*endpoint, len(urls))}
+	for i, url := range urls {
+		pool.endpoints[i] = &endpoint{url: url}
+	}
+	return pool
+}
+
+// order returns the endpoints to try for one request: healthy nodes in round-robin
+// order first, then nodes still cooling down as a last resort
+func (p *endpointPool) order() []*endpoint {
+	n := len(p.endpoints)
+	start := int(p.next.Add(1) % uint64(n))
+	now := time.Now()
+
+	healthy := make([]*endpoint, 0, n)
+	var cooling []*endpoint
+	for i := 0; i < n; i++ {
+		e := p.endpoints[(start+i)%n]
+		if e.healthy(now) {
+			healthy = append(healthy, e)
+		} else {
+			cooling = append(cooling, e)
+		}
+	}
+	return append(healthy, cooling...)
+}
+
+// isNodeFailure reports whether a response indicates the node itself is unavailable
+// (as opposed to a problem with the request) so the next node should be tried
+func isNodeFailure(statusCode int) bool {
+	return statusCode == http.StatusBadGateway ||
+		statusCode == http.StatusServiceUnavailable ||
+		statusCode == http.StatusGatewayTimeout
+}
+
+// do sends a request to the first available OpenSearch node, failing over to the
+// others on connection errors or unavailable responses
+func (p *Pipeline) do(ctx context.Context, method, path string, body []byte, contentType string) (*http.Response, error) {
+	var lastErr error
+
+	endpoints := p.endpoints.order()
+	for i, e := range endpoints {
+		var reader io.Reader
+		if body != nil {
+			reader = bytes.NewReader(body)
+		}
+
+		req, err := http.NewRequestWithContext(ctx, method, e.url+path, reader)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create request: %w", err)
+		}
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+
+		resp, err := p.httpClient.Do(req)
+		if err == nil && !isNodeFailure(resp.StatusCode) {
+			e.failedAt.Store(0)
+			return resp, nil
+		}
+		if err != nil && ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		e.failedAt.Store(time.Now().UnixNano())
+		if err == nil {
+			// Let the caller handle the status when no other node is left
+			if i == len(endpoints)-1 {
+				return resp, nil
+			}
+			resp.Body.Close()
+			err = fmt.Errorf("node unavailable: status %d", resp.StatusCode)
+		}
+
+		slog.Warn("OpenSearch node failed, trying next", "url", e.url, "error", err)
+		lastErr = err
+	}
+
+	return nil, lastErr
+}
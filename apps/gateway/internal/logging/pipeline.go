@@ -7,48 +7,105 @@ import (
 	"fmt"
 	"io"
 	"log/slog"
+	"math"
 	"net/http"
+	"sort"
 	"sync"
 	"time"
+	"unicode/utf8"
 
+	"github.com/lumina/gateway/internal/metrics"
 	"github.com/lumina/gateway/internal/models"
 )
 
 const (
-	indexName     = "lumina-logs"
-	batchSize     = 100
-	flushInterval = 5 * time.Second
-	workerCount   = 10
-	channelSize   = 1000
+	indexName      = "lumina-logs"
+	debugIndexName = "lumina-debug" // Raw bodies of captured requests, kept apart so retention can differ
+	batchSize      = 100
+	flushInterval  = 5 * time.Second
+	workerCount    = 10
+	channelSize    = 1000
+
+	// truncationMarker is appended to bodies that were cut short
+	truncationMarker = "…"
+)
+
+// Options configures optional pipeline behavior
+type Options struct {
+	BodyMode        models.LogBodyMode // Default body logging mode for keys without their own setting
+	BodyMaxChars    int                // Character limit applied to bodies in truncated mode
+	ContentMaxChars int                // Hard limit on stored response content in every mode, zero means unlimited
+	EnqueueTimeout  time.Duration      // How long Log waits for channel capacity before dropping, zero never waits
+	IndexRetry      time.Duration      // How often index creation is retried after failing at startup, zero never retries
+	TailSubscribers int                // Maximum concurrent live tail subscribers, zero disables live tails
+	TailBuffer      int                // Entries buffered per live tail subscriber before it misses some
+	MetadataDynamic string             // How undeclared metadata tags are mapped: true, false or strict; empty means false
+	MetadataFields  []string           // Metadata tags always mapped as keywords
+}
+
+// Mapping modes for metadata tags not listed in Options.MetadataFields, as OpenSearch's
+// dynamic mapping parameter
+const (
+	MetadataDynamicTrue   = "true"   // Every tag is mapped as a keyword when first seen
+	MetadataDynamicFalse  = "false"  // Undeclared tags are stored but can't be searched
+	MetadataDynamicStrict = "strict" // Entries with undeclared tags are rejected
+)
+
+var (
+	enqueueBlocked = metrics.NewCounter("lumina_log_enqueue_blocked_total",
+		"Log entries that had to wait for capacity in the logging pipeline")
+	enqueueBlockedSeconds = metrics.NewCounter("lumina_log_enqueue_blocked_seconds_total",
+		"Total time spent waiting for capacity in the logging pipeline")
+	entriesDropped = metrics.NewCounter("lumina_log_entries_dropped_total",
+		"Log entries dropped because the logging pipeline was full")
 )
 
 // Pipeline handles async logging to OpenSearch
 type Pipeline struct {
-	opensearchURL string
-	httpClient    *http.Client
-	logChan       chan *models.LogEntry
-	batch         []*models.LogEntry
-	batchMu       sync.Mutex
-	wg            sync.WaitGroup
-	done          chan struct{}
+	endpoints  *endpointPool
+	opts       Options
+	httpClient *http.Client
+	logChan    chan *models.LogEntry
+	batch      []*models.LogEntry
+	batchMu    sync.Mutex
+	wg         sync.WaitGroup
+	done       chan struct{}
+	tails      *tailHub
 }
 
 // New creates a new logging pipeline
-func New(opensearchURL string) (*Pipeline, error) {
-	slog.Info("initializing logging pipeline", "opensearch_url", opensearchURL)
+func New(opensearchURLs []string, opts Options) (*Pipeline, error) {
+	slog.Info("initializing logging pipeline", "opensearch_urls", opensearchURLs, "body_mode", opts.BodyMode)
+
+	if len(opensearchURLs) == 0 {
+		return nil, fmt.Errorf("at least one OpenSearch URL is required")
+	}
+
+	if opts.BodyMode == "" {
+		opts.BodyMode = models.LogBodyFull
+	}
+	if opts.MetadataDynamic == "" {
+		opts.MetadataDynamic = MetadataDynamicFalse
+	}
 
 	p := &Pipeline{
-		opensearchURL: opensearchURL,
-		httpClient:    &http.Client{Timeout: 30 * time.Second},
-		logChan:       make(chan *models.LogEntry, channelSize),
-		batch:         make([]*models.LogEntry, 0, batchSize),
-		done:          make(chan struct{}),
+		endpoints:  newEndpointPool(opensearchURLs),
+		opts:       opts,
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+		logChan:    make(chan *models.LogEntry, channelSize),
+		batch:      make([]*models.LogEntry, 0, batchSize),
+		done:       make(chan struct{}),
+		tails:      newTailHub(opts.TailSubscribers, opts.TailBuffer),
 	}
 
 	// Create index if not exists
 	if err := p.createIndex(); err != nil {
-		slog.Warn("failed to create index", "error", err)
 		// Don't fail - OpenSearch might not be ready yet
+		slog.Warn("failed to create index", "error", err, "retry_interval", opts.IndexRetry)
+		if opts.IndexRetry > 0 {
+			p.wg.Add(1)
+			go p.retryCreateIndex()
+		}
 	} else {
 		slog.Info("OpenSearch index created or already exists", "index", indexName)
 	}
@@ -86,9 +143,30 @@ func (p *Pipeline) Log(entry *models.LogEntry) {
 	select {
 	case p.logChan <- entry:
 		slog.Debug("entry added to channel", "trace_id", entry.TraceID)
+		return
 	default:
+	}
+
+	if p.opts.EnqueueTimeout <= 0 {
+		entriesDropped.Inc()
 		slog.Warn("log channel full, dropping log entry", "trace_id", entry.TraceID)
+		return
+	}
+
+	// Wait briefly for capacity; Log runs on the request path so the timeout is kept short
+	start := time.Now()
+	timer := time.NewTimer(p.opts.EnqueueTimeout)
+	defer timer.Stop()
+
+	enqueueBlocked.Inc()
+	select {
+	case p.logChan <- entry:
+		slog.Debug("entry added to channel after waiting", "trace_id", entry.TraceID, "waited", time.Since(start))
+	case <-timer.C:
+		entriesDropped.Inc()
+		slog.Warn("log channel full after waiting, dropping log entry", "trace_id", entry.TraceID, "waited", time.Since(start))
 	}
+	enqueueBlockedSeconds.Add(time.Since(start).Seconds())
 }
 
 func (p *Pipeline) worker() {
@@ -101,6 +179,7 @@ func (p *Pipeline) worker() {
 				return
 			}
 			p.addToBatch(entry)
+			p.publishTail(entry)
 		case <-p.done:
 			return
 		}
@@ -148,7 +227,7 @@ func (p *Pipeline) flush() {
 	p.batch = make([]*models.LogEntry, 0, batchSize)
 	p.batchMu.Unlock()
 
-	slog.Info("flushing batch to OpenSearch", "count", len(batch), "url", p.opensearchURL)
+	slog.Info("flushing batch to OpenSearch", "count", len(batch))
 	if err := p.bulkIndex(batch); err != nil {
 		slog.Error("failed to bulk index logs", "error", err, "count", len(batch))
 	} else {
@@ -156,59 +235,212 @@ func (p *Pipeline) flush() {
 	}
 }
 
+// retryCreateIndex keeps trying to create the index until it succeeds or the
+// pipeline is closed, so OpenSearch may start after the gateway
+func (p *Pipeline) retryCreateIndex() {
+	defer p.wg.Done()
+
+	ticker := time.NewTicker(p.opts.IndexRetry)
+	defer ticker.Stop()
+
+	for attempt := 2; ; attempt++ {
+		select {
+		case <-ticker.C:
+			if err := p.createIndex(); err != nil {
+				slog.Warn("failed to create index, will retry", "attempt", attempt, "error", err)
+				continue
+			}
+			slog.Info("OpenSearch index created or already exists", "index", indexName, "attempts", attempt)
+			return
+		case <-p.done:
+			return
+		}
+	}
+}
+
+// createIndex installs the index templates, creates the indices and brings the
+// metadata mapping of an index created before the templates up to date. The
+// templates match every index with the same prefix, so indices created later,
+// e.g. by rotation, get the same mappings.
 func (p *Pipeline) createIndex() error {
-	mapping := map[string]interface{}{
+	if err := p.putTemplate(indexName, logMapping(p.opts)); err != nil {
+		return err
+	}
+	if err := p.putTemplate(debugIndexName, debugMapping()); err != nil {
+		return err
+	}
+	if err := p.putIndex(indexName); err != nil {
+		return err
+	}
+	if err := p.putIndex(debugIndexName); err != nil {
+		return err
+	}
+
+	// An existing index keeps its mapping otherwise. A conflict leaves it as it was
+	// without holding up logging.
+	if err := p.putMapping(indexName, map[string]interface{}{
+		"properties": map[string]interface{}{"metadata": metadataMapping(p.opts)},
+	}); err != nil {
+		slog.Warn("failed to update metadata mapping of existing index", "index", indexName, "error", err)
+	}
+	return nil
+}
+
+// debugMapping maps the documents of the debug index. Every field is known, so
+// unexpected ones are kept in the source without being mapped.
+func debugMapping() map[string]interface{} {
+	return map[string]interface{}{
 		"mappings": map[string]interface{}{
+			"dynamic": "false",
 			"properties": map[string]interface{}{
 				"trace_id":         map[string]string{"type": "keyword"},
 				"timestamp":        map[string]string{"type": "date"},
 				"virtual_key_name": map[string]string{"type": "keyword"},
 				"virtual_key_id":   map[string]string{"type": "keyword"},
 				"user_id":          map[string]string{"type": "keyword"},
+				"capture":          map[string]string{"type": "keyword"},
+				"model":            map[string]string{"type": "keyword"},
+				"status_code":      map[string]string{"type": "integer"},
+				"raw_request":      map[string]string{"type": "text"},
+				"raw_response":     map[string]string{"type": "text"},
+			},
+		},
+	}
+}
+
+// logMapping maps the documents of the main log index. Fields the gateway writes are
+// typed explicitly and unexpected ones are kept in the source without being mapped;
+// only client metadata tags may add fields, as opts allows.
+func logMapping(opts Options) map[string]interface{} {
+	return map[string]interface{}{
+		"mappings": map[string]interface{}{
+			"dynamic": "false",
+			// Client metadata tags are arbitrary keys; map every one as an exact-match keyword
+			"dynamic_templates": []map[string]interface{}{
+				{"metadata_tags": map[string]interface{}{
+					"path_match":         "metadata.*",
+					"match_mapping_type": "string",
+					"mapping":            map[string]string{"type": "keyword"},
+				}},
+			},
+			"properties": map[string]interface{}{
+				"metadata":                 metadataMapping(opts),
+				"trace_id":                 map[string]string{"type": "keyword"},
+				"request_id":               map[string]string{"type": "keyword"},
+				"timestamp":                map[string]string{"type": "date"},
+				"virtual_key_name":         map[string]string{"type": "keyword"},
+				"virtual_key_id":           map[string]string{"type": "keyword"},
+				"user_id":                  map[string]string{"type": "keyword"},
+				"provider_key_fingerprint": map[string]string{"type": "keyword"},
+				"client_identity":          map[string]string{"type": "keyword"},
+				"share_token_id":           map[string]string{"type": "keyword"},
 				"request": map[string]interface{}{
 					"properties": map[string]interface{}{
-						"model":       map[string]string{"type": "keyword"},
-						"messages":    map[string]string{"type": "keyword"},
-						"temperature": map[string]string{"type": "float"},
-						"max_tokens":  map[string]string{"type": "integer"},
+						"model":           map[string]string{"type": "keyword"},
+						"requested_model": map[string]string{"type": "keyword"},
+						"provider":        map[string]string{"type": "keyword"},
+						"messages":        map[string]string{"type": "keyword"},
+						"messages_length": map[string]string{"type": "integer"},
+						"system":          map[string]string{"type": "text"},
+						"prompt":          map[string]string{"type": "text"},
+						"temperature":     map[string]string{"type": "float"},
+						"max_tokens":      map[string]string{"type": "integer"},
 					},
 				},
 				"response": map[string]interface{}{
 					"properties": map[string]interface{}{
-						"content":     map[string]string{"type": "text"},
-						"status_code": map[string]string{"type": "integer"},
-						"error":       map[string]string{"type": "text"},
+						"content":        map[string]string{"type": "text"},
+						"content_length": map[string]string{"type": "integer"},
+						"status_code":    map[string]string{"type": "integer"},
+						"over_budget":    map[string]string{"type": "boolean"},
+						"served_model":   map[string]string{"type": "keyword"},
+						"error":          map[string]string{"type": "text"},
+						"schema_errors":  map[string]string{"type": "text"},
 						"usage": map[string]interface{}{
 							"properties": map[string]interface{}{
 								"prompt_tokens":     map[string]string{"type": "integer"},
 								"completion_tokens": map[string]string{"type": "integer"},
 								"total_tokens":      map[string]string{"type": "integer"},
+								"cached_tokens":     map[string]string{"type": "integer"},
+								"image_tokens":      map[string]string{"type": "integer"},
+								"audio_seconds":     map[string]string{"type": "float"},
 							},
 						},
 					},
 				},
 				"metrics": map[string]interface{}{
 					"properties": map[string]interface{}{
-						"latency_ms": map[string]string{"type": "integer"},
-						"cost_usd":   map[string]string{"type": "float"},
+						"latency_ms":  map[string]string{"type": "integer"},
+						"cost_usd":    map[string]string{"type": "float"},
+						"sample_rate": map[string]string{"type": "float"},
 					},
 				},
 			},
 		},
 	}
+}
 
+// metadataMapping maps the client metadata tags: declared ones always as keywords,
+// others as the configured dynamic mode allows
+func metadataMapping(opts Options) map[string]interface{} {
+	properties := make(map[string]interface{}, len(opts.MetadataFields))
+	for _, field := range opts.MetadataFields {
+		properties[field] = map[string]string{"type": "keyword"}
+	}
+	return map[string]interface{}{
+		"type":       "object",
+		"dynamic":    opts.MetadataDynamic,
+		"properties": properties,
+	}
+}
+
+// putTemplate installs or replaces the index template applying mapping to every
+// index whose name starts with name
+func (p *Pipeline) putTemplate(name string, mapping map[string]interface{}) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"index_patterns": []string{name + "*"},
+		"template":       mapping,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal index template: %w", err)
+	}
+
+	resp, err := p.do(context.Background(), "PUT", "/_index_template/"+name, body, "application/json")
+	if err != nil {
+		return fmt.Errorf("failed to put index template: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status code putting index template %s: %d", name, resp.StatusCode)
+	}
+	return nil
+}
+
+// putMapping adds mapping to an existing index
+func (p *Pipeline) putMapping(name string, mapping map[string]interface{}) error {
 	body, err := json.Marshal(mapping)
 	if err != nil {
 		return fmt.Errorf("failed to marshal mapping: %w", err)
 	}
 
-	req, err := http.NewRequest("PUT", p.opensearchURL+"/"+indexName, bytes.NewReader(body))
+	resp, err := p.do(context.Background(), "PUT", "/"+name+"/_mapping", body, "application/json")
 	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
+		return fmt.Errorf("failed to put mapping: %w", err)
 	}
-	req.Header.Set("Content-Type", "application/json")
+	defer resp.Body.Close()
 
-	resp, err := p.httpClient.Do(req)
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, maxBulkErrorBody))
+		return fmt.Errorf("unexpected status code: %d: %s", resp.StatusCode, respBody)
+	}
+	return nil
+}
+
+// putIndex creates an index unless it already exists. Its mapping comes from the
+// matching index template.
+func (p *Pipeline) putIndex(name string) error {
+	resp, err := p.do(context.Background(), "PUT", "/"+name, nil, "")
 	if err != nil {
 		return fmt.Errorf("failed to create index: %w", err)
 	}
@@ -223,7 +455,8 @@ func (p *Pipeline) createIndex() error {
 }
 
 // toIndexableDoc converts a LogEntry to an indexable document,
-// serializing complex fields like messages to JSON strings
+// serializing complex fields like messages to JSON strings and
+// applying the effective body logging mode
 func (p *Pipeline) toIndexableDoc(entry *models.LogEntry) map[string]interface{} {
 	// Convert messages to JSON string if it's not already a string
 	var messagesStr string
@@ -236,37 +469,138 @@ func (p *Pipeline) toIndexableDoc(entry *models.LogEntry) map[string]interface{}
 		}
 	}
 
+	mode := entry.BodyMode
+	if mode == "" {
+		mode = p.opts.BodyMode
+	}
+
+	messagesStr, messagesLen := p.applyBodyMode(mode, messagesStr)
+	system, _ := p.applyBodyMode(mode, entry.Request.System)
+	prompt, _ := p.applyBodyMode(mode, entry.Request.Prompt)
+	content, contentLen := p.applyBodyMode(mode, entry.Response.Content)
+	content, contentLen = p.capContent(content, contentLen)
+
 	return map[string]interface{}{
-		"trace_id":         entry.TraceID,
-		"timestamp":        entry.Timestamp,
-		"virtual_key_name": entry.VirtualKeyName,
-		"virtual_key_id":   entry.VirtualKeyID,
-		"user_id":          entry.UserID,
+		"trace_id":                 entry.TraceID,
+		"request_id":               entry.RequestID,
+		"timestamp":                entry.Timestamp,
+		"virtual_key_name":         entry.VirtualKeyName,
+		"virtual_key_id":           entry.VirtualKeyID,
+		"user_id":                  entry.UserID,
+		"provider_key_fingerprint": entry.ProviderKey,
+		"client_identity":          entry.ClientIdentity,
+		"share_token_id":           entry.ShareTokenID,
+		"metadata":                 entry.Metadata,
 		"request": map[string]interface{}{
-			"model":       entry.Request.Model,
-			"provider":    entry.Request.Provider,
-			"messages":    messagesStr,
-			"prompt":      entry.Request.Prompt,
-			"temperature": entry.Request.Temperature,
-			"max_tokens":  entry.Request.MaxTokens,
+			"model":           entry.Request.Model,
+			"requested_model": entry.Request.RequestedModel,
+			"provider":        entry.Request.Provider,
+			"messages":        messagesStr,
+			"messages_length": messagesLen,
+			"system":          system,
+			"prompt":          prompt,
+			"temperature":     entry.Request.Temperature,
+			"max_tokens":      entry.Request.MaxTokens,
 		},
 		"response": map[string]interface{}{
-			"content":     entry.Response.Content,
-			"status_code": entry.Response.StatusCode,
-			"error":       entry.Response.Error,
+			"content":        content,
+			"content_length": contentLen,
+			"status_code":    entry.Response.StatusCode,
+			"error":          entry.Response.Error,
+			"schema_errors":  entry.Response.SchemaErrors,
+			"over_budget":    entry.Response.OverBudget,
+			"served_model":   entry.Response.ServedModel,
 			"usage": map[string]interface{}{
 				"prompt_tokens":     entry.Response.Usage.PromptTokens,
 				"completion_tokens": entry.Response.Usage.CompletionTokens,
 				"total_tokens":      entry.Response.Usage.TotalTokens,
+				"cached_tokens":     entry.Response.Usage.CachedTokens,
+				"image_tokens":      entry.Response.Usage.ImageTokens,
+				"audio_seconds":     entry.Response.Usage.AudioSeconds,
 			},
 		},
 		"metrics": map[string]interface{}{
-			"latency_ms": entry.Metrics.LatencyMs,
-			"cost_usd":   entry.Metrics.CostUSD,
+			"latency_ms":  entry.Metrics.LatencyMs,
+			"cost_usd":    entry.Metrics.CostUSD,
+			"sample_rate": entry.Metrics.SampleRate,
 		},
 	}
 }
 
+// captures reports whether an entry's raw bodies go to the debug index. Sampled
+// captures only apply where bodies are logged in full anyway: keys that reduce body
+// logging because they handle sensitive data are captured only when they opt in.
+func (p *Pipeline) captures(entry *models.LogEntry) bool {
+	switch entry.DebugCapture {
+	case models.DebugCaptureKey:
+		return true
+	case models.DebugCaptureSampled:
+		mode := entry.BodyMode
+		if mode == "" {
+			mode = p.opts.BodyMode
+		}
+		return mode == models.LogBodyFull
+	}
+	return false
+}
+
+// debugDoc builds the debug index document of a captured entry, with its bodies
+// exactly as sent and received
+func debugDoc(entry *models.LogEntry) map[string]interface{} {
+	return map[string]interface{}{
+		"trace_id":         entry.TraceID,
+		"timestamp":        entry.Timestamp,
+		"virtual_key_name": entry.VirtualKeyName,
+		"virtual_key_id":   entry.VirtualKeyID,
+		"user_id":          entry.UserID,
+		"capture":          entry.DebugCapture,
+		"model":            entry.Request.Model,
+		"status_code":      entry.Response.StatusCode,
+		"raw_request":      entry.RawRequest,
+		"raw_response":     entry.RawResponse,
+	}
+}
+
+// applyBodyMode reduces a body according to the logging mode and returns it
+// together with the original length in characters (zero when left untouched)
+func (p *Pipeline) applyBodyMode(mode models.LogBodyMode, body string) (string, int) {
+	length := utf8.RuneCountInString(body)
+
+	switch mode {
+	case models.LogBodyMetadata:
+		if length == 0 {
+			return "", 0
+		}
+		return "", length
+	case models.LogBodyTruncated:
+		if length <= p.opts.BodyMaxChars {
+			return body, 0
+		}
+		runes := []rune(body)
+		return string(runes[:p.opts.BodyMaxChars]) + truncationMarker, length
+	default:
+		return body, 0
+	}
+}
+
+// capContent cuts response content down to ContentMaxChars so very large
+// completions don't bloat the index. contentLen is the original length already
+// recorded by applyBodyMode, if any.
+func (p *Pipeline) capContent(content string, contentLen int) (string, int) {
+	if p.opts.ContentMaxChars <= 0 || len(content) <= p.opts.ContentMaxChars {
+		return content, contentLen
+	}
+
+	runes := []rune(content)
+	if len(runes) <= p.opts.ContentMaxChars {
+		return content, contentLen
+	}
+	if contentLen == 0 {
+		contentLen = len(runes)
+	}
+	return string(runes[:p.opts.ContentMaxChars]) + truncationMarker, contentLen
+}
+
 func (p *Pipeline) bulkIndex(entries []*models.LogEntry) error {
 	var buf bytes.Buffer
 
@@ -287,98 +621,117 @@ func (p *Pipeline) bulkIndex(entries []*models.LogEntry) error {
 		docBytes, _ := json.Marshal(doc)
 		buf.Write(docBytes)
 		buf.WriteByte('\n')
-	}
 
-	req, err := http.NewRequest("POST", p.opensearchURL+"/_bulk", &buf)
-	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
+		if p.captures(entry) {
+			debugAction, _ := json.Marshal(map[string]interface{}{
+				"index": map[string]interface{}{"_index": debugIndexName, "_id": entry.TraceID},
+			})
+			buf.Write(debugAction)
+			buf.WriteByte('\n')
+
+			debugBytes, _ := json.Marshal(debugDoc(entry))
+			buf.Write(debugBytes)
+			buf.WriteByte('\n')
+		}
 	}
-	req.Header.Set("Content-Type", "application/x-ndjson")
 
-	resp, err := p.httpClient.Do(req)
+	resp, err := p.do(context.Background(), "POST", "/_bulk", buf.Bytes(), "application/x-ndjson")
 	if err != nil {
 		return fmt.Errorf("failed to bulk index: %w", err)
 	}
 	defer resp.Body.Close()
 
-	// Read and parse response body
-	respBody, _ := io.ReadAll(resp.Body)
 	if resp.StatusCode != http.StatusOK {
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, maxBulkErrorBody))
 		slog.Error("OpenSearch bulk index failed", "status", resp.StatusCode, "response", string(respBody))
 		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
 	}
 
-	// Parse bulk response to check for individual document errors
-	var bulkResp struct {
-		Took   int  `json:"took"`
-		Errors bool `json:"errors"`
-		Items  []struct {
-			Index struct {
-				ID     string `json:"_id"`
-				Status int    `json:"status"`
-				Error  *struct {
-					Type   string `json:"type"`
-					Reason string `json:"reason"`
-				} `json:"error,omitempty"`
-			} `json:"index"`
-		} `json:"items"`
-	}
-
-	if err := json.Unmarshal(respBody, &bulkResp); err != nil {
+	// Check for individual document errors
+	result, err := decodeBulkResponse(resp.Body)
+	if err != nil {
 		slog.Warn("failed to parse bulk response", "error", err)
 		return nil
 	}
 
-	if bulkResp.Errors {
-		var failedCount int
-		for _, item := range bulkResp.Items {
-			if item.Index.Error != nil {
-				failedCount++
-				slog.Error("document index failed",
-					"id", item.Index.ID,
-					"status", item.Index.Status,
-					"error_type", item.Index.Error.Type,
-					"reason", item.Index.Error.Reason)
-			}
-		}
-		return fmt.Errorf("bulk index had %d failed documents out of %d", failedCount, len(bulkResp.Items))
+	if result.errors {
+		return fmt.Errorf("bulk index had %d failed documents out of %d", result.failed, result.items)
 	}
 
 	return nil
 }
 
+// SearchParams narrows down a log search
+type SearchParams struct {
+	Query      string            // Full-text query over messages and response content
+	UserID     string            // Owner of the key that made the request
+	KeyID      string            // Virtual key that made the request
+	Model      string            // Exact model, e.g. "openai/gpt-4o"
+	StatusCode *int              // Exact upstream status code
+	MinStatus  int               // Lowest status code, e.g. 400 for errors; zero for any
+	StartDate  *time.Time        // Inclusive lower bound on the timestamp
+	EndDate    *time.Time        // Inclusive upper bound on the timestamp
+	Metadata   map[string]string // Client-supplied tags that must all match
+	From       int
+	Size       int
+}
+
 // Search searches logs in OpenSearch
-func (p *Pipeline) Search(ctx context.Context, query string, model string, statusCode *int, startDate, endDate *time.Time, from, size int) ([]*models.LogEntry, int64, error) {
+func (p *Pipeline) Search(ctx context.Context, params SearchParams) ([]*models.LogEntry, int64, error) {
 	must := make([]map[string]interface{}, 0)
 
-	if query != "" {
+	if params.Query != "" {
 		must = append(must, map[string]interface{}{
 			"multi_match": map[string]interface{}{
-				"query":  query,
-				"fields": []string{"request.messages", "response.content"},
+				"query":  params.Query,
+				"fields": []string{"request.messages", "request.system", "response.content", "request_id"},
 			},
 		})
 	}
 
-	if model != "" {
+	if params.UserID != "" {
+		must = append(must, map[string]interface{}{
+			"term": map[string]string{"user_id": params.UserID},
+		})
+	}
+
+	if params.KeyID != "" {
+		must = append(must, map[string]interface{}{
+			"term": map[string]string{"virtual_key_id": params.KeyID},
+		})
+	}
+
+	if params.Model != "" {
+		must = append(must, map[string]interface{}{
+			"term": map[string]string{"request.model": params.Model},
+		})
+	}
+
+	if params.MinStatus > 0 {
+		must = append(must, map[string]interface{}{
+			"range": map[string]interface{}{"response.status_code": map[string]int{"gte": params.MinStatus}},
+		})
+	}
+
+	if params.StatusCode != nil {
 		must = append(must, map[string]interface{}{
-			"term": map[string]string{"request.model": model},
+			"term": map[string]int{"response.status_code": *params.StatusCode},
 		})
 	}
 
-	if statusCode != nil {
+	for key, value := range params.Metadata {
 		must = append(must, map[string]interface{}{
-			"term": map[string]int{"response.status_code": *statusCode},
+			"term": map[string]string{"metadata." + key: value},
 		})
 	}
 
-	if startDate != nil || endDate != nil {
+	if params.StartDate != nil || params.EndDate != nil {
 		rangeQuery := map[string]interface{}{}
-		if startDate != nil {
-			rangeQuery["gte"] = startDate.Format(time.RFC3339)
+		if params.StartDate != nil {
+			rangeQuery["gte"] = params.StartDate.Format(time.RFC3339)
 		}
-		if endDate != nil {
-			rangeQuery["lte"] = endDate.Format(time.RFC3339)
+		if params.EndDate != nil {
+			rangeQuery["lte"] = params.EndDate.Format(time.RFC3339)
 		}
 		must = append(must, map[string]interface{}{
 			"range": map[string]interface{}{"timestamp": rangeQuery},
@@ -394,8 +747,8 @@ func (p *Pipeline) Search(ctx context.Context, query string, model string, statu
 		"sort": []map[string]interface{}{
 			{"timestamp": map[string]string{"order": "desc"}},
 		},
-		"from": from,
-		"size": size,
+		"from": params.From,
+		"size": params.Size,
 	}
 
 	body, err := json.Marshal(searchQuery)
@@ -403,13 +756,7 @@ func (p *Pipeline) Search(ctx context.Context, query string, model string, statu
 		return nil, 0, fmt.Errorf("failed to marshal query: %w", err)
 	}
 
-	req, err := http.NewRequestWithContext(ctx, "POST", p.opensearchURL+"/"+indexName+"/_search", bytes.NewReader(body))
-	if err != nil {
-		return nil, 0, fmt.Errorf("failed to create request: %w", err)
-	}
-	req.Header.Set("Content-Type", "application/json")
-
-	resp, err := p.httpClient.Do(req)
+	resp, err := p.do(ctx, "POST", "/"+indexName+"/_search", body, "application/json")
 	if err != nil {
 		return nil, 0, fmt.Errorf("failed to search: %w", err)
 	}
@@ -440,12 +787,7 @@ func (p *Pipeline) Search(ctx context.Context, query string, model string, statu
 
 // GetLog retrieves a single log entry by ID
 func (p *Pipeline) GetLog(ctx context.Context, traceID string) (*models.LogEntry, error) {
-	req, err := http.NewRequestWithContext(ctx, "GET", p.opensearchURL+"/"+indexName+"/_doc/"+traceID, nil)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
-	}
-
-	resp, err := p.httpClient.Do(req)
+	resp, err := p.do(ctx, "GET", "/"+indexName+"/_doc/"+traceID, nil, "")
 	if err != nil {
 		return nil, fmt.Errorf("failed to get log: %w", err)
 	}
@@ -489,6 +831,15 @@ func (p *Pipeline) GetStats(ctx context.Context, userID string, startDate, endDa
 			"avg_latency": map[string]interface{}{
 				"avg": map[string]string{"field": "metrics.latency_ms"},
 			},
+			"total_tokens": map[string]interface{}{
+				"sum": map[string]string{"field": "response.usage.total_tokens"},
+			},
+			"prompt_tokens": map[string]interface{}{
+				"sum": map[string]string{"field": "response.usage.prompt_tokens"},
+			},
+			"completion_tokens": map[string]interface{}{
+				"sum": map[string]string{"field": "response.usage.completion_tokens"},
+			},
 			"success_count": map[string]interface{}{
 				"filter": map[string]interface{}{
 					"range": map[string]interface{}{
@@ -505,13 +856,7 @@ func (p *Pipeline) GetStats(ctx context.Context, userID string, startDate, endDa
 		return nil, fmt.Errorf("failed to marshal query: %w", err)
 	}
 
-	req, err := http.NewRequestWithContext(ctx, "POST", p.opensearchURL+"/"+indexName+"/_search", bytes.NewReader(body))
-	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
-	}
-	req.Header.Set("Content-Type", "application/json")
-
-	resp, err := p.httpClient.Do(req)
+	resp, err := p.do(ctx, "POST", "/"+indexName+"/_search", body, "application/json")
 	if err != nil {
 		return nil, fmt.Errorf("failed to search: %w", err)
 	}
@@ -530,6 +875,15 @@ func (p *Pipeline) GetStats(ctx context.Context, userID string, startDate, endDa
 			AvgLatency struct {
 				Value float64 `json:"value"`
 			} `json:"avg_latency"`
+			TotalTokens struct {
+				Value float64 `json:"value"`
+			} `json:"total_tokens"`
+			PromptTokens struct {
+				Value float64 `json:"value"`
+			} `json:"prompt_tokens"`
+			CompletionTokens struct {
+				Value float64 `json:"value"`
+			} `json:"completion_tokens"`
 			SuccessCount struct {
 				DocCount int64 `json:"doc_count"`
 			} `json:"success_count"`
@@ -546,9 +900,411 @@ func (p *Pipeline) GetStats(ctx context.Context, userID string, startDate, endDa
 	}
 
 	return &models.Overview{
-		TotalSpend:    result.Aggregations.TotalCost.Value,
-		TotalRequests: result.Hits.Total.Value,
-		AvgLatency:    result.Aggregations.AvgLatency.Value,
-		SuccessRate:   successRate,
+		TotalSpend:       result.Aggregations.TotalCost.Value,
+		TotalRequests:    result.Hits.Total.Value,
+		AvgLatency:       result.Aggregations.AvgLatency.Value,
+		SuccessRate:      successRate,
+		TotalTokens:      int64(result.Aggregations.TotalTokens.Value),
+		PromptTokens:     int64(result.Aggregations.PromptTokens.Value),
+		CompletionTokens: int64(result.Aggregations.CompletionTokens.Value),
 	}, nil
 }
+
+// maxModelsPerDay bounds the model terms sub-aggregation of GetDailyCostByModel
+const maxModelsPerDay = 50
+
+// GetDailyCostByModel aggregates spend per day with a nested breakdown by model
+func (p *Pipeline) GetDailyCostByModel(ctx context.Context, userID string, startDate, endDate time.Time) (*models.DailyModelStats, error) {
+	query := map[string]interface{}{
+		"query": map[string]interface{}{
+			"bool": map[string]interface{}{
+				"must": []map[string]interface{}{
+					{"term": map[string]string{"user_id": userID}},
+					{"range": map[string]interface{}{
+						"timestamp": map[string]interface{}{
+							"gte": startDate.Format(time.RFC3339),
+							"lte": endDate.Format(time.RFC3339),
+						},
+					}},
+				},
+			},
+		},
+		"aggs": map[string]interface{}{
+			"per_day": map[string]interface{}{
+				"date_histogram": map[string]interface{}{
+					"field":             "timestamp",
+					"calendar_interval": "day",
+					"format":            "yyyy-MM-dd",
+					"min_doc_count":     0,
+					"extended_bounds": map[string]string{
+						"min": startDate.Format("2006-01-02"),
+						"max": endDate.Format("2006-01-02"),
+					},
+				},
+				"aggs": map[string]interface{}{
+					"total_cost": map[string]interface{}{
+						"sum": map[string]string{"field": "metrics.cost_usd"},
+					},
+					"per_model": map[string]interface{}{
+						"terms": map[string]interface{}{
+							"field": "request.model",
+							"size":  maxModelsPerDay,
+						},
+						"aggs": map[string]interface{}{
+							"cost": map[string]interface{}{
+								"sum": map[string]string{"field": "metrics.cost_usd"},
+							},
+						},
+					},
+				},
+			},
+		},
+		"size": 0,
+	}
+
+	body, err := json.Marshal(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal query: %w", err)
+	}
+
+	resp, err := p.do(ctx, "POST", "/"+indexName+"/_search", body, "application/json")
+	if err != nil {
+		return nil, fmt.Errorf("failed to search: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+
+	var result struct {
+		Aggregations struct {
+			PerDay struct {
+				Buckets []struct {
+					Key       string `json:"key_as_string"`
+					TotalCost struct {
+						Value float64 `json:"value"`
+					} `json:"total_cost"`
+					PerModel struct {
+						Buckets []struct {
+							Key  string `json:"key"`
+							Cost struct {
+								Value float64 `json:"value"`
+							} `json:"cost"`
+						} `json:"buckets"`
+					} `json:"per_model"`
+				} `json:"buckets"`
+			} `json:"per_day"`
+		} `json:"aggregations"`
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	stats := &models.DailyModelStats{
+		Models: []string{},
+		Days:   make([]models.DailyModelCost, 0, len(result.Aggregations.PerDay.Buckets)),
+	}
+	modelTotals := map[string]float64{}
+
+	for _, day := range result.Aggregations.PerDay.Buckets {
+		entry := models.DailyModelCost{
+			Date:      day.Key,
+			TotalCost: day.TotalCost.Value,
+			Models:    make(map[string]float64, len(day.PerModel.Buckets)),
+		}
+		for _, model := range day.PerModel.Buckets {
+			entry.Models[model.Key] = model.Cost.Value
+			if _, seen := modelTotals[model.Key]; !seen {
+				stats.Models = append(stats.Models, model.Key)
+			}
+			modelTotals[model.Key] += model.Cost.Value
+		}
+		stats.Days = append(stats.Days, entry)
+	}
+
+	sort.SliceStable(stats.Models, func(i, j int) bool {
+		return modelTotals[stats.Models[i]] > modelTotals[stats.Models[j]]
+	})
+
+	return stats, nil
+}
+
+// GetProviderTraffic counts requests and upstream errors (5xx responses) per
+// provider across all users since the given time
+func (p *Pipeline) GetProviderTraffic(ctx context.Context, since time.Time) (map[string]models.ProviderStatus, error) {
+	query := map[string]interface{}{
+		"query": map[string]interface{}{
+			"range": map[string]interface{}{
+				"timestamp": map[string]string{"gte": since.Format(time.RFC3339)},
+			},
+		},
+		"aggs": map[string]interface{}{
+			"per_provider": map[string]interface{}{
+				"terms": map[string]interface{}{"field": "request.provider"},
+				"aggs": map[string]interface{}{
+					"errors": map[string]interface{}{
+						"filter": map[string]interface{}{
+							"range": map[string]interface{}{
+								"response.status_code": map[string]int{"gte": 500},
+							},
+						},
+					},
+				},
+			},
+		},
+		"size": 0,
+	}
+
+	body, err := json.Marshal(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal query: %w", err)
+	}
+
+	resp, err := p.do(ctx, "POST", "/"+indexName+"/_search", body, "application/json")
+	if err != nil {
+		return nil, fmt.Errorf("failed to search: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+
+	var result struct {
+		Aggregations struct {
+			PerProvider struct {
+				Buckets []struct {
+					Key      string `json:"key"`
+					DocCount int    `json:"doc_count"`
+					Errors   struct {
+						DocCount int `json:"doc_count"`
+					} `json:"errors"`
+				} `json:"buckets"`
+			} `json:"per_provider"`
+		} `json:"aggregations"`
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	traffic := make(map[string]models.ProviderStatus, len(result.Aggregations.PerProvider.Buckets))
+	for _, bucket := range result.Aggregations.PerProvider.Buckets {
+		traffic[bucket.Key] = models.ProviderStatus{
+			Provider: models.ProviderType(bucket.Key),
+			Requests: bucket.DocCount,
+			Errors:   bucket.Errors.DocCount,
+		}
+	}
+
+	return traffic, nil
+}
+
+// GetKeyActivity buckets a key's requests, errors and cost per hour over a time range
+func (p *Pipeline) GetKeyActivity(ctx context.Context, userID, keyID string, startDate, endDate time.Time) ([]models.ActivityBucket, error) {
+	query := map[string]interface{}{
+		"query": map[string]interface{}{
+			"bool": map[string]interface{}{
+				"must": []map[string]interface{}{
+					{"term": map[string]string{"user_id": userID}},
+					{"term": map[string]string{"virtual_key_id": keyID}},
+					{"range": map[string]interface{}{
+						"timestamp": map[string]interface{}{
+							"gte": startDate.Format(time.RFC3339),
+							"lte": endDate.Format(time.RFC3339),
+						},
+					}},
+				},
+			},
+		},
+		"aggs": map[string]interface{}{
+			"per_hour": map[string]interface{}{
+				"date_histogram": map[string]interface{}{
+					"field":          "timestamp",
+					"fixed_interval": "1h",
+					"min_doc_count":  0,
+					"extended_bounds": map[string]int64{
+						"min": startDate.UnixMilli(),
+						"max": endDate.UnixMilli(),
+					},
+				},
+				"aggs": map[string]interface{}{
+					"errors": map[string]interface{}{
+						"filter": map[string]interface{}{
+							"range": map[string]interface{}{
+								"response.status_code": map[string]int{"gte": 400},
+							},
+						},
+					},
+					"cost": map[string]interface{}{
+						"sum": map[string]string{"field": "metrics.cost_usd"},
+					},
+				},
+			},
+		},
+		"size": 0,
+	}
+
+	body, err := json.Marshal(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal query: %w", err)
+	}
+
+	resp, err := p.do(ctx, "POST", "/"+indexName+"/_search", body, "application/json")
+	if err != nil {
+		return nil, fmt.Errorf("failed to search: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+
+	var result struct {
+		Aggregations struct {
+			PerHour struct {
+				Buckets []struct {
+					Key      int64 `json:"key"` // Epoch milliseconds
+					DocCount int   `json:"doc_count"`
+					Errors   struct {
+						DocCount int `json:"doc_count"`
+					} `json:"errors"`
+					Cost struct {
+						Value float64 `json:"value"`
+					} `json:"cost"`
+				} `json:"buckets"`
+			} `json:"per_hour"`
+		} `json:"aggregations"`
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	buckets := make([]models.ActivityBucket, 0, len(result.Aggregations.PerHour.Buckets))
+	for _, b := range result.Aggregations.PerHour.Buckets {
+		buckets = append(buckets, models.ActivityBucket{
+			Timestamp: time.UnixMilli(b.Key).UTC(),
+			Requests:  b.DocCount,
+			Errors:    b.Errors.DocCount,
+			Cost:      b.Cost.Value,
+		})
+	}
+
+	return buckets, nil
+}
+
+// keyTotalsPageSize is the number of keys fetched per page by GetKeyTotals
+const keyTotalsPageSize = 500
+
+// sampledSumScript sums a field while scaling sampled entries back up by their
+// sample rate, so totals reflect every request rather than only the logged ones
+const sampledSumScript = `double rate = doc['metrics.sample_rate'].size() == 0 ? 0 : doc['metrics.sample_rate'].value;
+return doc[params.field].size() == 0 ? 0 : doc[params.field].value * (rate > 0 ? 1.0 / rate : 1.0);`
+
+// GetKeyTotals sums tokens and cost per key over a time range, paging through
+// every key with traffic. Sampled entries are extrapolated by their sample rate.
+func (p *Pipeline) GetKeyTotals(ctx context.Context, startDate, endDate time.Time) ([]models.DailyStat, error) {
+	var totals []models.DailyStat
+	var after map[string]interface{}
+
+	sampledSum := func(field string) map[string]interface{} {
+		return map[string]interface{}{
+			"sum": map[string]interface{}{
+				"script": map[string]interface{}{
+					"lang":   "painless",
+					"source": sampledSumScript,
+					"params": map[string]string{"field": field},
+				},
+			},
+		}
+	}
+
+	for {
+		composite := map[string]interface{}{
+			"size": keyTotalsPageSize,
+			"sources": []map[string]interface{}{
+				{"key_id": map[string]interface{}{"terms": map[string]string{"field": "virtual_key_id"}}},
+			},
+		}
+		if after != nil {
+			composite["after"] = after
+		}
+
+		query := map[string]interface{}{
+			"query": map[string]interface{}{
+				"range": map[string]interface{}{
+					"timestamp": map[string]interface{}{
+						"gte": startDate.Format(time.RFC3339),
+						"lt":  endDate.Format(time.RFC3339),
+					},
+				},
+			},
+			"aggs": map[string]interface{}{
+				"per_key": map[string]interface{}{
+					"composite": composite,
+					"aggs": map[string]interface{}{
+						"tokens": sampledSum("response.usage.total_tokens"),
+						"cost":   sampledSum("metrics.cost_usd"),
+					},
+				},
+			},
+			"size": 0,
+		}
+
+		body, err := json.Marshal(query)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal query: %w", err)
+		}
+
+		resp, err := p.do(ctx, "POST", "/"+indexName+"/_search", body, "application/json")
+		if err != nil {
+			return nil, fmt.Errorf("failed to search: %w", err)
+		}
+
+		var result struct {
+			Aggregations struct {
+				PerKey struct {
+					AfterKey map[string]interface{} `json:"after_key"`
+					Buckets  []struct {
+						Key struct {
+							KeyID string `json:"key_id"`
+						} `json:"key"`
+						Tokens struct {
+							Value float64 `json:"value"`
+						} `json:"tokens"`
+						Cost struct {
+							Value float64 `json:"value"`
+						} `json:"cost"`
+					} `json:"buckets"`
+				} `json:"per_key"`
+			} `json:"aggregations"`
+		}
+
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+		}
+		err = json.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode response: %w", err)
+		}
+
+		for _, b := range result.Aggregations.PerKey.Buckets {
+			totals = append(totals, models.DailyStat{
+				KeyID:       b.Key.KeyID,
+				Date:        startDate,
+				TotalTokens: int(math.Round(b.Tokens.Value)),
+				TotalCost:   b.Cost.Value,
+			})
+		}
+
+		if len(result.Aggregations.PerKey.Buckets) < keyTotalsPageSize || result.Aggregations.PerKey.AfterKey == nil {
+			return totals, nil
+		}
+		after = result.Aggregations.PerKey.AfterKey
+	}
+}
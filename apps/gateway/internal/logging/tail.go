@@ -0,0 +1,103 @@
+package logging
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/lumina/gateway/internal/metrics"
+	"github.com/lumina/gateway/internal/models"
+)
+
+// ErrTooManyTails is returned by Tail when the subscriber limit is reached
+var ErrTooManyTails = errors.New("too many live log subscribers")
+
+var tailDropped = metrics.NewCounter("lumina_log_tail_dropped_total",
+	"Log entries not delivered to a live tail subscriber because its buffer was full")
+
+// TailSubscription receives the entries logged for one user as they pass through
+// the pipeline, in the same form they are indexed in
+type TailSubscription struct {
+	userID  string
+	entries chan map[string]interface{}
+}
+
+// Entries is closed when the subscription ends, e.g. on shutdown
+func (s *TailSubscription) Entries() <-chan map[string]interface{} {
+	return s.entries
+}
+
+// tailHub fans out logged entries to live subscribers. Slow subscribers miss entries
+// rather than hold up the pipeline.
+type tailHub struct {
+	mu     sync.Mutex
+	subs   map[*TailSubscription]struct{}
+	max    int
+	buffer int
+	closed bool
+}
+
+func newTailHub(max, buffer int) *tailHub {
+	return &tailHub{subs: make(map[*TailSubscription]struct{}), max: max, buffer: buffer}
+}
+
+// Tail subscribes to the entries logged for a user from now on. Call EndTail when done.
+func (p *Pipeline) Tail(userID string) (*TailSubscription, error) {
+	h := p.tails
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.closed || len(h.subs) >= h.max {
+		return nil, ErrTooManyTails
+	}
+	sub := &TailSubscription{userID: userID, entries: make(chan map[string]interface{}, h.buffer)}
+	h.subs[sub] = struct{}{}
+	return sub, nil
+}
+
+// EndTail ends a subscription started with Tail
+func (p *Pipeline) EndTail(sub *TailSubscription) {
+	h := p.tails
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if _, ok := h.subs[sub]; ok {
+		delete(h.subs, sub)
+		close(sub.entries)
+	}
+}
+
+// EndTails ends every subscription and refuses new ones, so live tails don't hold
+// up a graceful shutdown
+func (p *Pipeline) EndTails() {
+	h := p.tails
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.closed = true
+	for sub := range h.subs {
+		delete(h.subs, sub)
+		close(sub.entries)
+	}
+}
+
+// publishTail delivers an entry to its user's subscribers, if any
+func (p *Pipeline) publishTail(entry *models.LogEntry) {
+	h := p.tails
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var doc map[string]interface{}
+	for sub := range h.subs {
+		if sub.userID != entry.UserID {
+			continue
+		}
+		if doc == nil {
+			doc = p.toIndexableDoc(entry)
+		}
+		select {
+		case sub.entries <- doc:
+		default:
+			tailDropped.Inc()
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// metric is anything that can render itself in the Prometheus text format
+type metric interface {
+	name() string
+	write(w io.Writer)
+}
+
+var (
+	registryMu sync.Mutex
+	registry   = map[string]metric{}
+)
+
+func register(m metric) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if _, exists := registry[m.name()]; exists {
+		panic("metrics: duplicate metric " + m.name())
+	}
+	registry[m.name()] = m
+}
+
+// Counter is a monotonically increasing value
+type Counter struct {
+	metricName string
+	help       string
+	bits       atomic.Uint64 // float64 bits
+}
+
+// NewCounter creates and registers a counter
+func NewCounter(name, help string) *Counter {
+	c := &Counter{metricName: name, help: help}
+	register(c)
+	return c
+}
+
+// Inc increments the counter by one
+func (c *Counter) Inc() {
+	c.Add(1)
+}
+
+// Add increases the counter by delta, which must not be negative
+func (c *Counter) Add(delta float64) {
+	for {
+		old := c.bits.Load()
+		updated := math.Float64bits(math.Float64frombits(old) + delta)
+		if c.bits.CompareAndSwap(old, updated) {
+			return
+		}
+	}
+}
+
+// Value returns the current count
+func (c *Counter) Value() float64 {
+	return math.Float64frombits(c.bits.Load())
+}
+
+func (c *Counter) name() string { return c.metricName }
+
+func (c *Counter) write(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n%s %g\n", c.metricName, c.help, c.metricName, c.metricName, c.Value())
+}
+
+// HistogramVec is a histogram partitioned by label values. Callers must keep the
+// number of distinct label values small.
+type HistogramVec struct {
+	metricName string
+	help       string
+	labels     []string
+	buckets    []float64 // Ascending upper bounds, excluding +Inf
+
+	mu     sync.Mutex
+	series map[string]*histogram // Keyed by the joined label values
+}
+
+type histogram struct {
+	labelValues []string
+	counts      []uint64 // Observations per bucket, the last one for +Inf
+	sum         float64
+}
+
+// NewHistogramVec creates and registers a histogram with the given bucket upper bounds
+// and label names
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	h := &HistogramVec{
+		metricName: name,
+		help:       help,
+		labels:     labels,
+		buckets:    buckets,
+		series:     map[string]*histogram{},
+	}
+	register(h)
+	return h
+}
+
+// Observe records a value for the given label values, one per label name
+func (h *HistogramVec) Observe(value float64, labelValues ...string) {
+	key := strings.Join(labelValues, "\xff")
+	bucket := sort.SearchFloat64s(h.buckets, value)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogram{labelValues: labelValues, counts: make([]uint64, len(h.buckets)+1)}
+		h.series[key] = s
+	}
+	s.counts[bucket]++
+	s.sum += value
+}
+
+func (h *HistogramVec) name() string { return h.metricName }
+
+func (h *HistogramVec) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	keys := make([]string, 0, len(h.series))
+	for key := range h.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.metricName, h.help, h.metricName)
+	for _, key := range keys {
+		s := h.series[key]
+		labels := make([]string, len(h.labels))
+		for i, label := range h.labels {
+			labels[i] = fmt.Sprintf("%s=\"%s\"", label, labelEscaper.Replace(s.labelValues[i]))
+		}
+		prefix := strings.Join(labels, ",")
+
+		var cumulative uint64
+		for i, count := range s.counts {
+			cumulative += count
+			le := "+Inf"
+			if i < len(h.buckets) {
+				le = fmt.Sprintf("%g", h.buckets[i])
+			}
+			fmt.Fprintf(w, "%s_bucket{%s,le=\"%s\"} %d\n", h.metricName, prefix, le, cumulative)
+		}
+		fmt.Fprintf(w, "%s_sum{%s} %g\n%s_count{%s} %d\n", h.metricName, prefix, s.sum, h.metricName, prefix, cumulative)
+	}
+}
+
+// labelEscaper escapes label values for the text exposition format
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// Handler serves every registered metric in the Prometheus text exposition format
+func Handler(w http.ResponseWriter, r *http.Request) {
+	registryMu.Lock()
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	metrics := make([]metric, len(names))
+	for i, name := range names {
+		metrics[i] = registry[name]
+	}
+	registryMu.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	for _, m := range metrics {
+		m.write(w)
+	}
+}
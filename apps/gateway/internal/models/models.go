@@ -12,25 +12,129 @@ const (
 	ProviderAnthropic ProviderType = "anthropic"
 )
 
+// Valid reports whether the provider is supported by the gateway
+func (p ProviderType) Valid() bool {
+	switch p {
+	case ProviderOpenAI, ProviderAnthropic:
+		return true
+	}
+	return false
+}
+
+// LogBodyMode controls how much of the request and response bodies gets indexed
+type LogBodyMode string
+
+const (
+	LogBodyFull      LogBodyMode = "full"
+	LogBodyTruncated LogBodyMode = "truncated"
+	LogBodyMetadata  LogBodyMode = "metadata"
+)
+
+// Valid reports whether the mode is one of the known body logging modes
+func (m LogBodyMode) Valid() bool {
+	switch m {
+	case LogBodyFull, LogBodyTruncated, LogBodyMetadata:
+		return true
+	}
+	return false
+}
+
+// BudgetMode controls whether a key's budget limit blocks requests
+type BudgetMode string
+
+const (
+	BudgetHard BudgetMode = "hard" // Reject requests with 402 once the budget is exceeded
+	BudgetSoft BudgetMode = "soft" // Let requests through, flag them and send a webhook
+)
+
+// Valid reports whether the mode is one of the known budget modes
+func (m BudgetMode) Valid() bool {
+	return m == BudgetHard || m == BudgetSoft
+}
+
+// StreamBudgetMode controls what happens when a streamed response's running
+// cost crosses the key's remaining budget
+type StreamBudgetMode string
+
+const (
+	StreamBudgetFlag  StreamBudgetMode = "flag"  // Let the stream finish and flag the log entry
+	StreamBudgetAbort StreamBudgetMode = "abort" // Stop the stream as soon as the budget is crossed
+)
+
+// Valid reports whether the mode is one of the known stream budget modes
+func (m StreamBudgetMode) Valid() bool {
+	return m == StreamBudgetFlag || m == StreamBudgetAbort
+}
+
+// ParamLimit bounds a numeric request parameter; values outside the range are clamped
+type ParamLimit struct {
+	Min     *float64 `json:"min,omitempty"`
+	Max     *float64 `json:"max,omitempty"`
+	Default *float64 `json:"default,omitempty"` // Set when the request omits the parameter
+}
+
+// LimitedParams lists the request parameters keys can limit, and whether each takes
+// whole numbers only. Limits apply under each provider's name for the parameter.
+var LimitedParams = map[string]bool{
+	"temperature":       false,
+	"top_p":             false,
+	"top_k":             true,
+	"max_tokens":        true,
+	"frequency_penalty": false,
+	"presence_penalty":  false,
+}
+
+// ModelRoute resolves a logical model name to a model picked by the estimated size
+// of the request's prompt: the first tier whose limit the prompt fits in, or Default
+type ModelRoute struct {
+	Tiers   []RouteTier `json:"tiers"`   // In ascending order of MaxPromptTokens
+	Default string      `json:"default"` // Model for prompts larger than every tier, e.g. "openai/gpt-4o"
+}
+
+// RouteTier is a model serving prompts of up to MaxPromptTokens estimated tokens
+type RouteTier struct {
+	MaxPromptTokens int    `json:"max_prompt_tokens"`
+	Model           string `json:"model"` // e.g. "openai/gpt-4o-mini"
+}
+
 // User represents a dashboard user
 type User struct {
 	ID           string    `json:"id" db:"id"`
 	Email        string    `json:"email" db:"email"`
 	PasswordHash string    `json:"-" db:"password_hash"`
+	IsAdmin      bool      `json:"is_admin" db:"is_admin"`
+	MaxKeys      *int      `json:"max_keys,omitempty" db:"max_keys"` // Overrides the deployment key limit when set
 	CreatedAt    time.Time `json:"created_at" db:"created_at"`
 }
 
 // VirtualKey represents a virtual API key (access control only, no provider keys)
 type VirtualKey struct {
-	ID            string     `json:"id" db:"id"`
-	UserID        string     `json:"user_id" db:"user_id"`
-	Name          string     `json:"name" db:"name"`
-	KeyHash       string     `json:"-" db:"key_hash"`
-	AllowedModels []string   `json:"allowed_models" db:"allowed_models"`
-	BudgetLimit   *float64   `json:"budget_limit" db:"budget_limit"`
-	CurrentSpend  float64    `json:"current_spend" db:"current_spend"`
-	CreatedAt     time.Time  `json:"created_at" db:"created_at"`
-	RevokedAt     *time.Time `json:"revoked_at,omitempty" db:"revoked_at"`
+	ID                 string                `json:"id" db:"id"`
+	UserID             string                `json:"user_id" db:"user_id"`
+	Name               string                `json:"name" db:"name"`
+	KeyHash            string                `json:"-" db:"key_hash"`
+	KeyPreview         string                `json:"key_preview" db:"key_preview"` // e.g. "lum_...3f9a", empty for keys created before previews
+	AllowedModels      []string              `json:"allowed_models" db:"allowed_models"`
+	BudgetLimit        *float64              `json:"budget_limit" db:"budget_limit"`
+	CurrentSpend       float64               `json:"current_spend" db:"current_spend"`
+	LogBodyMode        LogBodyMode           `json:"log_body_mode,omitempty" db:"log_body_mode"`
+	LogSampleRate      *float64              `json:"log_sample_rate,omitempty" db:"log_sample_rate"`
+	StreamBudget       StreamBudgetMode      `json:"stream_budget_mode,omitempty" db:"stream_budget_mode"`
+	Debug              bool                  `json:"debug" db:"debug"` // Allows overriding the model with the X-Lumina-Model header
+	BudgetMode         BudgetMode            `json:"budget_mode,omitempty" db:"budget_mode"`
+	DebugCapture       bool                  `json:"debug_capture" db:"debug_capture"`                       // Store raw request/response bodies in the debug index
+	AllowedOrigins     []string              `json:"allowed_origins" db:"allowed_origins"`                   // Web origins the key may be used from, empty means any
+	AutoRevokeExempt   bool                  `json:"auto_revoke_exempt" db:"auto_revoke_exempt"`             // Never revoked for being unused
+	ParamLimits        map[string]ParamLimit `json:"param_limits,omitempty" db:"param_limits"`               // Parameter name -> limits applied to requests
+	ModelRoutes        map[string]ModelRoute `json:"model_routes,omitempty" db:"model_routes"`               // Logical model name -> route picking the model by prompt size
+	MaxMessages        int                   `json:"max_messages,omitempty" db:"max_messages"`               // Most messages per request, zero for no limit
+	MaxPromptTokens    int                   `json:"max_prompt_tokens,omitempty" db:"max_prompt_tokens"`     // Most estimated prompt tokens per request, zero for no limit
+	OpenAIOrganization string                `json:"openai_organization,omitempty" db:"openai_organization"` // Sent as OpenAI-Organization on OpenAI requests
+	OpenAIProject      string                `json:"openai_project,omitempty" db:"openai_project"`           // Sent as OpenAI-Project on OpenAI requests
+	RequestQuota       *int64                `json:"request_quota,omitempty" db:"request_quota"`             // Most requests per calendar month (UTC), nil for no quota
+	CreatedAt          time.Time             `json:"created_at" db:"created_at"`
+	RevokedAt          *time.Time            `json:"revoked_at,omitempty" db:"revoked_at"`
+	LastUsedAt         *time.Time            `json:"last_used_at" db:"last_used_at"`
 }
 
 // UserProvider represents an account-level provider API key
@@ -39,8 +143,34 @@ type UserProvider struct {
 	UserID          string       `json:"user_id" db:"user_id"`
 	Provider        ProviderType `json:"provider" db:"provider"`
 	APIKeyEncrypted []byte       `json:"-" db:"api_key_encrypted"`
+	Label           string       `json:"label" db:"label"`
+	Models          []string     `json:"models" db:"models"`           // Model patterns the credential has access to, empty means any
+	KeyVersion      int          `json:"-" db:"key_version"`           // Version of the encryption key APIKeyEncrypted uses
+	Fingerprint     string       `json:"fingerprint" db:"fingerprint"` // e.g. "sha256:1a2b3c4d5e6f7a8b", empty for keys stored before fingerprints
 	CreatedAt       time.Time    `json:"created_at" db:"created_at"`
 	UpdatedAt       time.Time    `json:"updated_at" db:"updated_at"`
+	LastUsedAt      *time.Time   `json:"last_used_at,omitempty" db:"last_used_at"`
+}
+
+// Session is a dashboard login, identified by the ID in its token
+type Session struct {
+	ID         string     `json:"id" db:"id"`
+	UserID     string     `json:"-" db:"user_id"`
+	IP         string     `json:"ip" db:"ip"`
+	UserAgent  string     `json:"user_agent" db:"user_agent"`
+	CreatedAt  time.Time  `json:"created_at" db:"created_at"`
+	LastSeenAt time.Time  `json:"last_seen_at" db:"last_seen_at"` // Updated at most once a minute
+	ExpiresAt  time.Time  `json:"expires_at" db:"expires_at"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty" db:"revoked_at"`
+	Current    bool       `json:"current"` // The session making the request
+}
+
+// ProviderFilter narrows down the providers returned for a user
+type ProviderFilter struct {
+	Provider ProviderType // Empty matches any provider
+	Label    string       // Empty matches any label
+	Limit    int          // Zero means no limit
+	Offset   int
 }
 
 // DailyStat represents daily usage statistics
@@ -52,45 +182,253 @@ type DailyStat struct {
 	TotalCost   float64   `json:"total_cost" db:"total_cost"`
 }
 
+// DailyModelCost is one day of spend split by model, shaped for stacked charts
+type DailyModelCost struct {
+	Date      string             `json:"date"` // YYYY-MM-DD
+	TotalCost float64            `json:"total_cost"`
+	Models    map[string]float64 `json:"models"` // Cost per model for the day
+}
+
+// DailyModelStats is daily spend broken down by model over a date range
+type DailyModelStats struct {
+	Models []string         `json:"models"` // Every model appearing in the range, highest spend first
+	Days   []DailyModelCost `json:"days"`   // One entry per day, including days without traffic
+}
+
+// ActivityBucket is one interval of a key's request activity
+type ActivityBucket struct {
+	Timestamp time.Time `json:"timestamp"` // Start of the interval
+	Requests  int       `json:"requests"`
+	Errors    int       `json:"errors"` // Responses with status 400 or above
+	Cost      float64   `json:"cost"`
+}
+
+// KeyActivity is a key's hourly request activity, e.g. for a sparkline
+type KeyActivity struct {
+	KeyID   string           `json:"key_id"`
+	Buckets []ActivityBucket `json:"buckets"`
+}
+
 // KeyConfig is cached in Redis for fast lookups
 type KeyConfig struct {
-	KeyID         string            `json:"key_id"`
-	UserID        string            `json:"user_id"`
-	Name          string            `json:"name"`
-	AllowedModels []string          `json:"allowed_models"`
-	Providers     map[string]string `json:"providers"` // provider -> real_api_key (from user account)
-	BudgetLimit   *float64          `json:"budget_limit"`
-	CurrentSpend  float64           `json:"current_spend"`
+	KeyID              string                `json:"key_id"`
+	UserID             string                `json:"user_id"`
+	Name               string                `json:"name"`
+	AllowedModels      []string              `json:"allowed_models"`
+	Providers          map[string]string     `json:"providers"`                 // provider -> real_api_key (from user account)
+	ProviderModels     map[string][]string   `json:"provider_models,omitempty"` // provider -> model patterns its key has access to
+	BudgetLimit        *float64              `json:"budget_limit"`
+	CurrentSpend       float64               `json:"current_spend"`
+	LogBodyMode        LogBodyMode           `json:"log_body_mode,omitempty"`
+	LogSampleRate      *float64              `json:"log_sample_rate,omitempty"`
+	StreamBudget       StreamBudgetMode      `json:"stream_budget_mode,omitempty"`
+	Debug              bool                  `json:"debug,omitempty"`
+	BudgetMode         BudgetMode            `json:"budget_mode,omitempty"`
+	DebugCapture       bool                  `json:"debug_capture,omitempty"`
+	AllowedOrigins     []string              `json:"allowed_origins,omitempty"`
+	ParamLimits        map[string]ParamLimit `json:"param_limits,omitempty"`
+	ModelRoutes        map[string]ModelRoute `json:"model_routes,omitempty"`
+	MaxMessages        int                   `json:"max_messages,omitempty"`
+	MaxPromptTokens    int                   `json:"max_prompt_tokens,omitempty"`
+	OpenAIOrganization string                `json:"openai_organization,omitempty"`
+	OpenAIProject      string                `json:"openai_project,omitempty"`
+	RequestQuota       *int64                `json:"request_quota,omitempty"`
+	ShareTokenID       string                `json:"-"` // Set when the request authenticated with a share token of this key
+}
+
+// EffectiveKeyConfig is the resolved configuration the proxy applies to a key, without secrets
+type EffectiveKeyConfig struct {
+	KeyID              string                `json:"key_id"`
+	Name               string                `json:"name"`
+	Active             bool                  `json:"active"`         // False once the key is revoked
+	AllowedModels      []string              `json:"allowed_models"` // Empty allows every model
+	BudgetLimit        *float64              `json:"budget_limit"`
+	CurrentSpend       float64               `json:"current_spend"`
+	BudgetRemaining    *float64              `json:"budget_remaining"` // Null when the key has no budget
+	Providers          []ProviderType        `json:"providers"`        // Providers with an API key on the account
+	LogBodyMode        LogBodyMode           `json:"log_body_mode,omitempty"`
+	LogSampleRate      *float64              `json:"log_sample_rate,omitempty"`
+	StreamBudget       StreamBudgetMode      `json:"stream_budget_mode,omitempty"`
+	Debug              bool                  `json:"debug"`
+	BudgetMode         BudgetMode            `json:"budget_mode,omitempty"`
+	DebugCapture       bool                  `json:"debug_capture"`
+	AllowedOrigins     []string              `json:"allowed_origins"`
+	ParamLimits        map[string]ParamLimit `json:"param_limits,omitempty"`
+	ModelRoutes        map[string]ModelRoute `json:"model_routes,omitempty"`
+	MaxMessages        int                   `json:"max_messages"`      // Zero for no limit
+	MaxPromptTokens    int                   `json:"max_prompt_tokens"` // Zero for no limit
+	OpenAIOrganization string                `json:"openai_organization"`
+	OpenAIProject      string                `json:"openai_project"`
+	RequestQuota       *int64                `json:"request_quota"` // Null for no quota
+}
+
+// KeyError is a failed request made with a key
+type KeyError struct {
+	TraceID    string    `json:"trace_id"`
+	Timestamp  time.Time `json:"timestamp"`
+	Model      string    `json:"model"`
+	StatusCode int       `json:"status_code"`
+	Error      string    `json:"error"` // Logged error message, empty if none was recorded
+}
+
+// KeyErrors lists a key's most recent failed requests, newest first
+type KeyErrors struct {
+	KeyID  string     `json:"key_id"`
+	Errors []KeyError `json:"errors"`
+}
+
+// ModelPricing is a model's price in USD per million tokens, except AudioPerMinute
+type ModelPricing struct {
+	InputPerMillion       float64 `json:"input_per_million"`
+	OutputPerMillion      float64 `json:"output_per_million"`
+	CachedInputPerMillion float64 `json:"cached_input_per_million"`
+	AudioPerMinute        float64 `json:"audio_per_minute,omitempty"`
+}
+
+// CatalogModel is a model the gateway knows how to price
+type CatalogModel struct {
+	ID            string       `json:"id"` // "provider/model", as used in requests and allowed_models
+	Name          string       `json:"name"`
+	ContextWindow int          `json:"context_window,omitempty"` // In tokens, zero when not applicable
+	Pricing       ModelPricing `json:"pricing"`
 }
 
+// ProviderCatalog lists a supported provider and its curated models
+type ProviderCatalog struct {
+	Provider ProviderType   `json:"provider"`
+	Models   []CatalogModel `json:"models"`
+}
+
+// ProviderStatus summarizes a provider's recent health as seen through the gateway
+type ProviderStatus struct {
+	Provider  ProviderType `json:"provider"`
+	Status    string       `json:"status"` // up, degraded, down, or unknown without enough traffic
+	Requests  int          `json:"requests"`
+	Errors    int          `json:"errors"` // Upstream 5xx responses
+	ErrorRate float64      `json:"error_rate"`
+}
+
+// ProviderStatusResponse reports provider health over a recent window
+type ProviderStatusResponse struct {
+	WindowMinutes int              `json:"window_minutes"`
+	Providers     []ProviderStatus `json:"providers"`
+}
+
+// DeniedModel is a model pattern the operator disabled for every key
+type DeniedModel struct {
+	Pattern   string    `json:"pattern" db:"pattern"` // e.g. "openai/gpt-4-32k" or "anthropic/claude-2*"
+	Reason    string    `json:"reason" db:"reason"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
+
+// AuditEntry records a sensitive action taken by an admin
+type AuditEntry struct {
+	AdminID   string    `json:"admin_id" db:"admin_id"` // Empty for actions taken by the gateway itself
+	Action    string    `json:"action" db:"action"`     // e.g. "provider_key.reveal"
+	Target    string    `json:"target" db:"target"`     // What the action applied to, e.g. a trace ID
+	Reason    string    `json:"reason" db:"reason"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
+
+// BreakGlassRequest asks which provider key served a request
+type BreakGlassRequest struct {
+	TraceID string `json:"trace_id"`
+	Reason  string `json:"reason"` // Recorded in the audit log, required
+}
+
+// ProviderKeyReveal identifies the provider key that served a request without exposing it
+type ProviderKeyReveal struct {
+	TraceID     string `json:"trace_id"`
+	UserID      string `json:"user_id"`
+	Provider    string `json:"provider"`
+	Fingerprint string `json:"fingerprint"`
+	ProviderID  string `json:"provider_id,omitempty"` // Empty when the key has since been rotated or removed
+	Label       string `json:"label,omitempty"`
+	Current     bool   `json:"current"` // Whether the account still uses this key
+}
+
+// RotationStatus is the state of an encryption key rotation
+type RotationStatus string
+
+const (
+	RotationRunning   RotationStatus = "running"
+	RotationCompleted RotationStatus = "completed"
+)
+
+// EncryptionRotation tracks the background re-encryption of provider keys under a new encryption key version
+type EncryptionRotation struct {
+	ID            string         `json:"id" db:"id"`
+	TargetVersion int            `json:"target_version" db:"target_version"`
+	Status        RotationStatus `json:"status" db:"status"`
+	Total         int            `json:"total" db:"total"`     // Provider keys under other versions when the rotation started
+	Rotated       int            `json:"rotated" db:"rotated"` // Provider keys re-encrypted so far
+	Failed        int            `json:"failed" db:"failed"`   // Provider keys that couldn't be decrypted with any configured key
+	Cursor        string         `json:"-" db:"cursor_id"`
+	StartedBy     string         `json:"started_by,omitempty" db:"started_by"`
+	StartedAt     time.Time      `json:"started_at" db:"started_at"`
+	UpdatedAt     time.Time      `json:"updated_at" db:"updated_at"`
+	CompletedAt   *time.Time     `json:"completed_at,omitempty" db:"completed_at"`
+}
+
+// EncryptionStatus reports the encryption key in use and the progress of rotating to it
+type EncryptionStatus struct {
+	PrimaryVersion int                 `json:"primary_version"`
+	Remaining      int                 `json:"remaining"` // Provider keys still encrypted under other versions
+	Rotation       *EncryptionRotation `json:"rotation"`  // Most recent rotation, nil if none was started
+}
+
+// DebugCapture records why a request's raw bodies were captured for the debug index
+type DebugCapture string
+
+const (
+	DebugCaptureKey     DebugCapture = "key"     // The key has debug_capture enabled
+	DebugCaptureSampled DebugCapture = "sampled" // Picked by the deployment-wide capture sample rate
+)
+
 // LogEntry represents a logged request/response
 type LogEntry struct {
-	TraceID        string      `json:"trace_id"`
-	Timestamp      time.Time   `json:"timestamp"`
-	VirtualKeyName string      `json:"virtual_key_name"`
-	VirtualKeyID   string      `json:"virtual_key_id"`
-	UserID         string      `json:"user_id"`
-	Request        RequestLog  `json:"request"`
-	Response       ResponseLog `json:"response"`
-	Metrics        MetricsLog  `json:"metrics"`
+	TraceID        string            `json:"trace_id"`
+	RequestID      string            `json:"request_id,omitempty"` // Client-supplied X-Request-Id, for correlating with the client's own tracing
+	Timestamp      time.Time         `json:"timestamp"`
+	VirtualKeyName string            `json:"virtual_key_name"`
+	VirtualKeyID   string            `json:"virtual_key_id"`
+	UserID         string            `json:"user_id"`
+	ProviderKey    string            `json:"provider_key_fingerprint,omitempty"` // Fingerprint of the provider API key that served the request
+	ClientIdentity string            `json:"client_identity,omitempty"`          // Subject of the verified TLS client certificate, with mutual TLS
+	ShareTokenID   string            `json:"share_token_id,omitempty"`           // Share token the request was made with, logged under its originating key
+	Metadata       map[string]string `json:"metadata,omitempty"`                 // Client-supplied tags
+	Request        RequestLog        `json:"request"`
+	Response       ResponseLog       `json:"response"`
+	Metrics        MetricsLog        `json:"metrics"`
+	BodyMode       LogBodyMode       `json:"-"` // Per-key override of the pipeline's body logging mode
+	DebugCapture   DebugCapture      `json:"-"` // Set when the raw bodies below go to the debug index
+	RawRequest     string            `json:"-"`
+	RawResponse    string            `json:"-"`
 }
 
 // RequestLog contains the request details
 type RequestLog struct {
-	Model       string      `json:"model"`
-	Provider    string      `json:"provider"`
-	Messages    interface{} `json:"messages,omitempty"`
-	Prompt      string      `json:"prompt,omitempty"`
-	Temperature *float64    `json:"temperature,omitempty"`
-	MaxTokens   *int        `json:"max_tokens,omitempty"`
+	Model          string      `json:"model"`
+	RequestedModel string      `json:"requested_model,omitempty"` // Body's model when overridden with X-Lumina-Model or resolved by a model route
+	Provider       string      `json:"provider"`
+	Messages       interface{} `json:"messages,omitempty"`
+	MessagesLen    int         `json:"messages_length,omitempty"` // Original length when messages were truncated or omitted
+	System         string      `json:"system,omitempty"`          // Top-level system prompt (Anthropic)
+	Prompt         string      `json:"prompt,omitempty"`
+	Temperature    *float64    `json:"temperature,omitempty"`
+	MaxTokens      *int        `json:"max_tokens,omitempty"`
 }
 
 // ResponseLog contains the response details
 type ResponseLog struct {
-	Content    string   `json:"content,omitempty"`
-	Usage      UsageLog `json:"usage"`
-	StatusCode int      `json:"status_code"`
-	Error      string   `json:"error,omitempty"`
+	Content      string   `json:"content,omitempty"`
+	ContentLen   int      `json:"content_length,omitempty"` // Original length when content was truncated or omitted
+	Usage        UsageLog `json:"usage"`
+	StatusCode   int      `json:"status_code"`
+	Error        string   `json:"error,omitempty"`
+	SchemaErrors []string `json:"schema_errors,omitempty"` // Structured-output schema violations, when validation was requested
+	OverBudget   bool     `json:"over_budget,omitempty"`   // The estimated cost crossed the key's budget mid-stream
+	ServedModel  string   `json:"served_model,omitempty"`  // Exact model the provider reported serving
 }
 
 // UsageLog contains token usage
@@ -98,58 +436,164 @@ type UsageLog struct {
 	PromptTokens     int `json:"prompt_tokens"`
 	CompletionTokens int `json:"completion_tokens"`
 	TotalTokens      int `json:"total_tokens"`
+
+	// Billed separately by some providers; cached and image tokens are included in PromptTokens
+	CachedTokens int     `json:"cached_tokens,omitempty"`
+	ImageTokens  int     `json:"image_tokens,omitempty"`
+	AudioSeconds float64 `json:"audio_seconds,omitempty"`
 }
 
 // MetricsLog contains performance metrics
 type MetricsLog struct {
-	LatencyMs int     `json:"latency_ms"`
-	CostUSD   float64 `json:"cost_usd"`
+	LatencyMs  int     `json:"latency_ms"`
+	CostUSD    float64 `json:"cost_usd"`
+	SampleRate float64 `json:"sample_rate,omitempty"` // Fraction of similar requests that were logged, for extrapolating totals
 }
 
 // Overview represents dashboard overview stats
 type Overview struct {
-	TotalSpend    float64 `json:"total_spend"`
-	TotalRequests int64   `json:"total_requests"`
-	AvgLatency    float64 `json:"avg_latency"`
-	SuccessRate   float64 `json:"success_rate"`
+	TotalSpend       float64           `json:"total_spend"`
+	TotalRequests    int64             `json:"total_requests"`
+	AvgLatency       float64           `json:"avg_latency"`
+	SuccessRate      float64           `json:"success_rate"`
+	TotalTokens      int64             `json:"total_tokens"`
+	PromptTokens     int64             `json:"prompt_tokens"`
+	CompletionTokens int64             `json:"completion_tokens"`
+	RequestQuotas    []KeyRequestQuota `json:"request_quotas"` // Keys with a monthly request quota
+}
+
+// KeyRequestQuota is a key's use of its monthly request quota
+type KeyRequestQuota struct {
+	KeyID     string    `json:"key_id"`
+	KeyName   string    `json:"key_name"`
+	Quota     int64     `json:"quota"`
+	Used      int64     `json:"used"`
+	Remaining int64     `json:"remaining"`
+	ResetsAt  time.Time `json:"resets_at"` // Start of the next calendar month (UTC)
 }
 
 // CreateKeyRequest is the request to create a new virtual key
 type CreateKeyRequest struct {
-	Name          string   `json:"name"`
-	AllowedModels []string `json:"allowed_models"` // e.g., ["openai/*", "anthropic/claude-3-*"]
-	BudgetLimit   *float64 `json:"budget_limit"`
+	Name               string                `json:"name"`
+	AllowedModels      []string              `json:"allowed_models"` // e.g., ["openai/*", "anthropic/claude-3-*"]
+	BudgetLimit        *float64              `json:"budget_limit"`
+	LogBodyMode        LogBodyMode           `json:"log_body_mode,omitempty"`      // Empty uses the deployment default
+	LogSampleRate      *float64              `json:"log_sample_rate,omitempty"`    // Nil uses the deployment default
+	StreamBudget       StreamBudgetMode      `json:"stream_budget_mode,omitempty"` // Empty behaves like "flag"
+	Debug              bool                  `json:"debug,omitempty"`
+	BudgetMode         BudgetMode            `json:"budget_mode,omitempty"` // Empty behaves like "hard"
+	DebugCapture       bool                  `json:"debug_capture,omitempty"`
+	AllowedOrigins     []string              `json:"allowed_origins,omitempty"` // e.g. ["https://app.example.com"]
+	AutoRevokeExempt   bool                  `json:"auto_revoke_exempt,omitempty"`
+	ParamLimits        map[string]ParamLimit `json:"param_limits,omitempty"` // e.g. {"temperature": {"max": 1}}
+	ModelRoutes        map[string]ModelRoute `json:"model_routes,omitempty"` // e.g. {"chat": {"tiers": [{"max_prompt_tokens": 4000, "model": "openai/gpt-4o-mini"}], "default": "openai/gpt-4o"}}
+	MaxMessages        int                   `json:"max_messages,omitempty"`
+	MaxPromptTokens    int                   `json:"max_prompt_tokens,omitempty"`
+	OpenAIOrganization string                `json:"openai_organization,omitempty"` // e.g. "org-..."
+	OpenAIProject      string                `json:"openai_project,omitempty"`      // e.g. "proj_..."
+	RequestQuota       *int64                `json:"request_quota,omitempty"`       // Requests per calendar month (UTC)
+}
+
+// KeyExportVersion is the format version of exported key configurations
+const KeyExportVersion = 1
+
+// KeyExport is a key's configuration without its secret or spend, for re-creating
+// it in another environment
+type KeyExport struct {
+	Version    int              `json:"version"`
+	ExportedAt time.Time        `json:"exported_at"`
+	Config     CreateKeyRequest `json:"config"`
 }
 
 // UpdateKeyRequest is the request to update a virtual key
 type UpdateKeyRequest struct {
-	Name          *string  `json:"name,omitempty"`
-	AllowedModels []string `json:"allowed_models,omitempty"` // Replace allowed models
-	BudgetLimit   *float64 `json:"budget_limit,omitempty"`
+	Name               *string               `json:"name,omitempty"`
+	AllowedModels      []string              `json:"allowed_models,omitempty"` // Replace allowed models
+	BudgetLimit        *float64              `json:"budget_limit,omitempty"`
+	ClearBudget        bool                  `json:"clear_budget,omitempty"`  // Remove the budget limit entirely
+	LogBodyMode        *LogBodyMode          `json:"log_body_mode,omitempty"` // Empty string resets to the deployment default
+	LogSampleRate      *float64              `json:"log_sample_rate,omitempty"`
+	StreamBudget       *StreamBudgetMode     `json:"stream_budget_mode,omitempty"`
+	Debug              *bool                 `json:"debug,omitempty"`
+	BudgetMode         *BudgetMode           `json:"budget_mode,omitempty"`
+	DebugCapture       *bool                 `json:"debug_capture,omitempty"`
+	AllowedOrigins     []string              `json:"allowed_origins,omitempty"` // Replace allowed origins; an empty list removes the restriction
+	AutoRevokeExempt   *bool                 `json:"auto_revoke_exempt,omitempty"`
+	ParamLimits        map[string]ParamLimit `json:"param_limits,omitempty"`        // Replace parameter limits; an empty object removes them
+	ModelRoutes        map[string]ModelRoute `json:"model_routes,omitempty"`        // Replace model routes; an empty object removes them
+	MaxMessages        *int                  `json:"max_messages,omitempty"`        // Zero removes the limit
+	MaxPromptTokens    *int                  `json:"max_prompt_tokens,omitempty"`   // Zero removes the limit
+	OpenAIOrganization *string               `json:"openai_organization,omitempty"` // Empty string removes it
+	OpenAIProject      *string               `json:"openai_project,omitempty"`      // Empty string removes it
+	RequestQuota       *int64                `json:"request_quota,omitempty"`       // Zero removes the quota
+}
+
+// CreateShareTokenRequest is the request to mint a share token for a key
+type CreateShareTokenRequest struct {
+	ExpiresIn   int    `json:"expires_in,omitempty"`   // Lifetime in seconds, one hour by default
+	Model       string `json:"model,omitempty"`        // The only model the token may call, e.g. "openai/gpt-4o-mini"
+	MaxRequests int    `json:"max_requests,omitempty"` // Requests the token may make, zero for no cap of its own
+}
+
+// ShareToken is a signed, expiring token granting constrained use of a virtual key
+type ShareToken struct {
+	ID          string    `json:"id"`
+	KeyID       string    `json:"key_id"`
+	Token       string    `json:"token"`
+	Model       string    `json:"model,omitempty"`
+	MaxRequests int       `json:"max_requests,omitempty"`
+	ExpiresAt   time.Time `json:"expires_at"`
 }
 
 // SetProviderRequest is the request to set an account-level provider API key
 type SetProviderRequest struct {
 	Provider ProviderType `json:"provider"`
 	APIKey   string       `json:"api_key"`
+	Label    string       `json:"label,omitempty"`
+	Models   []string     `json:"models,omitempty"` // Model patterns the key has access to, e.g. ["gpt-4o*"]; empty means any
+}
+
+// ImportProviderResult is the outcome of one item of a bulk provider import
+type ImportProviderResult struct {
+	Index    int          `json:"index"` // Position in the request array
+	Provider ProviderType `json:"provider"`
+	Success  bool         `json:"success"`
+	Error    string       `json:"error,omitempty"`
+}
+
+// ImportProvidersResponse summarizes a bulk provider import
+type ImportProvidersResponse struct {
+	Imported int                    `json:"imported"`
+	Failed   int                    `json:"failed"`
+	Results  []ImportProviderResult `json:"results"`
 }
 
 // ProviderInfo represents provider info returned to the frontend (without the actual key)
 type ProviderInfo struct {
-	Provider  ProviderType `json:"provider"`
-	CreatedAt time.Time    `json:"created_at"`
-	UpdatedAt time.Time    `json:"updated_at"`
+	Provider    ProviderType `json:"provider"`
+	Label       string       `json:"label"`
+	Models      []string     `json:"models"`
+	Fingerprint string       `json:"fingerprint"` // Matches provider_key_fingerprint in request logs
+	CreatedAt   time.Time    `json:"created_at"`
+	UpdatedAt   time.Time    `json:"updated_at"`
+	LastUsedAt  *time.Time   `json:"last_used_at"`
 }
 
 // CreateKeyResponse is the response after creating a key
 type CreateKeyResponse struct {
-	ID            string   `json:"id"`
-	Name          string   `json:"name"`
-	AllowedModels []string `json:"allowed_models"`
-	VirtualKey    string   `json:"virtual_key"` // Only shown once
+	ID            string    `json:"id"`
+	Name          string    `json:"name"`
+	AllowedModels []string  `json:"allowed_models"`
+	VirtualKey    string    `json:"virtual_key"` // Only shown once
+	KeyPreview    string    `json:"key_preview"`
 	CreatedAt     time.Time `json:"created_at"`
 }
 
+// SetKeyLimitRequest is the admin request to override a user's maximum number of keys
+type SetKeyLimitRequest struct {
+	MaxKeys *int `json:"max_keys"` // Null restores the deployment default
+}
+
 // LoginRequest is the login request body
 type LoginRequest struct {
 	Email    string `json:"email"`
@@ -162,6 +606,13 @@ type RegisterRequest struct {
 	Password string `json:"password"`
 }
 
+// CreateUserRequest is the admin request to create a user
+type CreateUserRequest struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+	IsAdmin  bool   `json:"is_admin"`
+}
+
 // AuthResponse is the response for auth operations
 type AuthResponse struct {
 	User  *User  `json:"user"`
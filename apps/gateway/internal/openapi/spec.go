@@ -0,0 +1,433 @@
+package openapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lumina/gateway/internal/models"
+)
+
+var (
+	specOnce  sync.Once
+	specBytes []byte
+)
+
+// Handler serves the gateway's OpenAPI 3 document
+func Handler(w http.ResponseWriter, r *http.Request) {
+	specOnce.Do(func() {
+		specBytes, _ = json.MarshalIndent(Spec(), "", "  ")
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(specBytes)
+}
+
+// Spec builds the OpenAPI 3 document describing the dashboard API and the LLM proxy.
+// Component schemas are derived from the models package so they track the Go types.
+func Spec() map[string]interface{} {
+	g := &generator{schemas: map[string]interface{}{}}
+
+	// Register the request/response types referenced by the paths below
+	for _, v := range []interface{}{
+		models.User{}, models.AuthResponse{}, models.LoginRequest{}, models.RegisterRequest{},
+		models.VirtualKey{}, models.CreateKeyRequest{}, models.UpdateKeyRequest{}, models.CreateKeyResponse{}, models.KeyExport{},
+		models.SetProviderRequest{}, models.ProviderInfo{}, models.ImportProvidersResponse{},
+		models.Overview{}, models.DailyStat{}, models.LogEntry{}, models.SetKeyLimitRequest{}, models.CreateUserRequest{},
+		models.DailyModelStats{}, models.EffectiveKeyConfig{}, models.DeniedModel{}, models.BreakGlassRequest{}, models.ProviderKeyReveal{}, models.EncryptionRotation{}, models.EncryptionStatus{}, models.ProviderStatusResponse{}, models.ProviderCatalog{}, models.KeyActivity{}, models.KeyErrors{}, models.CreateShareTokenRequest{}, models.ShareToken{}, models.Session{},
+	} {
+		g.ref(reflect.TypeOf(v))
+	}
+
+	g.schemas["Error"] = map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"error": map[string]interface{}{"type": "string"},
+			// Validation failures list every invalid field
+			"errors": map[string]interface{}{
+				"type": "array",
+				"items": map[string]interface{}{
+					"type": "object",
+					"properties": map[string]interface{}{
+						"field":   map[string]interface{}{"type": "string"},
+						"message": map[string]interface{}{"type": "string"},
+					},
+				},
+			},
+		},
+	}
+	g.schemas["Message"] = map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"message": map[string]interface{}{"type": "string"},
+		},
+	}
+	g.schemas["LogSearchResult"] = map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"entries": map[string]interface{}{"type": "array", "items": schemaRef("LogEntry")},
+			"total":   map[string]interface{}{"type": "integer"},
+			"page":    map[string]interface{}{"type": "integer"},
+			"size":    map[string]interface{}{"type": "integer"},
+		},
+	}
+	// Proxy payloads follow the upstream provider schemas and are passed through as-is
+	g.schemas["ProviderPayload"] = map[string]interface{}{
+		"type":                 "object",
+		"description":          "Provider request or response body. The model must be given as 'provider/model', e.g. 'openai/gpt-4o'.",
+		"additionalProperties": true,
+	}
+
+	dashboard := []map[string][]string{{"bearerAuth": {}}, {"cookieAuth": {}}}
+	virtualKey := []map[string][]string{{"virtualKey": {}}}
+
+	paths := map[string]interface{}{
+		"/health": map[string]interface{}{
+			"get": operation("Health check", nil, nil, map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"status": map[string]interface{}{"type": "string"},
+				},
+			}),
+		},
+		"/metrics": map[string]interface{}{
+			"get": map[string]interface{}{
+				"summary": "Prometheus metrics",
+				"responses": map[string]interface{}{
+					"200": map[string]interface{}{
+						"description": "Metrics in the Prometheus text exposition format",
+						"content": map[string]interface{}{
+							"text/plain": map[string]interface{}{"schema": map[string]interface{}{"type": "string"}},
+						},
+					},
+				},
+			},
+		},
+		"/api/auth/register": map[string]interface{}{
+			"post": operation("Register a new user", nil, "RegisterRequest", "AuthResponse"),
+		},
+		"/api/auth/login": map[string]interface{}{
+			"post": operation("Log in", nil, "LoginRequest", "AuthResponse"),
+		},
+		"/api/auth/logout": map[string]interface{}{
+			"post": operation("Log out", dashboard, nil, "Message"),
+		},
+		"/api/auth/me": map[string]interface{}{
+			"get": operation("Get the current user", dashboard, nil, "User"),
+		},
+		"/api/auth/sessions": map[string]interface{}{
+			"get": operation("List the current user's active sessions", dashboard, nil, arrayOf("Session")),
+		},
+		"/api/auth/sessions/{id}": map[string]interface{}{
+			"parameters": []interface{}{pathParam("id")},
+			"delete":     operation("Revoke one of the current user's sessions", dashboard, nil, "Message"),
+		},
+		"/api/keys": map[string]interface{}{
+			"get":  operation("List virtual keys", dashboard, nil, arrayOf("VirtualKey")),
+			"post": operation("Create a virtual key", dashboard, "CreateKeyRequest", "CreateKeyResponse"),
+		},
+		"/api/keys/import": map[string]interface{}{
+			"post": operation("Create a virtual key from an exported configuration", dashboard, "KeyExport", "CreateKeyResponse"),
+		},
+		"/api/keys/{id}": map[string]interface{}{
+			"parameters": []interface{}{pathParam("id")},
+			"get":        operation("Get a virtual key", dashboard, nil, "VirtualKey"),
+			"put":        operation("Update a virtual key", dashboard, "UpdateKeyRequest", "Message"),
+			"delete":     operation("Revoke a virtual key", dashboard, nil, "Message"),
+		},
+		"/api/keys/{id}/config": map[string]interface{}{
+			"parameters": []interface{}{pathParam("id")},
+			"get":        operation("Get the effective configuration of a virtual key", dashboard, nil, "EffectiveKeyConfig"),
+		},
+		"/api/keys/{id}/activity": map[string]interface{}{
+			"parameters": []interface{}{pathParam("id")},
+			"get":        withQuery(operation("Get hourly request activity of a virtual key", dashboard, nil, "KeyActivity"), "hours"),
+		},
+		"/api/keys/{id}/errors": map[string]interface{}{
+			"parameters": []interface{}{pathParam("id")},
+			"get":        withQuery(operation("List the most recent failed requests of a virtual key", dashboard, nil, "KeyErrors"), "hours", "limit"),
+		},
+		"/api/keys/{id}/export": map[string]interface{}{
+			"parameters": []interface{}{pathParam("id")},
+			"get":        operation("Export a virtual key's configuration without its secret", dashboard, nil, "KeyExport"),
+		},
+		"/api/keys/{id}/share-tokens": map[string]interface{}{
+			"parameters": []interface{}{pathParam("id")},
+			"post":       operation("Mint a signed, expiring share token for a virtual key", dashboard, "CreateShareTokenRequest", "ShareToken"),
+		},
+		"/api/providers": map[string]interface{}{
+			"get": withQuery(operation("List configured providers", dashboard, nil, arrayOf("ProviderInfo")),
+				"provider", "label", "page", "size"),
+			"post": operation("Set a provider API key", dashboard, "SetProviderRequest", "Message"),
+		},
+		"/api/providers/import": map[string]interface{}{
+			"post": operation("Import several provider API keys at once", dashboard, arrayOf("SetProviderRequest"), "ImportProvidersResponse"),
+		},
+		"/api/meta/providers": map[string]interface{}{
+			"get": operation("List supported providers with a curated model catalog and pricing", dashboard, nil, arrayOf("ProviderCatalog")),
+		},
+		"/api/providers/status": map[string]interface{}{
+			"get": operation("Report recent provider health from gateway error rates", dashboard, nil, "ProviderStatusResponse"),
+		},
+		"/api/providers/{provider}": map[string]interface{}{
+			"parameters": []interface{}{pathParam("provider")},
+			"delete":     operation("Remove a provider API key", dashboard, nil, "Message"),
+		},
+		"/api/stats/overview": map[string]interface{}{
+			"get": withCSV(operation("Get overview statistics", dashboard, nil, "Overview")),
+		},
+		"/api/stats/daily": map[string]interface{}{
+			"get": withCSV(withQuery(operation("Get daily statistics", dashboard, nil, arrayOf("DailyStat")), "start", "end")),
+		},
+		"/api/stats/daily-by-model": map[string]interface{}{
+			"get": withCSV(withQuery(operation("Get daily spend broken down by model", dashboard, nil, "DailyModelStats"), "start", "end")),
+		},
+		"/api/logs": map[string]interface{}{
+			"get": withQuery(operation("Search request logs", dashboard, nil, "LogSearchResult"),
+				"q", "model", "status", "start", "end", "page", "size"),
+		},
+		"/api/logs/stream": map[string]interface{}{
+			"get": withEventStream(operation("Stream new request logs as server-sent log events", dashboard, nil, "LogEntry")),
+		},
+		"/api/logs/{id}": map[string]interface{}{
+			"parameters": []interface{}{pathParam("id")},
+			"get":        operation("Get a request log", dashboard, nil, "LogEntry"),
+		},
+		"/api/admin/denied-models": map[string]interface{}{
+			"get":    operation("List models disabled for every key (admin only)", dashboard, nil, arrayOf("DeniedModel")),
+			"post":   operation("Disable a model pattern for every key (admin only)", dashboard, "DeniedModel", "Message"),
+			"delete": withQuery(operation("Re-enable a denied model pattern (admin only)", dashboard, nil, "Message"), "pattern"),
+		},
+		"/api/admin/users": map[string]interface{}{
+			"post": operation("Create a user (admin only)", dashboard, "CreateUserRequest", "User"),
+		},
+		"/api/admin/users/{id}/key-limit": map[string]interface{}{
+			"parameters": []interface{}{pathParam("id")},
+			"put":        operation("Override a user's maximum number of keys (admin only)", dashboard, "SetKeyLimitRequest", "Message"),
+		},
+		"/api/admin/break-glass/provider-key": map[string]interface{}{
+			"post": operation("Identify the provider key that served a request; audited (admin only)", dashboard, "BreakGlassRequest", "ProviderKeyReveal"),
+		},
+		"/api/admin/encryption/rotate": map[string]interface{}{
+			"post": operation("Start re-encrypting provider keys under the primary encryption key (admin only)", dashboard, nil, "EncryptionRotation"),
+		},
+		"/api/admin/encryption/status": map[string]interface{}{
+			"get": operation("Get the progress of encryption key rotation (admin only)", dashboard, nil, "EncryptionStatus"),
+		},
+		"/v1/chat/completions": map[string]interface{}{
+			"post": operation("OpenAI-compatible chat completions", virtualKey, "ProviderPayload", "ProviderPayload"),
+		},
+		"/v1/completions": map[string]interface{}{
+			"post": operation("OpenAI-compatible text completions", virtualKey, "ProviderPayload", "ProviderPayload"),
+		},
+		"/v1/embeddings": map[string]interface{}{
+			"post": operation("OpenAI-compatible embeddings", virtualKey, "ProviderPayload", "ProviderPayload"),
+		},
+		"/anthropic/v1/messages": map[string]interface{}{
+			"post": operation("Anthropic messages, forwarded as sent; bare Claude model names are accepted", virtualKey, "ProviderPayload", "ProviderPayload"),
+		},
+		"/lumina/v2/chat/completions": map[string]interface{}{
+			"post": operation("Chat completions (v2, metadata required)", virtualKey, "ProviderPayload", "ProviderPayload"),
+		},
+		"/lumina/v2/completions": map[string]interface{}{
+			"post": operation("Text completions (v2, metadata required)", virtualKey, "ProviderPayload", "ProviderPayload"),
+		},
+		"/lumina/v2/embeddings": map[string]interface{}{
+			"post": operation("Embeddings (v2, metadata required)", virtualKey, "ProviderPayload", "ProviderPayload"),
+		},
+		"/lumina/v2/messages": map[string]interface{}{
+			"post": operation("Anthropic messages (v2, metadata required)", virtualKey, "ProviderPayload", "ProviderPayload"),
+		},
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":       "Lumina Gateway API",
+			"description": "Dashboard management API and LLM proxy of the Lumina gateway.",
+			"version":     "1.0.0",
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": g.schemas,
+			"securitySchemes": map[string]interface{}{
+				"bearerAuth": map[string]interface{}{
+					"type": "http", "scheme": "bearer", "bearerFormat": "JWT",
+					"description": "Dashboard session token returned by /api/auth/login",
+				},
+				"cookieAuth": map[string]interface{}{
+					"type": "apiKey", "in": "cookie", "name": "token",
+				},
+				"virtualKey": map[string]interface{}{
+					"type": "http", "scheme": "bearer",
+					"description": "Virtual key (lum_...) created in the dashboard, or a share token (lst_...) minted for one",
+				},
+			},
+		},
+	}
+}
+
+// operation describes a single endpoint. body and response are schema names
+// (or inline schemas); security may be nil for public endpoints.
+func operation(summary string, security []map[string][]string, body, response interface{}) map[string]interface{} {
+	op := map[string]interface{}{
+		"summary": summary,
+		"responses": map[string]interface{}{
+			"200": jsonContent("Success", response),
+			"400": jsonContent("Invalid request", "Error"),
+			"401": jsonContent("Unauthorized", "Error"),
+		},
+	}
+	if security != nil {
+		op["security"] = security
+	}
+	if body != nil {
+		op["requestBody"] = map[string]interface{}{
+			"required": true,
+			"content": map[string]interface{}{
+				"application/json": map[string]interface{}{"schema": toSchema(body)},
+			},
+		}
+	}
+	return op
+}
+
+func withQuery(op map[string]interface{}, names ...string) map[string]interface{} {
+	params := make([]interface{}, len(names))
+	for i, name := range names {
+		params[i] = map[string]interface{}{
+			"name": name, "in": "query", "required": false,
+			"schema": map[string]interface{}{"type": "string"},
+		}
+	}
+	op["parameters"] = params
+	return op
+}
+
+// withCSV documents that the success response is also available as CSV via the Accept header
+func withCSV(op map[string]interface{}) map[string]interface{} {
+	ok := op["responses"].(map[string]interface{})["200"].(map[string]interface{})
+	ok["content"].(map[string]interface{})["text/csv"] = map[string]interface{}{
+		"schema": map[string]interface{}{"type": "string"},
+	}
+	return op
+}
+
+// withEventStream documents a 200 response of server-sent events whose data is the
+// JSON schema the operation was built with
+func withEventStream(op map[string]interface{}) map[string]interface{} {
+	ok := op["responses"].(map[string]interface{})["200"].(map[string]interface{})
+	content := ok["content"].(map[string]interface{})
+	content["text/event-stream"] = content["application/json"]
+	delete(content, "application/json")
+	return op
+}
+
+func pathParam(name string) map[string]interface{} {
+	return map[string]interface{}{
+		"name": name, "in": "path", "required": true,
+		"schema": map[string]interface{}{"type": "string"},
+	}
+}
+
+func jsonContent(description string, schema interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"description": description,
+		"content": map[string]interface{}{
+			"application/json": map[string]interface{}{"schema": toSchema(schema)},
+		},
+	}
+}
+
+func toSchema(v interface{}) interface{} {
+	if name, ok := v.(string); ok {
+		return schemaRef(name)
+	}
+	return v
+}
+
+func arrayOf(name string) map[string]interface{} {
+	return map[string]interface{}{"type": "array", "items": schemaRef(name)}
+}
+
+func schemaRef(name string) map[string]interface{} {
+	return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+}
+
+// generator derives JSON schemas from Go types using their json tags
+type generator struct {
+	schemas map[string]interface{}
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// ref returns a reference to the named component schema for a struct type,
+// registering it (and any nested structs) on first use
+func (g *generator) ref(t reflect.Type) map[string]interface{} {
+	name := t.Name()
+	if _, ok := g.schemas[name]; !ok {
+		g.schemas[name] = nil // Reserve the name to stop recursion
+		g.schemas[name] = g.object(t)
+	}
+	return schemaRef(name)
+}
+
+func (g *generator) object(t reflect.Type) map[string]interface{} {
+	properties := map[string]interface{}{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		tag := field.Tag.Get("json")
+		name := strings.Split(tag, ",")[0]
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		properties[name] = g.schema(field.Type)
+	}
+	return map[string]interface{}{"type": "object", "properties": properties}
+}
+
+func (g *generator) schema(t reflect.Type) map[string]interface{} {
+	if t.Kind() == reflect.Ptr {
+		s := g.schema(t.Elem())
+		if _, isRef := s["$ref"]; isRef {
+			return s
+		}
+		s["nullable"] = true
+		return s
+	}
+
+	if t == timeType {
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": g.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": g.schema(t.Elem())}
+	case reflect.Struct:
+		return g.ref(t)
+	}
+
+	// interface{} and anything else accepts any JSON value
+	return map[string]interface{}{}
+}
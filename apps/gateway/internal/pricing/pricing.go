@@ -0,0 +1,167 @@
+// Package pricing holds the per-model prices used to cost requests, and the
+// curated model catalog derived from them.
+package pricing
+
+import (
+	"strings"
+
+	"github.com/lumina/gateway/internal/models"
+)
+
+// Price is the cost of a model in USD per million tokens, except AudioPerMinute
+type Price struct {
+	Input          float64
+	Output         float64
+	CachedInput    float64
+	ImageInput     float64
+	AudioPerMinute float64
+}
+
+// family is a group of models priced alike
+type family struct {
+	pattern        string // Matched against the model name, see provider.contains
+	input          float64
+	output         float64
+	image          float64 // Zero charges image input like text
+	audioPerMinute float64
+	catalog        []models.CatalogModel // Curated models listed for this family
+}
+
+type provider struct {
+	contains       bool    // Match family patterns as substrings instead of prefixes
+	cachedDivisor  float64 // Cached input costs the input price divided by this, zero for no discount
+	families       []family
+	fallbackInput  float64
+	fallbackOutput float64
+}
+
+// table lists families in match order, so more specific patterns come first
+var table = map[models.ProviderType]provider{
+	models.ProviderOpenAI: {
+		cachedDivisor: 2,
+		families: []family{
+			{pattern: "gpt-image", input: 5.00, output: 40.00, image: 10.00,
+				catalog: []models.CatalogModel{{Name: "gpt-image-1"}}},
+			{pattern: "whisper", audioPerMinute: 0.006,
+				catalog: []models.CatalogModel{{Name: "whisper-1"}}},
+			{pattern: "gpt-4o", input: 2.50, output: 10.00,
+				catalog: []models.CatalogModel{{Name: "gpt-4o", ContextWindow: 128000}, {Name: "gpt-4o-mini", ContextWindow: 128000}}},
+			{pattern: "gpt-4", input: 30.00, output: 60.00,
+				catalog: []models.CatalogModel{{Name: "gpt-4", ContextWindow: 8192}, {Name: "gpt-4-turbo", ContextWindow: 128000}}},
+			{pattern: "gpt-3.5", input: 0.50, output: 1.50,
+				catalog: []models.CatalogModel{{Name: "gpt-3.5-turbo", ContextWindow: 16385}}},
+			{pattern: "o1", input: 15.00, output: 60.00,
+				catalog: []models.CatalogModel{{Name: "o1", ContextWindow: 200000}}},
+		},
+		fallbackInput:  1.00,
+		fallbackOutput: 2.00,
+	},
+	models.ProviderAnthropic: {
+		contains:      true,
+		cachedDivisor: 10,
+		families: []family{
+			{pattern: "opus", input: 15.00, output: 75.00,
+				catalog: []models.CatalogModel{{Name: "claude-3-opus-20240229", ContextWindow: 200000}}},
+			{pattern: "sonnet", input: 3.00, output: 15.00,
+				catalog: []models.CatalogModel{{Name: "claude-3-5-sonnet-20241022", ContextWindow: 200000}}},
+			{pattern: "haiku", input: 0.25, output: 1.25,
+				catalog: []models.CatalogModel{{Name: "claude-3-haiku-20240307", ContextWindow: 200000}}},
+		},
+		fallbackInput:  3.00,
+		fallbackOutput: 15.00,
+	},
+}
+
+// defaultPrice applies to providers missing from the table
+var defaultPrice = Price{Input: 1.00, Output: 2.00, CachedInput: 1.00, ImageInput: 1.00}
+
+// Lookup returns the price of a model, given without its "provider/" prefix.
+// Unknown models are charged the provider's fallback price.
+func Lookup(providerName, model string) Price {
+	p, ok := table[models.ProviderType(providerName)]
+	if !ok {
+		return defaultPrice
+	}
+
+	for _, f := range p.families {
+		if p.matches(f.pattern, model) {
+			return p.price(f)
+		}
+	}
+	return p.price(family{input: p.fallbackInput, output: p.fallbackOutput})
+}
+
+// Known reports whether a model, given without its "provider/" prefix, has a price
+// in the table rather than being charged a fallback price
+func Known(providerName, model string) bool {
+	p := table[models.ProviderType(providerName)]
+	for _, f := range p.families {
+		if p.matches(f.pattern, model) {
+			return true
+		}
+	}
+	return false
+}
+
+// Family returns the name of the pricing family a model, given without its
+// "provider/" prefix, belongs to, e.g. "gpt-4o" or "sonnet", or "other" for models
+// outside the table. It groups models into a small, fixed set, e.g. for metric labels.
+func Family(providerName, model string) string {
+	p := table[models.ProviderType(providerName)]
+	for _, f := range p.families {
+		if p.matches(f.pattern, model) {
+			return f.pattern
+		}
+	}
+	return "other"
+}
+
+func (p provider) matches(pattern, model string) bool {
+	if p.contains {
+		return strings.Contains(model, pattern)
+	}
+	return strings.HasPrefix(model, pattern)
+}
+
+func (p provider) price(f family) Price {
+	price := Price{
+		Input:          f.input,
+		Output:         f.output,
+		CachedInput:    f.input,
+		ImageInput:     f.image,
+		AudioPerMinute: f.audioPerMinute,
+	}
+	if p.cachedDivisor > 0 {
+		price.CachedInput = f.input / p.cachedDivisor
+	}
+	if price.ImageInput == 0 {
+		price.ImageInput = f.input
+	}
+	return price
+}
+
+// Catalog lists the supported providers with their curated models and prices
+func Catalog() []models.ProviderCatalog {
+	providers := []models.ProviderType{models.ProviderOpenAI, models.ProviderAnthropic}
+
+	catalog := make([]models.ProviderCatalog, 0, len(providers))
+	for _, name := range providers {
+		p := table[name]
+		entry := models.ProviderCatalog{Provider: name, Models: []models.CatalogModel{}}
+		for _, f := range p.families {
+			price := p.price(f)
+			for _, m := range f.catalog {
+				m.ID = string(name) + "/" + m.Name
+				m.Pricing = models.ModelPricing{
+					InputPerMillion:       price.Input,
+					OutputPerMillion:      price.Output,
+					CachedInputPerMillion: price.CachedInput,
+					AudioPerMinute:        price.AudioPerMinute,
+				}
+				entry.Models = append(entry.Models, m)
+			}
+		}
+		catalog = append(catalog, entry)
+	}
+	return catalog
+}
@@ -0,0 +1,44 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/lumina/gateway/internal/models"
+)
+
+// annotateHeader opts a non-streaming request into an inline "_lumina" object in the
+// response body. Off by default since it breaks clients that validate the provider schema.
+const annotateHeader = "X-Lumina-Annotate"
+
+// responseAnnotation is the "_lumina" object added to annotated responses
+type responseAnnotation struct {
+	TraceID   string          `json:"trace_id"`
+	Cost      float64         `json:"cost"` // USD
+	LatencyMs int             `json:"latency_ms"`
+	Usage     models.UsageLog `json:"usage"`
+}
+
+// annotateResponse adds annotation to a JSON response object as "_lumina", leaving
+// the other fields byte-for-byte as the provider sent them. Bodies that aren't JSON
+// objects are returned unchanged.
+func annotateResponse(body []byte, annotation responseAnnotation) ([]byte, bool) {
+	var obj map[string]json.RawMessage
+	if err := json.Unmarshal(body, &obj); err != nil || obj == nil {
+		return body, false
+	}
+
+	encoded, err := json.Marshal(annotation)
+	if err != nil {
+		return body, false
+	}
+	obj["_lumina"] = encoded
+
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(obj); err != nil {
+		return body, false
+	}
+	return bytes.TrimRight(buf.Bytes(), "\n"), true
+}
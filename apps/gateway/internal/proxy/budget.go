@@ -0,0 +1,122 @@
+package proxy
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/lumina/gateway/internal/models"
+	"github.com/lumina/gateway/internal/tokenizer"
+	"github.com/lumina/gateway/internal/webhook"
+)
+
+const (
+	budgetExceededHeader = "X-Lumina-Budget-Exceeded"
+
+	// budgetWebhookInterval limits budget.exceeded webhooks to one per key per interval
+	budgetWebhookInterval = time.Hour
+)
+
+// SetNotifier sets the webhook notifier used for budget events
+func (h *Handler) SetNotifier(n *webhook.Notifier) {
+	h.notifier = n
+}
+
+// estimatePromptTokens counts the tokens the request sends upstream
+func estimatePromptTokens(lb *logBuilder) int {
+	return lb.prompt.count(lb.model)
+}
+
+// promptTokens counts the tokens of a request's messages, system prompt and text
+// prompt. Each count is made once per tokenizer encoding, however many of the
+// routing, size and budget checks ask for it.
+type promptTokens struct {
+	requestData map[string]interface{}
+	system      string
+	counts      map[string]int // Encoding -> tokens
+}
+
+func newPromptTokens(requestData map[string]interface{}) *promptTokens {
+	return &promptTokens{requestData: requestData, system: extractSystemPrompt(requestData), counts: make(map[string]int)}
+}
+
+// count returns the prompt's tokens with model's tokenizer
+func (p *promptTokens) count(model string) int {
+	encoding := tokenizer.Encoding(model)
+	if tokens, ok := p.counts[encoding]; ok {
+		return tokens
+	}
+
+	tokens := tokenizer.CountMessages(p.requestData["messages"], model) + tokenizer.CountText(p.system, model)
+	if text, ok := p.requestData["prompt"].(string); ok {
+		tokens += tokenizer.CountText(text, model)
+	}
+	p.counts[encoding] = tokens
+	return tokens
+}
+
+// checkBudget prices the prompt against the key's remaining budget. Hard-mode
+// keys over budget are rejected with 402 and it returns false; soft-mode keys
+// are let through and the request is flagged.
+func (h *Handler) checkBudget(w http.ResponseWriter, lb *logBuilder) bool {
+	if lb.keyConfig.BudgetLimit == nil {
+		return true
+	}
+
+	estimate := h.calculateCost(lb.provider, lb.model, models.UsageLog{PromptTokens: estimatePromptTokens(lb)})
+	if err := h.keyService.CheckBudget(lb.keyConfig, estimate); err == nil {
+		return true
+	}
+
+	if lb.keyConfig.BudgetMode != models.BudgetSoft {
+		h.writeError(w, http.StatusPaymentRequired, "budget limit exceeded for this key")
+		return false
+	}
+
+	w.Header().Set(budgetExceededHeader, "true")
+	h.flagOverBudget(lb)
+	return true
+}
+
+// flagOverBudget marks the request's log entry as over budget and notifies the
+// webhook, at most once per key per budgetWebhookInterval
+func (h *Handler) flagOverBudget(lb *logBuilder) {
+	if lb.overBudget {
+		return
+	}
+	lb.overBudget = true
+
+	if h.notifier == nil {
+		return
+	}
+
+	cfg := lb.keyConfig
+	data := map[string]interface{}{
+		"key_id":        cfg.KeyID,
+		"key_name":      cfg.Name,
+		"user_id":       cfg.UserID,
+		"budget_limit":  *cfg.BudgetLimit,
+		"current_spend": cfg.CurrentSpend,
+		"budget_mode":   cfg.BudgetMode,
+		"trace_id":      lb.traceID(),
+	}
+
+	h.keyService.Go(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+		defer cancel()
+
+		first, err := h.cache.FirstInWindow(ctx, "budget_exceeded:"+cfg.KeyID, budgetWebhookInterval)
+		if err != nil {
+			slog.Warn("failed to throttle budget webhook", "key_id", cfg.KeyID, "error", err)
+			return
+		}
+		if !first {
+			return
+		}
+
+		if err := h.notifier.Send(ctx, webhook.EventBudgetExceeded, data); err != nil {
+			slog.Warn("failed to send budget webhook", "key_id", cfg.KeyID, "error", err)
+		}
+	})
+}
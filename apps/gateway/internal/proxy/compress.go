@@ -0,0 +1,26 @@
+package proxy
+
+import (
+	"bytes"
+	"compress/gzip"
+	"slices"
+)
+
+// shouldGzip reports whether a request body of the given size should be sent
+// to the provider gzip-compressed
+func (h *Handler) shouldGzip(provider string, size int) bool {
+	return h.opts.GzipMinBytes > 0 && size >= h.opts.GzipMinBytes && slices.Contains(h.opts.GzipProviders, provider)
+}
+
+// gzipBody compresses a request body
+func gzipBody(body []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(body); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
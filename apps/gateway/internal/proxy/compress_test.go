@@ -0,0 +1,94 @@
+package proxy
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestShouldGzip(t *testing.T) {
+	h := &Handler{opts: Options{GzipMinBytes: 1024, GzipProviders: []string{"openai"}}}
+	tests := []struct {
+		provider string
+		size     int
+		want     bool
+	}{
+		{"openai", 1024, true},
+		{"openai", 1023, false},
+		{"anthropic", 4096, false},
+	}
+	for _, tt := range tests {
+		if got := h.shouldGzip(tt.provider, tt.size); got != tt.want {
+			t.Errorf("shouldGzip(%q, %d) = %v, want %v", tt.provider, tt.size, got, tt.want)
+		}
+	}
+
+	disabled := &Handler{opts: Options{GzipProviders: []string{"openai"}}}
+	if disabled.shouldGzip("openai", 1<<20) {
+		t.Error("shouldGzip with GzipMinBytes unset = true, want false")
+	}
+}
+
+func TestGzipUpstreamRequest(t *testing.T) {
+	th := newTestHandler(t, Options{GzipMinBytes: 1024, GzipProviders: []string{"openai"}})
+	virtualKey := th.addKey(t, testKeyConfig())
+
+	// The upstream decodes the body as the provider would, per Content-Encoding
+	var encoding string
+	var received map[string]interface{}
+	th.upstream(t, func(w http.ResponseWriter, r *http.Request) {
+		encoding = r.Header.Get("Content-Encoding")
+		var body io.Reader = r.Body
+		if encoding == "gzip" {
+			zr, err := gzip.NewReader(r.Body)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			body = zr
+		}
+		received = nil
+		if err := json.NewDecoder(body).Decode(&received); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id":"chatcmpl-1","model":"gpt-4o","choices":[{"index":0,"message":{"role":"assistant","content":"ok"}}],"usage":{"prompt_tokens":1,"completion_tokens":1,"total_tokens":2}}`))
+	})
+
+	tests := []struct {
+		name     string
+		model    string
+		prompt   string
+		encoding string
+	}{
+		{"large body", "openai/gpt-4o", strings.Repeat("lorem ipsum ", 200), "gzip"},
+		{"small body", "openai/gpt-4o", "hello", ""},
+		{"provider not listed", "anthropic/claude-3-5-sonnet-20241022", strings.Repeat("lorem ipsum ", 200), ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, _ := json.Marshal(map[string]interface{}{
+				"model":      tt.model,
+				"max_tokens": 10,
+				"messages":   []map[string]string{{"role": "user", "content": tt.prompt}},
+			})
+			rec := proxyRequest(th.ChatCompletions, virtualKey, string(body))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, body %s", rec.Code, rec.Body.String())
+			}
+			th.nextLog(t)
+
+			if encoding != tt.encoding {
+				t.Errorf("Content-Encoding = %q, want %q", encoding, tt.encoding)
+			}
+			messages, _ := received["messages"].([]interface{})
+			if len(messages) != 1 || messages[0].(map[string]interface{})["content"] != tt.prompt {
+				t.Errorf("upstream received messages %v, want the client's prompt", received["messages"])
+			}
+		})
+	}
+}
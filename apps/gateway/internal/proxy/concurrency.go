@@ -0,0 +1,55 @@
+package proxy
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/lumina/gateway/internal/metrics"
+)
+
+// slotPollInterval is how often a queued request retries for a free provider slot
+const slotPollInterval = 50 * time.Millisecond
+
+var providerConcurrencyRejected = metrics.NewCounter("lumina_provider_concurrency_rejected_total",
+	"Requests rejected because their provider's global concurrency limit was reached")
+
+// acquireProviderSlot enforces the deployment-wide concurrency limit of a provider,
+// waiting up to ProviderConcurrencyWait for a slot. It returns a release function
+// and false when no slot became available. Redis errors fail open.
+func (h *Handler) acquireProviderSlot(ctx context.Context, provider, traceID string) (func(), bool) {
+	limit := h.opts.ProviderConcurrency[provider]
+	if limit <= 0 || h.cache == nil {
+		return func() {}, true
+	}
+
+	name := "provider:" + provider
+	deadline := time.Now().Add(h.opts.ProviderConcurrencyWait)
+
+	for {
+		ok, err := h.cache.AcquireSlot(ctx, name, traceID, limit)
+		if err != nil {
+			slog.Warn("provider concurrency limit unavailable", "provider", provider, "error", err)
+			return func() {}, true
+		}
+		if ok {
+			return func() {
+				// The request context may already be canceled when releasing
+				if err := h.cache.ReleaseSlot(context.Background(), name, traceID); err != nil {
+					slog.Warn("failed to release provider slot", "provider", provider, "error", err)
+				}
+			}, true
+		}
+
+		if time.Now().Add(slotPollInterval).After(deadline) {
+			providerConcurrencyRejected.Inc()
+			return nil, false
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, false
+		case <-time.After(slotPollInterval):
+		}
+	}
+}
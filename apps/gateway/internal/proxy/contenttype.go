@@ -0,0 +1,38 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// isJSONContentType reports whether a Content-Type header declares JSON, with or
+// without parameters such as charset
+func isJSONContentType(header string) bool {
+	mediaType, _, err := mime.ParseMediaType(header)
+	return err == nil && (mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"))
+}
+
+// invalidBodyError returns the status and message for a body that failed to parse
+// as JSON, telling a missing or wrong Content-Type apart from malformed JSON. Bodies
+// are parsed regardless of Content-Type, so clients that omit it but send valid JSON
+// keep working.
+func invalidBodyError(contentType string, err error) (int, string) {
+	switch {
+	case contentType == "":
+		return http.StatusBadRequest, "request body is not valid JSON and has no Content-Type header; send a JSON body with Content-Type: application/json"
+	case !isJSONContentType(contentType):
+		mediaType, _, _ := mime.ParseMediaType(contentType)
+		if mediaType == "" {
+			mediaType = contentType
+		}
+		return http.StatusUnsupportedMediaType, fmt.Sprintf("unsupported Content-Type %q; this endpoint accepts application/json", mediaType)
+	case errors.Is(err, io.EOF):
+		return http.StatusBadRequest, "request body is empty"
+	default:
+		return http.StatusBadRequest, fmt.Sprintf("malformed JSON body: %v", err)
+	}
+}
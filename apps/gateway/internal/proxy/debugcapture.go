@@ -0,0 +1,20 @@
+package proxy
+
+import (
+	"math/rand"
+
+	"github.com/lumina/gateway/internal/models"
+)
+
+// debugCapture decides whether a request's raw bodies are captured for the debug
+// index: always for keys with debug_capture, otherwise for a DebugCaptureRate sample.
+// The logging pipeline drops sampled captures of keys that don't log full bodies.
+func (h *Handler) debugCapture(keyConfig *models.KeyConfig) models.DebugCapture {
+	if keyConfig.DebugCapture {
+		return models.DebugCaptureKey
+	}
+	if h.opts.DebugCaptureRate > 0 && rand.Float64() < h.opts.DebugCaptureRate {
+		return models.DebugCaptureSampled
+	}
+	return ""
+}
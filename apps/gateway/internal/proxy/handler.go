@@ -1,44 +1,194 @@
 package proxy
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
+	"maps"
+	"math/rand"
 	"net/http"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/uuid"
 
 	"github.com/lumina/gateway/internal/auth"
+	"github.com/lumina/gateway/internal/cache"
 	"github.com/lumina/gateway/internal/logging"
+	"github.com/lumina/gateway/internal/metrics"
 	"github.com/lumina/gateway/internal/models"
+	"github.com/lumina/gateway/internal/pricing"
+	"github.com/lumina/gateway/internal/schema"
+	"github.com/lumina/gateway/internal/webhook"
 )
 
 const (
 	openAIBaseURL    = "https://api.openai.com"
 	anthropicBaseURL = "https://api.anthropic.com"
+
+	// validateSchemaHeader opts a request into checking the response against its json_schema response_format
+	validateSchemaHeader = "X-Lumina-Validate-Schema"
+
+	// metadataHeader carries client tags (a JSON object) recorded with the request log
+	metadataHeader = "X-Lumina-Metadata"
+
+	// costTrailersHeader opts a streaming request into cost and token trailers
+	costTrailersHeader = "X-Lumina-Cost-Trailers"
+	costTrailer        = "X-Lumina-Cost"
+	tokensTrailer      = "X-Lumina-Tokens"
+
+	// modelOverrideHeader replaces the body's model for routing; only honored for debug keys
+	modelOverrideHeader = "X-Lumina-Model"
+
+	// Limits on client-supplied metadata tags
+	maxMetadataBytes = 4096
+	maxMetadataKeys  = 32
+)
+
+// Options configures optional proxy behavior
+type Options struct {
+	// InjectStreamUsage asks OpenAI to report token usage at the end of streamed
+	// responses (stream_options.include_usage) so they can be billed accurately
+	InjectStreamUsage bool
+
+	// StreamIdleTimeout ends a streaming response when the upstream sends nothing for this long
+	StreamIdleTimeout time.Duration
+
+	// UpstreamTimeout and StreamTimeout bound the whole upstream call, including reading
+	// the response, for non-streaming and streaming requests. Zero disables the deadline.
+	UpstreamTimeout time.Duration
+	StreamTimeout   time.Duration
+
+	// LogSampleRate is the fraction of successful requests that get logged when the
+	// key doesn't set its own rate. Errors are always logged and spend is always tracked.
+	LogSampleRate float64
+
+	// AnthropicDefaultMaxTokens is set as max_tokens on Anthropic requests that omit it.
+	// Zero rejects such requests with a 400 instead.
+	AnthropicDefaultMaxTokens int
+
+	// GzipMinBytes is the request body size from which bodies forwarded to one of
+	// GzipProviders are gzip-compressed. Zero disables compression.
+	GzipMinBytes  int
+	GzipProviders []string
+
+	// Transforms rewrite request bodies per provider before forwarding, see LoadTransforms
+	Transforms map[string]TransformRules
+
+	// ProviderConcurrency caps in-flight requests per provider across all replicas,
+	// protecting shared provider accounts from upstream throttling. Requests wait up
+	// to ProviderConcurrencyWait for a slot before being rejected with 429.
+	ProviderConcurrency     map[string]int
+	ProviderConcurrencyWait time.Duration
+
+	// MaxStreams caps the streaming responses this replica serves at once, and
+	// MaxStreamsPerKey those of any single key. Further streaming requests are
+	// rejected with 503 and Retry-After. Zero means no limit.
+	MaxStreams       int
+	MaxStreamsPerKey int
+
+	// RateLimit is the number of requests each key may make per minute across all
+	// replicas. Zero means no limit.
+	RateLimit int
+
+	// DebugCaptureRate is the fraction of requests whose raw request and response
+	// bodies are stored in the debug index, in addition to keys with debug_capture
+	DebugCaptureRate float64
+
+	// ForwardedHeaders lists the upstream response headers relayed to clients;
+	// entries may end in "*" to match a prefix. Content-Type is always relayed.
+	ForwardedHeaders []string
+
+	// ProviderMetadata controls whether the body's metadata object is forwarded to
+	// providers: ProviderMetadataOff (default), ProviderMetadataClient or ProviderMetadataMerge
+	ProviderMetadata string
+
+	// UnsupportedParams controls what happens to request parameters the provider
+	// doesn't support: UnsupportedParamsStrip (default) or UnsupportedParamsReject
+	UnsupportedParams string
+
+	// UnknownPricing controls requests for models missing from the pricing table:
+	// UnknownPricingFallback (default) charges the provider's fallback price, and
+	// UnknownPricingReject fails them for deployments that require accurate billing
+	UnknownPricing string
+
+	// RewriteResponseModel reports the model name the client sent in responses
+	// instead of the exact model the provider served, e.g. "gpt-4o" rather than
+	// "gpt-4o-2024-08-06". The served model is still logged.
+	RewriteResponseModel bool
+
+	// DefaultProvider routes model names without a "provider/" prefix to this
+	// provider. Empty rejects them.
+	DefaultProvider string
+
+	// EchoRequestID returns a client-supplied request ID on the response
+	EchoRequestID bool
+
+	// RequestIDHeader names the header carrying client request IDs, which are
+	// recorded in the request log, X-Request-Id when empty. For traceparent the
+	// W3C trace ID is recorded.
+	RequestIDHeader string
+
+	// LegacyCompletions serves the deprecated text completions endpoint; when
+	// false its requests get a 404 pointing to chat completions
+	LegacyCompletions bool
+}
+
+const (
+	traceIDHeader          = "X-Lumina-Trace-Id"
+	defaultRequestIDHeader = "X-Request-Id"
+	traceparentHeader      = "Traceparent"
+
+	// maxRequestIDLength bounds client request IDs so they can't bloat logs
+	maxRequestIDLength = 128
 )
 
 // Handler handles LLM proxy requests
 type Handler struct {
 	keyService  *auth.KeyService
 	logPipeline *logging.Pipeline
+	cache       *cache.Cache
+	notifier    *webhook.Notifier
+	streams     *streamLimiter
+	opts        Options
+	version     Version
 	httpClient  *http.Client
+	recordSpend func(keyID string, cost float64, tokens int) // keyService.RecordSpend, replaced in tests
 }
 
 // NewHandler creates a new proxy handler
-func NewHandler(keyService *auth.KeyService, logPipeline *logging.Pipeline) *Handler {
+func NewHandler(keyService *auth.KeyService, logPipeline *logging.Pipeline, cache *cache.Cache, opts Options) *Handler {
+	if opts.RequestIDHeader == "" {
+		opts.RequestIDHeader = defaultRequestIDHeader
+	}
+	opts.RequestIDHeader = http.CanonicalHeaderKey(opts.RequestIDHeader)
+
 	return &Handler{
 		keyService:  keyService,
 		logPipeline: logPipeline,
-		httpClient: &http.Client{
-			Timeout: 120 * time.Second,
-		},
+		cache:       cache,
+		opts:        opts,
+		version:     V1,
+		streams:     newStreamLimiter(opts.MaxStreams, opts.MaxStreamsPerKey),
+		// Deadlines are set per request in proxyUnified, once it's known whether it streams
+		httpClient:  &http.Client{},
+		recordSpend: keyService.RecordSpend,
+	}
+}
+
+// upstreamTimeout returns the deadline for an upstream call
+func (h *Handler) upstreamTimeout(isStreaming bool) time.Duration {
+	if isStreaming {
+		return h.opts.StreamTimeout
 	}
+	return h.opts.UpstreamTimeout
 }
 
 // parseModel parses a model string in the format "provider/model"
@@ -51,39 +201,96 @@ func parseModel(model string) (provider string, actualModel string, err error) {
 	return parts[0], parts[1], nil
 }
 
+// endpoint describes a proxied API endpoint
+type endpoint struct {
+	path        string // Upstream path for OpenAI
+	requestType string
+	streaming   bool   // Whether the endpoint can return a streamed response
+	native      string // Provider whose own API the endpoint serves, empty for the unified format
+}
+
+var (
+	chatEndpoint       = endpoint{path: "/v1/chat/completions", requestType: "chat", streaming: true}
+	completionEndpoint = endpoint{path: "/v1/completions", requestType: "completion", streaming: true}
+	embeddingEndpoint  = endpoint{path: "/v1/embeddings", requestType: "embedding"}
+	messagesEndpoint   = endpoint{path: "/v1/messages", requestType: "anthropic", streaming: true}
+
+	// Native Anthropic route: bare model names, bodies forwarded as sent
+	anthropicNativeEndpoint = endpoint{path: "/v1/messages", requestType: "anthropic", streaming: true, native: "anthropic"}
+)
+
 // ChatCompletions handles chat completions with unified provider/model format
 func (h *Handler) ChatCompletions(w http.ResponseWriter, r *http.Request) {
-	h.proxyUnified(w, r, "/v1/chat/completions", "chat")
+	h.proxyUnified(w, r, chatEndpoint)
 }
 
-// Completions handles completions with unified provider/model format
+// Completions handles legacy text completions with unified provider/model format
 func (h *Handler) Completions(w http.ResponseWriter, r *http.Request) {
-	h.proxyUnified(w, r, "/v1/completions", "completion")
+	if !h.opts.LegacyCompletions {
+		h.writeError(w, http.StatusNotFound, "the legacy completions endpoint is disabled on this gateway; use chat/completions")
+		return
+	}
+	h.proxyUnified(w, r, completionEndpoint)
 }
 
 // Embeddings handles embeddings with unified provider/model format
 func (h *Handler) Embeddings(w http.ResponseWriter, r *http.Request) {
-	h.proxyUnified(w, r, "/v1/embeddings", "embedding")
+	h.proxyUnified(w, r, embeddingEndpoint)
 }
 
 // AnthropicMessages handles Anthropic messages API with unified provider/model format
 func (h *Handler) AnthropicMessages(w http.ResponseWriter, r *http.Request) {
-	h.proxyUnified(w, r, "/v1/messages", "anthropic")
+	h.proxyUnified(w, r, messagesEndpoint)
+}
+
+// AnthropicNativeMessages handles the Anthropic messages API as a passthrough: model
+// names need no provider prefix and the body reaches Anthropic as the client sent it.
+// Keys, allowed models, budgets and logging apply as on every other route.
+func (h *Handler) AnthropicNativeMessages(w http.ResponseWriter, r *http.Request) {
+	h.proxyUnified(w, r, anthropicNativeEndpoint)
 }
 
 // proxyUnified handles all proxy requests with the unified provider/model format
-func (h *Handler) proxyUnified(w http.ResponseWriter, r *http.Request, path string, requestType string) {
+func (h *Handler) proxyUnified(w http.ResponseWriter, r *http.Request, ep endpoint) {
 	ctx := r.Context()
 	traceID := uuid.New().String()
 	startTime := time.Now()
 
+	w.Header().Set(traceIDHeader, traceID)
+	requestID, echo := h.clientRequestID(r)
+	if echo != "" {
+		w.Header().Set(h.opts.RequestIDHeader, echo)
+	}
+
 	// Extract and validate virtual key
 	keyConfig, err := h.extractAndValidateKey(ctx, r)
+	if errors.Is(err, auth.ErrNoProviders) {
+		h.writeErrorCode(w, http.StatusBadRequest, errorCodeNoProviders,
+			"no provider API keys configured for your account; add one in settings")
+		return
+	}
+	if errors.Is(err, auth.ErrShareTokenExhausted) {
+		h.writeError(w, http.StatusTooManyRequests, err.Error())
+		return
+	}
 	if err != nil {
 		h.writeError(w, http.StatusUnauthorized, err.Error())
 		return
 	}
 
+	if !originAllowed(keyConfig.AllowedOrigins, r) {
+		h.writeError(w, http.StatusForbidden, "this key may only be used from its allowed origins")
+		return
+	}
+
+	if !h.checkRateLimit(ctx, w, keyConfig) {
+		return
+	}
+
+	if !h.checkRequestQuota(ctx, w, keyConfig) {
+		return
+	}
+
 	// Read request body
 	bodyBytes, err := io.ReadAll(r.Body)
 	if err != nil {
@@ -92,20 +299,97 @@ func (h *Handler) proxyUnified(w http.ResponseWriter, r *http.Request, path stri
 	}
 	r.Body.Close()
 
-	// Parse request for logging
+	// Parse request for logging, keeping numbers as json.Number so values such as
+	// structured-output schemas survive the re-marshal below unchanged
 	var requestData map[string]interface{}
-	if err := json.Unmarshal(bodyBytes, &requestData); err != nil {
-		h.writeError(w, http.StatusBadRequest, "invalid JSON body")
+	decoder := json.NewDecoder(bytes.NewReader(bodyBytes))
+	decoder.UseNumber()
+	if err := decoder.Decode(&requestData); err != nil {
+		status, message := invalidBodyError(r.Header.Get("Content-Type"), err)
+		h.writeError(w, status, message)
+		return
+	}
+	if requestData == nil {
+		h.writeError(w, http.StatusBadRequest, "request body must be a JSON object")
 		return
 	}
 
+	// Collect client metadata tags for our logs. The body's metadata field is
+	// removed here and only forwarded again if provider metadata is enabled.
+	// On native routes it belongs to the provider's API and tags come from the
+	// header only.
+	metadataSource := requestData
+	if ep.native != "" {
+		metadataSource = nil
+	}
+	clientMetadata := requestData["metadata"]
+	metadata, err := extractMetadata(r, metadataSource)
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if ep.native == "" {
+		forwardedMetadata, err := h.providerMetadata(clientMetadata, keyConfig.Name, traceID)
+		if err != nil {
+			h.writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if forwardedMetadata != nil {
+			requestData["metadata"] = forwardedMetadata
+		}
+	}
+
+	if h.version.RequireMetadata && len(metadata) == 0 {
+		h.writeError(w, http.StatusBadRequest, fmt.Sprintf("API %s requires metadata tags via the %s header or the body's metadata field", h.version.Name, metadataHeader))
+		return
+	}
+
+	// Structured-output validation is opt-in per request since it parses the full response
+	validateSchema := r.Header.Get(validateSchemaHeader) == "true"
+
 	// Extract model (in format "provider/model")
 	modelField := extractModel(requestData)
+	clientModel := modelField
+
+	// Debug keys can route to another model without changing client code
+	requestedModel := ""
+	if override := r.Header.Get(modelOverrideHeader); override != "" {
+		if !keyConfig.Debug {
+			h.writeError(w, http.StatusForbidden, fmt.Sprintf("the %s header requires a key with debug enabled", modelOverrideHeader))
+			return
+		}
+		requestedModel, modelField = modelField, override
+	}
+
+	// Keys can map a logical model name to models picked by prompt size. The prompt
+	// is counted once here and reused by the size and budget checks.
+	prompt := newPromptTokens(requestData)
+	if routed := routeModel(prompt, keyConfig.ModelRoutes, modelField, traceID); routed != modelField {
+		if requestedModel == "" {
+			requestedModel = modelField
+		}
+		modelField = routed
+	}
+
+	// Bare model names belong to a native route's provider, or go to the default
+	// provider when one is configured
+	if ep.native != "" && modelField != "" && !strings.Contains(modelField, "/") {
+		modelField = ep.native + "/" + modelField
+	} else if h.opts.DefaultProvider != "" && modelField != "" && !strings.Contains(modelField, "/") {
+		modelField = h.opts.DefaultProvider + "/" + modelField
+		slog.Info("inferred provider for bare model name", "trace_id", traceID, "model", modelField)
+	}
+
 	provider, actualModel, err := parseModel(modelField)
 	if err != nil {
 		h.writeError(w, http.StatusBadRequest, err.Error())
 		return
 	}
+	if ep.native != "" && provider != ep.native {
+		h.writeError(w, http.StatusBadRequest, fmt.Sprintf("only %s models are served on this route; use /v1 for model '%s'", ep.native, modelField))
+		return
+	}
 
 	// Validate model is allowed
 	if !h.keyService.IsModelAllowed(keyConfig, modelField) {
@@ -113,23 +397,29 @@ func (h *Handler) proxyUnified(w http.ResponseWriter, r *http.Request, path stri
 		return
 	}
 
+	// Operator overrides apply even when the key allows the model
+	if h.keyService.IsModelDenied(modelField) {
+		h.writeError(w, http.StatusForbidden, fmt.Sprintf("model '%s' has been disabled on this gateway", modelField))
+		return
+	}
+
 	// Get API key for the provider
-	realAPIKey, err := h.keyService.GetProviderKey(keyConfig, provider)
-	fmt.Println("Provider:", provider, "API Key:", realAPIKey)
+	realAPIKey, err := h.keyService.GetProviderKey(ctx, keyConfig, provider, actualModel)
 	if err != nil {
-		if err == auth.ErrProviderNotFound {
-			h.writeError(w, http.StatusBadRequest, fmt.Sprintf("provider '%s' is not configured for this key", provider))
-			return
+		switch {
+		case errors.Is(err, auth.ErrProviderUnsupported):
+			h.writeError(w, http.StatusBadRequest, fmt.Sprintf("provider '%s' is not supported by the gateway; use one of 'openai' or 'anthropic'", provider))
+		case errors.Is(err, auth.ErrProviderNotConfigured):
+			h.writeErrorCode(w, http.StatusBadRequest, errorCodeProviderNotConfigured, fmt.Sprintf("no API key configured for provider '%s'; add one in your account's provider settings", provider))
+		case errors.Is(err, auth.ErrProviderModelNoAccess):
+			h.writeError(w, http.StatusForbidden, fmt.Sprintf("the '%s' API key on this account does not have access to model '%s'", provider, actualModel))
+		default:
+			h.writeError(w, http.StatusInternalServerError, "failed to get provider key")
 		}
-		h.writeError(w, http.StatusInternalServerError, "failed to get provider key")
 		return
 	}
 
-	// Replace model with actual model name (without provider prefix)
-	requestData["model"] = actualModel
-	modifiedBody, err := json.Marshal(requestData)
-	if err != nil {
-		h.writeError(w, http.StatusInternalServerError, "failed to modify request")
+	if !h.checkModelPricing(ctx, w, provider, actualModel, traceID) {
 		return
 	}
 
@@ -138,57 +428,71 @@ func (h *Handler) proxyUnified(w http.ResponseWriter, r *http.Request, path stri
 	if stream, ok := requestData["stream"].(bool); ok {
 		isStreaming = stream
 	}
+	if isStreaming && !ep.streaming {
+		h.writeError(w, http.StatusBadRequest, fmt.Sprintf("streaming is not supported for %s requests", ep.requestType))
+		return
+	}
 
-	// Route to appropriate provider
-	var targetURL string
-	var headers map[string]string
-
-	switch provider {
-	case "openai":
-		targetURL = openAIBaseURL + path
-		headers = map[string]string{
-			"Content-Type":  "application/json",
-			"Authorization": "Bearer " + realAPIKey,
-		}
-	case "anthropic":
-		// Anthropic uses different endpoint
-		targetURL = anthropicBaseURL + "/v1/messages"
-		headers = map[string]string{
-			"Content-Type":      "application/json",
-			"x-api-key":         realAPIKey,
-			"anthropic-version": "2023-06-01",
+	if h.opts.UnsupportedParams == UnsupportedParamsReject && ep.native == "" {
+		if unsupported := h.findUnsupportedParams(requestData, provider); len(unsupported) > 0 {
+			h.writeError(w, http.StatusBadRequest, fmt.Sprintf("parameters not supported by provider '%s': %s", provider, strings.Join(unsupported, ", ")))
+			return
 		}
-	default:
-		h.writeError(w, http.StatusBadRequest, fmt.Sprintf("unsupported provider: %s", provider))
-		return
 	}
 
-	// Create upstream request
-	upstreamReq, err := http.NewRequestWithContext(ctx, "POST", targetURL, bytes.NewReader(modifiedBody))
-	if err != nil {
-		h.writeError(w, http.StatusInternalServerError, "failed to create upstream request")
+	// Keys can bound sampling parameters and default omitted ones. Native routes
+	// forward the body untouched.
+	if ep.native == "" {
+		h.applyParamLimits(requestData, keyConfig.ParamLimits, provider, traceID)
+	}
+
+	// Anthropic rejects requests without max_tokens, which OpenAI clients usually omit
+	if provider == "anthropic" && ep.native == "" && requestData["max_tokens"] == nil && h.opts.AnthropicDefaultMaxTokens <= 0 {
+		h.writeError(w, http.StatusBadRequest, "max_tokens is required for Anthropic models")
 		return
 	}
 
-	// Set headers
-	for key, value := range headers {
-		upstreamReq.Header.Set(key, value)
+	lb := newLogBuilder(traceID, keyConfig, requestData, prompt, metadata, provider, modelField, startTime, validateSchema)
+	lb.entry.RequestID = requestID
+	lb.entry.ProviderKey = auth.FingerprintProviderKey(realAPIKey)
+	lb.entry.ClientIdentity = clientIdentity(r)
+	lb.entry.ShareTokenID = keyConfig.ShareTokenID
+	if lb.entry.DebugCapture = h.debugCapture(keyConfig); lb.entry.DebugCapture != "" {
+		lb.entry.RawRequest = string(bodyBytes)
+	}
+	lb.entry.Request.RequestedModel = requestedModel
+	if h.opts.RewriteResponseModel && ep.native == "" {
+		lb.responseModel = clientModel
 	}
 
-	// Forward request
-	resp, err := h.httpClient.Do(upstreamReq)
-	if err != nil {
-		h.writeError(w, http.StatusBadGateway, "failed to reach upstream")
+	if !h.checkRequestSize(w, lb) {
 		return
 	}
-	defer resp.Body.Close()
 
-	latencyMs := int(time.Since(startTime).Milliseconds())
+	if !h.checkBudget(w, lb) {
+		return
+	}
 
-	if isStreaming {
-		h.handleStreamingResponse(w, resp, traceID, keyConfig, requestData, provider, modelField, startTime)
-	} else {
-		h.handleJSONResponse(w, resp, traceID, keyConfig, requestData, provider, modelField, latencyMs)
+	lb.annotate = !isStreaming && r.Header.Get(annotateHeader) == "true"
+
+	// Trailers must be announced before the body is written
+	costTrailers := isStreaming && r.Header.Get(costTrailersHeader) == "true"
+	if costTrailers {
+		w.Header().Set("Trailer", costTrailer+", "+tokensTrailer)
+	}
+
+	if timeout := h.upstreamTimeout(isStreaming); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	target := upstreamTarget{provider: provider, model: actualModel, apiKey: realAPIKey}
+	if provider == "openai" {
+		target.openAIOrganization, target.openAIProject = keyConfig.OpenAIOrganization, keyConfig.OpenAIProject
+	}
+	if err := h.forward(ctx, w, ep, requestData, target, isStreaming, costTrailers, lb); err != nil {
+		h.writeError(w, err.status, err.message)
 	}
 }
 
@@ -198,11 +502,12 @@ func (h *Handler) extractAndValidateKey(ctx context.Context, r *http.Request) (*
 		return nil, fmt.Errorf("missing or invalid authorization header")
 	}
 
+	// Share tokens are accepted here too and resolve to their key's narrowed configuration
 	virtualKey := strings.TrimPrefix(authHeader, "Bearer ")
 	return h.keyService.ValidateKey(ctx, virtualKey)
 }
 
-func (h *Handler) handleJSONResponse(w http.ResponseWriter, resp *http.Response, traceID string, keyConfig *models.KeyConfig, requestData map[string]interface{}, provider string, fullModel string, latencyMs int) {
+func (h *Handler) handleJSONResponse(w http.ResponseWriter, resp *http.Response, lb *logBuilder) {
 	// Read response body
 	respBody, err := io.ReadAll(resp.Body)
 	if err != nil {
@@ -210,69 +515,46 @@ func (h *Handler) handleJSONResponse(w http.ResponseWriter, resp *http.Response,
 		return
 	}
 
+	latencyMs := int(time.Since(lb.startTime).Milliseconds())
+
 	// Parse response for logging
 	var responseData map[string]interface{}
 	json.Unmarshal(respBody, &responseData)
 
-	// Extract usage info
-	usage := models.UsageLog{}
-	if u, ok := responseData["usage"].(map[string]interface{}); ok {
-		if pt, ok := u["prompt_tokens"].(float64); ok {
-			usage.PromptTokens = int(pt)
-		}
-		if ct, ok := u["completion_tokens"].(float64); ok {
-			usage.CompletionTokens = int(ct)
-		}
-		usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
-	}
-
-	// Calculate cost using provider
-	cost := h.calculateCost(provider, fullModel, usage)
-
-	// Update spend
-	go func() {
-		ctx := context.Background()
-		if err := h.keyService.UpdateSpend(ctx, keyConfig.KeyID, cost, usage.TotalTokens); err != nil {
-			slog.Error("failed to update spend", "error", err)
-		}
-	}()
-
-	// Log the request
-	logEntry := &models.LogEntry{
-		TraceID:        traceID,
-		Timestamp:      time.Now(),
-		VirtualKeyName: keyConfig.Name,
-		VirtualKeyID:   keyConfig.KeyID,
-		UserID:         keyConfig.UserID,
-		Request: models.RequestLog{
-			Model:    fullModel,
-			Provider: provider,
-			Messages: requestData["messages"],
-		},
-		Response: models.ResponseLog{
-			Content:    extractContent(responseData),
-			Usage:      usage,
-			StatusCode: resp.StatusCode,
-		},
-		Metrics: models.MetricsLog{
-			LatencyMs: latencyMs,
-			CostUSD:   cost,
-		},
-	}
-	h.logPipeline.Log(logEntry)
+	if lb.entry.DebugCapture != "" {
+		lb.entry.RawResponse = string(respBody)
+	}
 
-	// Write response
-	for key, values := range resp.Header {
-		for _, value := range values {
-			w.Header().Add(key, value)
+	usage, _ := extractUsage(responseData)
+	cost := h.complete(lb, models.ResponseLog{
+		Content:     extractContent(responseData),
+		Usage:       usage,
+		StatusCode:  resp.StatusCode,
+		ServedModel: servedModel(responseData),
+	}, latencyMs)
+
+	if lb.responseModel != "" && resp.StatusCode < 400 {
+		if rewritten, ok := replaceModel(respBody, lb.responseModel); ok {
+			respBody = rewritten
 		}
 	}
+
+	if lb.annotate && resp.StatusCode < 400 {
+		annotation := responseAnnotation{TraceID: lb.traceID(), Cost: cost, LatencyMs: latencyMs, Usage: usage}
+		if annotated, ok := annotateResponse(respBody, annotation); ok {
+			respBody = annotated
+		}
+	}
+
+	// Write response
+	h.copyResponseHeaders(w, resp.Header)
 	w.WriteHeader(resp.StatusCode)
 	w.Write(respBody)
 }
 
-func (h *Handler) handleStreamingResponse(w http.ResponseWriter, resp *http.Response, traceID string, keyConfig *models.KeyConfig, requestData map[string]interface{}, provider string, fullModel string, startTime time.Time) {
-	// Set streaming headers
+func (h *Handler) handleStreamingResponse(w http.ResponseWriter, resp *http.Response, lb *logBuilder, stripUsage, costTrailers bool) {
+	// Set streaming headers; rate limit headers are still relayed from upstream
+	h.copyResponseHeaders(w, resp.Header)
 	w.Header().Set("Content-Type", "text/event-stream")
 	w.Header().Set("Cache-Control", "no-cache")
 	w.Header().Set("Connection", "keep-alive")
@@ -284,54 +566,236 @@ func (h *Handler) handleStreamingResponse(w http.ResponseWriter, resp *http.Resp
 		return
 	}
 
-	// Stream response
-	var fullContent strings.Builder
-	var usage models.UsageLog
+	// Watchdog: closing the body unblocks a pending Read when the upstream goes silent
+	var stalled atomic.Bool
+	if h.opts.StreamIdleTimeout > 0 {
+		watchdog := time.AfterFunc(h.opts.StreamIdleTimeout, func() {
+			stalled.Store(true)
+			resp.Body.Close()
+		})
+		defer watchdog.Stop()
+
+		resp.Body = &idleResetReader{
+			ReadCloser: resp.Body,
+			onRead:     func() { watchdog.Reset(h.opts.StreamIdleTimeout) },
+		}
+	}
+
+	// Events are reassembled from lines, however the upstream's writes split them, and
+	// only parsed once complete. Unless an event may be rewritten or dropped, its bytes
+	// are relayed line by line as they arrive rather than held until it is complete.
+	passthrough := !stripUsage && lb.responseModel == ""
+	acc := &streamAccumulator{stripUsage: stripUsage, rewriteModel: lb.responseModel, captureRaw: lb.entry.DebugCapture != ""}
+	reader := bufio.NewReader(resp.Body)
+	var event bytes.Buffer
+
+	// Track the estimated cost against the key's budget as content arrives
+	budget := h.newStreamBudget(lb)
+	overBudget, aborted := false, false
 
-	buf := make([]byte, 4096)
 	for {
-		n, err := resp.Body.Read(buf)
-		if n > 0 {
-			w.Write(buf[:n])
+		line, err := reader.ReadBytes('\n')
+		if stalled.Load() {
+			break
+		}
+		event.Write(line)
+		if passthrough && len(line) > 0 {
+			w.Write(line)
 			flusher.Flush()
+		}
 
-			// Try to extract content from SSE data
-			// This is a simplified version - production would parse SSE properly
-			fullContent.Write(buf[:n])
+		// A blank line terminates an SSE event; forward whatever remains at EOF
+		if len(bytes.TrimSpace(line)) == 0 || err != nil {
+			if event.Len() > 0 {
+				if out, forward := acc.processEvent(event.Bytes()); forward && !passthrough {
+					w.Write(out)
+					flusher.Flush()
+				}
+			}
+			event.Reset()
+
+			if budget != nil && !overBudget && budget.track(acc.content.String()) {
+				overBudget = true
+				slog.Warn("stream exceeded key budget", "trace_id", lb.traceID(), "key_id", lb.keyConfig.KeyID, "abort", budget.abort)
+				h.flagOverBudget(lb)
+				if budget.abort {
+					aborted = true
+					break
+				}
+			}
 		}
-		if err == io.EOF {
+
+		if err != nil {
 			break
 		}
+	}
+
+	// Tell the client why the stream ended early
+	statusCode := resp.StatusCode
+	var streamErr string
+	if stalled.Load() {
+		statusCode = http.StatusGatewayTimeout
+		streamErr = fmt.Sprintf("upstream sent no data for %s", h.opts.StreamIdleTimeout)
+		errEvent, _ := json.Marshal(map[string]interface{}{
+			"error": map[string]string{"message": streamErr, "type": "timeout"},
+		})
+		fmt.Fprintf(w, "event: error\ndata: %s\n\n", errEvent)
+		flusher.Flush()
+		slog.Warn("upstream stream stalled", "trace_id", lb.traceID(), "idle_timeout", h.opts.StreamIdleTimeout)
+	}
+	if aborted {
+		statusCode = http.StatusPaymentRequired
+		streamErr = "stream stopped: estimated cost exceeded the key's budget"
+		errEvent, _ := json.Marshal(map[string]interface{}{
+			"error": map[string]string{"message": streamErr, "type": "budget_exceeded"},
+		})
+		fmt.Fprintf(w, "event: error\ndata: %s\n\n", errEvent)
+		flusher.Flush()
+	}
+
+	// Fall back to the estimate for usage the provider didn't report
+	usage := acc.usage
+	if budget != nil {
+		usage = budget.fill(usage)
+	}
+
+	if acc.captureRaw {
+		lb.entry.RawResponse = acc.raw.String()
+	}
+
+	latencyMs := int(time.Since(lb.startTime).Milliseconds())
+	cost := h.complete(lb, models.ResponseLog{
+		Content:     acc.content.String(),
+		Usage:       usage,
+		StatusCode:  statusCode,
+		Error:       streamErr,
+		OverBudget:  overBudget,
+		ServedModel: acc.servedModel,
+	}, latencyMs)
+
+	if costTrailers {
+		w.Header().Set(costTrailer, strconv.FormatFloat(cost, 'f', 6, 64))
+		w.Header().Set(tokensTrailer, strconv.Itoa(usage.TotalTokens))
+	}
+}
+
+// requestCost is the distribution of per-request cost, labelled by provider and
+// pricing family to keep the number of series small
+var requestCost = metrics.NewHistogramVec("lumina_request_cost_usd",
+	"Cost in USD of completed requests that incurred one",
+	[]float64{0.0001, 0.0005, 0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5},
+	"provider", "model_family")
+
+// complete prices a finished request, records the spend against its key and logs it.
+// It returns the cost.
+func (h *Handler) complete(lb *logBuilder, response models.ResponseLog, latencyMs int) float64 {
+	keyID := lb.keyConfig.KeyID
+	usage := response.Usage
+	cost := h.calculateCost(lb.provider, lb.model, usage)
+
+	// Failed requests usually cost nothing and would swamp the lowest bucket
+	if cost > 0 {
+		_, model, err := parseModel(lb.model)
 		if err != nil {
-			break
+			model = lb.model
+		}
+		requestCost.Observe(cost, lb.provider, pricing.Family(lb.provider, model))
+	}
+
+	// Queued so the response isn't held up; shutdown drains the queue
+	h.recordSpend(keyID, cost, usage.TotalTokens)
+
+	h.logSampled(lb.finish(response, latencyMs, cost), lb.keyConfig)
+	return cost
+}
+
+// idleResetReader calls onRead whenever the wrapped body delivers data
+type idleResetReader struct {
+	io.ReadCloser
+	onRead func()
+}
+
+func (r *idleResetReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if n > 0 {
+		r.onRead()
+	}
+	return n, err
+}
+
+// logSampled sends the entry to the log pipeline subject to the key's sampling rate.
+// Error responses bypass sampling so failures are always visible.
+func (h *Handler) logSampled(entry *models.LogEntry, keyConfig *models.KeyConfig) {
+	rate := h.opts.LogSampleRate
+	if keyConfig.LogSampleRate != nil {
+		rate = *keyConfig.LogSampleRate
+	}
+
+	// Debug captures are always kept
+	if entry.Response.StatusCode < 400 && rate < 1 && entry.DebugCapture == "" {
+		if rand.Float64() >= rate {
+			return
+		}
+		entry.Metrics.SampleRate = rate
+	}
+
+	h.logPipeline.Log(entry)
+}
+
+// clientRequestID returns the caller's request ID from the configured header, and
+// the value to echo back under the same header when echoing is enabled, or empty
+// strings. No ID is generated when absent; the trace ID covers that.
+//
+// The client's ID is recorded alongside the gateway's trace ID rather than
+// replacing it. The trace ID is the log entry's document ID, and a client value
+// isn't unique: every span of a W3C trace shares its trace ID, and retrying
+// clients reuse correlation IDs, so later entries would overwrite earlier ones.
+func (h *Handler) clientRequestID(r *http.Request) (id, echo string) {
+	id = strings.TrimSpace(r.Header.Get(h.opts.RequestIDHeader))
+
+	// A traceparent is echoed whole, since a bare trace ID isn't a valid value
+	if h.opts.RequestIDHeader == traceparentHeader {
+		traceID, ok := parseTraceparent(id)
+		if !ok {
+			return "", ""
 		}
+		id, echo = traceID, id
+	} else {
+		if len(id) > maxRequestIDLength {
+			id = id[:maxRequestIDLength]
+		}
+		echo = id
+	}
+
+	if !h.opts.EchoRequestID {
+		echo = ""
 	}
+	return id, echo
+}
 
-	latencyMs := int(time.Since(startTime).Milliseconds())
+// parseTraceparent extracts the trace ID of a W3C traceparent header value,
+// "<version>-<trace-id>-<parent-id>-<flags>". Future versions may append fields.
+func parseTraceparent(value string) (string, bool) {
+	parts := strings.Split(value, "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || (parts[0] == "00" && len(parts) != 4) {
+		return "", false
+	}
+	traceID, parentID, flags := parts[1], parts[2], parts[3]
+	if len(traceID) != 32 || len(parentID) != 16 || len(flags) != 2 ||
+		!isLowerHex(parts[0]+traceID+parentID+flags) ||
+		traceID == strings.Repeat("0", 32) || parentID == strings.Repeat("0", 16) {
+		return "", false
+	}
+	return traceID, true
+}
 
-	// Log the streaming request (with partial data)
-	logEntry := &models.LogEntry{
-		TraceID:        traceID,
-		Timestamp:      time.Now(),
-		VirtualKeyName: keyConfig.Name,
-		VirtualKeyID:   keyConfig.KeyID,
-		UserID:         keyConfig.UserID,
-		Request: models.RequestLog{
-			Model:    fullModel,
-			Provider: provider,
-			Messages: requestData["messages"],
-		},
-		Response: models.ResponseLog{
-			Content:    "[streaming response]",
-			Usage:      usage,
-			StatusCode: resp.StatusCode,
-		},
-		Metrics: models.MetricsLog{
-			LatencyMs: latencyMs,
-			CostUSD:   0, // Estimated separately for streaming
-		},
+func isLowerHex(s string) bool {
+	for _, c := range s {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
 	}
-	h.logPipeline.Log(logEntry)
+	return true
 }
 
 func (h *Handler) writeError(w http.ResponseWriter, status int, message string) {
@@ -340,6 +804,21 @@ func (h *Handler) writeError(w http.ResponseWriter, status int, message string)
 	json.NewEncoder(w).Encode(map[string]string{"error": message})
 }
 
+// Error codes let clients tell apart errors that share a status
+const (
+	errorCodeNoProviders           = "no_providers_configured" // The account has no provider API keys at all
+	errorCodeProviderNotConfigured = "provider_not_configured" // The account has none for the requested provider
+	errorCodeUnknownPricing        = "unknown_model_pricing"   // The model has no price and UnknownPricing rejects it
+	errorCodeRequestQuota          = "request_quota_exceeded"  // The key used up its monthly request quota
+)
+
+// writeErrorCode writes an error with a machine-readable code alongside the message
+func (h *Handler) writeErrorCode(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": message, "code": code})
+}
+
 func extractModel(data map[string]interface{}) string {
 	if model, ok := data["model"].(string); ok {
 		return model
@@ -347,6 +826,209 @@ func extractModel(data map[string]interface{}) string {
 	return "unknown"
 }
 
+// extractMetadata collects client tags from the metadata header and the body's
+// metadata field (header values win), removing the field from the request so it
+// isn't forwarded upstream
+func extractMetadata(r *http.Request, requestData map[string]interface{}) (map[string]string, error) {
+	raw := map[string]interface{}{}
+
+	if body, ok := requestData["metadata"]; ok {
+		obj, ok := body.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("metadata must be a JSON object")
+		}
+		for k, v := range obj {
+			raw[k] = v
+		}
+		delete(requestData, "metadata")
+	}
+
+	if header := r.Header.Get(metadataHeader); header != "" {
+		if len(header) > maxMetadataBytes {
+			return nil, fmt.Errorf("%s must not exceed %d bytes", metadataHeader, maxMetadataBytes)
+		}
+		var obj map[string]interface{}
+		if err := json.Unmarshal([]byte(header), &obj); err != nil {
+			return nil, fmt.Errorf("%s must be a JSON object", metadataHeader)
+		}
+		for k, v := range obj {
+			raw[k] = v
+		}
+	}
+
+	if len(raw) == 0 {
+		return nil, nil
+	}
+	if len(raw) > maxMetadataKeys {
+		return nil, fmt.Errorf("metadata must not have more than %d keys", maxMetadataKeys)
+	}
+
+	metadata := make(map[string]string, len(raw))
+	size := 0
+	for k, v := range raw {
+		if k == "" || strings.ContainsAny(k, ".*") {
+			return nil, fmt.Errorf("invalid metadata key %q", k)
+		}
+		switch v := v.(type) {
+		case string:
+			metadata[k] = v
+		case json.Number, float64, bool:
+			metadata[k] = fmt.Sprint(v)
+		default:
+			return nil, fmt.Errorf("metadata value for %q must be a string, number or boolean", k)
+		}
+		size += len(k) + len(metadata[k])
+	}
+	if size > maxMetadataBytes {
+		return nil, fmt.Errorf("metadata must not exceed %d bytes", maxMetadataBytes)
+	}
+
+	return metadata, nil
+}
+
+// injectStreamUsage sets stream_options.include_usage on the request unless the
+// client already configured it, and reports whether it was injected
+func injectStreamUsage(requestData map[string]interface{}) bool {
+	// Copy rather than update stream_options, which may be shared with the client's request
+	streamOptions, _ := requestData["stream_options"].(map[string]interface{})
+	streamOptions = maps.Clone(streamOptions)
+	if streamOptions == nil {
+		streamOptions = map[string]interface{}{}
+	}
+	if _, set := streamOptions["include_usage"]; set {
+		return false
+	}
+
+	streamOptions["include_usage"] = true
+	requestData["stream_options"] = streamOptions
+	return true
+}
+
+// extractUsage reads the token usage block from a response or streaming chunk,
+// accepting both OpenAI (prompt/completion) and Anthropic (input/output) token names
+func extractUsage(data map[string]interface{}) (models.UsageLog, bool) {
+	usage := models.UsageLog{}
+	u, ok := data["usage"].(map[string]interface{})
+	if !ok {
+		return usage, false
+	}
+
+	// OpenAI format
+	if pt, ok := u["prompt_tokens"].(float64); ok {
+		usage.PromptTokens = int(pt)
+	}
+	if ct, ok := u["completion_tokens"].(float64); ok {
+		usage.CompletionTokens = int(ct)
+	}
+
+	if details, ok := u["prompt_tokens_details"].(map[string]interface{}); ok {
+		if cached, ok := details["cached_tokens"].(float64); ok {
+			usage.CachedTokens = int(cached)
+		}
+		if images, ok := details["image_tokens"].(float64); ok {
+			usage.ImageTokens = int(images)
+		}
+	}
+
+	// Anthropic format. Cache reads and writes are reported apart from input_tokens,
+	// so fold them in to keep PromptTokens comparable across providers.
+	if it, ok := u["input_tokens"].(float64); ok {
+		usage.PromptTokens = int(it)
+		if read, ok := u["cache_read_input_tokens"].(float64); ok {
+			usage.PromptTokens += int(read)
+			usage.CachedTokens = int(read)
+		}
+		if created, ok := u["cache_creation_input_tokens"].(float64); ok {
+			usage.PromptTokens += int(created)
+		}
+	}
+	if ot, ok := u["output_tokens"].(float64); ok {
+		usage.CompletionTokens = int(ot)
+	}
+
+	// Image generation models report image input tokens separately
+	if details, ok := u["input_tokens_details"].(map[string]interface{}); ok {
+		if images, ok := details["image_tokens"].(float64); ok {
+			usage.ImageTokens = int(images)
+		}
+	}
+
+	// Transcription models bill by duration
+	if u["type"] == "duration" {
+		if seconds, ok := u["seconds"].(float64); ok {
+			usage.AudioSeconds = seconds
+		}
+	}
+
+	usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+
+	return usage, true
+}
+
+// validateStructuredOutput checks the response content against the request's
+// json_schema response_format, returning the violations found (nil when the request
+// has no schema or the content conforms)
+func validateStructuredOutput(requestData map[string]interface{}, content string) []string {
+	format, ok := requestData["response_format"].(map[string]interface{})
+	if !ok || format["type"] != "json_schema" {
+		return nil
+	}
+	jsonSchema, ok := format["json_schema"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	s, ok := jsonSchema["schema"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+
+	var doc interface{}
+	decoder := json.NewDecoder(strings.NewReader(content))
+	decoder.UseNumber()
+	if err := decoder.Decode(&doc); err != nil {
+		return []string{"$: response content is not valid JSON"}
+	}
+
+	return schema.Validate(s, doc)
+}
+
+// extractSystemPrompt returns the top-level system prompt Anthropic requests carry
+// outside of messages, either as a string or as a list of text blocks
+func extractSystemPrompt(requestData map[string]interface{}) string {
+	switch system := requestData["system"].(type) {
+	case string:
+		return system
+	case []interface{}:
+		var parts []string
+		for _, block := range system {
+			if b, ok := block.(map[string]interface{}); ok {
+				if text, ok := b["text"].(string); ok {
+					parts = append(parts, text)
+				}
+			}
+		}
+		return strings.Join(parts, "\n")
+	}
+	return ""
+}
+
+// extractPrompt returns the prompt of a legacy completion request for logging.
+// Prompts given as arrays of strings or token IDs are kept as JSON.
+func extractPrompt(requestData map[string]interface{}) string {
+	switch prompt := requestData["prompt"].(type) {
+	case nil:
+		return ""
+	case string:
+		return prompt
+	default:
+		encoded, _ := json.Marshal(prompt)
+		return string(encoded)
+	}
+}
+
+// extractContent returns the generated text of a response. Only the text is
+// kept for logging, so sibling structures such as logprobs never reach the index
+// while the client still receives the upstream body unchanged.
 func extractContent(data map[string]interface{}) string {
 	// OpenAI format
 	if choices, ok := data["choices"].([]interface{}); ok && len(choices) > 0 {
@@ -356,72 +1038,47 @@ func extractContent(data map[string]interface{}) string {
 					return content
 				}
 			}
+			// Legacy completions return text directly on the choice, next to logprobs
+			if text, ok := choice["text"].(string); ok {
+				return text
+			}
 		}
 	}
 
-	// Anthropic format
+	// Anthropic format: concatenate all text blocks, skipping tool_use and other block types
 	if content, ok := data["content"].([]interface{}); ok && len(content) > 0 {
-		if item, ok := content[0].(map[string]interface{}); ok {
-			if text, ok := item["text"].(string); ok {
-				return text
+		var text strings.Builder
+		for _, block := range content {
+			if item, ok := block.(map[string]interface{}); ok {
+				if t, ok := item["text"].(string); ok {
+					text.WriteString(t)
+				}
 			}
 		}
+		return text.String()
 	}
 
 	return ""
 }
 
 func (h *Handler) calculateCost(provider string, model string, usage models.UsageLog) float64 {
-	// Pricing per 1M tokens (simplified)
-	var inputPrice, outputPrice float64
-
 	// Extract just the model name if full format provided
 	_, actualModel, err := parseModel(model)
 	if err != nil {
 		actualModel = model
 	}
+	price := pricing.Lookup(provider, actualModel)
 
-	switch provider {
-	case "openai":
-		switch {
-		case strings.HasPrefix(actualModel, "gpt-4o"):
-			inputPrice = 2.50
-			outputPrice = 10.00
-		case strings.HasPrefix(actualModel, "gpt-4"):
-			inputPrice = 30.00
-			outputPrice = 60.00
-		case strings.HasPrefix(actualModel, "gpt-3.5"):
-			inputPrice = 0.50
-			outputPrice = 1.50
-		case strings.HasPrefix(actualModel, "o1"):
-			inputPrice = 15.00
-			outputPrice = 60.00
-		default:
-			inputPrice = 1.00
-			outputPrice = 2.00
-		}
-	case "anthropic":
-		switch {
-		case strings.Contains(actualModel, "opus"):
-			inputPrice = 15.00
-			outputPrice = 75.00
-		case strings.Contains(actualModel, "sonnet"):
-			inputPrice = 3.00
-			outputPrice = 15.00
-		case strings.Contains(actualModel, "haiku"):
-			inputPrice = 0.25
-			outputPrice = 1.25
-		default:
-			inputPrice = 3.00
-			outputPrice = 15.00
-		}
-	default:
-		inputPrice = 1.00
-		outputPrice = 2.00
+	textTokens := usage.PromptTokens - usage.CachedTokens - usage.ImageTokens
+	if textTokens < 0 {
+		textTokens = 0
 	}
 
-	inputCost := float64(usage.PromptTokens) / 1_000_000 * inputPrice
-	outputCost := float64(usage.CompletionTokens) / 1_000_000 * outputPrice
+	inputCost := float64(textTokens) / 1_000_000 * price.Input
+	cachedCost := float64(usage.CachedTokens) / 1_000_000 * price.CachedInput
+	imageCost := float64(usage.ImageTokens) / 1_000_000 * price.ImageInput
+	outputCost := float64(usage.CompletionTokens) / 1_000_000 * price.Output
+	audioCost := usage.AudioSeconds / 60 * price.AudioPerMinute
 
-	return inputCost + outputCost
+	return inputCost + cachedCost + imageCost + outputCost + audioCost
 }
@@ -0,0 +1,525 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+
+	"github.com/lumina/gateway/internal/auth"
+	"github.com/lumina/gateway/internal/cache"
+	"github.com/lumina/gateway/internal/logging"
+	"github.com/lumina/gateway/internal/models"
+)
+
+// spendRecord is one call to the handler's recordSpend
+type spendRecord struct {
+	keyID  string
+	cost   float64
+	tokens int
+}
+
+// testHandler is a proxy handler backed by an in-memory Redis and a log pipeline
+// whose OpenSearch accepts everything. Spend is recorded in memory rather than
+// in a database, and every request is logged unless opts sets a sample rate.
+type testHandler struct {
+	*Handler
+	redis *miniredis.Miniredis
+	cache *cache.Cache
+	logs  *logging.TailSubscription
+
+	mu    sync.Mutex
+	spend []spendRecord
+}
+
+const testUserID = "user-1"
+
+func newTestHandler(t *testing.T, opts Options) *testHandler {
+	t.Helper()
+	if opts.LogSampleRate == 0 {
+		opts.LogSampleRate = 1
+	}
+
+	mr := miniredis.RunT(t)
+	c, err := cache.New("redis://"+mr.Addr(), cache.Options{})
+	if err != nil {
+		t.Fatalf("cache.New: %v", err)
+	}
+	t.Cleanup(func() { c.Close() })
+
+	opensearch := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		w.Write([]byte(`{}`))
+	}))
+	t.Cleanup(opensearch.Close)
+	pipeline, err := logging.New([]string{opensearch.URL}, logging.Options{TailSubscribers: 1, TailBuffer: 16})
+	if err != nil {
+		t.Fatalf("logging.New: %v", err)
+	}
+	t.Cleanup(func() { pipeline.Close() })
+	logs, err := pipeline.Tail(testUserID)
+	if err != nil {
+		t.Fatalf("Tail: %v", err)
+	}
+
+	keyService := auth.NewKeyService(nil, c, auth.Keyring{}, nil, auth.Policy{})
+	th := &testHandler{Handler: NewHandler(keyService, pipeline, c, opts), redis: mr, cache: c, logs: logs}
+	th.recordSpend = func(keyID string, cost float64, tokens int) {
+		th.mu.Lock()
+		defer th.mu.Unlock()
+		th.spend = append(th.spend, spendRecord{keyID, cost, tokens})
+	}
+	return th
+}
+
+// nextLog waits for the next entry logged for the test user and returns it as
+// the JSON document sent to OpenSearch
+func (th *testHandler) nextLog(t *testing.T) map[string]interface{} {
+	t.Helper()
+	select {
+	case doc := <-th.logs.Entries():
+		encoded, err := json.Marshal(doc)
+		if err != nil {
+			t.Fatalf("marshal log entry: %v", err)
+		}
+		var entry map[string]interface{}
+		if err := json.Unmarshal(encoded, &entry); err != nil {
+			t.Fatalf("unmarshal log entry: %v", err)
+		}
+		return entry
+	case <-time.After(2 * time.Second):
+		t.Fatal("no log entry")
+		return nil
+	}
+}
+
+// spendRecords returns the spend recorded so far
+func (th *testHandler) spendRecords() []spendRecord {
+	th.mu.Lock()
+	defer th.mu.Unlock()
+	return append([]spendRecord(nil), th.spend...)
+}
+
+// addKey caches config under a new virtual key and returns the key. Its usage is
+// marked as recently recorded so that lookups don't write to the database.
+func (th *testHandler) addKey(t *testing.T, config *models.KeyConfig) string {
+	t.Helper()
+	virtualKey := th.keyService.GenerateVirtualKey()
+	if err := th.cache.SetKeyConfig(t.Context(), th.keyService.HashKey(virtualKey), config); err != nil {
+		t.Fatalf("SetKeyConfig: %v", err)
+	}
+	th.redis.Set("last_used:key:"+config.KeyID, "1")
+	for provider := range config.Providers {
+		th.redis.Set("last_used:provider:"+config.UserID+":"+provider, "1")
+	}
+	return virtualKey
+}
+
+// upstream sends provider requests to handler instead of the real APIs. The
+// request's Host still names the provider's API.
+func (th *testHandler) upstream(t *testing.T, handler http.HandlerFunc) {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	target, _ := url.Parse(server.URL)
+	th.httpClient = &http.Client{Transport: redirectTransport{target: target}}
+}
+
+// redirectTransport sends every request to target
+type redirectTransport struct {
+	target *url.URL
+}
+
+func (rt redirectTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	r = r.Clone(r.Context())
+	r.URL.Scheme = rt.target.Scheme
+	r.URL.Host = rt.target.Host
+	return http.DefaultTransport.RoundTrip(r)
+}
+
+// proxyRequest sends a client request with virtualKey to a proxy endpoint handler
+func proxyRequest(serve http.HandlerFunc, virtualKey, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, "/v1/test", strings.NewReader(body))
+	r.Header.Set("Authorization", "Bearer "+virtualKey)
+	r.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	serve(rec, r)
+	return rec
+}
+
+// testKeyConfig is a key of the test user with credentials for every provider
+func testKeyConfig() *models.KeyConfig {
+	return &models.KeyConfig{
+		KeyID:     "key-1",
+		UserID:    testUserID,
+		Name:      "test",
+		Providers: map[string]string{"openai": "sk-openai", "anthropic": "sk-ant"},
+	}
+}
+
+// splitReader returns its chunks one Read at a time, like an upstream flushing
+// partial writes
+type splitReader struct {
+	chunks []string
+}
+
+func (r *splitReader) Read(p []byte) (int, error) {
+	if len(r.chunks) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p, r.chunks[0])
+	if n < len(r.chunks[0]) {
+		r.chunks[0] = r.chunks[0][n:]
+	} else {
+		r.chunks = r.chunks[1:]
+	}
+	return n, nil
+}
+
+// streamResponse wraps body as an upstream SSE response
+func streamResponse(body io.Reader) *http.Response {
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": []string{"text/event-stream"}},
+		Body:       io.NopCloser(body),
+	}
+}
+
+// logUsage returns the usage recorded in a log entry
+func logUsage(t *testing.T, entry map[string]interface{}) map[string]interface{} {
+	t.Helper()
+	response, _ := entry["response"].(map[string]interface{})
+	usage, ok := response["usage"].(map[string]interface{})
+	if !ok {
+		t.Fatalf("log entry has no usage: %v", entry)
+	}
+	return usage
+}
+
+func TestHandleStreamingResponseUsageSplitAcrossReads(t *testing.T) {
+	th := newTestHandler(t, Options{})
+
+	stream := "data: {\"id\":\"c1\",\"model\":\"gpt-4o\",\"choices\":[{\"index\":0,\"delta\":{\"content\":\"Hello\"}}]}\n\n" +
+		"data: {\"id\":\"c1\",\"model\":\"gpt-4o\",\"choices\":[],\"usage\":{\"prompt_tokens\":12,\"completion_tokens\":34,\"total_tokens\":46}}\n\n" +
+		"data: [DONE]\n\n"
+	split := bytes.Index([]byte(stream), []byte(`"completion_tokens"`)) + 5
+
+	tests := []struct {
+		name   string
+		chunks []string
+	}{
+		{"mid usage json", []string{stream[:split], stream[split:]}},
+		{"one byte reads", splitEvery(stream, 1)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			requestData := map[string]interface{}{"model": "openai/gpt-4o"}
+			lb := newLogBuilder("trace-1", testKeyConfig(), requestData, newPromptTokens(requestData), nil, "openai", "openai/gpt-4o", time.Now(), false)
+			rec := httptest.NewRecorder()
+			th.handleStreamingResponse(rec, streamResponse(&splitReader{chunks: tt.chunks}), lb, false, false)
+
+			if got := rec.Body.String(); got != stream {
+				t.Errorf("relayed stream = %q, want it unchanged", got)
+			}
+
+			usage := logUsage(t, th.nextLog(t))
+			if usage["prompt_tokens"] != float64(12) || usage["completion_tokens"] != float64(34) || usage["total_tokens"] != float64(46) {
+				t.Errorf("logged usage = %v, want 12 prompt and 34 completion tokens", usage)
+			}
+			records := th.spendRecords()
+			if last := records[len(records)-1]; last.tokens != 46 || last.cost <= 0 {
+				t.Errorf("recorded spend = %+v, want 46 tokens at a positive cost", last)
+			}
+		})
+	}
+}
+
+// logContent returns the response content recorded in a log entry
+func logContent(entry map[string]interface{}) string {
+	response, _ := entry["response"].(map[string]interface{})
+	content, _ := response["content"].(string)
+	return content
+}
+
+// jsonResponse wraps body as an upstream JSON response
+func jsonResponse(body []byte) *http.Response {
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Body:       io.NopCloser(bytes.NewReader(body)),
+	}
+}
+
+// readTestdata returns a recorded upstream response
+func readTestdata(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile("testdata/" + name)
+	if err != nil {
+		t.Fatalf("read testdata: %v", err)
+	}
+	return data
+}
+
+func TestExtractUsageAnthropic(t *testing.T) {
+	var message map[string]interface{}
+	if err := json.Unmarshal(readTestdata(t, "anthropic_message.json"), &message); err != nil {
+		t.Fatal(err)
+	}
+	usage, ok := extractUsage(message)
+	if !ok || usage.PromptTokens != 12 || usage.CompletionTokens != 12 || usage.TotalTokens != 24 {
+		t.Errorf("extractUsage = %+v, %v; want 12 input and 12 output tokens", usage, ok)
+	}
+
+	// Cache reads and writes count as prompt tokens
+	cached := map[string]interface{}{"usage": map[string]interface{}{
+		"input_tokens": float64(10), "cache_read_input_tokens": float64(100), "cache_creation_input_tokens": float64(50), "output_tokens": float64(5),
+	}}
+	usage, _ = extractUsage(cached)
+	if usage.PromptTokens != 160 || usage.CachedTokens != 100 || usage.TotalTokens != 165 {
+		t.Errorf("extractUsage with cache tokens = %+v, want 160 prompt tokens of which 100 cached", usage)
+	}
+}
+
+func TestAnthropicMessageResponse(t *testing.T) {
+	th := newTestHandler(t, Options{})
+	model := "anthropic/claude-3-5-sonnet-20241022"
+	body := readTestdata(t, "anthropic_message.json")
+
+	requestData := map[string]interface{}{"model": model}
+	lb := newLogBuilder("trace-1", testKeyConfig(), requestData, newPromptTokens(requestData), nil, "anthropic", model, time.Now(), false)
+	rec := httptest.NewRecorder()
+	th.handleJSONResponse(rec, jsonResponse(body), lb)
+
+	if !bytes.Equal(rec.Body.Bytes(), body) {
+		t.Errorf("relayed body = %s, want it unchanged", rec.Body.Bytes())
+	}
+	entry := th.nextLog(t)
+	if got := logContent(entry); got != "Hello! How can I help you today?" {
+		t.Errorf("logged content = %q", got)
+	}
+	if usage := logUsage(t, entry); usage["prompt_tokens"] != float64(12) || usage["completion_tokens"] != float64(12) {
+		t.Errorf("logged usage = %v, want 12 input and 12 output tokens", usage)
+	}
+	if spend := th.spendRecords(); len(spend) != 1 || spend[0].cost <= 0 || spend[0].tokens != 24 {
+		t.Errorf("recorded spend = %+v, want 24 tokens at a positive cost", spend)
+	}
+}
+
+func TestAnthropicMessageStream(t *testing.T) {
+	th := newTestHandler(t, Options{})
+	model := "anthropic/claude-3-5-sonnet-20241022"
+	stream := readTestdata(t, "anthropic_stream.txt")
+
+	requestData := map[string]interface{}{"model": model, "stream": true}
+	lb := newLogBuilder("trace-1", testKeyConfig(), requestData, newPromptTokens(requestData), nil, "anthropic", model, time.Now(), false)
+	rec := httptest.NewRecorder()
+	th.handleStreamingResponse(rec, streamResponse(bytes.NewReader(stream)), lb, false, false)
+
+	if !bytes.Equal(rec.Body.Bytes(), stream) {
+		t.Errorf("relayed stream = %s, want it unchanged", rec.Body.Bytes())
+	}
+	entry := th.nextLog(t)
+	if got := logContent(entry); got != "Hello! How can I help you today?" {
+		t.Errorf("logged content = %q", got)
+	}
+	// Input tokens arrive on message_start, output tokens on message_delta
+	if usage := logUsage(t, entry); usage["prompt_tokens"] != float64(12) || usage["completion_tokens"] != float64(12) {
+		t.Errorf("logged usage = %v, want 12 input and 12 output tokens", usage)
+	}
+	if spend := th.spendRecords(); len(spend) != 1 || spend[0].cost <= 0 || spend[0].tokens != 24 {
+		t.Errorf("recorded spend = %+v, want 24 tokens at a positive cost", spend)
+	}
+}
+
+func TestLogprobsNotLogged(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		content string
+	}{
+		{
+			"chat",
+			`{"id":"chatcmpl-1","object":"chat.completion","model":"gpt-4o-2024-08-06","choices":[{"index":0,"message":{"role":"assistant","content":"Hi there"},` +
+				`"logprobs":{"content":[{"token":"Hi","logprob":-0.01,"bytes":[72,105],"top_logprobs":[{"token":"Hi","logprob":-0.01,"bytes":[72,105]}]},` +
+				`{"token":" there","logprob":-0.2,"bytes":[32,116,104,101,114,101],"top_logprobs":[]}]},"finish_reason":"stop"}],` +
+				`"usage":{"prompt_tokens":5,"completion_tokens":2,"total_tokens":7}}`,
+			"Hi there",
+		},
+		{
+			"legacy completion",
+			`{"id":"cmpl-1","object":"text_completion","model":"gpt-3.5-turbo-instruct","choices":[{"index":0,"text":"Hi there",` +
+				`"logprobs":{"tokens":["Hi"," there"],"token_logprobs":[-0.01,-0.2],"top_logprobs":[{"Hi":-0.01},{" there":-0.2}],"text_offset":[0,2]},"finish_reason":"stop"}],` +
+				`"usage":{"prompt_tokens":5,"completion_tokens":2,"total_tokens":7}}`,
+			"Hi there",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			th := newTestHandler(t, Options{})
+			requestData := map[string]interface{}{"model": "openai/gpt-4o", "logprobs": true}
+			lb := newLogBuilder("trace-1", testKeyConfig(), requestData, newPromptTokens(requestData), nil, "openai", "openai/gpt-4o", time.Now(), false)
+			rec := httptest.NewRecorder()
+			th.handleJSONResponse(rec, jsonResponse([]byte(tt.body)), lb)
+
+			// The client still gets the logprobs
+			if rec.Body.String() != tt.body {
+				t.Errorf("relayed body = %s, want it unchanged", rec.Body.String())
+			}
+
+			entry := th.nextLog(t)
+			if got := logContent(entry); got != tt.content {
+				t.Errorf("logged content = %q, want %q", got, tt.content)
+			}
+			encoded, _ := json.Marshal(entry)
+			if bytes.Contains(encoded, []byte("logprob")) {
+				t.Errorf("log entry contains logprobs: %s", encoded)
+			}
+		})
+	}
+}
+
+func TestAnthropicNativeBareModel(t *testing.T) {
+	// Parameter limits and response model rewriting apply to the unified routes only
+	th := newTestHandler(t, Options{RewriteResponseModel: true})
+	config := testKeyConfig()
+	defaultTemperature, maxTopK := 0.2, 3.0
+	config.ParamLimits = map[string]models.ParamLimit{
+		"temperature": {Default: &defaultTemperature},
+		"top_k":       {Max: &maxTopK},
+	}
+	virtualKey := th.addKey(t, config)
+
+	var upstreamHost, upstreamPath, upstreamKey string
+	var upstreamBody []byte
+	th.upstream(t, func(w http.ResponseWriter, r *http.Request) {
+		upstreamHost, upstreamPath, upstreamKey = r.Host, r.URL.Path, r.Header.Get("x-api-key")
+		upstreamBody, _ = io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(readTestdata(t, "anthropic_message.json"))
+	})
+
+	// Fields the unified route would rewrite, such as metadata, pass through as sent
+	body := `{"model":"claude-3-5-sonnet-20241022","max_tokens":1024,"system":"Be brief.","metadata":{"user_id":"u-42","team":"search"},"top_k":5,` +
+		`"messages":[{"role":"user","content":[{"type":"text","text":"Hello","cache_control":{"type":"ephemeral"}}]}]}`
+	rec := proxyRequest(th.AnthropicNativeMessages, virtualKey, body)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", rec.Code, rec.Body.String())
+	}
+
+	if got, want := rec.Body.Bytes(), readTestdata(t, "anthropic_message.json"); !bytes.Equal(got, want) {
+		t.Errorf("response = %s, want the provider's response unchanged", got)
+	}
+	if upstreamHost != "api.anthropic.com" || upstreamPath != "/v1/messages" || upstreamKey != "sk-ant" {
+		t.Errorf("upstream request went to %s%s with key %q, want Anthropic's messages API", upstreamHost, upstreamPath, upstreamKey)
+	}
+	var sent, received interface{}
+	json.Unmarshal([]byte(body), &sent)
+	if err := json.Unmarshal(upstreamBody, &received); err != nil || !reflect.DeepEqual(sent, received) {
+		t.Errorf("upstream body = %s, want the client's body unchanged", upstreamBody)
+	}
+
+	entry := th.nextLog(t)
+	request, _ := entry["request"].(map[string]interface{})
+	if request["model"] != "anthropic/claude-3-5-sonnet-20241022" || request["provider"] != "anthropic" {
+		t.Errorf("logged model = %v from %v, want anthropic/claude-3-5-sonnet-20241022", request["model"], request["provider"])
+	}
+	if spend := th.spendRecords(); len(spend) != 1 || spend[0].cost <= 0 {
+		t.Errorf("recorded spend = %+v, want a positive cost", spend)
+	}
+}
+
+func TestAnthropicNativeRejectsOtherProviders(t *testing.T) {
+	th := newTestHandler(t, Options{})
+	virtualKey := th.addKey(t, testKeyConfig())
+	th.upstream(t, func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("unexpected upstream request to %s%s", r.Host, r.URL.Path)
+	})
+
+	rec := proxyRequest(th.AnthropicNativeMessages, virtualKey, `{"model":"openai/gpt-4o","max_tokens":10,"messages":[{"role":"user","content":"Hello"}]}`)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want 400", rec.Code)
+	}
+}
+
+// splitEvery cuts s into chunks of n bytes
+func splitEvery(s string, n int) []string {
+	var chunks []string
+	for len(s) > n {
+		chunks = append(chunks, s[:n])
+		s = s[n:]
+	}
+	return append(chunks, s)
+}
+
+func TestParseTraceparent(t *testing.T) {
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	tests := []struct {
+		name  string
+		value string
+		want  string
+	}{
+		{"valid", "00-" + traceID + "-00f067aa0ba902b7-01", traceID},
+		{"future version with extra field", "01-" + traceID + "-00f067aa0ba902b7-01-extra", traceID},
+		{"version 00 with extra field", "00-" + traceID + "-00f067aa0ba902b7-01-extra", ""},
+		{"invalid version ff", "ff-" + traceID + "-00f067aa0ba902b7-01", ""},
+		{"uppercase hex", "00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01", ""},
+		{"non-hex", "00-" + traceID + "-00f067aa0ba902bz-01", ""},
+		{"all-zero trace ID", "00-00000000000000000000000000000000-00f067aa0ba902b7-01", ""},
+		{"all-zero parent ID", "00-" + traceID + "-0000000000000000-01", ""},
+		{"short trace ID", "00-4bf92f3577b34da6-00f067aa0ba902b7-01", ""},
+		{"missing flags", "00-" + traceID + "-00f067aa0ba902b7", ""},
+		{"empty", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := parseTraceparent(tt.value)
+			if got != tt.want || ok != (tt.want != "") {
+				t.Errorf("parseTraceparent(%q) = %q, %v, want %q", tt.value, got, ok, tt.want)
+			}
+		})
+	}
+}
+
+func TestClientRequestID(t *testing.T) {
+	const traceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	tests := []struct {
+		name     string
+		header   string
+		echo     bool
+		value    string
+		wantID   string
+		wantEcho string
+	}{
+		{"request ID", "X-Request-Id", true, "req-1", "req-1", "req-1"},
+		{"request ID not echoed", "X-Request-Id", false, "req-1", "req-1", ""},
+		{"correlation ID", "X-Correlation-Id", true, "corr-1", "corr-1", "corr-1"},
+		{"traceparent", "traceparent", true, traceparent, "4bf92f3577b34da6a3ce929d0e0e4736", traceparent},
+		{"traceparent not echoed", "traceparent", false, traceparent, "4bf92f3577b34da6a3ce929d0e0e4736", ""},
+		{"malformed traceparent", "traceparent", true, "00-abc-def-01", "", ""},
+		{"absent", "X-Request-Id", true, "", "", ""},
+		{"too long", "X-Request-Id", true, strings.Repeat("a", 200), strings.Repeat("a", maxRequestIDLength), strings.Repeat("a", maxRequestIDLength)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewHandler(nil, nil, nil, Options{EchoRequestID: tt.echo, RequestIDHeader: tt.header})
+			r := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+			if tt.value != "" {
+				r.Header.Set(tt.header, tt.value)
+			}
+			id, echo := h.clientRequestID(r)
+			if id != tt.wantID || echo != tt.wantEcho {
+				t.Errorf("clientRequestID() = %q, %q, want %q, %q", id, echo, tt.wantID, tt.wantEcho)
+			}
+		})
+	}
+}
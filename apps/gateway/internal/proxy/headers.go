@@ -0,0 +1,39 @@
+package proxy
+
+import (
+	"net/http"
+	"strings"
+)
+
+// forwardHeader reports whether an upstream response header is on the allow-list.
+// Entries match case-insensitively and may end in "*" to match a prefix, e.g.
+// "x-ratelimit-*". Content-Type is always forwarded.
+func (h *Handler) forwardHeader(name string) bool {
+	if strings.EqualFold(name, "Content-Type") {
+		return true
+	}
+	for _, allowed := range h.opts.ForwardedHeaders {
+		if prefix, ok := strings.CutSuffix(allowed, "*"); ok {
+			if len(name) >= len(prefix) && strings.EqualFold(name[:len(prefix)], prefix) {
+				return true
+			}
+		} else if strings.EqualFold(name, allowed) {
+			return true
+		}
+	}
+	return false
+}
+
+// copyResponseHeaders relays allow-listed upstream headers to the client, keeping
+// the gateway's own headers over upstream ones. Everything else, such as
+// Set-Cookie, provider CORS headers and internal request IDs, is dropped.
+func (h *Handler) copyResponseHeaders(w http.ResponseWriter, upstream http.Header) {
+	for key, values := range upstream {
+		if _, set := w.Header()[key]; set || !h.forwardHeader(key) {
+			continue
+		}
+		for _, value := range values {
+			w.Header().Add(key, value)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package proxy
+
+import (
+	"time"
+
+	"github.com/lumina/gateway/internal/models"
+)
+
+// logBuilder is seeded with everything known about a request before it is sent
+// upstream, so the JSON and streaming paths produce log entries the same way
+type logBuilder struct {
+	keyConfig      *models.KeyConfig
+	requestData    map[string]interface{}
+	provider       string
+	model          string // Full "provider/model" name
+	startTime      time.Time
+	validateSchema bool
+	responseModel  string        // Model name reported back to the client, empty keeps the served one
+	overBudget     bool          // Set once the request is known to exceed the key's budget
+	annotate       bool          // Add a "_lumina" object with cost and latency to a non-streaming response
+	prompt         *promptTokens // Prompt token counts, shared with model routing
+	entry          *models.LogEntry
+}
+
+func newLogBuilder(traceID string, keyConfig *models.KeyConfig, requestData map[string]interface{}, prompt *promptTokens, metadata map[string]string, provider, model string, startTime time.Time, validateSchema bool) *logBuilder {
+	return &logBuilder{
+		keyConfig:      keyConfig,
+		requestData:    requestData,
+		provider:       provider,
+		model:          model,
+		startTime:      startTime,
+		validateSchema: validateSchema,
+		prompt:         prompt,
+		entry: &models.LogEntry{
+			TraceID:        traceID,
+			VirtualKeyName: keyConfig.Name,
+			VirtualKeyID:   keyConfig.KeyID,
+			UserID:         keyConfig.UserID,
+			Metadata:       metadata,
+			Request: models.RequestLog{
+				Model:    model,
+				Provider: provider,
+				Messages: requestData["messages"],
+				System:   extractSystemPrompt(requestData),
+				Prompt:   extractPrompt(requestData),
+			},
+			BodyMode: keyConfig.LogBodyMode,
+		},
+	}
+}
+
+// traceID returns the request's trace ID
+func (b *logBuilder) traceID() string {
+	return b.entry.TraceID
+}
+
+// finish completes the entry with the response and metrics
+func (b *logBuilder) finish(response models.ResponseLog, latencyMs int, cost float64) *models.LogEntry {
+	if b.validateSchema && response.StatusCode < 400 {
+		response.SchemaErrors = validateStructuredOutput(b.requestData, response.Content)
+	}
+
+	response.OverBudget = response.OverBudget || b.overBudget
+
+	b.entry.Timestamp = time.Now()
+	b.entry.Response = response
+	b.entry.Metrics = models.MetricsLog{
+		LatencyMs: latencyMs,
+		CostUSD:   cost,
+	}
+	return b.entry
+}
@@ -0,0 +1,79 @@
+package proxy
+
+import (
+	"fmt"
+	"unicode/utf8"
+)
+
+// Modes for forwarding the body's metadata object to providers
+const (
+	ProviderMetadataOff    = "off"    // Strip it; metadata is only recorded in our logs
+	ProviderMetadataClient = "client" // Forward the client's metadata
+	ProviderMetadataMerge  = "merge"  // Forward it with gateway tags added; client keys win
+)
+
+// Provider limits on metadata, as enforced by OpenAI
+const (
+	maxProviderMetadataKeys     = 16
+	maxProviderMetadataKeyLen   = 64
+	maxProviderMetadataValueLen = 512
+)
+
+// providerMetadata builds the metadata object forwarded upstream from the client's
+// body metadata, validating it against provider limits so requests aren't rejected
+// upstream. It returns nil when there is nothing to forward.
+func (h *Handler) providerMetadata(clientMetadata interface{}, keyName, traceID string) (map[string]interface{}, error) {
+	if h.opts.ProviderMetadata != ProviderMetadataClient && h.opts.ProviderMetadata != ProviderMetadataMerge {
+		return nil, nil
+	}
+
+	client, _ := clientMetadata.(map[string]interface{})
+	if len(client) > maxProviderMetadataKeys {
+		return nil, fmt.Errorf("metadata forwarded to providers must not have more than %d keys", maxProviderMetadataKeys)
+	}
+
+	forwarded := make(map[string]interface{}, len(client)+2)
+	for k, v := range client {
+		value := fmt.Sprint(v) // Providers only accept string values
+		if utf8.RuneCountInString(k) > maxProviderMetadataKeyLen {
+			return nil, fmt.Errorf("metadata key %q must not exceed %d characters", k, maxProviderMetadataKeyLen)
+		}
+		if utf8.RuneCountInString(value) > maxProviderMetadataValueLen {
+			return nil, fmt.Errorf("metadata value for %q must not exceed %d characters", k, maxProviderMetadataValueLen)
+		}
+		forwarded[k] = value
+	}
+
+	if h.opts.ProviderMetadata == ProviderMetadataMerge {
+		gateway := []struct{ key, value string }{
+			{"lumina_key_name", keyName},
+			{"lumina_trace_id", traceID},
+		}
+		for _, tag := range gateway {
+			if _, set := forwarded[tag.key]; set || len(forwarded) >= maxProviderMetadataKeys {
+				continue
+			}
+			forwarded[tag.key] = truncateRunes(tag.value, maxProviderMetadataValueLen)
+		}
+	}
+
+	if len(forwarded) == 0 {
+		return nil, nil
+	}
+	return forwarded, nil
+}
+
+// anthropicMetadata narrows forwarded metadata to the only key Anthropic accepts
+func anthropicMetadata(metadata map[string]interface{}) map[string]interface{} {
+	if userID, ok := metadata["user_id"]; ok {
+		return map[string]interface{}{"user_id": userID}
+	}
+	return nil
+}
+
+func truncateRunes(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	return string([]rune(s)[:n])
+}
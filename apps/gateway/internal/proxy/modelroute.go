@@ -0,0 +1,36 @@
+package proxy
+
+import (
+	"fmt"
+	"log/slog"
+
+	"github.com/lumina/gateway/internal/models"
+)
+
+// routeModel resolves a logical model name the key routes by prompt size to the model
+// for this request's estimated prompt, and logs the choice. Other names are returned
+// unchanged.
+func routeModel(prompt *promptTokens, routes map[string]models.ModelRoute, name, traceID string) string {
+	route, ok := routes[name]
+	if !ok {
+		return name
+	}
+
+	// Prompts are counted with the smallest tier's tokenizer, since its limit is the first compared
+	counter := route.Default
+	if len(route.Tiers) > 0 {
+		counter = route.Tiers[0].Model
+	}
+	tokens := prompt.count(counter)
+
+	model, reason := route.Default, "prompt exceeds every tier"
+	for _, tier := range route.Tiers {
+		if tokens <= tier.MaxPromptTokens {
+			model, reason = tier.Model, fmt.Sprintf("prompt within %d tokens", tier.MaxPromptTokens)
+			break
+		}
+	}
+
+	slog.Info("routed model by prompt size", "trace_id", traceID, "route", name, "prompt_tokens", tokens, "model", model, "reason", reason)
+	return model
+}
@@ -0,0 +1,39 @@
+package proxy
+
+import (
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// requestOrigin returns the web origin a request says it was sent from, taken from
+// the Origin header or, failing that, the Referer. Both are set by browsers but can
+// be forged by any other client.
+func requestOrigin(r *http.Request) string {
+	if origin := r.Header.Get("Origin"); origin != "" && origin != "null" {
+		return normalizeOrigin(origin)
+	}
+	if referer, err := url.Parse(r.Header.Get("Referer")); err == nil && referer.Host != "" {
+		return normalizeOrigin(referer.Scheme + "://" + referer.Host)
+	}
+	return ""
+}
+
+// normalizeOrigin lowercases an origin and drops a trailing slash so equivalent forms compare equal
+func normalizeOrigin(origin string) string {
+	return strings.TrimSuffix(strings.ToLower(origin), "/")
+}
+
+// originAllowed reports whether a request may use a key restricted to the allowed
+// origins. Keys without allowed origins accept any request.
+func originAllowed(allowed []string, r *http.Request) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+	origin := requestOrigin(r)
+	if origin == "" {
+		return false
+	}
+	return slices.ContainsFunc(allowed, func(a string) bool { return normalizeOrigin(a) == origin })
+}
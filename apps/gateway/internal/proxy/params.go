@@ -0,0 +1,89 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"slices"
+
+	"github.com/lumina/gateway/internal/models"
+)
+
+// providerParamNames maps limited parameters to the names a provider accepts them
+// under, where they differ from the limit's name. Defaults are set under the first.
+var providerParamNames = map[string]map[string][]string{
+	"openai": {"max_tokens": {"max_tokens", "max_completion_tokens"}},
+}
+
+// applyParamLimits clamps the request's parameters into the key's limits and sets
+// the defaults of omitted ones, logging what it changed. Parameters the provider
+// doesn't support are left alone, as are non-numeric values for the provider to reject.
+func (h *Handler) applyParamLimits(body map[string]interface{}, limits map[string]models.ParamLimit, provider, traceID string) {
+	var clamped, defaulted []string
+	for param, limit := range limits {
+		if slices.Contains(unsupportedParams[provider], param) {
+			continue
+		}
+		integer := models.LimitedParams[param]
+		names, ok := providerParamNames[provider][param]
+		if !ok {
+			names = []string{param}
+		}
+
+		present := false
+		for _, name := range names {
+			if body[name] == nil {
+				continue
+			}
+			present = true
+			value, ok := numericParam(body[name])
+			if !ok {
+				continue
+			}
+			if limited := clampParam(limit, value); limited != value {
+				body[name] = paramValue(limited, integer)
+				clamped = append(clamped, fmt.Sprintf("%s: %v->%v", name, value, limited))
+			}
+		}
+		if !present && limit.Default != nil {
+			body[names[0]] = paramValue(*limit.Default, integer)
+			defaulted = append(defaulted, names[0])
+		}
+	}
+
+	if len(clamped)+len(defaulted) > 0 {
+		slog.Info("applied key parameter limits", "trace_id", traceID, "provider", provider,
+			"clamped", clamped, "defaulted", defaulted)
+	}
+}
+
+// clampParam returns value limited to the range of limit
+func clampParam(limit models.ParamLimit, value float64) float64 {
+	if limit.Min != nil && value < *limit.Min {
+		return *limit.Min
+	}
+	if limit.Max != nil && value > *limit.Max {
+		return *limit.Max
+	}
+	return value
+}
+
+// numericParam returns the value of a JSON number decoded with or without UseNumber
+func numericParam(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case json.Number:
+		f, err := n.Float64()
+		return f, err == nil
+	case float64:
+		return n, true
+	}
+	return 0, false
+}
+
+// paramValue encodes a limited value, as a whole number for parameters that take one
+func paramValue(v float64, integer bool) interface{} {
+	if integer {
+		return int64(v)
+	}
+	return v
+}
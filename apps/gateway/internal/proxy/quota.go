@@ -0,0 +1,48 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/lumina/gateway/internal/models"
+)
+
+// Monthly request quota headers, alongside the rate limit ones
+const (
+	quotaLimitHeader     = "X-Lumina-Quota-Limit"
+	quotaRemainingHeader = "X-Lumina-Quota-Remaining"
+	quotaResetHeader     = "X-Lumina-Quota-Reset"
+)
+
+// checkRequestQuota counts the request against its key's monthly request quota and
+// reports the quota, remaining requests and reset time in headers. It writes a 429
+// and returns false once the quota is used up. Redis errors let the request through.
+func (h *Handler) checkRequestQuota(ctx context.Context, w http.ResponseWriter, keyConfig *models.KeyConfig) bool {
+	if keyConfig.RequestQuota == nil || *keyConfig.RequestQuota <= 0 {
+		return true
+	}
+	quota := *keyConfig.RequestQuota
+
+	count, reset, err := h.cache.IncrementRequestQuota(ctx, keyConfig.KeyID)
+	if err != nil {
+		slog.Warn("failed to check request quota", "key_id", keyConfig.KeyID, "error", err)
+		return true
+	}
+
+	w.Header().Set(quotaLimitHeader, strconv.FormatInt(quota, 10))
+	w.Header().Set(quotaRemainingHeader, strconv.FormatInt(max(quota-count, 0), 10))
+	w.Header().Set(quotaResetHeader, strconv.FormatInt(reset.Unix(), 10))
+
+	if count > quota {
+		retryAfter := int(time.Until(reset).Seconds()) + 1
+		w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+		h.writeErrorCode(w, http.StatusTooManyRequests, errorCodeRequestQuota,
+			fmt.Sprintf("monthly request quota of %d exceeded for this key; it resets on %s", quota, reset.Format("2006-01-02")))
+		return false
+	}
+	return true
+}
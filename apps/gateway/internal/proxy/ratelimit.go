@@ -0,0 +1,47 @@
+package proxy
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/lumina/gateway/internal/models"
+)
+
+// Rate limit headers, following GitHub's convention
+const (
+	rateLimitLimitHeader     = "X-Lumina-RateLimit-Limit"
+	rateLimitRemainingHeader = "X-Lumina-RateLimit-Remaining"
+	rateLimitResetHeader     = "X-Lumina-RateLimit-Reset"
+)
+
+// checkRateLimit counts the request against its key's per-minute limit and reports
+// the limit, remaining requests and reset time in headers. It writes a 429 and
+// returns false once the limit is exceeded. Redis errors let the request through.
+func (h *Handler) checkRateLimit(ctx context.Context, w http.ResponseWriter, keyConfig *models.KeyConfig) bool {
+	limit := h.opts.RateLimit
+	if limit <= 0 {
+		return true
+	}
+
+	count, reset, err := h.cache.IncrementRateLimit(ctx, keyConfig.KeyID)
+	if err != nil {
+		slog.Warn("failed to check rate limit", "key_id", keyConfig.KeyID, "error", err)
+		return true
+	}
+
+	remaining := max(int64(limit)-count, 0)
+	w.Header().Set(rateLimitLimitHeader, strconv.Itoa(limit))
+	w.Header().Set(rateLimitRemainingHeader, strconv.FormatInt(remaining, 10))
+	w.Header().Set(rateLimitResetHeader, strconv.FormatInt(reset.Unix(), 10))
+
+	if count > int64(limit) {
+		retryAfter := int(time.Until(reset).Seconds()) + 1
+		w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+		h.writeError(w, http.StatusTooManyRequests, "rate limit exceeded; try again after the window resets")
+		return false
+	}
+	return true
+}
@@ -0,0 +1,30 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// checkRequestSize rejects requests over the key's message or estimated prompt token
+// limits with 413 and returns false
+func (h *Handler) checkRequestSize(w http.ResponseWriter, lb *logBuilder) bool {
+	cfg := lb.keyConfig
+
+	if cfg.MaxMessages > 0 {
+		if messages, ok := lb.requestData["messages"].([]interface{}); ok && len(messages) > cfg.MaxMessages {
+			h.writeError(w, http.StatusRequestEntityTooLarge,
+				fmt.Sprintf("request has %d messages; this key allows at most %d", len(messages), cfg.MaxMessages))
+			return false
+		}
+	}
+
+	if cfg.MaxPromptTokens > 0 {
+		if tokens := estimatePromptTokens(lb); tokens > cfg.MaxPromptTokens {
+			h.writeError(w, http.StatusRequestEntityTooLarge,
+				fmt.Sprintf("request prompt is about %d tokens; this key allows at most %d", tokens, cfg.MaxPromptTokens))
+			return false
+		}
+	}
+
+	return true
+}
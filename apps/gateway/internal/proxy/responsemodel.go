@@ -0,0 +1,52 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// replaceModel rewrites the model name reported in a response body or stream
+// chunk, either at the top level or, for Anthropic's message_start event, under
+// "message". Every other field is kept as sent. It reports whether a model field
+// was found.
+func replaceModel(body []byte, model string) ([]byte, bool) {
+	var obj map[string]json.RawMessage
+	if err := json.Unmarshal(body, &obj); err != nil {
+		return body, false
+	}
+
+	if _, ok := obj["model"]; ok {
+		name, _ := json.Marshal(model)
+		obj["model"] = name
+	} else if message, ok := obj["message"]; ok {
+		rewritten, found := replaceModel(message, model)
+		if !found {
+			return body, false
+		}
+		obj["message"] = rewritten
+	} else {
+		return body, false
+	}
+
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(obj); err != nil {
+		return body, false
+	}
+	return bytes.TrimRight(buf.Bytes(), "\n"), true
+}
+
+// servedModel returns the model a provider reports having served in a response
+// or stream chunk
+func servedModel(data map[string]interface{}) string {
+	if model, ok := data["model"].(string); ok {
+		return model
+	}
+	if message, ok := data["message"].(map[string]interface{}); ok {
+		if model, ok := message["model"].(string); ok {
+			return model
+		}
+	}
+	return ""
+}
@@ -0,0 +1,137 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+
+	"github.com/lumina/gateway/internal/models"
+)
+
+// streamAccumulator collects content and usage from SSE events as they are proxied
+type streamAccumulator struct {
+	content      strings.Builder
+	usage        models.UsageLog
+	servedModel  string
+	stripUsage   bool   // Drop the usage-only chunk when the client did not ask for it
+	rewriteModel string // Model name reported to the client in place of the served one, if set
+	captureRaw   bool   // Keep the events exactly as received, for debug capture
+	raw          strings.Builder
+}
+
+// processEvent inspects a complete SSE event (all lines up to and including the
+// terminating blank line). It returns the event to forward, which may have its
+// model rewritten, and whether it should be forwarded to the client at all.
+func (a *streamAccumulator) processEvent(event []byte) ([]byte, bool) {
+	if a.captureRaw {
+		a.raw.Write(event)
+	}
+
+	forward := true
+	lines := bytes.Split(event, []byte("\n"))
+	rewritten := false
+
+	for i, line := range lines {
+		line = bytes.TrimRight(line, "\r")
+		if !bytes.HasPrefix(line, []byte("data:")) {
+			continue
+		}
+
+		payload := bytes.TrimSpace(bytes.TrimPrefix(line, []byte("data:")))
+		if len(payload) == 0 || bytes.Equal(payload, []byte("[DONE]")) {
+			continue
+		}
+
+		var chunk map[string]interface{}
+		if err := json.Unmarshal(payload, &chunk); err != nil {
+			continue
+		}
+
+		if model := servedModel(chunk); model != "" {
+			a.servedModel = model
+			if a.rewriteModel != "" {
+				if replaced, ok := replaceModel(payload, a.rewriteModel); ok {
+					lines[i] = append([]byte("data: "), replaced...)
+					rewritten = true
+				}
+			}
+		}
+
+		// Anthropic reports input tokens on message_start and output tokens on message_delta
+		if message, ok := chunk["message"].(map[string]interface{}); ok {
+			if usage, ok := extractUsage(message); ok {
+				a.mergeUsage(usage)
+			}
+		}
+		if usage, ok := extractUsage(chunk); ok {
+			a.mergeUsage(usage)
+		}
+		a.content.WriteString(extractDelta(chunk))
+
+		if a.stripUsage && isUsageOnlyChunk(chunk) {
+			forward = false
+		}
+	}
+
+	if rewritten {
+		event = bytes.Join(lines, []byte("\n"))
+	}
+	return event, forward
+}
+
+// mergeUsage folds a (possibly partial) usage report into the running totals
+func (a *streamAccumulator) mergeUsage(usage models.UsageLog) {
+	if usage.PromptTokens > 0 {
+		a.usage.PromptTokens = usage.PromptTokens
+	}
+	if usage.CompletionTokens > 0 {
+		a.usage.CompletionTokens = usage.CompletionTokens
+	}
+	if usage.CachedTokens > 0 {
+		a.usage.CachedTokens = usage.CachedTokens
+	}
+	if usage.ImageTokens > 0 {
+		a.usage.ImageTokens = usage.ImageTokens
+	}
+	if usage.AudioSeconds > 0 {
+		a.usage.AudioSeconds = usage.AudioSeconds
+	}
+	a.usage.TotalTokens = a.usage.PromptTokens + a.usage.CompletionTokens
+}
+
+// extractDelta returns the incremental text carried by a streaming chunk
+func extractDelta(chunk map[string]interface{}) string {
+	// OpenAI format
+	if choices, ok := chunk["choices"].([]interface{}); ok && len(choices) > 0 {
+		if choice, ok := choices[0].(map[string]interface{}); ok {
+			if delta, ok := choice["delta"].(map[string]interface{}); ok {
+				if content, ok := delta["content"].(string); ok {
+					return content
+				}
+			}
+			// Legacy completions stream text directly on the choice
+			if text, ok := choice["text"].(string); ok {
+				return text
+			}
+		}
+	}
+
+	// Anthropic format
+	if delta, ok := chunk["delta"].(map[string]interface{}); ok {
+		if text, ok := delta["text"].(string); ok {
+			return text
+		}
+	}
+
+	return ""
+}
+
+// isUsageOnlyChunk reports whether a chunk is the trailing usage report OpenAI
+// emits when stream_options.include_usage is set
+func isUsageOnlyChunk(chunk map[string]interface{}) bool {
+	if _, ok := chunk["usage"].(map[string]interface{}); !ok {
+		return false
+	}
+	choices, ok := chunk["choices"].([]interface{})
+	return ok && len(choices) == 0
+}
@@ -0,0 +1,67 @@
+package proxy
+
+import (
+	"github.com/lumina/gateway/internal/models"
+	"github.com/lumina/gateway/internal/tokenizer"
+)
+
+// streamBudget estimates the running cost of a streamed response from the text
+// received so far, since providers only report usage once the stream ends
+type streamBudget struct {
+	h                *Handler
+	provider         string
+	model            string
+	remaining        float64
+	abort            bool // Never set for soft-mode keys
+	promptTokens     int
+	completionTokens int
+	counted          int // Bytes of streamed content already tokenized
+}
+
+// newStreamBudget returns a tracker for the key's remaining budget, or nil when
+// the key has no budget limit
+func (h *Handler) newStreamBudget(lb *logBuilder) *streamBudget {
+	limit := lb.keyConfig.BudgetLimit
+	if limit == nil {
+		return nil
+	}
+
+	return &streamBudget{
+		h:            h,
+		provider:     lb.provider,
+		model:        lb.model,
+		remaining:    *limit - lb.keyConfig.CurrentSpend,
+		abort:        lb.keyConfig.StreamBudget == models.StreamBudgetAbort && lb.keyConfig.BudgetMode != models.BudgetSoft,
+		promptTokens: estimatePromptTokens(lb),
+	}
+}
+
+// track counts the content streamed since the last call and reports whether
+// the estimated cost now exceeds the remaining budget
+func (b *streamBudget) track(content string) bool {
+	b.completionTokens += tokenizer.CountText(content[b.counted:], b.model)
+	b.counted = len(content)
+	return b.h.calculateCost(b.provider, b.model, b.usage()) > b.remaining
+}
+
+// usage returns the estimated usage so far
+func (b *streamBudget) usage() models.UsageLog {
+	return models.UsageLog{
+		PromptTokens:     b.promptTokens,
+		CompletionTokens: b.completionTokens,
+		TotalTokens:      b.promptTokens + b.completionTokens,
+	}
+}
+
+// fill completes usage the provider didn't report, e.g. on an aborted stream,
+// with the estimate
+func (b *streamBudget) fill(usage models.UsageLog) models.UsageLog {
+	if usage.PromptTokens == 0 {
+		usage.PromptTokens = b.promptTokens
+	}
+	if usage.CompletionTokens == 0 {
+		usage.CompletionTokens = b.completionTokens
+	}
+	usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+	return usage
+}
@@ -0,0 +1,88 @@
+package proxy
+
+import (
+	"sync"
+
+	"github.com/lumina/gateway/internal/metrics"
+)
+
+// streamRetryAfter is the Retry-After, in seconds, sent when no stream slot is free
+const streamRetryAfter = "5"
+
+var streamsRejected = metrics.NewCounter("lumina_streams_rejected_total",
+	"Streaming requests rejected because the concurrent stream limit was reached")
+
+// streamLimiter caps the streaming connections this replica holds open, overall
+// and per key. Streams are long-lived, so unlike provider slots the limit is local:
+// it protects this process's goroutines and connections.
+type streamLimiter struct {
+	global chan struct{} // nil when there is no overall limit
+	perKey int           // Zero means no per-key limit
+
+	mu     sync.Mutex
+	active map[string]int // Open streams by key ID
+}
+
+// newStreamLimiter returns a limiter for max streams overall and perKey streams per
+// key, or nil when neither is limited
+func newStreamLimiter(max, perKey int) *streamLimiter {
+	if max <= 0 && perKey <= 0 {
+		return nil
+	}
+
+	l := &streamLimiter{perKey: perKey, active: make(map[string]int)}
+	if max > 0 {
+		l.global = make(chan struct{}, max)
+	}
+	return l
+}
+
+// acquire takes a stream slot for the key without waiting. It returns a release
+// function and false when the key or the replica is at its limit.
+func (l *streamLimiter) acquire(keyID string) (func(), bool) {
+	if l == nil {
+		return func() {}, true
+	}
+
+	if l.perKey > 0 {
+		l.mu.Lock()
+		if l.active[keyID] >= l.perKey {
+			l.mu.Unlock()
+			streamsRejected.Inc()
+			return nil, false
+		}
+		l.active[keyID]++
+		l.mu.Unlock()
+	}
+
+	if l.global != nil {
+		select {
+		case l.global <- struct{}{}:
+		default:
+			l.releaseKey(keyID)
+			streamsRejected.Inc()
+			return nil, false
+		}
+	}
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			if l.global != nil {
+				<-l.global
+			}
+			l.releaseKey(keyID)
+		})
+	}, true
+}
+
+func (l *streamLimiter) releaseKey(keyID string) {
+	if l.perKey <= 0 {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.active[keyID]--; l.active[keyID] <= 0 {
+		delete(l.active, keyID)
+	}
+}
@@ -0,0 +1,142 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+)
+
+// TransformRules is a declarative set of rewrites applied to top-level fields of a
+// request body before it is forwarded to a provider. Renames run first, then drops,
+// then defaults.
+type TransformRules struct {
+	Rename   map[string]string      `json:"rename,omitempty"`   // Field -> name the provider expects; skipped if the new name is already set
+	Drop     []string               `json:"drop,omitempty"`     // Fields the provider rejects
+	Defaults map[string]interface{} `json:"defaults,omitempty"` // Values set when the client didn't send the field
+}
+
+// builtinTransforms smooth over the request quirks of the known providers.
+// Parameters a provider doesn't support at all are handled by unsupportedParams.
+var builtinTransforms = map[string]TransformRules{
+	"openai": {
+		Rename: map[string]string{"stop_sequences": "stop"},
+	},
+	"anthropic": {
+		Rename: map[string]string{"stop": "stop_sequences"},
+	},
+}
+
+// LoadTransforms returns the built-in rulesets extended with those in the JSON file at
+// path, which maps provider names to rules. Renames and defaults from the file
+// override built-in ones for the same field; drops are added. An empty path returns
+// the built-ins.
+func LoadTransforms(path string) (map[string]TransformRules, error) {
+	transforms := make(map[string]TransformRules, len(builtinTransforms))
+	for provider, rules := range builtinTransforms {
+		transforms[provider] = rules.merge(TransformRules{})
+	}
+	if path == "" {
+		return transforms, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read request transforms: %w", err)
+	}
+	var custom map[string]TransformRules
+	if err := json.Unmarshal(data, &custom); err != nil {
+		return nil, fmt.Errorf("invalid request transforms: %w", err)
+	}
+
+	for provider, rules := range custom {
+		if err := rules.validate(); err != nil {
+			return nil, fmt.Errorf("invalid request transforms for %s: %w", provider, err)
+		}
+		transforms[provider] = transforms[provider].merge(rules)
+	}
+	return transforms, nil
+}
+
+// validate rejects rules that would rewrite the fields the gateway relies on
+func (r TransformRules) validate() error {
+	reserved := func(field string) bool { return field == "model" || field == "stream" }
+	for from, to := range r.Rename {
+		if reserved(from) || reserved(to) || to == "" {
+			return fmt.Errorf("cannot rename %q to %q", from, to)
+		}
+	}
+	for _, field := range r.Drop {
+		if reserved(field) {
+			return fmt.Errorf("cannot drop %q", field)
+		}
+	}
+	for field := range r.Defaults {
+		if reserved(field) {
+			return fmt.Errorf("cannot set a default for %q", field)
+		}
+	}
+	return nil
+}
+
+// merge returns a copy of r extended with other, whose renames and defaults win
+func (r TransformRules) merge(other TransformRules) TransformRules {
+	merged := TransformRules{
+		Rename:   make(map[string]string),
+		Drop:     slices.Concat(r.Drop, other.Drop),
+		Defaults: make(map[string]interface{}),
+	}
+	for _, m := range []map[string]string{r.Rename, other.Rename} {
+		for from, to := range m {
+			merged.Rename[from] = to
+		}
+	}
+	for _, m := range []map[string]interface{}{r.Defaults, other.Defaults} {
+		for field, value := range m {
+			merged.Defaults[field] = value
+		}
+	}
+	return merged
+}
+
+// applyTransforms rewrites body in place with the provider's rules and logs the
+// fields it changed
+func (h *Handler) applyTransforms(body map[string]interface{}, provider, traceID string) {
+	rules, ok := h.opts.Transforms[provider]
+	if !ok {
+		return
+	}
+
+	var renamed, dropped, defaulted []string
+	for from, to := range rules.Rename {
+		value, ok := body[from]
+		if !ok {
+			continue
+		}
+		delete(body, from)
+		if _, set := body[to]; !set {
+			body[to] = value
+			renamed = append(renamed, from+"->"+to)
+		} else {
+			dropped = append(dropped, from)
+		}
+	}
+	for _, field := range rules.Drop {
+		if _, ok := body[field]; ok {
+			delete(body, field)
+			dropped = append(dropped, field)
+		}
+	}
+	for field, value := range rules.Defaults {
+		if _, ok := body[field]; !ok {
+			body[field] = value
+			defaulted = append(defaulted, field)
+		}
+	}
+
+	if len(renamed)+len(dropped)+len(defaulted) > 0 {
+		slog.Info("transformed request", "trace_id", traceID, "provider", provider,
+			"renamed", renamed, "dropped", dropped, "defaulted", defaulted)
+	}
+}
@@ -0,0 +1,47 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/lumina/gateway/internal/metrics"
+	"github.com/lumina/gateway/internal/pricing"
+)
+
+// Modes for handling models missing from the pricing table
+const (
+	UnknownPricingFallback = "fallback" // Charge the provider's fallback price
+	UnknownPricingReject   = "reject"   // Fail the request with a 400
+)
+
+// unpricedWarnWindow is how often an unpriced model is logged across all replicas
+const unpricedWarnWindow = time.Hour
+
+var unpricedRequests = metrics.NewCounter("lumina_unpriced_model_requests_total",
+	"Requests for models missing from the pricing table, whether charged a fallback price or rejected.")
+
+// checkModelPricing handles requests for models missing from the pricing table
+// according to Options.UnknownPricing, logging each such model so operators can
+// price it. It reports whether the request may proceed, having written the error
+// response otherwise.
+func (h *Handler) checkModelPricing(ctx context.Context, w http.ResponseWriter, provider, model, traceID string) bool {
+	if pricing.Known(provider, model) {
+		return true
+	}
+	unpricedRequests.Inc()
+
+	reject := h.opts.UnknownPricing == UnknownPricingReject
+	if due, err := h.cache.FirstInWindow(ctx, "unpriced:"+provider+"/"+model, unpricedWarnWindow); err != nil || due {
+		slog.Warn("model missing from the pricing table", "trace_id", traceID, "provider", provider, "model", model, "rejected", reject)
+	}
+
+	if reject {
+		h.writeErrorCode(w, http.StatusBadRequest, errorCodeUnknownPricing,
+			fmt.Sprintf("model '%s/%s' has no known price on this gateway", provider, model))
+		return false
+	}
+	return true
+}
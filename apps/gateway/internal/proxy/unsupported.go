@@ -0,0 +1,53 @@
+package proxy
+
+import (
+	"log/slog"
+	"slices"
+)
+
+// Modes for handling request parameters the target provider doesn't support
+const (
+	UnsupportedParamsStrip  = "strip"  // Remove them before forwarding
+	UnsupportedParamsReject = "reject" // Fail the request with a 400
+)
+
+// unsupportedParams lists, per provider, parameters clients commonly send that the
+// provider rejects, usually OpenAI-only sampling options sent to Anthropic
+var unsupportedParams = map[string][]string{
+	"openai": {"top_k"},
+	"anthropic": {
+		"frequency_penalty", "presence_penalty", "logit_bias", "logprobs", "top_logprobs",
+		"n", "seed", "user", "parallel_tool_calls",
+	},
+}
+
+// findUnsupportedParams returns the parameters in body the provider doesn't support,
+// ignoring those the provider's transforms rename or drop
+func (h *Handler) findUnsupportedParams(body map[string]interface{}, provider string) []string {
+	rules := h.opts.Transforms[provider]
+
+	var found []string
+	for _, param := range unsupportedParams[provider] {
+		if _, ok := body[param]; !ok {
+			continue
+		}
+		if _, renamed := rules.Rename[param]; renamed || slices.Contains(rules.Drop, param) {
+			continue
+		}
+		found = append(found, param)
+	}
+	return found
+}
+
+// stripUnsupportedParams removes parameters the provider doesn't support from body
+// and logs them
+func (h *Handler) stripUnsupportedParams(body map[string]interface{}, provider, traceID string) {
+	stripped := h.findUnsupportedParams(body, provider)
+	if len(stripped) == 0 {
+		return
+	}
+	for _, param := range stripped {
+		delete(body, param)
+	}
+	slog.Info("stripped unsupported parameters", "trace_id", traceID, "provider", provider, "params", stripped)
+}
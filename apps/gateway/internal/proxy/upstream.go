@@ -0,0 +1,174 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/http"
+)
+
+// upstreamTarget is a provider and model a request can be sent to
+type upstreamTarget struct {
+	provider string
+	model    string // Model name as the provider knows it, without the provider prefix
+	apiKey   string
+
+	// OpenAI organization and project the key pins requests to, sent as headers
+	openAIOrganization string
+	openAIProject      string
+}
+
+// attemptError is a failed attempt that wrote nothing to the client, so the
+// request can still be replayed against the same or another target
+type attemptError struct {
+	status  int
+	message string
+}
+
+func (e *attemptError) Error() string {
+	return e.message
+}
+
+// upstreamBody marshals a fresh body for one attempt at target. requestData is the
+// client's parsed request and is left untouched, so every retry or fallback starts
+// from the original rather than a body already rewritten for another target.
+// It reports whether a usage report was injected into an OpenAI stream.
+func (h *Handler) upstreamBody(ep endpoint, requestData map[string]interface{}, target upstreamTarget, isStreaming bool, traceID string) ([]byte, bool, error) {
+	body := maps.Clone(requestData)
+	body["model"] = target.model
+
+	// Native routes forward the client's body as sent, apart from the model name
+	if ep.native != "" {
+		encoded, err := json.Marshal(body)
+		return encoded, false, err
+	}
+
+	if metadata, ok := body["metadata"].(map[string]interface{}); ok && target.provider == "anthropic" {
+		if narrowed := anthropicMetadata(metadata); narrowed != nil {
+			body["metadata"] = narrowed
+		} else {
+			delete(body, "metadata")
+		}
+	}
+
+	h.applyTransforms(body, target.provider, traceID)
+	h.stripUnsupportedParams(body, target.provider, traceID)
+
+	// Ask OpenAI to report usage on streams so they can be billed; the usage-only
+	// chunk is stripped again before forwarding if the client didn't request it
+	stripUsage := false
+	if isStreaming && target.provider == "openai" && h.opts.InjectStreamUsage && (ep == chatEndpoint || ep == completionEndpoint) {
+		stripUsage = injectStreamUsage(body)
+	}
+
+	if target.provider == "anthropic" && body["max_tokens"] == nil && h.opts.AnthropicDefaultMaxTokens > 0 {
+		body["max_tokens"] = h.opts.AnthropicDefaultMaxTokens
+		slog.Info("injected default max_tokens", "trace_id", traceID, "model", target.provider+"/"+target.model, "max_tokens", h.opts.AnthropicDefaultMaxTokens)
+	}
+
+	encoded, err := json.Marshal(body)
+	return encoded, stripUsage, err
+}
+
+// newUpstreamRequest builds the provider request for one attempt at target
+func (h *Handler) newUpstreamRequest(ctx context.Context, ep endpoint, target upstreamTarget, body []byte) (*http.Request, error) {
+	var targetURL string
+	var headers map[string]string
+
+	switch target.provider {
+	case "openai":
+		targetURL = openAIBaseURL + ep.path
+		headers = map[string]string{
+			"Content-Type":  "application/json",
+			"Authorization": "Bearer " + target.apiKey,
+		}
+		if target.openAIOrganization != "" {
+			headers["OpenAI-Organization"] = target.openAIOrganization
+		}
+		if target.openAIProject != "" {
+			headers["OpenAI-Project"] = target.openAIProject
+		}
+	case "anthropic":
+		// Anthropic uses different endpoint
+		targetURL = anthropicBaseURL + "/v1/messages"
+		headers = map[string]string{
+			"Content-Type":      "application/json",
+			"x-api-key":         target.apiKey,
+			"anthropic-version": "2023-06-01",
+		}
+	default:
+		return nil, fmt.Errorf("unsupported provider: %s", target.provider)
+	}
+
+	// Compress large bodies, e.g. big embedding batches, for providers that accept it
+	if h.shouldGzip(target.provider, len(body)) {
+		compressed, err := gzipBody(body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to compress request: %w", err)
+		}
+		body = compressed
+		headers["Content-Encoding"] = "gzip"
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", targetURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create upstream request: %w", err)
+	}
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+
+	return req, nil
+}
+
+// forward makes one attempt at target and relays the response to the client.
+// It returns an attemptError only while nothing has been written to w; once the
+// upstream responds the attempt is final, and a stream in particular must never
+// be replayed after its first byte has reached the client.
+func (h *Handler) forward(ctx context.Context, w http.ResponseWriter, ep endpoint, requestData map[string]interface{}, target upstreamTarget, isStreaming, costTrailers bool, lb *logBuilder) *attemptError {
+	body, stripUsage, err := h.upstreamBody(ep, requestData, target, isStreaming, lb.traceID())
+	if err != nil {
+		return &attemptError{http.StatusInternalServerError, "failed to modify request"}
+	}
+
+	upstreamReq, err := h.newUpstreamRequest(ctx, ep, target, body)
+	if err != nil {
+		slog.Error("failed to build upstream request", "trace_id", lb.traceID(), "error", err)
+		return &attemptError{http.StatusInternalServerError, "failed to create upstream request"}
+	}
+
+	if isStreaming {
+		releaseStream, ok := h.streams.acquire(lb.keyConfig.KeyID)
+		if !ok {
+			w.Header().Set("Retry-After", streamRetryAfter)
+			return &attemptError{http.StatusServiceUnavailable, "too many concurrent streams; try again shortly"}
+		}
+		defer releaseStream()
+	}
+
+	release, ok := h.acquireProviderSlot(ctx, target.provider, lb.traceID())
+	if !ok {
+		return &attemptError{http.StatusTooManyRequests, fmt.Sprintf("too many concurrent requests to provider '%s'; try again shortly", target.provider)}
+	}
+	defer release()
+
+	resp, err := h.httpClient.Do(upstreamReq)
+	if errors.Is(err, context.DeadlineExceeded) {
+		return &attemptError{http.StatusGatewayTimeout, fmt.Sprintf("upstream did not respond within %s", h.upstreamTimeout(isStreaming))}
+	}
+	if err != nil {
+		return &attemptError{http.StatusBadGateway, "failed to reach upstream"}
+	}
+	defer resp.Body.Close()
+
+	if isStreaming {
+		h.handleStreamingResponse(w, resp, lb, stripUsage, costTrailers)
+	} else {
+		h.handleJSONResponse(w, resp, lb)
+	}
+	return nil
+}
@@ -0,0 +1,32 @@
+package proxy
+
+import "github.com/go-chi/chi/v5"
+
+// Version holds request handling rules that differ between API versions, so
+// stricter behavior can ship under a new prefix without breaking existing clients
+type Version struct {
+	Name            string // e.g. "v1"
+	RequireMetadata bool   // Reject requests that carry no metadata tags
+}
+
+// V1 is the OpenAI-compatible API served under /v1 and /anthropic/v1
+var V1 = Version{Name: "v1"}
+
+// V2 is the Lumina-native API served under /lumina/v2
+var V2 = Version{Name: "v2", RequireMetadata: true}
+
+// WithVersion returns a handler sharing h's services and options that applies the
+// rules of the given API version
+func (h *Handler) WithVersion(v Version) *Handler {
+	versioned := *h
+	versioned.version = v
+	return &versioned
+}
+
+// Mount registers every proxy endpoint of the handler's version on r
+func (h *Handler) Mount(r chi.Router) {
+	r.Post("/chat/completions", h.ChatCompletions)
+	r.Post("/completions", h.Completions)
+	r.Post("/embeddings", h.Embeddings)
+	r.Post("/messages", h.AnthropicMessages)
+}
@@ -0,0 +1,215 @@
+package schema
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// maxErrors caps the number of violations reported for a single document
+const maxErrors = 20
+
+// Validate checks a decoded JSON document against a JSON schema and returns a
+// human-readable description of every violation found (nil when it conforms).
+//
+// It covers the subset of JSON Schema used by structured outputs: type, enum,
+// const, properties, required, additionalProperties, items and anyOf/oneOf/allOf.
+// Keywords outside that subset (including $ref) are ignored rather than rejected.
+func Validate(schema map[string]interface{}, doc interface{}) []string {
+	v := &validator{}
+	v.validate(schema, doc, "$")
+	return v.errors
+}
+
+type validator struct {
+	errors []string
+}
+
+func (v *validator) fail(path, format string, args ...interface{}) {
+	if len(v.errors) < maxErrors {
+		v.errors = append(v.errors, path+": "+fmt.Sprintf(format, args...))
+	}
+}
+
+func (v *validator) validate(schema map[string]interface{}, doc interface{}, path string) {
+	if t, ok := schema["type"]; ok && !matchesType(t, doc) {
+		v.fail(path, "expected type %v, got %s", t, typeOf(doc))
+		return
+	}
+
+	if enum, ok := schema["enum"].([]interface{}); ok {
+		found := false
+		for _, candidate := range enum {
+			if equal(candidate, doc) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			v.fail(path, "value is not one of the allowed enum values")
+		}
+	}
+
+	if c, ok := schema["const"]; ok && !equal(c, doc) {
+		v.fail(path, "value does not match const")
+	}
+
+	if all, ok := schema["allOf"].([]interface{}); ok {
+		for _, sub := range all {
+			if subSchema, ok := sub.(map[string]interface{}); ok {
+				v.validate(subSchema, doc, path)
+			}
+		}
+	}
+
+	for _, keyword := range []string{"anyOf", "oneOf"} {
+		if options, ok := schema[keyword].([]interface{}); ok {
+			matches := 0
+			for _, sub := range options {
+				if subSchema, ok := sub.(map[string]interface{}); ok && len(Validate(subSchema, doc)) == 0 {
+					matches++
+				}
+			}
+			if matches == 0 || (keyword == "oneOf" && matches > 1) {
+				v.fail(path, "value does not match %s", keyword)
+			}
+		}
+	}
+
+	switch value := doc.(type) {
+	case map[string]interface{}:
+		v.validateObject(schema, value, path)
+	case []interface{}:
+		if items, ok := schema["items"].(map[string]interface{}); ok {
+			for i, item := range value {
+				v.validate(items, item, fmt.Sprintf("%s[%d]", path, i))
+			}
+		}
+	}
+}
+
+func (v *validator) validateObject(schema map[string]interface{}, obj map[string]interface{}, path string) {
+	if required, ok := schema["required"].([]interface{}); ok {
+		for _, r := range required {
+			if name, ok := r.(string); ok {
+				if _, present := obj[name]; !present {
+					v.fail(path, "missing required property %q", name)
+				}
+			}
+		}
+	}
+
+	properties, _ := schema["properties"].(map[string]interface{})
+
+	// Iterate in a stable order so reported errors are deterministic
+	names := make([]string, 0, len(obj))
+	for name := range obj {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		childPath := path + "." + name
+		if propSchema, ok := properties[name].(map[string]interface{}); ok {
+			v.validate(propSchema, obj[name], childPath)
+			continue
+		}
+
+		switch additional := schema["additionalProperties"].(type) {
+		case bool:
+			if !additional {
+				v.fail(path, "unexpected property %q", name)
+			}
+		case map[string]interface{}:
+			v.validate(additional, obj[name], childPath)
+		}
+	}
+}
+
+// matchesType reports whether doc satisfies a "type" keyword, which may be a
+// single type name or a list of them
+func matchesType(t interface{}, doc interface{}) bool {
+	switch t := t.(type) {
+	case string:
+		return isType(t, doc)
+	case []interface{}:
+		for _, name := range t {
+			if s, ok := name.(string); ok && isType(s, doc) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+func isType(name string, doc interface{}) bool {
+	switch name {
+	case "object":
+		_, ok := doc.(map[string]interface{})
+		return ok
+	case "array":
+		_, ok := doc.([]interface{})
+		return ok
+	case "string":
+		_, ok := doc.(string)
+		return ok
+	case "boolean":
+		_, ok := doc.(bool)
+		return ok
+	case "null":
+		return doc == nil
+	case "number":
+		_, ok := toFloat(doc)
+		return ok
+	case "integer":
+		if n, ok := doc.(json.Number); ok {
+			_, err := n.Int64()
+			return err == nil
+		}
+		f, ok := toFloat(doc)
+		return ok && f == float64(int64(f))
+	}
+	return true
+}
+
+func typeOf(doc interface{}) string {
+	switch doc.(type) {
+	case nil:
+		return "null"
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case json.Number, float64:
+		return "number"
+	}
+	return strings.ToLower(reflect.TypeOf(doc).String())
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case json.Number:
+		f, err := n.Float64()
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// equal compares two decoded JSON values, treating numbers by value regardless
+// of whether they were decoded as float64 or json.Number
+func equal(a, b interface{}) bool {
+	if fa, ok := toFloat(a); ok {
+		fb, ok := toFloat(b)
+		return ok && fa == fb
+	}
+	return reflect.DeepEqual(a, b)
+}
@@ -0,0 +1,136 @@
+// Package tokenizer estimates prompt token counts before a request is sent upstream,
+// for budget checks and cost estimates. Counts are exact for OpenAI models and an
+// approximation for other providers, which don't publish their tokenizers.
+package tokenizer
+
+import (
+	"log/slog"
+	"strings"
+	"sync"
+
+	"github.com/pkoukk/tiktoken-go"
+	tiktoken_loader "github.com/pkoukk/tiktoken-go-loader"
+)
+
+const (
+	encodingO200K  = "o200k_base"
+	encodingCL100K = "cl100k_base"
+
+	// Per-message framing overhead for chat formats, as documented by OpenAI
+	tokensPerMessage = 3
+	tokensPerName    = 1
+	tokensPerReply   = 3
+)
+
+var (
+	encoders   = map[string]*tiktoken.Tiktoken{}
+	encodersMu sync.Mutex
+)
+
+func init() {
+	// Use the BPE ranks embedded in the binary rather than downloading them at runtime
+	tiktoken.SetBpeLoader(tiktoken_loader.NewOfflineLoader())
+}
+
+// encodingFor picks the BPE encoding for a model, given with or without its
+// "provider/" prefix
+func encodingFor(model string) string {
+	if i := strings.Index(model, "/"); i >= 0 {
+		model = model[i+1:]
+	}
+
+	switch {
+	case strings.HasPrefix(model, "gpt-4o"), strings.HasPrefix(model, "gpt-4.1"),
+		strings.HasPrefix(model, "o1"), strings.HasPrefix(model, "o3"), strings.HasPrefix(model, "o4"):
+		return encodingO200K
+	default:
+		// GPT-4, GPT-3.5, embeddings, and the approximation used for other providers
+		return encodingCL100K
+	}
+}
+
+// Encoding returns the name of the encoding tokens are counted with for a model.
+// Models with the same encoding get the same counts.
+func Encoding(model string) string {
+	return encodingFor(model)
+}
+
+// encoder returns the cached encoder for an encoding, loading it on first use
+func encoder(encoding string) *tiktoken.Tiktoken {
+	encodersMu.Lock()
+	defer encodersMu.Unlock()
+
+	if enc, ok := encoders[encoding]; ok {
+		return enc
+	}
+
+	enc, err := tiktoken.GetEncoding(encoding)
+	if err != nil {
+		slog.Error("failed to load tokenizer encoding", "encoding", encoding, "error", err)
+	}
+	// Cache failures too so a broken encoding isn't reloaded on every request
+	encoders[encoding] = enc
+	return enc
+}
+
+// CountText returns the number of tokens in text for a model
+func CountText(text, model string) int {
+	if text == "" {
+		return 0
+	}
+	enc := encoder(encodingFor(model))
+	if enc == nil {
+		// Rough fallback of four characters per token
+		return (len(text) + 3) / 4
+	}
+	return len(enc.EncodeOrdinary(text))
+}
+
+// CountMessages estimates the prompt tokens of a chat request's messages, as
+// decoded from JSON. Text in both plain string and content-part form is counted;
+// non-text parts such as images are not.
+func CountMessages(messages interface{}, model string) int {
+	list, ok := messages.([]interface{})
+	if !ok {
+		return 0
+	}
+
+	total := tokensPerReply
+	for _, m := range list {
+		msg, ok := m.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		total += tokensPerMessage
+		if role, ok := msg["role"].(string); ok {
+			total += CountText(role, model)
+		}
+		if name, ok := msg["name"].(string); ok {
+			total += CountText(name, model) + tokensPerName
+		}
+		total += CountText(messageText(msg["content"]), model)
+	}
+
+	return total
+}
+
+// messageText concatenates the text of a message's content, which is either a
+// string or a list of typed parts
+func messageText(content interface{}) string {
+	switch c := content.(type) {
+	case string:
+		return c
+	case []interface{}:
+		var text strings.Builder
+		for _, part := range c {
+			if p, ok := part.(map[string]interface{}); ok {
+				if t, ok := p["text"].(string); ok {
+					text.WriteString(t)
+				}
+			}
+		}
+		return text.String()
+	}
+	return ""
+}
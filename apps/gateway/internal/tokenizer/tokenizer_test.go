@@ -0,0 +1,134 @@
+package tokenizer
+
+import "testing"
+
+func TestEncodingFor(t *testing.T) {
+	tests := []struct {
+		model string
+		want  string
+	}{
+		{"gpt-4o", encodingO200K},
+		{"openai/gpt-4o-mini", encodingO200K},
+		{"gpt-4.1", encodingO200K},
+		{"o3-mini", encodingO200K},
+		{"gpt-4", encodingCL100K},
+		{"openai/gpt-3.5-turbo", encodingCL100K},
+		{"anthropic/claude-3-5-sonnet-20241022", encodingCL100K},
+		{"some-unknown-model", encodingCL100K},
+	}
+	for _, tt := range tests {
+		if got := encodingFor(tt.model); got != tt.want {
+			t.Errorf("encodingFor(%q) = %q, want %q", tt.model, got, tt.want)
+		}
+	}
+}
+
+func TestCountText(t *testing.T) {
+	if got := CountText("", "gpt-4o"); got != 0 {
+		t.Errorf("CountText of empty text = %d, want 0", got)
+	}
+	for _, model := range []string{"gpt-4o", "gpt-4"} {
+		if got := CountText("hello world", model); got != 2 {
+			t.Errorf("CountText(%q, %q) = %d, want 2", "hello world", model, got)
+		}
+	}
+}
+
+func TestCountMessages(t *testing.T) {
+	// Each message is "user" (1 token) and "hello world" (2 tokens) plus 3 tokens
+	// of framing, and the reply adds another 3
+	tests := []struct {
+		name     string
+		messages interface{}
+		want     int
+	}{
+		{
+			name:     "string content",
+			messages: []interface{}{map[string]interface{}{"role": "user", "content": "hello world"}},
+			want:     9,
+		},
+		{
+			name: "content parts",
+			messages: []interface{}{map[string]interface{}{"role": "user", "content": []interface{}{
+				map[string]interface{}{"type": "text", "text": "hello"},
+				map[string]interface{}{"type": "image_url", "image_url": map[string]interface{}{"url": "https://example.com/a.png"}},
+				map[string]interface{}{"type": "text", "text": " world"},
+			}}},
+			want: 9,
+		},
+		{
+			name: "name",
+			messages: []interface{}{
+				map[string]interface{}{"role": "user", "name": "alice", "content": "hello world"},
+			},
+			want: 11,
+		},
+		{
+			name: "several messages",
+			messages: []interface{}{
+				map[string]interface{}{"role": "user", "content": "hello world"},
+				map[string]interface{}{"role": "user", "content": "hello world"},
+			},
+			want: 15,
+		},
+		{"not a list", "hello world", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := CountMessages(tt.messages, "gpt-4o"); got != tt.want {
+				t.Errorf("CountMessages() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCountTextFallback(t *testing.T) {
+	// An encoding that failed to load is cached as nil
+	encodersMu.Lock()
+	saved, ok := encoders[encodingCL100K]
+	encoders[encodingCL100K] = nil
+	encodersMu.Unlock()
+	t.Cleanup(func() {
+		encodersMu.Lock()
+		defer encodersMu.Unlock()
+		if ok {
+			encoders[encodingCL100K] = saved
+		} else {
+			delete(encoders, encodingCL100K)
+		}
+	})
+
+	tests := []struct {
+		text string
+		want int
+	}{
+		{"abcd", 1},
+		{"abcde", 2},
+		{"hello world", 3},
+	}
+	for _, tt := range tests {
+		if got := CountText(tt.text, "gpt-4"); got != tt.want {
+			t.Errorf("CountText(%q) with no encoder = %d, want %d", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestEncoderCached(t *testing.T) {
+	first := encoder(encodingO200K)
+	if first == nil {
+		t.Fatal("encoder(o200k_base) = nil")
+	}
+	if second := encoder(encodingO200K); second != first {
+		t.Error("second encoder(o200k_base) loaded a new encoder")
+	}
+
+	if enc := encoder("no_such_encoding"); enc != nil {
+		t.Fatal("encoder of an unknown encoding is not nil")
+	}
+	encodersMu.Lock()
+	_, cached := encoders["no_such_encoding"]
+	encodersMu.Unlock()
+	if !cached {
+		t.Error("failed encoding was not cached")
+	}
+}
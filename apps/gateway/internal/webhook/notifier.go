@@ -0,0 +1,78 @@
+// Package webhook delivers gateway events to an operator-configured HTTP endpoint
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// signatureHeader carries the hex HMAC-SHA256 of the body when a secret is configured
+const signatureHeader = "X-Lumina-Signature"
+
+// Event types
+const (
+	EventBudgetExceeded = "budget.exceeded"
+	EventKeyIdleRevoked = "key.idle_revoked"
+)
+
+// Event is the JSON body posted to the webhook
+type Event struct {
+	Type      string      `json:"type"`
+	Timestamp time.Time   `json:"timestamp"`
+	Data      interface{} `json:"data"`
+}
+
+// Notifier posts events to a webhook URL
+type Notifier struct {
+	url        string
+	secret     []byte
+	httpClient *http.Client
+}
+
+// New creates a notifier for url. Requests are signed when secret is non-empty.
+func New(url, secret string) *Notifier {
+	return &Notifier{
+		url:    url,
+		secret: []byte(secret),
+		httpClient: &http.Client{
+			Timeout: 10 * time.Second,
+		},
+	}
+}
+
+// Send posts an event and waits for the endpoint to accept it with a 2xx status
+func (n *Notifier) Send(ctx context.Context, eventType string, data interface{}) error {
+	body, err := json.Marshal(Event{Type: eventType, Timestamp: time.Now().UTC(), Data: data})
+	if err != nil {
+		return fmt.Errorf("failed to marshal event: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", n.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if len(n.secret) > 0 {
+		mac := hmac.New(sha256.New, n.secret)
+		mac.Write(body)
+		req.Header.Set(signatureHeader, hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := n.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send webhook: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -358,7 +358,12 @@ export default function KeysPage() {
                   <tbody>
                     {keys.map((key) => (
                       <tr key={key.id} className="border-b last:border-0">
-                        <td className="py-4 font-medium">{key.name}</td>
+                        <td className="py-4">
+                          <div className="font-medium">{key.name}</div>
+                          {key.key_preview && (
+                            <div className="font-mono text-xs text-muted-foreground">{key.key_preview}</div>
+                          )}
+                        </td>
                         <td className="py-4">
                           <div className="max-w-[200px] truncate text-sm text-muted-foreground">
                             {key.allowed_models?.length > 0
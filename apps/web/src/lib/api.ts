@@ -10,6 +10,7 @@ export interface VirtualKey {
   id: string
   user_id: string
   name: string
+  key_preview: string
   allowed_models: string[]
   budget_limit: number | null
   current_spend: number